@@ -1,13 +1,25 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/azure"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
 	"github.com/ThiagoScheffer/azure-tagger-api/internal/handlers"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/health"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/idempotency"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/metrics"
 	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/telemetry"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	httpSwagger "github.com/swaggo/http-swagger/v2"
@@ -35,65 +47,171 @@ import (
 // @description - CI/CD with GitHub Actions
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// flag > env > config file > defaults, see config.Usage (-h)
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		config.Usage(os.Stderr)
+		return
+	}
+	if err != nil {
+		slog.Error("invalid configuration", slog.String("error", err.Error()))
+		os.Exit(2)
+	}
+
+	logger := logging.New(os.Stdout, cfg.Log.Level)
+	slog.SetDefault(logger)
+	logger.Info("effective config", slog.Any("config", cfg)) // secrets are redacted by Config.LogValue
+
+	shutdownTracing, err := telemetry.SetupTracing(context.Background(), cfg.Tracing.Exporter)
+	if err != nil {
+		logger.Error("could not setup tracing", slog.String("error", err.Error()))
+		os.Exit(1)
 	}
 
 	st := store.NewMemoryStore()
 
 	router := chi.NewRouter()
-	router.Use(middleware.Logger)
-	router.Use(middleware.Timeout(60 * time.Second))
-	router.Use(middleware.Recoverer)
-	router.Use(middleware.RealIP)
 	router.Use(middleware.RequestID)
+	router.Use(middleware.RealIP)
+	router.Use(telemetry.Middleware)
+	router.Use(metrics.Middleware)
+	router.Use(logging.Middleware(logger)) // needs request id + trace, so after them
+	router.Use(middleware.Recoverer)
+	router.Use(requestTimeout(cfg.Server.RequestTimeout))
 
 	router.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 
+	// probes for Container Apps: liveness never looks at dependencies,
+	// readiness does (results cached so we don't hammer AAD)
+	checker := health.NewChecker(cfg.Readiness.CacheTTL, cfg.Readiness.CheckTimeout, readinessChecks(cfg.Azure, st)...)
+	router.Get("/livez", health.Live)
+	router.Get("/readyz", checker.Ready)
+
+	router.Handle("/metrics", metrics.Handler()) //prometheus scrape
+
 	router.Get("/swagger/*", httpSwagger.WrapHandler) //for swagger ui
 
-	h := handlers.New(st)
+	h := handlers.New(st, cfg)
+	h.StartExpiry(cfg.Tags.ExpiryInterval) // stopped by h.Interrupt
 
+	idem := idempotency.New(cfg.Server.IdempotencyTTL)
 	router.Route("/v1", func(r chi.Router) {
-
-		r.Post("/resources", h.CreateResource)
-
-		// @Summary Get a resource
-		// @Tags    resources
-		// @Produce json
-		// @Param   id   path     string true "Resource ID"
-		// @Success 200  {object} models.Resource
-		// @Failure 404  {object} map[string]string
-		// @Router  /resources/{id} [get]
-		r.Get("/resources", h.ListResources)
-
-		// @Summary Get a resource
-		// @Tags    resources
-		// @Produce json
-		// @Param   id   path     string true "Resource ID"
-		// @Success 200  {object} models.Resource
-		// @Failure 404  {object} map[string]string
-		// @Router  /resources/{id} [get]
-		r.Get("/resources/{id}", h.GetResource)
-		//r.Put("/resources/{id}/tags", handler.UpdateResourceTags)
-
-		// @Summary Delete a resource
-		// @Tags    resources
-		// @Param   id path string true "Resource ID"
-		// @Success 204
-		// @Failure 404 {object} map[string]string
-		// @Router  /resources/{id} [delete]
-		r.Delete("/resources/{id}", h.DeleteResource)
-
-		r.Post("/resources/{id}/apply-tags", h.ApplyTagsToAzure) //endpoint
+		r.Use(idem.Middleware) // retries with the same Idempotency-Key get the first answer
+		h.Routes(r)
 	})
 
-	log.Printf("Starting server on port %s", port)
-	if err := http.ListenAndServe(":"+port, router); err != nil {
-		log.Fatalf("Could not start server: %s\n", err.Error())
+	srv := &http.Server{
+		Addr:              ":" + cfg.Server.Port,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout, // validated > request timeout
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+	srv.RegisterOnShutdown(h.CloseStreams) // Shutdown doesn't wait for open event streams
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("starting server", slog.String("port", cfg.Server.Port))
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		logger.Error("could not start server", slog.String("error", err.Error()))
+		exitCode = 1
+	case <-ctx.Done():
+		stop() // a second signal kills the process right away
+		logger.Info("shutdown signal received")
+	}
+
+	shutdown(logger, srv, h, shutdownTracing, cfg.Server.ShutdownDrain)
+	os.Exit(exitCode)
+}
+
+// requestTimeout is middleware.Timeout for everything but the event stream,
+// which stays open.
+func requestTimeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		timeout := middleware.Timeout(d)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v1/events" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			timeout.ServeHTTP(w, r)
+		})
+	}
+}
+
+// readinessChecks lists what /readyz verifies: the store, a token for every
+// credential profile and every configured subscription. Azure misconfiguration
+// (no subscription, bad profile...) makes the azure checks fail instead of
+// hiding it.
+func readinessChecks(cfg config.Azure, st *store.MemoryStore) []health.Check {
+	tagger, err := azure.NewTagger(cfg)
+	azureCheck := func(check func(context.Context, *azure.Tagger) error) func(context.Context) error {
+		return func(ctx context.Context) error {
+			if err != nil {
+				return err
+			}
+			return check(ctx, tagger)
+		}
+	}
+	tokenCheck := func(profile string) func(context.Context, *azure.Tagger) error {
+		return func(ctx context.Context, t *azure.Tagger) error { return t.CheckToken(ctx, profile) }
+	}
+
+	checks := []health.Check{
+		{Name: "store", Run: st.Ping},
+		{Name: "azure_token", Run: azureCheck(tokenCheck(azure.DefaultProfile))},
+	}
+	for _, p := range cfg.Profiles {
+		checks = append(checks, health.Check{Name: "azure_token:" + p.Name, Run: azureCheck(tokenCheck(p.Name))})
+	}
+	checks = append(checks, health.Check{Name: "azure_subscription", Run: azureCheck(func(ctx context.Context, t *azure.Tagger) error {
+		return t.CheckSubscription(ctx)
+	})})
+	return checks
+}
+
+// shutdown stops the service in dependency order: the HTTP server first so no
+// new work comes in, then the background jobs and the Azure applies that didn't
+// finish within the drain period (recorded as interrupted/canceled), and the
+// telemetry exporters last so the shutdown itself is still traced. The memory
+// store has nothing to flush.
+func shutdown(logger *slog.Logger, srv *http.Server, h *handlers.Handler, flushTracing func(context.Context) error, drain time.Duration) {
+	logger.Info("draining in-flight requests", slog.Duration("drain_timeout", drain))
+
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	drainErr := srv.Shutdown(ctx)
+	if drainErr != nil && !errors.Is(drainErr, http.ErrServerClosed) {
+		logger.Warn("drain period over, interrupting azure applies", slog.String("error", drainErr.Error()))
+	}
+
+	// jobs don't hold a request open, they are stopped even after a clean drain
+	ictx, icancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := h.Interrupt(ictx); err != nil {
+		logger.Error("azure applies did not stop in time", slog.String("error", err.Error()))
+	}
+	icancel()
+	if drainErr != nil {
+		srv.Close()
+	}
+
+	fctx, fcancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer fcancel()
+	if err := flushTracing(fctx); err != nil {
+		logger.Warn("could not flush traces", slog.String("error", err.Error()))
 	}
+	logger.Info("shutdown complete")
 }
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/pkg/client"
+	"gopkg.in/yaml.v3"
+)
+
+func runCreate(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("create", "NAME AZURE_ID [KEY=VALUE...]")
+	var sets listFlag
+	expires := kvFlag{}
+	fs.Var(&sets, "tag-set", "tag set to merge under the tags, repeatable")
+	fs.Var(expires, "expires", "KEY=TTL|TIME expiry of a tag, e.g. incident=72h, repeatable")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return usageErr(fs, "create needs a name and an Azure ID")
+	}
+	tags, err := keyValues(args[2:])
+	if err != nil {
+		return err
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	res, err := c.CreateResource(ctx, client.CreateResource{Name: args[0], AzureID: args[1], Tags: tags, TagSets: sets, Expires: expires})
+	if err != nil {
+		return err
+	}
+	return a.printResources([]client.Resource{res}, res)
+}
+
+func runList(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("list", "")
+	var opts client.ListOptions
+	fs.StringVar(&opts.Selector, "l", "", "tag selector, e.g. env=prod,!owner")
+	fs.StringVar(&opts.Scope, "scope", "", "Azure ID of a subscription or resource group, keeps what is under it")
+	fs.StringVar(&opts.Query, "q", "", "part of the name or Azure ID")
+	fs.BoolVar(&opts.NeedsApply, "needs-apply", false, "only the resources Azure may be behind on")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usageErr(fs, "list takes no argument")
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	list, err := c.ListResources(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return a.printResources(list, list)
+}
+
+func (a *app) printResources(list []client.Resource, v any) error {
+	return a.printer().print(v, func(w io.Writer) {
+		row(w, "ID", "NAME", "AZURE ID", "NEEDS APPLY", "TAGS")
+		for _, r := range list {
+			row(w, r.ID, r.Name, r.AzureID, yesNo(r.NeedsApply), pairs(r.Tags))
+		}
+	})
+}
+
+func runGet(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("get", "ID")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usageErr(fs, "get needs a resource ID")
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	res, err := c.GetResource(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return a.printer().print(res, func(w io.Writer) {
+		row(w, "ID:", res.ID)
+		row(w, "Name:", res.Name)
+		row(w, "Azure ID:", res.AzureID)
+		row(w, "Scope:", res.Scope)
+		row(w, "Tag sets:", list(res.TagSets))
+		row(w, "Needs apply:", yesNo(res.NeedsApply))
+		if la := res.LastApply; la != nil {
+			row(w, "Last apply:", strings.TrimSpace(la.Status+" "+when(la.FinishedUnix)+" "+la.Error))
+		}
+		row(w, "")
+		row(w, "KEY", "VALUE", "SOURCE", "EXPIRES")
+		for _, k := range slices.Sorted(maps.Keys(res.EffectiveTags)) {
+			t := res.EffectiveTags[k]
+			source := t.Source
+			if t.TagSet != "" {
+				source += " " + t.TagSet
+			} else if t.InheritedFrom != "" {
+				source += " " + t.InheritedFrom
+			}
+			row(w, k, t.Value, source, when(res.TagExpiry[k]))
+		}
+	})
+}
+
+func runDelete(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("delete", "ID...")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usageErr(fs, "delete needs resource IDs")
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	for _, id := range args {
+		if err := c.DeleteResource(ctx, id); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		fmt.Fprintln(a.stderr, "deleted", id)
+	}
+	return nil
+}
+
+func runTag(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("tag", "set ID KEY=VALUE... | unset ID KEY...")
+	expires := kvFlag{}
+	fs.Var(expires, "expires", "set: KEY=TTL|TIME expiry of a tag, e.g. incident=72h, repeatable")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 3 || args[0] != "set" && args[0] != "unset" {
+		return usageErr(fs, "tag needs set or unset, a resource ID and tags")
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	var res client.Resource
+	if args[0] == "set" {
+		tags, err := keyValues(args[2:])
+		if err != nil {
+			return err
+		}
+		res, err = c.SetTags(ctx, args[1], tags, expires)
+		if err != nil {
+			return err
+		}
+	} else if res, err = c.UnsetTags(ctx, args[1], args[2:]...); err != nil {
+		return err
+	}
+	return a.printResources([]client.Resource{res}, res)
+}
+
+func runApply(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("apply", "ID [KEY=VALUE...]")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usageErr(fs, "apply needs a resource ID")
+	}
+	tags, err := keyValues(args[1:])
+	if err != nil {
+		return err
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 { // the stored ones
+		res, err := c.GetResource(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		tags = res.Tags
+	}
+	out, err := c.ApplyTags(ctx, args[0], tags)
+	if err != nil {
+		return err
+	}
+	return a.printer().print(out, func(w io.Writer) {
+		row(w, "AZURE ID", "TAGS")
+		row(w, out.Resource, pairs(out.Tags))
+	})
+}
+
+func runHistory(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("history", "ID")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usageErr(fs, "history needs a resource ID")
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	history, err := c.History(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return a.printer().print(history, func(w io.Writer) {
+		row(w, "TIME", "ACTION", "ACTOR", "SET", "REMOVED", "DETAIL")
+		for _, e := range history {
+			row(w, when(e.Unix), e.Action, cmp.Or(e.Actor, "-"), pairs(e.Tags), list(e.Removed), cmp.Or(e.Detail, "-"))
+		}
+	})
+}
+
+func runDrift(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("drift", "")
+	selector := fs.String("l", "", "tag selector, e.g. env=prod,!owner")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usageErr(fs, "drift takes no argument")
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	drift, err := c.Drift(ctx, *selector)
+	if err != nil {
+		return err
+	}
+	return a.printer().print(drift, func(w io.Writer) {
+		row(w, "NAME", "AZURE ID", "TO SET", "TO REMOVE", "ERROR")
+		for _, d := range drift.Drifted {
+			row(w, d.Name, d.AzureID, pairs(d.Set), list(d.Removed), cmp.Or(d.Error, "-"))
+		}
+		fmt.Fprintf(w, "\n%d of %d resources drifted\n", len(drift.Drifted), drift.Checked)
+	})
+}
+
+func runRename(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("rename", "MAPPING_FILE")
+	var opts client.RenameOptions
+	fs.StringVar(&opts.Selector, "l", "", "tag selector of the resources to rename, all by default")
+	fs.BoolVar(&opts.Push, "push", false, "apply the changed resources to Azure too")
+	dryRun := fs.Bool("dry-run", false, "only show what would change")
+	wait := fs.Bool("wait", false, "follow the job until it is finished")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usageErr(fs, "rename needs a mapping file, JSON or YAML like the mapping of GET /tags/inventory")
+	}
+	var m client.TagMapping
+	b, err := readFile(a, args[0])
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(b, &m); err != nil { // JSON is YAML too
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		preview, err := c.PreviewRename(ctx, m, opts.Selector)
+		if err != nil {
+			return err
+		}
+		return a.printer().print(preview, func(w io.Writer) {
+			row(w, "NAME", "AZURE ID", "SET", "REMOVED", "CONFLICTS")
+			for _, d := range preview.Diffs {
+				row(w, d.Name, d.AzureID, pairs(d.Set), list(d.Removed), list(d.Conflicts))
+			}
+			fmt.Fprintf(w, "\n%d matched, %d to change, %d with conflicts\n", preview.Matched, preview.Changed, preview.Conflicts)
+		})
+	}
+	job, err := c.RenameTags(ctx, m, opts)
+	if err != nil {
+		return err
+	}
+	return a.job(ctx, c, job, *wait, time.Second)
+}
+
+func runJobs(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("jobs", "[get ID]")
+	wait := fs.Bool("wait", false, "get: follow the job until it is finished")
+	interval := fs.Duration("interval", time.Second, "get: time between two polls with --wait")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(args) == 0 || len(args) == 1 && args[0] == "list":
+		jobs, err := c.ListJobs(ctx)
+		if err != nil {
+			return err
+		}
+		return a.printJobs(jobs, jobs)
+	case len(args) == 2 && args[0] == "get":
+		job, err := c.GetJob(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		return a.job(ctx, c, job, *wait, *interval)
+	}
+	return usageErr(fs, "jobs takes list or get ID")
+}
+
+// job prints a job, once finished when wait is set, following its progress
+// on stderr. A job that failed is an error.
+func (a *app) job(ctx context.Context, c *client.Client, job client.Job, wait bool, interval time.Duration) error {
+	if wait && !job.Finished() {
+		var err error
+		job, err = c.WaitJob(ctx, job.ID, interval, func(j client.Job) {
+			fmt.Fprintf(a.stderr, "job %s: %s %d/%d, %d failed\n", j.ID, j.Status, j.Done, j.Total, j.Failed)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := a.printJobs([]client.Job{job}, job); err != nil {
+		return err
+	}
+	if wait && job.Status != client.JobSucceeded {
+		return fmt.Errorf("job %s %s: %s", job.ID, job.Status, cmp.Or(job.Error, strings.Join(job.Errors, "; ")))
+	}
+	return nil
+}
+
+func (a *app) printJobs(jobs []client.Job, v any) error {
+	return a.printer().print(v, func(w io.Writer) {
+		row(w, "ID", "KIND", "STATUS", "PROGRESS", "FAILED", "CREATED")
+		for _, j := range jobs {
+			row(w, j.ID, j.Kind, j.Status, fmt.Sprintf("%d/%d", j.Done, j.Total), j.Failed, when(j.CreatedUnix))
+		}
+	})
+}
+
+func runImport(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("import", "FILE|-")
+	var opts client.ImportOptions
+	fs.StringVar(&opts.Format, "format", "", "csv or ndjson (default from the file extension, csv)")
+	fs.BoolVar(&opts.Atomic, "atomic", false, "import all rows or none")
+	fs.StringVar(&opts.Delimiter, "delimiter", "", "CSV delimiter: one character, tab or semicolon")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usageErr(fs, "import needs a file, - for stdin")
+	}
+	if ext := filepath.Ext(args[0]); opts.Format == "" && (ext == ".ndjson" || ext == ".jsonl") {
+		opts.Format = "ndjson"
+	}
+	in := a.stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	res, err := c.Import(ctx, in, opts)
+	var apiErr *client.Error
+	if err != nil && (!errors.As(err, &apiErr) || res.Rows == 0) {
+		return err
+	}
+	if perr := a.printer().print(res, func(w io.Writer) {
+		row(w, "ROWS", "CREATED", "UPDATED", "FAILED")
+		row(w, res.Rows, res.Created, res.Updated, res.Failed)
+		if len(res.Errors) > 0 {
+			row(w, "")
+			row(w, "LINE", "AZURE ID", "ERROR")
+			for _, e := range res.Errors {
+				row(w, e.Row, cmp.Or(e.AzureID, "-"), e.Error)
+			}
+		}
+	}); perr != nil {
+		return perr
+	}
+	return err
+}
+
+func runExport(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("export", "")
+	var opts client.ExportOptions
+	var tags listFlag
+	fs.StringVar(&opts.Format, "format", "", "csv (default) or ndjson")
+	fs.Var(&tags, "tag", "tag column, repeatable (default every key in use)")
+	fs.StringVar(&opts.Selector, "l", "", "tag selector, e.g. env=prod,!owner")
+	fs.StringVar(&opts.Delimiter, "delimiter", "", "CSV delimiter: one character, tab or semicolon")
+	file := fs.String("f", "", "file to write instead of stdout")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usageErr(fs, "export takes no argument")
+	}
+	opts.Tags = tags
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	rc, err := c.Export(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if *file == "" {
+		_, err = io.Copy(a.stdout, rc)
+		return err
+	}
+	f, err := os.Create(*file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runConfig(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("config", "get-contexts | current-context | use-context NAME | set-context NAME | delete-context NAME")
+	var server, token, tokenEnv string
+	fs.StringVar(&server, "server", "", "set-context: server URL, e.g. https://tagger.example.com")
+	fs.StringVar(&token, "token", "", "set-context: bearer token")
+	fs.StringVar(&tokenEnv, "token-env", "", "set-context: environment variable holding the bearer token")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usageErr(fs, "config needs a subcommand")
+	}
+	cfg, path, err := a.config()
+	if err != nil {
+		return err
+	}
+	name := ""
+	if len(args) > 1 {
+		name = args[1]
+	}
+
+	switch {
+	case args[0] == "get-contexts" && len(args) == 1:
+		return a.printer().print(cfg, func(w io.Writer) {
+			row(w, "CURRENT", "NAME", "SERVER")
+			for _, n := range slices.Sorted(maps.Keys(cfg.Contexts)) {
+				current := ""
+				if n == cfg.CurrentContext {
+					current = "*"
+				}
+				row(w, current, n, cfg.Contexts[n].Server)
+			}
+		})
+	case args[0] == "current-context" && len(args) == 1:
+		if cfg.CurrentContext == "" {
+			return errors.New("no current context")
+		}
+		fmt.Fprintln(a.stdout, cfg.CurrentContext)
+		return nil
+	case args[0] == "use-context" && len(args) == 2:
+		if _, ok := cfg.Contexts[name]; !ok {
+			return fmt.Errorf("no context %s", name)
+		}
+		cfg.CurrentContext = name
+	case args[0] == "set-context" && len(args) == 2:
+		c, ok := cfg.Contexts[name]
+		if !ok {
+			c = &Context{}
+			cfg.Contexts[name] = c
+		}
+		if server != "" {
+			c.Server = server
+		}
+		if token != "" {
+			c.Token = token
+		}
+		if tokenEnv != "" {
+			c.TokenEnv = tokenEnv
+		}
+		if c.Server == "" {
+			return errors.New("set-context needs --server for a new context")
+		}
+		if _, err := client.New(c.Server); err != nil {
+			return err
+		}
+		if cfg.CurrentContext == "" {
+			cfg.CurrentContext = name
+		}
+	case args[0] == "delete-context" && len(args) == 2:
+		if _, ok := cfg.Contexts[name]; !ok {
+			return fmt.Errorf("no context %s", name)
+		}
+		delete(cfg.Contexts, name)
+		if cfg.CurrentContext == name {
+			cfg.CurrentContext = ""
+		}
+	default:
+		return usageErr(fs, "unknown config subcommand")
+	}
+	if err := cfg.save(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stderr, "%s: %s done\n", path, args[0])
+	return nil
+}
+
+// readFile reads a file, stdin for -.
+func readFile(a *app, path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(a.stdin)
+	}
+	return os.ReadFile(path)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultServer is used when the config file has no context, it is where
+// go run ./cmd/api listens.
+const defaultServer = "http://localhost:8080"
+
+// Config is the taggerctl config file: named contexts, one per server.
+type Config struct {
+	CurrentContext string              `yaml:"current-context,omitempty" json:"current-context"`
+	Contexts       map[string]*Context `yaml:"contexts,omitempty" json:"contexts"`
+}
+
+// Context is a server and how to call it.
+type Context struct {
+	Server string `yaml:"server" json:"server"`
+	// Token is sent as a bearer token, for a server behind Container Apps
+	// authentication. TokenEnv names an environment variable holding it
+	// instead, to keep it out of the file.
+	Token    string `yaml:"token,omitempty" json:"-"` // not printed
+	TokenEnv string `yaml:"token-env,omitempty" json:"token-env,omitempty"`
+}
+
+// configPath is $TAGGERCTL_CONFIG, or taggerctl/config.yaml in the user
+// config directory (~/.config on Linux).
+func configPath() (string, error) {
+	if p := os.Getenv("TAGGERCTL_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "taggerctl", "config.yaml"), nil
+}
+
+// loadConfig reads the config file, an empty config when there is none.
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{Contexts: map[string]*Context{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if cfg.Contexts == nil {
+		cfg.Contexts = map[string]*Context{}
+	}
+	for name, c := range cfg.Contexts {
+		if c == nil || c.Server == "" {
+			return nil, fmt.Errorf("%s: context %s has no server", path, name)
+		}
+	}
+	return cfg, nil
+}
+
+// save writes the config, readable by the user only since it may hold
+// tokens.
+func (cfg *Config) save(path string) error {
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// context is the named context, the current one when name is empty, or
+// the default server when the file has none.
+func (cfg *Config) context(name string) (Context, error) {
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+	if name == "" {
+		if len(cfg.Contexts) > 0 {
+			return Context{}, errors.New("no current context, see taggerctl config use-context")
+		}
+		return Context{Server: defaultServer}, nil
+	}
+	c, ok := cfg.Contexts[name]
+	if !ok {
+		return Context{}, fmt.Errorf("no context %s", name)
+	}
+	out := *c
+	if out.TokenEnv != "" {
+		out.Token = os.Getenv(out.TokenEnv)
+	}
+	return out, nil
+}
@@ -0,0 +1,196 @@
+// Command taggerctl manages the resources and tags of a tagger API server
+// from the shell, through pkg/client. The servers are named contexts in a
+// config file, see taggerctl config.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/pkg/client"
+)
+
+type command struct {
+	name    string
+	args    string // usage after the name
+	summary string
+	run     func(ctx context.Context, a *app, args []string) error
+}
+
+// commands, in the order of the usage
+var commands = []command{
+	{"create", "NAME AZURE_ID [KEY=VALUE...]", "Register an Azure ID with tags", runCreate},
+	{"list", "", "List the resources, filtered", runList},
+	{"get", "ID", "Show a resource and its effective tags", runGet},
+	{"delete", "ID...", "Unregister resources (their Azure tags stay)", runDelete},
+	{"tag", "set ID KEY=VALUE... | unset ID KEY...", "Set or remove tags of a resource", runTag},
+	{"apply", "ID [KEY=VALUE...]", "Apply the tags of a resource to Azure", runApply},
+	{"history", "ID", "Show the tag changes of a resource", runHistory},
+	{"drift", "", "Compare the Azure tags with the desired ones", runDrift},
+	{"rename", "MAPPING_FILE", "Rename tag keys and remap values in bulk", runRename},
+	{"jobs", "[get ID]", "List the background jobs, or follow one", runJobs},
+	{"import", "FILE|-", "Import resources from CSV or NDJSON", runImport},
+	{"export", "", "Export the resources as CSV or NDJSON", runExport},
+	{"config", "get-contexts | current-context | use-context NAME | set-context NAME | delete-context NAME", "Manage the contexts", runConfig},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "taggerctl:", err)
+		os.Exit(1)
+	}
+}
+
+// app is what the commands share: where they write and the common flags.
+type app struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+
+	configPath  string
+	contextName string
+	output      string
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	a := &app{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		a.usage()
+		return flag.ErrHelp
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(ctx, a, args[1:])
+		}
+	}
+	a.usage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func (a *app) usage() {
+	fmt.Fprint(a.stderr, "Usage: taggerctl COMMAND [flags] [args]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(a.stderr, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprint(a.stderr, "\nEvery command takes --context, --config and -o table|json|yaml, see taggerctl COMMAND -h.\n")
+}
+
+// flags is the flag set of a command, with the common flags.
+func (a *app) flags(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet("taggerctl "+name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(a.stderr, "Usage: taggerctl %s [flags] %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&a.configPath, "config", "", "config file (default $TAGGERCTL_CONFIG or taggerctl/config.yaml in the user config dir)")
+	fs.StringVar(&a.contextName, "context", "", "context to use instead of the current one")
+	fs.StringVar(&a.output, "o", outTable, "output: table, json or yaml")
+	return fs
+}
+
+// parse parses flags anywhere among the arguments and returns the others.
+func (a *app) parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	return rest, checkOutput(a.output)
+}
+
+func (a *app) printer() printer {
+	return printer{w: a.stdout, format: a.output}
+}
+
+func (a *app) config() (*Config, string, error) {
+	path := a.configPath
+	if path == "" {
+		var err error
+		if path, err = configPath(); err != nil {
+			return nil, "", err
+		}
+	}
+	cfg, err := loadConfig(path)
+	return cfg, path, err
+}
+
+// client calls the server of the context.
+func (a *app) client() (*client.Client, error) {
+	cfg, _, err := a.config()
+	if err != nil {
+		return nil, err
+	}
+	c, err := cfg.context(a.contextName)
+	if err != nil {
+		return nil, err
+	}
+	var opts []client.Option
+	if c.Token != "" {
+		opts = append(opts, client.WithToken(c.Token))
+	}
+	return client.New(c.Server, opts...)
+}
+
+// usageErr is a wrong number of arguments.
+func usageErr(fs *flag.FlagSet, msg string) error {
+	fs.Usage()
+	return errors.New(msg)
+}
+
+// keyValues parses KEY=VALUE arguments.
+func keyValues(args []string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, arg := range args {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("%q is not KEY=VALUE", arg)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// kvFlag is a repeatable KEY=VALUE flag.
+type kvFlag map[string]string
+
+func (f kvFlag) String() string { return pairs(f) }
+
+func (f kvFlag) Set(s string) error {
+	kv, err := keyValues([]string{s})
+	if err != nil {
+		return err
+	}
+	for k, v := range kv {
+		f[k] = v
+	}
+	return nil
+}
+
+// listFlag is a repeatable flag.
+type listFlag []string
+
+func (f *listFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *listFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/handlers"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+	"github.com/ThiagoScheffer/azure-tagger-api/pkg/client"
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v3"
+)
+
+const rgApp = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app"
+
+// ctl runs taggerctl with a config file in dir, what it wrote on stdout
+// and the error.
+type ctl struct {
+	t   *testing.T
+	cfg string
+}
+
+func (c ctl) run(stdin string, args ...string) (string, error) {
+	c.t.Helper()
+	var out, errOut bytes.Buffer
+	err := run(context.Background(), append(args, "--config", c.cfg), strings.NewReader(stdin), &out, &errOut)
+	return out.String(), err
+}
+
+func (c ctl) ok(args ...string) string {
+	c.t.Helper()
+	out, err := c.run("", args...)
+	if err != nil {
+		c.t.Fatalf("taggerctl %s: %v", strings.Join(args, " "), err)
+	}
+	return out
+}
+
+// newCtl points the context local at a server with the routes taggerctl
+// calls.
+func newCtl(t *testing.T) ctl {
+	h := handlers.New(store.NewMemoryStore(), config.Default())
+	r := chi.NewRouter()
+	r.Route("/v1", func(r chi.Router) {
+		r.Post("/resources", h.CreateResource)
+		r.Get("/resources", h.ListResources)
+		r.Get("/resources/{id}", h.GetResource)
+		r.Delete("/resources/{id}", h.DeleteResource)
+		r.Patch("/resources/{id}/tags", h.UpdateResourceTags)
+		r.Delete("/resources/{id}/tags", h.UnsetResourceTags)
+		r.Get("/resources/{id}/history", h.GetResourceHistory)
+		r.Post("/import", h.ImportResources)
+		r.Get("/export", h.ExportResources)
+		r.Post("/tags/rename", h.RenameTags)
+		r.Get("/jobs", h.ListJobs)
+		r.Get("/jobs/{id}", h.GetJob)
+	})
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	c := ctl{t: t, cfg: filepath.Join(t.TempDir(), "config.yaml")}
+	c.ok("config", "set-context", "local", "--server", srv.URL, "--token-env", "TAGGERCTL_TEST_TOKEN")
+	return c
+}
+
+func TestTaggerctl_Contexts(t *testing.T) {
+	c := ctl{t: t, cfg: filepath.Join(t.TempDir(), "config.yaml")}
+	if _, err := c.run("", "config", "set-context", "dev"); err == nil {
+		t.Fatal("expected a new context without --server rejected")
+	}
+	c.ok("config", "set-context", "dev", "--server", "http://localhost:8080")
+	c.ok("config", "set-context", "prod", "--server", "https://tagger.example.com", "--token", "secret")
+	if out := c.ok("config", "current-context"); out != "dev\n" {
+		t.Fatalf("expected the first context current, got %q", out)
+	}
+	c.ok("config", "use-context", "prod")
+	out := c.ok("config", "get-contexts")
+	if !strings.Contains(out, "*        prod") || strings.Contains(out, "secret") {
+		t.Fatalf("expected prod current and no token, got\n%s", out)
+	}
+	if out := c.ok("config", "get-contexts", "-o", "json"); strings.Contains(out, "secret") {
+		t.Fatalf("expected no token in the json, got %s", out)
+	}
+	b, _ := os.ReadFile(c.cfg)
+	var cfg Config
+	if err := yaml.Unmarshal(b, &cfg); err != nil || cfg.Contexts["prod"].Token != "secret" {
+		t.Fatalf("expected the token saved, got %s (%v)", b, err)
+	}
+	c.ok("config", "delete-context", "prod")
+	if _, err := c.run("", "list"); err == nil || !strings.Contains(err.Error(), "no current context") {
+		t.Fatalf("expected no current context, got %v", err)
+	}
+	if _, err := c.run("", "list", "--context", "nope"); err == nil {
+		t.Fatal("expected an unknown context rejected")
+	}
+}
+
+func TestTaggerctl_Resources(t *testing.T) {
+	c := newCtl(t)
+	var res client.Resource
+	out := c.ok("create", "rg-app", rgApp, "env=dev", "owner=me", "-o", "json")
+	if err := json.Unmarshal([]byte(out), &res); err != nil || res.ID == "" {
+		t.Fatalf("expected the resource as json, got %s", out)
+	}
+	c.ok("create", "web", rgApp+"/providers/Microsoft.Web/sites/web", "env=prod")
+
+	out = c.ok("list", "-l", "env=dev")
+	if !strings.Contains(out, "NEEDS APPLY") || !strings.Contains(out, "env=dev,owner=me") || strings.Contains(out, "web") {
+		t.Fatalf("expected rg-app only, got\n%s", out)
+	}
+	c.ok("tag", "set", res.ID, "temp=1", "--expires", "temp=72h")
+	out = c.ok("tag", "unset", res.ID, "owner")
+	if !strings.Contains(out, "env=dev,temp=1") {
+		t.Fatalf("expected owner removed, got\n%s", out)
+	}
+	out = c.ok("get", res.ID)
+	if !strings.Contains(out, "temp") || !strings.Contains(out, "explicit") || !strings.Contains(out, "Needs apply:  yes") {
+		t.Fatalf("expected the effective tags, got\n%s", out)
+	}
+	var history []map[string]any
+	out = c.ok("history", res.ID, "-o", "yaml")
+	if err := yaml.Unmarshal([]byte(out), &history); err != nil || len(history) != 2 || history[1]["action"] != "tags_removed" {
+		t.Fatalf("expected the history as yaml, got %s", out)
+	}
+
+	c.ok("delete", res.ID)
+	if _, err := c.run("", "get", res.ID); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected a 404, got %v", err)
+	}
+	if _, err := c.run("", "tag", "set", "x"); err == nil {
+		t.Fatal("expected tag set without tags rejected")
+	}
+	if _, err := c.run("", "list", "-o", "xml"); err == nil {
+		t.Fatal("expected an unknown output rejected")
+	}
+}
+
+func TestTaggerctl_ImportRenameExport(t *testing.T) {
+	c := newCtl(t)
+	in := "azure_id,name,Env\n" + rgApp + ",rg-app,Prod\n"
+	out, err := c.run(in, "import", "-")
+	if err != nil || !strings.Contains(out, "1     1") {
+		t.Fatalf("expected 1 row created, got %v\n%s", err, out)
+	}
+	if out, err := c.run("azure_id\nnope\n", "import", "-", "--atomic"); err == nil || !strings.Contains(out, "nope") {
+		t.Fatalf("expected the failed row and an error, got %v\n%s", err, out)
+	}
+
+	mapping := filepath.Join(t.TempDir(), "mapping.yaml")
+	os.WriteFile(mapping, []byte("keys:\n  Env: env\nvalues:\n  env:\n    Prod: prod\n"), 0o600)
+	if out := c.ok("rename", mapping, "--dry-run"); !strings.Contains(out, "env=prod") || !strings.Contains(out, "1 matched, 1 to change") {
+		t.Fatalf("expected the preview, got\n%s", out)
+	}
+	out = c.ok("rename", mapping, "--wait")
+	if !strings.Contains(out, "rename_tags") || !strings.Contains(out, "succeeded") || !strings.Contains(out, "1/1") {
+		t.Fatalf("expected the job succeeded, got\n%s", out)
+	}
+	if out := c.ok("jobs"); !strings.Contains(out, "rename_tags") {
+		t.Fatalf("expected the job listed, got\n%s", out)
+	}
+
+	file := filepath.Join(t.TempDir(), "out.ndjson")
+	c.ok("export", "--format", "ndjson", "-f", file)
+	if b, _ := os.ReadFile(file); !strings.Contains(string(b), `"env":"prod"`) {
+		t.Fatalf("expected the renamed tags exported, got %s", b)
+	}
+}
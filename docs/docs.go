@@ -4,11 +4,11 @@ package docs
 import "github.com/swaggo/swag"
 
 const docTemplate = `{
-    "schemes": {{ marshal .Schemes }},
+    "schemes": [[ marshal .Schemes ]],
     "swagger": "2.0",
     "info": {
-        "description": "{{escape .Description}}",
-        "title": "{{.Title}}",
+        "description": "[[escape .Description]]",
+        "title": "[[.Title]]",
         "termsOfService": "https://example.com/terms",
         "contact": {
             "name": "Your Name",
@@ -17,14 +17,14 @@ const docTemplate = `{
         "license": {
             "name": "MIT"
         },
-        "version": "{{.Version}}"
+        "version": "[[.Version]]"
     },
-    "host": "{{.Host}}",
-    "basePath": "{{.BasePath}}",
+    "host": "[[.Host]]",
+    "basePath": "[[.BasePath]]",
     "paths": {
-        "/resources": {
+        "/discover": {
             "post": {
-                "description": "Stores an Azure resource ID + tags",
+                "description": "Lists the resources of a subscription or a resource group in Azure and registers the ones not registered yet, with their Azure tags run through the tagging rules.\nThe ones a rule changed are marked needs_apply. Registered ones whose Azure tags differ from their desired tags count as drifted (drift.detected event when new or changed).\nA resource the rules fail on is listed in failed and not registered, the others still are.",
                 "consumes": [
                     "application/json"
                 ],
@@ -32,25 +32,31 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "resources"
+                    "azure"
                 ],
-                "summary": "Create a resource",
+                "summary": "Discover resources",
                 "parameters": [
                     {
-                        "description": "Resource payload",
+                        "description": "Scope to discover",
                         "name": "payload",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handlers.createReq"
+                            "$ref": "#/definitions/handlers.discoverReq"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.Resource"
+                            "$ref": "#/definitions/handlers.discoverResp"
                         }
                     },
                     "400": {
@@ -61,46 +67,280 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
                     }
                 }
             }
         },
-        "/resources/{id}/apply-tags": {
+        "/drift": {
+            "get": {
+                "description": "Reads the Azure tags of the registered resources the selector picks (all by default) and returns the ones that differ from their effective tags, with what an apply would set and remove.\nA drifted one gets a drift.detected event when its drift is new or changed since its last one, not on every check.\nA resource whose tags couldn't be read (e.g. gone from Azure) is listed with the error.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "azure"
+                ],
+                "summary": "Check the registered resources for drift",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tag selector, e.g. env=prod,!owner",
+                        "name": "selector",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.driftResp"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/events": {
+            "get": {
+                "description": "Server-Sent Events of resource changes (resource.created/updated/deleted, tags.updated), Azure applies (azure.applying/applied/failed), drift.detected and job.updated.\nThe SSE id is the event ID: reconnecting with Last-Event-ID resumes from the last 1000 events. When that ID is gone a \"reset\" event comes first, reload GET /resources.\nselector only lets through the events of resources whose tags match; job events have no resource and always pass, filter them out with types.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "events"
+                ],
+                "summary": "Stream events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tag selector, e.g. env=prod,costCenter,!owner (values are globs)",
+                        "name": "selector",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated event types",
+                        "name": "types",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Resume after this event",
+                        "name": "Last-Event-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "event stream",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/export": {
+            "get": {
+                "description": "Streams the registered resources, sorted by Azure ID, in the format POST /import reads: CSV (azure_id, name, then a column per tag key) or NDJSON.\ntags picks the tag columns (default every key in use; tag keys named azure_id or name only come out in NDJSON). selector picks the resources, see GET /events.",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "resources"
+                ],
+                "summary": "Export resources and their tags",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "csv (default) | ndjson",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated tag keys",
+                        "name": "tags",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tag selector, e.g. env=prod,!owner",
+                        "name": "selector",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "CSV delimiter: one character (default ,), tab or semicolon",
+                        "name": "delimiter",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "CSV or NDJSON rows",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/export/cost-allocation": {
+            "get": {
+                "description": "One record per registered resource, sorted by ResourceId, for tag-based cost allocation: ResourceId (lower case, like in Azure Cost Management data), SubscriptionId, ResourceGroupName, ResourceType, ResourceName, a column per dimension key, Tags (JSON), AllocationStatus and MissingDimensions.\nThe dimensions are tags.cost_dimensions (default costCenter, project, owner) or dimensions, read from the desired tags ignoring case. AllocationStatus is allocated (all set), partial or unallocated (none set). selector picks the resources, see GET /events.",
+                "produces": [
+                    "text/csv",
+                    "application/vnd.apache.parquet"
+                ],
+                "tags": [
+                    "resources"
+                ],
+                "summary": "Export the cost-allocation dimensions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "csv (default) | parquet",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated dimension keys",
+                        "name": "dimensions",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tag selector, e.g. env=prod,!owner",
+                        "name": "selector",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "CSV delimiter: one character (default ,), tab or semicolon",
+                        "name": "delimiter",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "CSV or Parquet file",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/import": {
             "post": {
+                "description": "Upserts one resource per row: Azure IDs not registered yet are created (templates and tagging rules applied like POST /resources), the tags of the others are merged into theirs and they are marked needs_apply. Empty cells are skipped, an import never removes a tag.\nCSV: an azure_id column, an optional name column and one column per tag key. NDJSON: one {\"azureId\", \"name\", \"tags\"} object per line. The format comes from format or the Content-Type.\nEvery row is validated and the failing ones reported with their line. With atomic=true nothing is imported when a row fails (422), otherwise the valid rows are.",
                 "consumes": [
-                    "application/json"
+                    "text/csv",
+                    "application/x-ndjson"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "azure"
+                    "resources"
                 ],
-                "summary": "Apply tags to the Azure resource",
+                "summary": "Import resources and their tags",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Resource ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "csv | ndjson",
+                        "name": "format",
+                        "in": "query"
                     },
                     {
-                        "description": "Tags to apply",
+                        "type": "boolean",
+                        "description": "All rows or none",
+                        "name": "atomic",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "CSV delimiter: one character (default ,), tab or semicolon",
+                        "name": "delimiter",
+                        "in": "query"
+                    },
+                    {
+                        "description": "CSV or NDJSON rows",
                         "name": "payload",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handlers.applyReq"
+                            "type": "string"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/handlers.importResp"
                         }
                     },
                     "400": {
@@ -112,8 +352,8 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "413": {
+                        "description": "Request Entity Too Large",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -121,60 +361,2230 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "422": {
+                        "description": "Unprocessable Entity",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/handlers.importResp"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "handlers.applyReq": {
-            "type": "object",
-            "properties": {
-                "tags": {
-                    "type": "object",
-                    "additionalProperties": {
-                        "type": "string"
+        },
+        "/jobs": {
+            "get": {
+                "description": "Newest first. Jobs are kept in memory, the oldest finished ones are dropped.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "jobs"
+                ],
+                "summary": "List background jobs",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Job"
+                            }
+                        }
                     }
                 }
             }
         },
-        "handlers.createReq": {
-            "type": "object",
-            "properties": {
-                "azureId": {
-                    "type": "string"
-                },
-                "name": {
-                    "type": "string"
-                },
-                "tags": {
-                    "type": "object",
-                    "additionalProperties": {
-                        "type": "string"
+        "/jobs/{id}": {
+            "get": {
+                "description": "Status and progress (done/failed out of total) of a job, e.g. a tag set re-apply.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "jobs"
+                ],
+                "summary": "Get a background job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Job"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
                     }
                 }
             }
         },
-        "models.Resource": {
-            "type": "object",
-            "properties": {
+        "/reports/compliance": {
+            "get": {
+                "description": "Checks every registered resource against the tag policy (tags.required_keys, tags.allowed_values): coverage overall, per required key, per subscription and per resource group, and the non-compliant resources with why.\nThe desired tags are checked, with live=true the tags in Azure too (one read per resource): a resource is compliant when both are. One whose Azure tags can't be read counts as unknown, outside the coverage, and is listed in unchecked. selector picks the resources, see GET /events.\nformat=csv gives one flat table (a section column per kind of row), format=html a printable page.",
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "text/html"
+                ],
+                "tags": [
+                    "reports"
+                ],
+                "summary": "Tag compliance report",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Check the Azure tags too",
+                        "name": "live",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "json (default) | csv | html",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tag selector, e.g. env=prod,!owner",
+                        "name": "selector",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "CSV delimiter: one character (default ,), tab or semicolon",
+                        "name": "delimiter",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ComplianceReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/resources": {
+            "get": {
+                "description": "The registered resources, oldest first. selector filters on their own tags, scope keeps a subscription or resource group and what is under it, q the ones whose name or Azure ID contains it (ignoring case).\nlimit pages the list: X-Next-Cursor is then set while more remain, pass it back as cursor with the same filters for the next page.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resources"
+                ],
+                "summary": "List resources",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tag selector, e.g. env=prod,!owner",
+                        "name": "selector",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Azure ID of a subscription or resource group",
+                        "name": "scope",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Part of the name or Azure ID",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only the ones Azure may be behind on",
+                        "name": "needsApply",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 1 to 1000 (default all)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "X-Next-Cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Resource"
+                            }
+                        },
+                        "headers": {
+                            "X-Next-Cursor": {
+                                "type": "string",
+                                "description": "Cursor of the next page, when there is one"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Stores an Azure ID + tags. The ID can be a subscription, a resource group or a resource, the scope kind is detected from it.\nTag values can be templates, e.g. {{.RG}}-team or {{.Now | date \"2006-01-02\"}}, rendered and stored at creation.\ntagSets references existing tag sets, merged in order under the resource tags.\nThe tagging rules (tags.rules) then run over the tags, see /rules/preview.\nexpires gives tags an expiry (RFC 3339 time or TTL like 72h), they are removed from the store and from Azure then.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resources"
+                ],
+                "summary": "Create a resource",
+                "parameters": [
+                    {
+                        "description": "Resource payload",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.createReq"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.Resource"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/resources/{id}": {
+            "get": {
+                "description": "Returns the resource with its effective tags: its tag sets in order, overridden by its own tags, plus the ones inherited from its resource group (tags.inherited_keys), each with its source.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resources"
+                ],
+                "summary": "Get a resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResourceDetail"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Unregisters the resource, its Azure tags stay.",
+                "tags": [
+                    "resources"
+                ],
+                "summary": "Delete a resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/resources/{id}/apply-tags": {
+            "post": {
+                "description": "Replaces the Azure tags with the resource tag sets, overridden by the body tags, plus the ones inherited from the registered resource group (tags.inherited_keys).\nTemplated values ({{.RG}}, {{.Now | date \"2006-01-02\"}}...) are rendered first.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "azure"
+                ],
+                "summary": "Apply tags to the Azure resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Tags to apply",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.applyReq"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.applyResp"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/resources/{id}/history": {
+            "get": {
+                "description": "The tag changes of the resource, oldest first: updates, removals, renames and expired tags removed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resources"
+                ],
+                "summary": "Tag history of a resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.HistoryEntry"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/resources/{id}/tag-sets": {
+            "put": {
+                "description": "Replaces the tag sets the resource references, merged in that order under its own tags. The resource is marked needs_apply.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resources"
+                ],
+                "summary": "Set the tag sets of a resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Tag set names",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.resourceTagSetsReq"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Resource"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/resources/{id}/tags": {
+            "delete": {
+                "description": "Removes the keys (ignoring case) from the stored tags and marks the resource needs_apply when some were there. Nothing is pushed to Azure. Tags from tag sets or inherited stay, see GET /resources/{id}.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resources"
+                ],
+                "summary": "Remove tags from a resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated keys, e.g. owner,temp",
+                        "name": "keys",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Resource"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Merges the tags into the stored ones (templates rendered) and marks the resource needs_apply. Nothing is pushed to Azure.\nexpires makes a tag temporary (e.g. incident=INC123 for 72h); a tag set again without it becomes permanent.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resources"
+                ],
+                "summary": "Set tags on a resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Tags to set",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.updateTagsReq"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Resource"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/rules/preview": {
+            "get": {
+                "description": "Shows which rules (tags.rules) would fire for an Azure ID and the tags they would produce. The tags are the ones of the registered entry when there is one.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rules"
+                ],
+                "summary": "Preview the tagging rules",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Azure ID",
+                        "name": "azureId",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.rulePreviewResp"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tags/inventory": {
+            "get": {
+                "description": "Counts every tag key and value of the registered resources (their own tags, not the tag sets) and, with scope, of the resources found in Azure under a subscription or resource group.\nNear-duplicates (same ignoring case and separators, an abbreviation like env/environment, or at most distance edits) are suggested a canonical spelling: the most used one. mapping has them all, for POST /tags/rename.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tags"
+                ],
+                "summary": "Tag key and value inventory",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Azure ID of a subscription or resource group to count too",
+                        "name": "scope",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Max edits between near-duplicates (default 2, 0 for case and abbreviations only)",
+                        "name": "distance",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.TagInventory"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tags/rename": {
+            "post": {
+                "description": "Queues a job applying the mapping to the tags of the registered resources the selector picks (all by default): keys renamed ({\"Env\": \"env\"}, as spelled), then values remapped per key ({\"env\": {\"Prod\": \"prod\"}}).\nKeys renamed onto the same one merge when their values agree, otherwise that resource keeps them and fails in the job. Changed resources are marked needs_apply and the change is in their history. Follow it on /jobs/{id}.\ndryRun=true only returns what would change. push=true also applies the changed resources to Azure in the job, like a tag set re-apply: the old keys go and the new ones come.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tags"
+                ],
+                "summary": "Rename tag keys and remap values in bulk",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tag selector, e.g. env=prod,!owner",
+                        "name": "selector",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only show the changes",
+                        "name": "dryRun",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Apply the changed resources to Azure",
+                        "name": "push",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Mapping, e.g. the one of GET /tags/inventory",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.TagMapping"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.renamePreview"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/models.Job"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tagsets": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tagsets"
+                ],
+                "summary": "List tag sets",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.TagSet"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Stores a named set of tags (e.g. prod-baseline) at version 1. Resources reference it with tagSets.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tagsets"
+                ],
+                "summary": "Create a tag set",
+                "parameters": [
+                    {
+                        "description": "Tag set",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.tagSetReq"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.TagSet"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tagsets/{name}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tagsets"
+                ],
+                "summary": "Get a tag set",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tag set name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.TagSet"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Replaces the tags of the set and bumps its version. Every resource referencing it is marked needs_apply.\nWith reapply=true a background job applies them to Azure (202, follow it on /jobs/{id}).\nWhen the job can't be queued the update still answers 200, with queue_error set.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tagsets"
+                ],
+                "summary": "Update a tag set",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tag set name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Re-apply the marked resources",
+                        "name": "reapply",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Tag set",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.tagSetReq"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.tagSetUpdateResp"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.tagSetUpdateResp"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Only a tag set no resource references can be deleted.",
+                "tags": [
+                    "tagsets"
+                ],
+                "summary": "Delete a tag set",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tag set name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "List webhooks",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Webhook"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Events (resource.created/updated/deleted, tags.updated, azure.applying/applied/failed, drift.detected, job.updated) are POSTed to the URL as JSON, signed with HMAC-SHA256 of \"\u003ctimestamp\u003e.\u003cbody\u003e\" in X-Tagger-Signature.\nFailed deliveries are retried with backoff, then kept as dead letters. The secret is only returned here.\nURLs to loopback, private or link-local addresses are refused unless webhooks.allow_private is set.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Register a webhook",
+                "parameters": [
+                    {
+                        "description": "Webhook",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.webhookReq"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.Webhook"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/dead-letters": {
+            "get": {
+                "description": "Deliveries that were rejected or ran out of retries, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "List dead webhook deliveries",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Delivery"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/dead-letters/{id}/redeliver": {
+            "post": {
+                "description": "Sends the event again, with a fresh set of retries. 503 when the service is stopping or the delivery queue is full.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Redeliver a dead webhook delivery",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Delivery ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/models.Delivery"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/{id}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Get a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Webhook"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Deliveries still being retried are dropped.",
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Delete a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe, see the README",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "handlers.applyReq": {
+            "type": "object",
+            "properties": {
+                "tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.applyResp": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "resource": {
+                    "description": "Azure ID",
+                    "type": "string"
+                },
+                "scope": {
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.createReq": {
+            "type": "object",
+            "properties": {
+                "azureId": {
+                    "type": "string"
+                },
+                "expires": {
+                    "description": "Expires gives keys of Tags an expiry: RFC 3339 time or TTL (\"72h\")",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                },
+                "tagSets": {
+                    "description": "merged in order under Tags",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.discoverFailure": {
+            "type": "object",
+            "properties": {
+                "azure_id": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.discoverReq": {
+            "type": "object",
+            "properties": {
+                "scope": {
+                    "description": "Azure ID of a subscription or a resource group",
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.discoverResp": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.discoveredResource"
+                    }
+                },
+                "drifted": {
+                    "description": "registered, Azure tags differ from the desired ones",
+                    "type": "integer"
+                },
+                "failed": {
+                    "description": "not registered, e.g. a rule template failed on it",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.discoverFailure"
+                    }
+                },
+                "found": {
+                    "type": "integer"
+                },
+                "skipped": {
+                    "description": "already registered",
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.discoveredResource": {
+            "type": "object",
+            "properties": {
+                "azure_id": {
+                    "type": "string"
+                },
+                "create_unix": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "last_apply": {
+                    "$ref": "#/definitions/models.ApplyResult"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "needs_apply": {
+                    "description": "NeedsApply is set when the desired tags changed since the last\nsuccessful apply (tag set update, tag update, rules on discovery), so\nAzure may be behind.",
+                    "type": "boolean"
+                },
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.RuleHit"
+                    }
+                },
+                "scope": {
+                    "description": "what AzureID points to, see Scope*",
+                    "type": "string"
+                },
+                "tag_expiry": {
+                    "description": "TagExpiry is when (unix) a key of Tags expires, removed then from the\nstore and from Azure.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "tag_sets": {
+                    "description": "TagSets are merged in order under Tags, which override them.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.driftEntry": {
+            "type": "object",
+            "properties": {
+                "azure_id": {
+                    "type": "string"
+                },
+                "error": {
+                    "description": "Azure tags not read",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "removed": {
+                    "description": "only in Azure",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "set": {
+                    "description": "missing or different in Azure",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.driftResp": {
+            "type": "object",
+            "properties": {
+                "checked": {
+                    "type": "integer"
+                },
+                "drifted": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.driftEntry"
+                    }
+                }
+            }
+        },
+        "handlers.importError": {
+            "type": "object",
+            "properties": {
+                "azure_id": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "row": {
+                    "description": "line in the file",
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.importResp": {
+            "type": "object",
+            "properties": {
+                "atomic": {
+                    "description": "nothing was imported when a row failed",
+                    "type": "boolean"
+                },
+                "created": {
+                    "type": "integer"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.importError"
+                    }
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "rows": {
+                    "type": "integer"
+                },
+                "updated": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.renamePreview": {
+            "type": "object",
+            "properties": {
+                "changed": {
+                    "type": "integer"
+                },
+                "conflicts": {
+                    "description": "resources keeping keys whose values differ",
+                    "type": "integer"
+                },
+                "diffs": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.tagDiff"
+                    }
+                },
+                "matched": {
+                    "description": "resources the selector picked",
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.resourceTagSetsReq": {
+            "type": "object",
+            "properties": {
+                "tagSets": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.rulePreviewResp": {
+            "type": "object",
+            "properties": {
+                "azure_id": {
+                    "type": "string"
+                },
+                "registered": {
+                    "description": "tags are the ones of the registered entry",
+                    "type": "boolean"
+                },
+                "result": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.RuleHit"
+                    }
+                },
+                "scope": {
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.tagDiff": {
+            "type": "object",
+            "properties": {
+                "azure_id": {
+                    "type": "string"
+                },
+                "conflicts": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                },
+                "removed": {
+                    "description": "keys gone",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "set": {
+                    "description": "added or changed",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.tagSetReq": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "name": {
+                    "description": "ignored on update, the path has it",
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.tagSetUpdateResp": {
+            "type": "object",
+            "properties": {
+                "job": {
+                    "$ref": "#/definitions/models.Job"
+                },
+                "marked": {
+                    "description": "resources now needing an apply",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "queue_error": {
+                    "description": "the re-apply wasn't queued, the update is done",
+                    "type": "string"
+                },
+                "tag_set": {
+                    "$ref": "#/definitions/models.TagSet"
+                }
+            }
+        },
+        "handlers.updateTagsReq": {
+            "type": "object",
+            "properties": {
+                "expires": {
+                    "description": "RFC 3339 time or TTL (\"72h\") per key of Tags",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.webhookReq": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "description": "empty means every event",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "secret": {
+                    "description": "generated when empty",
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ApplyResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "finished_unix": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.ComplianceReport": {
+            "type": "object",
+            "properties": {
+                "compliant": {
+                    "type": "integer"
+                },
+                "generated_unix": {
+                    "type": "integer"
+                },
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.KeyCoverage"
+                    }
+                },
+                "live": {
+                    "description": "the Azure tags were checked too",
+                    "type": "boolean"
+                },
+                "non_compliant": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.NonCompliance"
+                    }
+                },
+                "percent": {
+                    "description": "0 when Total is 0",
+                    "type": "number"
+                },
+                "required_keys": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "resource_groups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.GroupCoverage"
+                    }
+                },
+                "subscriptions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.GroupCoverage"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "unchecked": {
+                    "description": "live, the Azure tags couldn't be read",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Unchecked"
+                    }
+                },
+                "unknown": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.Delivery": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
+                "created_unix": {
+                    "type": "integer"
+                },
+                "event_id": {
+                    "type": "string"
+                },
+                "event_type": {
+                    "type": "string"
+                },
+                "finished_unix": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "last_status_code": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "webhook_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.EffectiveTag": {
+            "type": "object",
+            "properties": {
+                "inherited_from": {
+                    "description": "Azure ID of the resource group",
+                    "type": "string"
+                },
+                "source": {
+                    "type": "string"
+                },
+                "tag_set": {
+                    "description": "name@version",
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.GroupCoverage": {
+            "type": "object",
+            "properties": {
+                "compliant": {
+                    "type": "integer"
+                },
+                "percent": {
+                    "description": "0 when Total is 0",
+                    "type": "number"
+                },
+                "resource_group": {
+                    "type": "string"
+                },
+                "subscription": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "unknown": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.HistoryEntry": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "actor": {
+                    "description": "identity.Caller, empty for the service itself",
+                    "type": "string"
+                },
+                "detail": {
+                    "type": "string"
+                },
+                "removed": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "tags": {
+                    "description": "set",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "unix": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.Job": {
+            "type": "object",
+            "properties": {
+                "created_unix": {
+                    "type": "integer"
+                },
+                "done": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "errors": {
+                    "description": "first failures only",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "finished_unix": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "started_unix": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.KeyCoverage": {
+            "type": "object",
+            "properties": {
+                "compliant": {
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "percent": {
+                    "description": "0 when Total is 0",
+                    "type": "number"
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "unknown": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.KeyUsage": {
+            "type": "object",
+            "properties": {
+                "azure": {
+                    "type": "integer"
+                },
+                "count": {
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "values": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ValueUsage"
+                    }
+                }
+            }
+        },
+        "models.NonCompliance": {
+            "type": "object",
+            "properties": {
+                "azure_id": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "resource_group": {
+                    "type": "string"
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "subscription": {
+                    "type": "string"
+                },
+                "violations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Violation"
+                    }
+                }
+            }
+        },
+        "models.Resource": {
+            "type": "object",
+            "properties": {
+                "azure_id": {
+                    "type": "string"
+                },
+                "create_unix": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "last_apply": {
+                    "$ref": "#/definitions/models.ApplyResult"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "needs_apply": {
+                    "description": "NeedsApply is set when the desired tags changed since the last\nsuccessful apply (tag set update, tag update, rules on discovery), so\nAzure may be behind.",
+                    "type": "boolean"
+                },
+                "scope": {
+                    "description": "what AzureID points to, see Scope*",
+                    "type": "string"
+                },
+                "tag_expiry": {
+                    "description": "TagExpiry is when (unix) a key of Tags expires, removed then from the\nstore and from Azure.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "tag_sets": {
+                    "description": "TagSets are merged in order under Tags, which override them.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.ResourceDetail": {
+            "type": "object",
+            "properties": {
                 "azure_id": {
                     "type": "string"
                 },
                 "create_unix": {
                     "type": "integer"
                 },
+                "effective_tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/models.EffectiveTag"
+                    }
+                },
                 "id": {
                     "type": "string"
                 },
+                "last_apply": {
+                    "$ref": "#/definitions/models.ApplyResult"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "needs_apply": {
+                    "description": "NeedsApply is set when the desired tags changed since the last\nsuccessful apply (tag set update, tag update, rules on discovery), so\nAzure may be behind.",
+                    "type": "boolean"
+                },
+                "scope": {
+                    "description": "what AzureID points to, see Scope*",
+                    "type": "string"
+                },
+                "tag_expiry": {
+                    "description": "TagExpiry is when (unix) a key of Tags expires, removed then from the\nstore and from Azure.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "tag_sets": {
+                    "description": "TagSets are merged in order under Tags, which override them.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "tags": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.RuleHit": {
+            "type": "object",
+            "properties": {
+                "defaulted": {
+                    "description": "keys that were missing",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "removed": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "rule": {
+                    "type": "string"
+                },
+                "set": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.TagInventory": {
+            "type": "object",
+            "properties": {
+                "azure_resources": {
+                    "description": "found in Azure under the scope asked, 0 if none",
+                    "type": "integer"
+                },
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.KeyUsage"
+                    }
+                },
+                "mapping": {
+                    "description": "Mapping has every suggestion, ready to be edited and sent to POST\n/tags/rename.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.TagMapping"
+                        }
+                    ]
+                },
+                "resources": {
+                    "description": "registered resources counted",
+                    "type": "integer"
+                },
+                "suggestions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.TagSuggestion"
+                    }
+                }
+            }
+        },
+        "models.TagMapping": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "values": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "object",
+                        "additionalProperties": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "models.TagSet": {
+            "type": "object",
+            "properties": {
+                "created_unix": {
+                    "type": "integer"
+                },
+                "description": {
+                    "type": "string"
+                },
                 "name": {
                     "type": "string"
                 },
@@ -183,6 +2593,112 @@ const docTemplate = `{
                     "additionalProperties": {
                         "type": "string"
                     }
+                },
+                "updated_unix": {
+                    "type": "integer"
+                },
+                "version": {
+                    "description": "1 on creation, +1 on every update",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.TagSuggestion": {
+            "type": "object",
+            "properties": {
+                "canonical": {
+                    "type": "string"
+                },
+                "key": {
+                    "description": "canonical key of the values",
+                    "type": "string"
+                },
+                "kind": {
+                    "description": "SuggestKey or SuggestValue",
+                    "type": "string"
+                },
+                "variants": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.Unchecked": {
+            "type": "object",
+            "properties": {
+                "azure_id": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "resource_group": {
+                    "type": "string"
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "subscription": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ValueUsage": {
+            "type": "object",
+            "properties": {
+                "azure": {
+                    "type": "integer"
+                },
+                "count": {
+                    "type": "integer"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Violation": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "source": {
+                    "description": "ViolationStore or ViolationAzure",
+                    "type": "string"
+                }
+            }
+        },
+        "models.Webhook": {
+            "type": "object",
+            "properties": {
+                "created_unix": {
+                    "type": "integer"
+                },
+                "events": {
+                    "description": "Event* types, empty = all",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "type": "string"
+                },
+                "secret": {
+                    "description": "Secret signs the deliveries (HMAC-SHA256), only returned on creation.",
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
                 }
             }
         }
@@ -199,8 +2715,8 @@ var SwaggerInfo = &swag.Spec{
 	Description:      "REST API that manages Azure resource metadata and applies tags using Azure SDK.\nThis project demonstrates:\n- Clean architecture\n- Dependency injection\n- Unit testing with mocks\n- Docker containerization\n- CI/CD with GitHub Actions",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
-	LeftDelim:        "{{",
-	RightDelim:       "}}",
+	LeftDelim:        "[[",
+	RightDelim:       "]]",
 }
 
 func init() {
@@ -0,0 +1,44 @@
+package azure
+
+import (
+	"net/url"
+
+	_ "github.com/Azure/azure-sdk-for-go/sdk/azcore/arm/runtime" // fills the ResourceManager entry of the known clouds
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+)
+
+// cloudConfig returns the SDK cloud of the config. Both the credentials (for
+// the authority host) and the ARM clients (for the endpoint) must use it.
+func cloudConfig(cfg config.Azure) cloud.Configuration {
+	switch cfg.Cloud {
+	case config.CloudGovernment:
+		return cloud.AzureGovernment
+	case config.CloudChina:
+		return cloud.AzureChina
+	case config.CloudCustom:
+		audience := cfg.ARMAudience
+		if audience == "" {
+			audience = cfg.ARMEndpoint
+		}
+		return cloud.Configuration{
+			ActiveDirectoryAuthorityHost: cfg.AuthorityHost,
+			Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+				cloud.ResourceManager: {Endpoint: cfg.ARMEndpoint, Audience: audience},
+			},
+		}
+	}
+	return cloud.AzurePublic
+}
+
+// armScope is the token scope of ARM in a cloud, built like the SDK does.
+func armScope(c cloud.Configuration) string {
+	return c.Services[cloud.ResourceManager].Audience + "/.default"
+}
+
+// insecureHTTP tells whether the ARM endpoint is plain http. config.Validate
+// only allows that on loopback, for a local fake ARM.
+func insecureHTTP(c cloud.Configuration) bool {
+	u, err := url.Parse(c.Services[cloud.ResourceManager].Endpoint)
+	return err == nil && u.Scheme == "http"
+}
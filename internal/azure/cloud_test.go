@@ -0,0 +1,66 @@
+package azure
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+)
+
+func TestCloudConfig_TableDriven(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       config.Azure
+		authority string
+		endpoint  string
+		scope     string
+		http      bool
+	}{
+		{"public", config.Azure{Cloud: config.CloudPublic},
+			"https://login.microsoftonline.com/", "https://management.azure.com", "https://management.core.windows.net//.default", false},
+		{"government", config.Azure{Cloud: config.CloudGovernment},
+			"https://login.microsoftonline.us/", "https://management.usgovcloudapi.net", "https://management.core.usgovcloudapi.net/.default", false},
+		{"china", config.Azure{Cloud: config.CloudChina},
+			"https://login.chinacloudapi.cn/", "https://management.chinacloudapi.cn", "https://management.core.chinacloudapi.cn/.default", false},
+		{"custom, audience defaults to endpoint", config.Azure{Cloud: config.CloudCustom, ARMEndpoint: "http://127.0.0.1:8443", AuthorityHost: "https://login.example.com/"},
+			"https://login.example.com/", "http://127.0.0.1:8443", "http://127.0.0.1:8443/.default", true},
+		{"custom audience", config.Azure{Cloud: config.CloudCustom, ARMEndpoint: "https://arm.example.com", ARMAudience: "https://arm.example.com/audience", AuthorityHost: "https://login.example.com/"},
+			"https://login.example.com/", "https://arm.example.com", "https://arm.example.com/audience/.default", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := cloudConfig(tc.cfg)
+			if c.ActiveDirectoryAuthorityHost != tc.authority {
+				t.Fatalf("expected authority %q, got %q", tc.authority, c.ActiveDirectoryAuthorityHost)
+			}
+			if got := c.Services[cloud.ResourceManager].Endpoint; got != tc.endpoint {
+				t.Fatalf("expected endpoint %q, got %q", tc.endpoint, got)
+			}
+			if got := armScope(c); got != tc.scope {
+				t.Fatalf("expected scope %q, got %q", tc.scope, got)
+			}
+			if got := insecureHTTP(c); got != tc.http {
+				t.Fatalf("expected insecure http %v, got %v", tc.http, got)
+			}
+		})
+	}
+}
+
+func TestNewTagger_UsesCloud(t *testing.T) {
+	cfg := config.Default().Azure
+	cfg.ResourceAPIVersion = "2021-04-01"
+	cfg.Cloud = config.CloudGovernment
+
+	tg, err := NewTagger(cfg)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	opts := tg.clientOptions()
+	if opts.Cloud.ActiveDirectoryAuthorityHost != cloud.AzureGovernment.ActiveDirectoryAuthorityHost {
+		t.Fatalf("expected the government cloud in the client options, got %+v", opts.Cloud)
+	}
+	if opts.InsecureAllowCredentialWithHTTP {
+		t.Fatal("expected https only outside a custom http endpoint")
+	}
+}
@@ -0,0 +1,119 @@
+package azure
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+)
+
+// DefaultProfile names the credential used for subscriptions no profile lists.
+const DefaultProfile = "default"
+
+// credentials picks the identity of a subscription: the profile listing it,
+// or the default credential.
+type credentials struct {
+	def      azcore.TokenCredential
+	profiles map[string]azcore.TokenCredential // profile name -> credential
+	bySub    map[string]string                 // lowercased subscription -> profile name
+	order    []string                          // profile names, config order
+	subs     []string                          // subscriptions of all profiles, config order
+}
+
+func newCredentials(cfg config.Azure) (*credentials, error) {
+	opts := azcore.ClientOptions{Cloud: cloudConfig(cfg)}
+	def, err := newCredential(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &credentials{
+		def:      def,
+		profiles: make(map[string]azcore.TokenCredential, len(cfg.Profiles)),
+		bySub:    make(map[string]string),
+	}
+	for _, p := range cfg.Profiles {
+		cred, err := newProfileCredential(p, opts)
+		if err != nil {
+			return nil, fmt.Errorf("credential profile %q: %w", p.Name, err)
+		}
+		c.profiles[p.Name] = cred
+		c.order = append(c.order, p.Name)
+		for _, sub := range p.Subscriptions {
+			c.bySub[strings.ToLower(sub)] = p.Name
+			c.subs = append(c.subs, sub)
+		}
+	}
+	return c, nil
+}
+
+// newCredential uses the service principal of the config when there is one,
+// DefaultAzureCredential (managed identity, az login...) otherwise.
+func newCredential(cfg config.Azure, opts azcore.ClientOptions) (azcore.TokenCredential, error) {
+	if cfg.ClientSecret != "" {
+		return azidentity.NewClientSecretCredential(cfg.TenantID, cfg.ClientID, cfg.ClientSecret,
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: opts})
+	}
+	return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: opts})
+}
+
+// newProfileCredential builds the credential of a profile. opts carries the
+// cloud, so the token comes from the authority of the configured cloud.
+func newProfileCredential(p config.Profile, opts azcore.ClientOptions) (azcore.TokenCredential, error) {
+	switch p.Type {
+	case config.ProfileClientSecret:
+		return azidentity.NewClientSecretCredential(p.TenantID, p.ClientID, p.ClientSecret,
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: opts})
+
+	case config.ProfileClientCertificate:
+		data, err := os.ReadFile(p.CertificatePath)
+		if err != nil {
+			return nil, err
+		}
+		var password []byte
+		if p.CertificatePassword != "" {
+			password = []byte(p.CertificatePassword)
+		}
+		certs, key, err := azidentity.ParseCertificates(data, password)
+		if err != nil {
+			return nil, err
+		}
+		return azidentity.NewClientCertificateCredential(p.TenantID, p.ClientID, certs, key,
+			&azidentity.ClientCertificateCredentialOptions{ClientOptions: opts})
+
+	case config.ProfileWorkloadIdentity:
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: opts,
+			TenantID:      p.TenantID,
+			ClientID:      p.ClientID,
+			TokenFilePath: p.TokenFilePath, // empty = AZURE_FEDERATED_TOKEN_FILE
+		})
+
+	case config.ProfileManagedIdentity:
+		return azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ClientOptions: opts,
+			ID:            azidentity.ClientID(p.ClientID),
+		})
+	}
+	return nil, fmt.Errorf("unknown profile type %q", p.Type)
+}
+
+// forSubscription returns the profile name and credential of a subscription.
+func (c *credentials) forSubscription(sub string) (string, azcore.TokenCredential) {
+	if name, ok := c.bySub[strings.ToLower(sub)]; ok {
+		return name, c.profiles[name]
+	}
+	return DefaultProfile, c.def
+}
+
+// byName returns the credential of a profile (DefaultProfile included).
+func (c *credentials) byName(name string) (azcore.TokenCredential, bool) {
+	if name == DefaultProfile {
+		return c.def, true
+	}
+	cred, ok := c.profiles[name]
+	return cred, ok
+}
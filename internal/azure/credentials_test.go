@@ -0,0 +1,54 @@
+package azure
+
+import (
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+)
+
+func TestCredentials_ForSubscription(t *testing.T) {
+	cfg := config.Default().Azure
+	cfg.Profiles = []config.Profile{{
+		Name:          "partner",
+		Type:          config.ProfileClientSecret,
+		TenantID:      "33333333-3333-3333-3333-333333333333",
+		ClientID:      "44444444-4444-4444-4444-444444444444",
+		ClientSecret:  "s3cr3t",
+		Subscriptions: []string{"AAAAAAAA-1111-1111-1111-111111111111"},
+	}}
+
+	creds, err := newCredentials(cfg)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		sub  string
+		want string
+	}{
+		{"aaaaaaaa-1111-1111-1111-111111111111", "partner"},
+		{"bbbbbbbb-1111-1111-1111-111111111111", DefaultProfile},
+	}
+	for _, tc := range tests {
+		name, cred := creds.forSubscription(tc.sub)
+		if name != tc.want {
+			t.Fatalf("subscription %s: expected profile %q, got %q", tc.sub, tc.want, name)
+		}
+		if want, _ := creds.byName(tc.want); cred != want {
+			t.Fatalf("subscription %s: expected the credential of profile %q", tc.sub, tc.want)
+		}
+	}
+}
+
+func TestNewCredentials_BadCertificate(t *testing.T) {
+	cfg := config.Default().Azure
+	cfg.Profiles = []config.Profile{{
+		Name: "cert", Type: config.ProfileClientCertificate,
+		TenantID: "t", ClientID: "c", CertificatePath: "/does/not/exist.pem",
+		Subscriptions: []string{"aaaaaaaa-1111-1111-1111-111111111111"},
+	}}
+
+	if _, err := newCredentials(cfg); err == nil {
+		t.Fatal("expected missing certificate to fail")
+	}
+}
@@ -0,0 +1,71 @@
+package azure
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+var (
+	ErrInvalidResourceID      = errors.New("invalid azure resource id")
+	ErrSubscriptionNotAllowed = errors.New("subscription is not in the allowed list")
+)
+
+// SubscriptionOf returns the subscription a full Azure resource ID lives in.
+func SubscriptionOf(resourceID string) (string, error) {
+	id, err := arm.ParseResourceID(resourceID)
+	if err != nil || id.SubscriptionID == "" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidResourceID, resourceID)
+	}
+	return id.SubscriptionID, nil
+}
+
+// ScopeOf tells whether an Azure ID is a subscription, a resource group or
+// a resource (models.Scope*). Anything below a subscription that isn't a
+// resource group is a resource, nested ones included.
+func ScopeOf(azureID string) (string, error) {
+	id, err := arm.ParseResourceID(azureID)
+	if err != nil || id.SubscriptionID == "" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidResourceID, azureID)
+	}
+	switch {
+	case strings.EqualFold(id.ResourceType.String(), arm.SubscriptionResourceType.String()):
+		return models.ScopeSubscription, nil
+	case strings.EqualFold(id.ResourceType.String(), arm.ResourceGroupResourceType.String()):
+		return models.ScopeResourceGroup, nil
+	}
+	return models.ScopeResource, nil
+}
+
+// ResourceGroupOf returns the Azure ID of the resource group a resource lives
+// in, false for subscriptions, resource groups and subscription-level resources.
+func ResourceGroupOf(azureID string) (string, bool) {
+	id, err := arm.ParseResourceID(azureID)
+	if err != nil || id.ResourceGroupName == "" ||
+		strings.EqualFold(id.ResourceType.String(), arm.ResourceGroupResourceType.String()) {
+		return "", false
+	}
+	return "/subscriptions/" + id.SubscriptionID + "/resourceGroups/" + id.ResourceGroupName, true
+}
+
+// Authorize returns the subscription of resourceID, or ErrSubscriptionNotAllowed
+// when allowed is not empty and doesn't contain it. It never calls Azure, so
+// handlers use it to reject a request up front.
+func Authorize(allowed []string, resourceID string) (string, error) {
+	sub, err := SubscriptionOf(resourceID)
+	if err != nil {
+		return "", err
+	}
+	if len(allowed) == 0 {
+		return sub, nil
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(a, sub) { // GUIDs, case doesn't matter
+			return sub, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrSubscriptionNotAllowed, sub)
+}
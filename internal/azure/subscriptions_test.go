@@ -0,0 +1,82 @@
+package azure
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+func TestAuthorize_TableDriven(t *testing.T) {
+	const vm = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1"
+
+	tests := []struct {
+		name    string
+		allowed []string
+		id      string
+		wantSub string
+		wantErr error
+	}{
+		{"no allowlist", nil, vm, "11111111-1111-1111-1111-111111111111", nil},
+		{"allowed, different case", []string{"11111111-1111-1111-1111-11111111111A", "11111111-1111-1111-1111-111111111111"}, vm, "11111111-1111-1111-1111-111111111111", nil},
+		{"not allowed", []string{"22222222-2222-2222-2222-222222222222"}, vm, "", ErrSubscriptionNotAllowed},
+		{"subscription scope", nil, "/subscriptions/33333333-3333-3333-3333-333333333333", "33333333-3333-3333-3333-333333333333", nil},
+		{"not an azure id", nil, "vm-1", "", ErrInvalidResourceID},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sub, err := Authorize(tc.allowed, tc.id)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if sub != tc.wantSub {
+				t.Fatalf("expected subscription %q, got %q", tc.wantSub, sub)
+			}
+		})
+	}
+}
+
+func TestScopeOf_TableDriven(t *testing.T) {
+	tests := []struct {
+		id      string
+		want    string
+		wantErr error
+	}{
+		{"/subscriptions/11111111-1111-1111-1111-111111111111", models.ScopeSubscription, nil},
+		{"/subscriptions/11111111-1111-1111-1111-111111111111/resourcegroups/rg-app", models.ScopeResourceGroup, nil},
+		{"/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app/providers/Microsoft.Compute/virtualMachines/vm-1", models.ScopeResource, nil},
+		{"/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app/providers/Microsoft.Sql/servers/s/databases/db", models.ScopeResource, nil},
+		{"/providers/Microsoft.Management/managementGroups/mg", "", ErrInvalidResourceID},
+		{"rg-app", "", ErrInvalidResourceID},
+	}
+
+	for _, tc := range tests {
+		got, err := ScopeOf(tc.id)
+		if !errors.Is(err, tc.wantErr) || got != tc.want {
+			t.Fatalf("%s: expected %q/%v, got %q/%v", tc.id, tc.want, tc.wantErr, got, err)
+		}
+	}
+}
+
+func TestResourceGroupOf(t *testing.T) {
+	const rg = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app"
+
+	tests := []struct {
+		id     string
+		want   string
+		wantOK bool
+	}{
+		{rg + "/providers/Microsoft.Compute/virtualMachines/vm-1", rg, true},
+		{rg + "/providers/Microsoft.Sql/servers/s/databases/db", rg, true},
+		{rg, "", false},
+		{"/subscriptions/11111111-1111-1111-1111-111111111111", "", false},
+		{"vm-1", "", false},
+	}
+	for _, tc := range tests {
+		got, ok := ResourceGroupOf(tc.id)
+		if got != tc.want || ok != tc.wantOK {
+			t.Fatalf("%s: expected %q/%v, got %q/%v", tc.id, tc.want, tc.wantOK, got, ok)
+		}
+	}
+}
@@ -3,39 +3,105 @@ package azure
 import (
 	"context"
 	"errors"
-	"os"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/tracing/azotel"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/metrics"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
-var ErrMissingSubscription = errors.New("AZURE_SUBSCRIPTION_ID is missing")
+var ErrMissingSubscription = errors.New("no subscription configured: set AZURE_SUBSCRIPTION_ID or AZURE_ALLOWED_SUBSCRIPTIONS")
 
+var tracer = otel.Tracer("github.com/ThiagoScheffer/azure-tagger-api/internal/azure")
+
+// Tagger applies tags through ARM. The subscription of each call comes from
+// the resource ID, with one set of ARM clients per subscription (created on
+// first use and reused, so the pipeline and the token cache are shared).
 type Tagger struct {
-	subscriptionID string
-	apiVersion     string
+	apiVersion string
+	allowed    []string
+	defaultSub string // only used by the readiness checks
+	creds      *credentials
+	cloud      cloud.Configuration
+
+	mu    sync.Mutex
+	bySub map[string]*subClients
 }
 
-func NewTagger(apiVersion string) (*Tagger, error) {
-	sub := os.Getenv("AZURE_SUBSCRIPTION_ID")
-	if sub == "" {
-		return nil, ErrMissingSubscription
-	}
-	if apiVersion == "" {
+func NewTagger(cfg config.Azure) (*Tagger, error) {
+	if cfg.ResourceAPIVersion == "" {
 		return nil, errors.New("apiVersion is missing")
 	}
-	return &Tagger{subscriptionID: sub, apiVersion: apiVersion}, nil
+	creds, err := newCredentials(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Tagger{
+		apiVersion: cfg.ResourceAPIVersion,
+		allowed:    cfg.AllowedSubscriptions,
+		defaultSub: cfg.SubscriptionID,
+		creds:      creds,
+		cloud:      cloudConfig(cfg),
+		bySub:      make(map[string]*subClients),
+	}, nil
 }
 
-// ApplyTags applies tags to a resourceID (full Azure resource ID).
-func (t *Tagger) ApplyTags(ctx context.Context, resourceID string, tags map[string]string) error {
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+// ApplyTags replaces the tags of resourceID, a full Azure ID of a resource,
+// a resource group or a subscription.
+func (t *Tagger) ApplyTags(ctx context.Context, resourceID string, tags map[string]string) (err error) {
+	ctx, span := tracer.Start(ctx, "azure.ApplyTags")
+	span.SetAttributes(
+		attribute.String("azure.resource_id", resourceID),
+		attribute.Int("azure.tag_count", len(tags)),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, metrics.ErrorClass(err))
+		}
+		span.End()
+	}()
+
+	// checked again here even if the handler already did: nothing may reach
+	// ARM for a subscription outside the allowlist
+	sub, err := Authorize(t.allowed, resourceID)
 	if err != nil {
 		return err
 	}
+	span.SetAttributes(attribute.String("azure.subscription_id", sub))
 
-	client, err := armresources.NewClient(t.subscriptionID, cred, nil)
+	profile, _ := t.creds.forSubscription(sub)
+	span.SetAttributes(attribute.String("azure.credential_profile", profile))
+	ctx = logging.WithContext(ctx, logging.FromContext(ctx).With(
+		slog.String("azure_id", resourceID),
+		slog.String("subscription_id", sub),
+		slog.String("credential_profile", profile),
+	))
+
+	scope, err := ScopeOf(resourceID)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.String("azure.scope", scope))
+
+	clients, err := t.clients(sub)
 	if err != nil {
 		return err
 	}
@@ -45,15 +111,326 @@ func (t *Tagger) ApplyTags(ctx context.Context, resourceID string, tags map[stri
 		azureTags[k] = to.Ptr(v)
 	}
 
-	poller, err := client.BeginUpdateByID(ctx, resourceID, t.apiVersion, armresources.GenericResource{
+	// subscriptions and resource groups have no api-version of their own to
+	// PATCH, they go through the Tags API (PUT replaces, like the PATCH below)
+	if scope != models.ScopeResource {
+		start := time.Now()
+		_, err = clients.tags.CreateOrUpdateAtScope(ctx, resourceID, armresources.TagsResource{
+			Properties: &armresources.Tags{Tags: azureTags},
+		}, nil)
+		observe(ctx, "tags_create_or_update_at_scope", sub, start, err)
+		return err
+	}
+
+	start := time.Now()
+	poller, err := clients.resources.BeginUpdateByID(ctx, resourceID, t.apiVersion, armresources.GenericResource{
 		Tags: azureTags,
 	}, nil)
+	observe(ctx, "update_by_id", sub, start, err)
 	if err != nil {
 		return err
 	}
 
-	_, err = poller.PollUntilDone(ctx, nil)
+	pollCtx, pollSpan := tracer.Start(ctx, "azure.PollUntilDone")
+	start = time.Now()
+	_, err = poller.PollUntilDone(pollCtx, nil)
+	observe(ctx, "update_by_id_poll", sub, start, err)
+	pollSpan.End()
 	return err
 }
 
+// RemoveTags deletes tags from resourceID (any scope) through the Tags API.
+// Only the exact key/value pairs go: a tag changed in Azure since is kept.
+func (t *Tagger) RemoveTags(ctx context.Context, resourceID string, tags map[string]string) (err error) {
+	ctx, span := tracer.Start(ctx, "azure.RemoveTags")
+	span.SetAttributes(
+		attribute.String("azure.resource_id", resourceID),
+		attribute.Int("azure.tag_count", len(tags)),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, metrics.ErrorClass(err))
+		}
+		span.End()
+	}()
+
+	sub, err := Authorize(t.allowed, resourceID)
+	if err != nil {
+		return err
+	}
+	clients, err := t.clients(sub)
+	if err != nil {
+		return err
+	}
+	ctx = logging.WithContext(ctx, logging.FromContext(ctx).With(slog.String("azure_id", resourceID)))
+
+	azureTags := make(map[string]*string, len(tags))
+	for k, v := range tags {
+		azureTags[k] = to.Ptr(v)
+	}
+	start := time.Now()
+	_, err = clients.tags.UpdateAtScope(ctx, resourceID, armresources.TagsPatchResource{
+		Operation:  to.Ptr(armresources.TagsPatchOperationDelete),
+		Properties: &armresources.Tags{Tags: azureTags},
+	}, nil)
+	observe(ctx, "tags_delete_at_scope", sub, start, err)
+	return err
+}
+
+// GetTags returns the current Azure tags of scopeID (any scope) through the
+// Tags API.
+func (t *Tagger) GetTags(ctx context.Context, scopeID string) (tags map[string]string, err error) {
+	ctx, span := tracer.Start(ctx, "azure.GetTags")
+	span.SetAttributes(attribute.String("azure.resource_id", scopeID))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, metrics.ErrorClass(err))
+		}
+		span.End()
+	}()
+
+	sub, err := Authorize(t.allowed, scopeID)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := t.clients(sub)
+	if err != nil {
+		return nil, err
+	}
+	ctx = logging.WithContext(ctx, logging.FromContext(ctx).With(slog.String("azure_id", scopeID)))
+
+	start := time.Now()
+	resp, err := clients.tags.GetAtScope(ctx, scopeID, nil)
+	observe(ctx, "tags_get_at_scope", sub, start, err)
+	if err != nil {
+		return nil, err
+	}
+	tags = map[string]string{}
+	if resp.Properties != nil {
+		for k, v := range resp.Properties.Tags {
+			if v != nil {
+				tags[k] = *v
+			}
+		}
+	}
+	return tags, nil
+}
+
+// Discovered is a resource found in Azure by ListResources.
+type Discovered struct {
+	ID   string
+	Tags map[string]string
+}
+
+// ListResources lists the resources of a subscription or a resource group
+// (scopeID) with their current Azure tags.
+func (t *Tagger) ListResources(ctx context.Context, scopeID string) (found []Discovered, err error) {
+	ctx, span := tracer.Start(ctx, "azure.ListResources")
+	span.SetAttributes(attribute.String("azure.scope_id", scopeID))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, metrics.ErrorClass(err))
+		}
+		span.SetAttributes(attribute.Int("azure.resource_count", len(found)))
+		span.End()
+	}()
+
+	sub, err := Authorize(t.allowed, scopeID)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := ScopeOf(scopeID)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := t.clients(sub)
+	if err != nil {
+		return nil, err
+	}
+	ctx = logging.WithContext(ctx, logging.FromContext(ctx).With(slog.String("azure_id", scopeID)))
+
+	start := time.Now()
+	switch scope {
+	case models.ScopeSubscription:
+		found, err = collect(ctx, clients.resources.NewListPager(nil),
+			func(p armresources.ClientListResponse) []*armresources.GenericResourceExpanded { return p.Value })
+	case models.ScopeResourceGroup:
+		id, _ := arm.ParseResourceID(scopeID)
+		found, err = collect(ctx, clients.resources.NewListByResourceGroupPager(id.ResourceGroupName, nil),
+			func(p armresources.ClientListByResourceGroupResponse) []*armresources.GenericResourceExpanded {
+				return p.Value
+			})
+	default:
+		return nil, fmt.Errorf("%w: can only list a subscription or a resource group", ErrInvalidResourceID)
+	}
+	observe(ctx, "list_resources", sub, start, err)
+	return found, err
+}
+
+func collect[T any](ctx context.Context, pager *runtime.Pager[T], page func(T) []*armresources.GenericResourceExpanded) ([]Discovered, error) {
+	var found []Discovered
+	for pager.More() {
+		p, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page(p) {
+			if r == nil || r.ID == nil {
+				continue
+			}
+			d := Discovered{ID: *r.ID, Tags: make(map[string]string, len(r.Tags))}
+			for k, v := range r.Tags {
+				if v != nil {
+					d.Tags[k] = *v
+				}
+			}
+			found = append(found, d)
+		}
+	}
+	return found, nil
+}
+
+// subClients are the ARM clients of one subscription.
+type subClients struct {
+	resources *armresources.Client
+	tags      *armresources.TagsClient
+}
+
+// clients returns the ARM clients of a subscription, creating them on first
+// use with the credential of the subscription's profile.
+func (t *Tagger) clients(sub string) (*subClients, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c, ok := t.bySub[sub]; ok {
+		return c, nil
+	}
+	_, cred := t.creds.forSubscription(sub)
+	resources, err := armresources.NewClient(sub, observedCredential{cred, sub}, t.clientOptions())
+	if err != nil {
+		return nil, err
+	}
+	tags, err := armresources.NewTagsClient(sub, observedCredential{cred, sub}, t.clientOptions())
+	if err != nil {
+		return nil, err
+	}
+	c := &subClients{resources: resources, tags: tags}
+	t.bySub[sub] = c
+	return c, nil
+}
+
+// Subscriptions lists the subscriptions the readiness check verifies: the
+// allowlist, the default subscription and the ones of every profile.
+func (t *Tagger) Subscriptions() []string {
+	candidates := slices.Clone(t.allowed)
+	candidates = append(candidates, t.defaultSub)
+	candidates = append(candidates, t.creds.subs...)
+
+	var subs []string
+	for _, sub := range candidates {
+		if sub != "" && !slices.ContainsFunc(subs, func(s string) bool { return strings.EqualFold(s, sub) }) {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// Profiles lists the credential profiles, DefaultProfile first.
+func (t *Tagger) Profiles() []string {
+	return append([]string{DefaultProfile}, t.creds.order...)
+}
+
+// CheckToken verifies that a token for ARM can be acquired with a profile,
+// i.e. the credential is configured and its tenant answers. Used by /readyz.
+func (t *Tagger) CheckToken(ctx context.Context, profile string) error {
+	cred, ok := t.creds.byName(profile)
+	if !ok {
+		return fmt.Errorf("unknown credential profile %q", profile)
+	}
+	_, err := observedCredential{cred, ""}.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{armScope(t.cloud)}})
+	return err
+}
+
+// CheckSubscription verifies that every configured subscription exists and
+// that our identity can read it, by listing at most one resource group.
+func (t *Tagger) CheckSubscription(ctx context.Context) error {
+	subs := t.Subscriptions()
+	if len(subs) == 0 {
+		return ErrMissingSubscription
+	}
+
+	var errs []error
+	for _, sub := range subs {
+		_, cred := t.creds.forSubscription(sub)
+		client, err := armresources.NewResourceGroupsClient(sub, observedCredential{cred, sub}, t.clientOptions())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		start := time.Now()
+		pager := client.NewListPager(&armresources.ResourceGroupsClientListOptions{Top: to.Ptr[int32](1)})
+		_, err = pager.NextPage(ctx)
+		observe(ctx, "list_resource_groups", sub, start, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("subscription %s: %w", sub, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// observe records the outcome of one Azure operation in the metrics and the log.
+// The token itself never reaches the log, only whether we got one.
+func observe(ctx context.Context, op, subscriptionID string, start time.Time, err error) {
+	metrics.ObserveAzure(op, subscriptionID, start, err)
+
+	attrs := []slog.Attr{
+		slog.String("op", op),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error_class", metrics.ErrorClass(err)), slog.String("error", err.Error()))
+		logging.FromContext(ctx).LogAttrs(ctx, slog.LevelError, "azure operation failed", attrs...)
+		return
+	}
+	logging.FromContext(ctx).LogAttrs(ctx, slog.LevelInfo, "azure operation succeeded", attrs...)
+}
+
+// clientOptions points the SDK pipeline at the configured cloud and plugs it
+// into our tracer provider, so every ARM request and LRO poll shows up as a
+// child span of azure.ApplyTags.
+func (t *Tagger) clientOptions() *arm.ClientOptions {
+	return &arm.ClientOptions{
+		ClientOptions: policy.ClientOptions{
+			Cloud:                           t.cloud,
+			InsecureAllowCredentialWithHTTP: insecureHTTP(t.cloud),
+			TracingProvider:                 azotel.NewTracingProvider(otel.GetTracerProvider(), nil),
+		},
+	}
+}
+
+// observedCredential times token acquisition separately from the ARM calls,
+// so a slow AAD doesn't look like a slow ARM.
+type observedCredential struct {
+	cred           azcore.TokenCredential
+	subscriptionID string
+}
+
+func (c observedCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	ctx, span := tracer.Start(ctx, "azure.GetToken")
+	defer span.End()
+
+	start := time.Now()
+	tok, err := c.cred.GetToken(ctx, opts)
+	observe(ctx, "get_token", c.subscriptionID, start, err)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, metrics.ErrorClass(err))
+	}
+	return tok, err
+}
+
 //obs new sdk version has different method signature for ApplyTags
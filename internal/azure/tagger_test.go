@@ -0,0 +1,239 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/fakearm"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/metrics"
+)
+
+const (
+	testSub = "11111111-1111-1111-1111-111111111111"
+	testVM  = "/subscriptions/" + testSub + "/resourceGroups/rg-app/providers/Microsoft.Compute/virtualMachines/vm-1"
+)
+
+// newFakeTagger returns the real Tagger talking to a fake ARM, with a fake
+// credential in place of the default one.
+func newFakeTagger(t *testing.T, allowed ...string) (*Tagger, *fakearm.Server, *fakearm.Credential) {
+	t.Helper()
+	srv := fakearm.NewServer()
+	t.Cleanup(srv.Close)
+
+	cfg := config.Default().Azure
+	cfg.ResourceAPIVersion = "2021-04-01"
+	cfg.SubscriptionID = testSub
+	cfg.AllowedSubscriptions = allowed
+	cfg.Cloud = config.CloudCustom
+	cfg.ARMEndpoint = srv.URL
+	cfg.AuthorityHost = "https://login.fake.local/"
+
+	tg, err := NewTagger(cfg)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	cred := &fakearm.Credential{}
+	tg.creds.def = cred
+	return tg, srv, cred
+}
+
+func TestTagger_ApplyTags_FakeARM(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*fakearm.Server)
+	}{
+		{"synchronous", func(*fakearm.Server) {}},
+		{"long-running", func(s *fakearm.Server) { s.SetLRO(3, 5*time.Millisecond) }},
+		{"throttled then ok", func(s *fakearm.Server) {
+			s.Inject(fakearm.Fault{Method: http.MethodPatch, Status: http.StatusTooManyRequests, Times: 2})
+		}},
+		{"5xx then ok", func(s *fakearm.Server) {
+			s.Inject(fakearm.Fault{Method: http.MethodPatch, Status: http.StatusInternalServerError, Times: 1})
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tg, srv, cred := newFakeTagger(t)
+			srv.Add(testVM, map[string]string{"old": "tag"})
+			tc.setup(srv)
+
+			want := map[string]string{"env": "prod", "owner": "ana"}
+			if err := tg.ApplyTags(context.Background(), testVM, want); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if got, _ := srv.Tags(testVM); !maps.Equal(got, want) {
+				t.Fatalf("expected ARM tags %v, got %v", want, got)
+			}
+			if scopes := cred.Scopes(); len(scopes) != 1 || scopes[0] != srv.URL+"/.default" {
+				t.Fatalf("expected a token for the custom cloud, got %v", scopes)
+			}
+		})
+	}
+}
+
+func TestTagger_ApplyTags_Scopes_FakeARM(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		via  string
+	}{
+		{"subscription", "/subscriptions/" + testSub, "PUT /subscriptions/" + testSub + "/providers/Microsoft.Resources/tags/default"},
+		{"resource group", "/subscriptions/" + testSub + "/resourceGroups/rg-app", "PUT /subscriptions/" + testSub + "/resourceGroups/rg-app/providers/Microsoft.Resources/tags/default"},
+		{"resource", testVM, "PATCH " + testVM},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tg, srv, _ := newFakeTagger(t)
+			srv.Add(testVM, nil)
+			srv.Add(tc.id, map[string]string{"old": "tag"})
+
+			want := map[string]string{"cost-center": "42"}
+			if err := tg.ApplyTags(context.Background(), tc.id, want); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if got, _ := srv.Tags(tc.id); !maps.Equal(got, want) {
+				t.Fatalf("expected ARM tags %v, got %v", want, got)
+			}
+			if reqs := srv.Requests(); !slices.Contains(reqs, tc.via) {
+				t.Fatalf("expected %q, got %v", tc.via, reqs)
+			}
+		})
+	}
+}
+
+func TestTagger_ApplyTags_FakeARM_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*fakearm.Server)
+		class string
+	}{
+		{"conflict", func(s *fakearm.Server) {
+			s.Add(testVM, nil)
+			s.Inject(fakearm.Fault{Method: http.MethodPatch, Status: http.StatusConflict})
+		}, "conflict"},
+		{"missing resource", func(*fakearm.Server) {}, "not_found"},
+		{"slow LRO past the deadline", func(s *fakearm.Server) {
+			s.Add(testVM, nil)
+			s.SetLRO(100, 50*time.Millisecond)
+		}, "timeout"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tg, srv, _ := newFakeTagger(t)
+			tc.setup(srv)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+			err := tg.ApplyTags(ctx, testVM, map[string]string{"env": "prod"})
+			if got := metrics.ErrorClass(err); got != tc.class {
+				t.Fatalf("expected error class %q, got %q (%v)", tc.class, got, err)
+			}
+		})
+	}
+}
+
+func TestTagger_ApplyTags_NotAllowedNeverReachesARM(t *testing.T) {
+	tg, srv, _ := newFakeTagger(t, "22222222-2222-2222-2222-222222222222")
+	srv.Add(testVM, nil)
+
+	err := tg.ApplyTags(context.Background(), testVM, map[string]string{"env": "prod"})
+	if !errors.Is(err, ErrSubscriptionNotAllowed) {
+		t.Fatalf("expected ErrSubscriptionNotAllowed, got %v", err)
+	}
+	if reqs := srv.Requests(); len(reqs) != 0 {
+		t.Fatalf("expected no ARM request, got %v", reqs)
+	}
+}
+
+func TestTagger_ReadinessChecks_FakeARM(t *testing.T) {
+	tg, srv, cred := newFakeTagger(t)
+	srv.Add("/subscriptions/"+testSub+"/resourceGroups/rg-app", nil)
+	ctx := context.Background()
+
+	if err := tg.CheckToken(ctx, DefaultProfile); err != nil {
+		t.Fatalf("expected token check to pass, got %v", err)
+	}
+	if err := tg.CheckSubscription(ctx); err != nil {
+		t.Fatalf("expected subscription check to pass, got %v", err)
+	}
+
+	cred.Err = errors.New("aad is down")
+	if err := tg.CheckToken(ctx, DefaultProfile); err == nil {
+		t.Fatal("expected token check to fail")
+	}
+}
+
+func TestTagger_ListResources_FakeARM(t *testing.T) {
+	tg, srv, _ := newFakeTagger(t)
+	srv.Add(testVM, map[string]string{"env": "prod"})
+	srv.Add("/subscriptions/"+testSub+"/resourceGroups/rg-app/providers/Microsoft.Storage/storageAccounts/st1", nil)
+	srv.Add("/subscriptions/"+testSub+"/resourceGroups/rg-other/providers/Microsoft.Web/sites/web", nil)
+
+	tests := []struct {
+		name  string
+		scope string
+		want  int
+	}{
+		{"subscription", "/subscriptions/" + testSub, 3},
+		{"resource group", "/subscriptions/" + testSub + "/resourceGroups/rg-app", 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			found, err := tg.ListResources(context.Background(), tc.scope)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(found) != tc.want {
+				t.Fatalf("expected %d resources, got %+v", tc.want, found)
+			}
+			i := slices.IndexFunc(found, func(d Discovered) bool { return d.ID == testVM })
+			if i < 0 || found[i].Tags["env"] != "prod" {
+				t.Fatalf("expected %s with its tags, got %+v", testVM, found)
+			}
+		})
+	}
+
+	if _, err := tg.ListResources(context.Background(), testVM); !errors.Is(err, ErrInvalidResourceID) {
+		t.Fatalf("expected ErrInvalidResourceID for a resource scope, got %v", err)
+	}
+}
+
+func TestTagger_RemoveTags_FakeARM(t *testing.T) {
+	tg, srv, _ := newFakeTagger(t)
+	srv.Add(testVM, map[string]string{"incident": "INC123", "freeze": "changed-in-azure", "env": "prod"})
+
+	err := tg.RemoveTags(context.Background(), testVM, map[string]string{"incident": "INC123", "freeze": "yes"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	want := map[string]string{"freeze": "changed-in-azure", "env": "prod"} // only the exact pair goes
+	if got, _ := srv.Tags(testVM); !maps.Equal(got, want) {
+		t.Fatalf("expected ARM tags %v, got %v", want, got)
+	}
+	if reqs := srv.Requests(); !slices.Contains(reqs, "PATCH "+testVM+"/providers/Microsoft.Resources/tags/default") {
+		t.Fatalf("expected a Tags API delete, got %v", reqs)
+	}
+}
+
+func TestTagger_GetTags_FakeARM(t *testing.T) {
+	tg, srv, _ := newFakeTagger(t)
+	srv.Add(testVM, map[string]string{"env": "prod"})
+
+	got, err := tg.GetTags(context.Background(), testVM)
+	if err != nil || !maps.Equal(got, map[string]string{"env": "prod"}) {
+		t.Fatalf("expected the ARM tags, got %v (%v)", got, err)
+	}
+	_, err = tg.GetTags(context.Background(), testVM+"-gone")
+	if metrics.ErrorClass(err) != "not_found" {
+		t.Fatalf("expected not_found, got %v", err)
+	}
+}
@@ -0,0 +1,654 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tmpl"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is every setting of the service. Load fills it from (lowest to
+// highest precedence) the defaults, a YAML file, environment variables and
+// command-line flags.
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Log       Log       `yaml:"log"`
+	Tracing   Tracing   `yaml:"tracing"`
+	Readiness Readiness `yaml:"readiness"`
+	Azure     Azure     `yaml:"azure"`
+	Tags      Tags      `yaml:"tags"`
+	Webhooks  Webhooks  `yaml:"webhooks"`
+}
+
+type Server struct {
+	Port              string        `yaml:"port"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	RequestTimeout    time.Duration `yaml:"request_timeout"` // chi middleware.Timeout
+	ShutdownDrain     time.Duration `yaml:"shutdown_drain"`
+	// IdempotencyTTL is how long the answer to a request with an
+	// Idempotency-Key is replayed to its retries.
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl"`
+}
+
+type Log struct {
+	Level string `yaml:"level"`
+}
+
+type Tracing struct {
+	Exporter string `yaml:"exporter"` // otlp | stdout | none
+}
+
+type Readiness struct {
+	CacheTTL     time.Duration `yaml:"cache_ttl"`
+	CheckTimeout time.Duration `yaml:"check_timeout"`
+}
+
+type Azure struct {
+	// SubscriptionID is the default subscription, only checked by /readyz: the
+	// subscription of each call comes from the resource's Azure ID.
+	SubscriptionID string `yaml:"subscription_id"`
+	// AllowedSubscriptions, when not empty, lists the only subscriptions the
+	// service may touch. Anything else is rejected before calling Azure.
+	AllowedSubscriptions []string `yaml:"allowed_subscriptions"`
+
+	ResourceAPIVersion string        `yaml:"resource_api_version"` // e.g. "2021-04-01" (depends on resource type!)
+	ApplyTimeout       time.Duration `yaml:"apply_timeout"`
+
+	// Cloud is AzurePublic, AzureGovernment, AzureChina or Custom. Custom
+	// needs ARMEndpoint and AuthorityHost, ARMAudience defaults to the endpoint.
+	// The endpoint may be plain http on loopback only (local fake ARM).
+	Cloud         string `yaml:"cloud"`
+	ARMEndpoint   string `yaml:"arm_endpoint"`
+	ARMAudience   string `yaml:"arm_audience"`
+	AuthorityHost string `yaml:"authority_host"`
+
+	// Optional service principal. When empty DefaultAzureCredential is used
+	// (managed identity in Container Apps, az login locally).
+	TenantID     string `yaml:"tenant_id"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+
+	// Profiles are extra identities (e.g. a partner tenant) used for the
+	// subscriptions they list. Other subscriptions use the credential above.
+	// YAML only, there is no env var or flag for them.
+	Profiles []Profile `yaml:"profiles"`
+}
+
+// Tags is how the desired tags of a resource are built.
+type Tags struct {
+	// InheritedKeys flow from a registered resource group to the registered
+	// resources inside it (e.g. costCenter, env). Empty = no inheritance.
+	InheritedKeys []string `yaml:"inherited_keys"`
+	// InheritPrecedence decides who wins when both set an inherited key:
+	// "child" (the resource's own tag) or "parent" (the resource group's).
+	InheritPrecedence string `yaml:"inherit_precedence"`
+	// ExpiryInterval is how often expired tags (PATCH .../tags "expires")
+	// are removed from the store and from Azure.
+	ExpiryInterval time.Duration `yaml:"expiry_interval"`
+	// Rules run in order on the tags of resources created or discovered.
+	// YAML only, like the credential profiles.
+	Rules []Rule `yaml:"rules"`
+	// RequiredKeys and AllowedValues are the tag policy the compliance report
+	// checks. AllowedValues are case-insensitive globs per key, YAML only.
+	RequiredKeys  []string            `yaml:"required_keys"`
+	AllowedValues map[string][]string `yaml:"allowed_values"`
+	// CostDimensions are the keys the cost-allocation export has a column
+	// for, a resource missing them is flagged.
+	CostDimensions []string `yaml:"cost_dimensions"`
+}
+
+// Rule sets, defaults or removes tag keys on the resources it matches.
+type Rule struct {
+	Name  string    `yaml:"name"`
+	Match RuleMatch `yaml:"match"`
+
+	Set     map[string]string `yaml:"set"`     // always written
+	Default map[string]string `yaml:"default"` // only when the key is missing
+	Remove  []string          `yaml:"remove"`
+}
+
+// RuleMatch conditions are ANDed, an empty one matches anything. Every
+// pattern is a case-insensitive glob (path.Match: * ? [a-z]).
+type RuleMatch struct {
+	Subscription  string `yaml:"subscription"`
+	ResourceGroup string `yaml:"resource_group"` // e.g. rg-data-*
+	Type          string `yaml:"type"`           // e.g. Microsoft.Compute/virtualMachines
+	Name          string `yaml:"name"`
+	Scope         string `yaml:"scope"` // subscription, resource_group or resource
+	// Tags must all be present with a matching value ("*" = any value).
+	Tags map[string]string `yaml:"tags"`
+}
+
+type Webhooks struct {
+	Timeout time.Duration `yaml:"timeout"` // per delivery attempt
+	// RetryBackoff is the wait before the first retry, doubled on each one.
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// AllowPrivate lets webhooks reach loopback, private and link-local
+	// addresses, refused by default.
+	AllowPrivate bool `yaml:"allow_private"`
+}
+
+// Inheritance precedences
+const (
+	PrecedenceChild  = "child"
+	PrecedenceParent = "parent"
+)
+
+// Clouds
+const (
+	CloudPublic     = "AzurePublic"
+	CloudGovernment = "AzureGovernment"
+	CloudChina      = "AzureChina"
+	CloudCustom     = "Custom"
+)
+
+// Credential profile types
+const (
+	ProfileClientSecret      = "client_secret"
+	ProfileClientCertificate = "client_certificate"
+	ProfileWorkloadIdentity  = "workload_identity"
+	ProfileManagedIdentity   = "managed_identity" // user-assigned, by client ID
+)
+
+// Profile is a named credential for the subscriptions of one tenant.
+type Profile struct {
+	Name          string   `yaml:"name"`
+	Type          string   `yaml:"type"`
+	TenantID      string   `yaml:"tenant_id"`
+	ClientID      string   `yaml:"client_id"`
+	Subscriptions []string `yaml:"subscriptions"`
+
+	ClientSecret        string `yaml:"client_secret"`        // client_secret
+	CertificatePath     string `yaml:"certificate_path"`     // client_certificate, PEM or PKCS12
+	CertificatePassword string `yaml:"certificate_password"` // client_certificate, optional
+	TokenFilePath       string `yaml:"token_file_path"`      // workload_identity, defaults to AZURE_FEDERATED_TOKEN_FILE
+}
+
+// Default is the config when nothing is set.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Port:              "8080",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      75 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			RequestTimeout:    60 * time.Second,
+			ShutdownDrain:     20 * time.Second, // Container Apps waits 30s before SIGKILL
+			IdempotencyTTL:    24 * time.Hour,
+		},
+		Log:     Log{Level: "info"},
+		Tracing: Tracing{Exporter: "none"},
+		Readiness: Readiness{
+			CacheTTL:     30 * time.Second,
+			CheckTimeout: 5 * time.Second,
+		},
+		Azure: Azure{
+			ApplyTimeout: 15 * time.Second,
+			Cloud:        CloudPublic,
+		},
+		Tags: Tags{
+			InheritPrecedence: PrecedenceChild,
+			ExpiryInterval:    time.Minute,
+			CostDimensions:    []string{"costCenter", "project", "owner"},
+		},
+		Webhooks: Webhooks{
+			Timeout:      5 * time.Second,
+			RetryBackoff: 2 * time.Second,
+		},
+	}
+}
+
+// binding ties one config field to its YAML key, env var and flag.
+type binding struct {
+	key    string // dotted YAML path, also used when printing
+	env    string
+	flag   string
+	secret bool
+	ptr    any // *string, *time.Duration, *bool or *[]string (comma separated in env/flags)
+}
+
+func (c *Config) bindings() []binding {
+	return []binding{
+		{key: "server.port", env: "PORT", flag: "port", ptr: &c.Server.Port},
+		{key: "server.read_header_timeout", env: "HTTP_READ_HEADER_TIMEOUT", flag: "read-header-timeout", ptr: &c.Server.ReadHeaderTimeout},
+		{key: "server.read_timeout", env: "HTTP_READ_TIMEOUT", flag: "read-timeout", ptr: &c.Server.ReadTimeout},
+		{key: "server.write_timeout", env: "HTTP_WRITE_TIMEOUT", flag: "write-timeout", ptr: &c.Server.WriteTimeout},
+		{key: "server.idle_timeout", env: "HTTP_IDLE_TIMEOUT", flag: "idle-timeout", ptr: &c.Server.IdleTimeout},
+		{key: "server.request_timeout", env: "HTTP_REQUEST_TIMEOUT", flag: "request-timeout", ptr: &c.Server.RequestTimeout},
+		{key: "server.shutdown_drain", env: "SHUTDOWN_DRAIN_TIMEOUT", flag: "shutdown-drain", ptr: &c.Server.ShutdownDrain},
+		{key: "server.idempotency_ttl", env: "IDEMPOTENCY_TTL", flag: "idempotency-ttl", ptr: &c.Server.IdempotencyTTL},
+		{key: "log.level", env: "LOG_LEVEL", flag: "log-level", ptr: &c.Log.Level},
+		{key: "tracing.exporter", env: "OTEL_TRACES_EXPORTER", flag: "trace-exporter", ptr: &c.Tracing.Exporter},
+		{key: "readiness.cache_ttl", env: "READINESS_CACHE_TTL", flag: "readiness-cache-ttl", ptr: &c.Readiness.CacheTTL},
+		{key: "readiness.check_timeout", env: "READINESS_CHECK_TIMEOUT", flag: "readiness-check-timeout", ptr: &c.Readiness.CheckTimeout},
+		{key: "azure.subscription_id", env: "AZURE_SUBSCRIPTION_ID", flag: "azure-subscription-id", ptr: &c.Azure.SubscriptionID},
+		{key: "azure.allowed_subscriptions", env: "AZURE_ALLOWED_SUBSCRIPTIONS", flag: "azure-allowed-subscriptions", ptr: &c.Azure.AllowedSubscriptions},
+		{key: "azure.resource_api_version", env: "AZURE_RESOURCE_API_VERSION", flag: "azure-resource-api-version", ptr: &c.Azure.ResourceAPIVersion},
+		{key: "azure.apply_timeout", env: "AZURE_APPLY_TIMEOUT", flag: "azure-apply-timeout", ptr: &c.Azure.ApplyTimeout},
+		{key: "azure.cloud", env: "AZURE_CLOUD", flag: "azure-cloud", ptr: &c.Azure.Cloud},
+		{key: "azure.arm_endpoint", env: "AZURE_ARM_ENDPOINT", flag: "azure-arm-endpoint", ptr: &c.Azure.ARMEndpoint},
+		{key: "azure.arm_audience", env: "AZURE_ARM_AUDIENCE", flag: "azure-arm-audience", ptr: &c.Azure.ARMAudience},
+		{key: "azure.authority_host", env: "AZURE_AUTHORITY_HOST", flag: "azure-authority-host", ptr: &c.Azure.AuthorityHost},
+		{key: "azure.tenant_id", env: "AZURE_TENANT_ID", flag: "azure-tenant-id", ptr: &c.Azure.TenantID},
+		{key: "azure.client_id", env: "AZURE_CLIENT_ID", flag: "azure-client-id", ptr: &c.Azure.ClientID},
+		{key: "azure.client_secret", env: "AZURE_CLIENT_SECRET", flag: "azure-client-secret", secret: true, ptr: &c.Azure.ClientSecret},
+		{key: "tags.inherited_keys", env: "TAGS_INHERITED_KEYS", flag: "tags-inherited-keys", ptr: &c.Tags.InheritedKeys},
+		{key: "tags.inherit_precedence", env: "TAGS_INHERIT_PRECEDENCE", flag: "tags-inherit-precedence", ptr: &c.Tags.InheritPrecedence},
+		{key: "tags.expiry_interval", env: "TAGS_EXPIRY_INTERVAL", flag: "tags-expiry-interval", ptr: &c.Tags.ExpiryInterval},
+		{key: "tags.required_keys", env: "TAGS_REQUIRED_KEYS", flag: "tags-required-keys", ptr: &c.Tags.RequiredKeys},
+		{key: "tags.cost_dimensions", env: "TAGS_COST_DIMENSIONS", flag: "tags-cost-dimensions", ptr: &c.Tags.CostDimensions},
+		{key: "webhooks.timeout", env: "WEBHOOK_TIMEOUT", flag: "webhook-timeout", ptr: &c.Webhooks.Timeout},
+		{key: "webhooks.retry_backoff", env: "WEBHOOK_RETRY_BACKOFF", flag: "webhook-retry-backoff", ptr: &c.Webhooks.RetryBackoff},
+		{key: "webhooks.allow_private", env: "WEBHOOK_ALLOW_PRIVATE", flag: "webhook-allow-private", ptr: &c.Webhooks.AllowPrivate},
+	}
+}
+
+// Load builds the config from args (os.Args[1:]) and getenv (os.Getenv).
+// The YAML file is given by -config or CONFIG_FILE.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", "", "path to a YAML config file (env CONFIG_FILE)")
+	flags := map[string]*string{}
+	for _, b := range cfg.bindings() {
+		flags[b.flag] = fs.String(b.flag, "", fmt.Sprintf("%s (env %s)", b.key, b.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	path := *configFile
+	if path == "" {
+		path = getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadFile(&cfg, path); err != nil {
+			return cfg, err
+		}
+	}
+
+	for _, b := range cfg.bindings() {
+		if v := getenv(b.env); v != "" {
+			if err := set(b.ptr, v); err != nil {
+				return cfg, fmt.Errorf("env %s: %w", b.env, err)
+			}
+		}
+	}
+
+	// only the flags given on the command line, an empty default must not
+	// override the file or env
+	var flagErr error
+	byFlag := map[string]binding{}
+	for _, b := range cfg.bindings() {
+		byFlag[b.flag] = b
+	}
+	fs.Visit(func(f *flag.Flag) {
+		b, ok := byFlag[f.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		if err := set(b.ptr, *flags[f.Name]); err != nil {
+			flagErr = fmt.Errorf("flag -%s: %w", f.Name, err)
+		}
+	})
+	if flagErr != nil {
+		return cfg, flagErr
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true) // typos in the file should fail, not be ignored
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func set(ptr any, v string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = v
+	case *time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*p = d
+	case *bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*p = b
+	case *[]string:
+		*p = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported config type %T", ptr)
+	}
+	return nil
+}
+
+// Validate checks the config at startup, so a typo fails the revision instead
+// of a request at 3am.
+func (c Config) Validate() error {
+	var errs []error
+
+	if p, err := strconv.Atoi(c.Server.Port); err != nil || p < 1 || p > 65535 {
+		errs = append(errs, fmt.Errorf("server.port %q is not a valid port", c.Server.Port))
+	}
+	for _, b := range c.bindings() {
+		if d, ok := b.ptr.(*time.Duration); ok && *d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be > 0", b.key))
+		}
+	}
+	if c.Server.WriteTimeout <= c.Server.RequestTimeout {
+		errs = append(errs, errors.New("server.write_timeout must be greater than server.request_timeout"))
+	}
+
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("log.level %q must be debug, info, warn or error", c.Log.Level))
+	}
+	switch c.Tracing.Exporter {
+	case "otlp", "stdout", "none":
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter %q must be otlp, stdout or none", c.Tracing.Exporter))
+	}
+
+	for _, sub := range c.Azure.AllowedSubscriptions {
+		if _, err := uuid.Parse(sub); err != nil {
+			errs = append(errs, fmt.Errorf("azure.allowed_subscriptions: %q is not a subscription ID", sub))
+		}
+	}
+
+	errs = append(errs, validateCloud(c.Azure)...)
+
+	if c.Azure.ClientSecret != "" && (c.Azure.TenantID == "" || c.Azure.ClientID == "") {
+		errs = append(errs, errors.New("azure.client_secret needs azure.tenant_id and azure.client_id"))
+	}
+	errs = append(errs, validateProfiles(c.Azure.Profiles)...)
+
+	switch c.Tags.InheritPrecedence {
+	case PrecedenceChild, PrecedenceParent:
+	default:
+		errs = append(errs, fmt.Errorf("tags.inherit_precedence %q must be %s or %s", c.Tags.InheritPrecedence, PrecedenceChild, PrecedenceParent))
+	}
+	for i, k := range c.Tags.InheritedKeys {
+		if strings.TrimSpace(k) == "" {
+			errs = append(errs, fmt.Errorf("tags.inherited_keys[%d] is empty", i))
+		}
+	}
+	for i, k := range c.Tags.RequiredKeys {
+		if strings.TrimSpace(k) == "" {
+			errs = append(errs, fmt.Errorf("tags.required_keys[%d] is empty", i))
+		}
+	}
+	for i, k := range c.Tags.CostDimensions {
+		switch {
+		case strings.TrimSpace(k) == "":
+			errs = append(errs, fmt.Errorf("tags.cost_dimensions[%d] is empty", i))
+		case slices.ContainsFunc(c.Tags.CostDimensions[:i], func(d string) bool { return strings.EqualFold(d, k) }):
+			errs = append(errs, fmt.Errorf("tags.cost_dimensions: %s is there twice", k))
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(c.Tags.AllowedValues)) {
+		values := c.Tags.AllowedValues[k]
+		if len(values) == 0 {
+			errs = append(errs, fmt.Errorf("tags.allowed_values.%s: no value allowed", k))
+		}
+		for _, v := range values {
+			if _, err := path.Match(v, ""); err != nil {
+				errs = append(errs, fmt.Errorf("tags.allowed_values.%s: bad pattern %q", k, v))
+			}
+		}
+	}
+	errs = append(errs, validateRules(c.Tags.Rules)...)
+
+	return errors.Join(errs...)
+}
+
+// authorities are the authority hosts of the known clouds.
+var authorities = map[string]string{
+	CloudPublic:     cloud.AzurePublic.ActiveDirectoryAuthorityHost,
+	CloudGovernment: cloud.AzureGovernment.ActiveDirectoryAuthorityHost,
+	CloudChina:      cloud.AzureChina.ActiveDirectoryAuthorityHost,
+}
+
+func validateCloud(a Azure) []error {
+	switch a.Cloud {
+	case CloudPublic, CloudGovernment, CloudChina:
+		// the AKS workload identity webhook sets AZURE_AUTHORITY_HOST in every
+		// pod, to the authority of the cloud
+		if strings.EqualFold(strings.TrimSuffix(a.AuthorityHost, "/"), strings.TrimSuffix(authorities[a.Cloud], "/")) {
+			a.AuthorityHost = ""
+		}
+		if a.ARMEndpoint != "" || a.ARMAudience != "" || a.AuthorityHost != "" {
+			return []error{fmt.Errorf("azure.arm_endpoint, azure.arm_audience and azure.authority_host need azure.cloud %s (got %s)", CloudCustom, a.Cloud)}
+		}
+		return nil
+	case CloudCustom:
+	default:
+		return []error{fmt.Errorf("azure.cloud %q must be %s, %s, %s or %s", a.Cloud, CloudPublic, CloudGovernment, CloudChina, CloudCustom)}
+	}
+
+	var errs []error
+	if a.ARMEndpoint == "" {
+		errs = append(errs, errors.New("azure.arm_endpoint is required for azure.cloud Custom"))
+	} else if u, err := url.Parse(a.ARMEndpoint); err != nil || u.Host == "" {
+		errs = append(errs, fmt.Errorf("azure.arm_endpoint %q is not a URL", a.ARMEndpoint))
+	} else if u.Scheme != "https" && !(u.Scheme == "http" && isLoopback(u.Hostname())) {
+		// the bearer token goes in clear over http, fine for a fake ARM on this machine only
+		errs = append(errs, fmt.Errorf("azure.arm_endpoint %q must be https (http only on localhost)", a.ARMEndpoint))
+	}
+	if a.AuthorityHost == "" {
+		errs = append(errs, errors.New("azure.authority_host is required for azure.cloud Custom"))
+	} else if u, err := url.Parse(a.AuthorityHost); err != nil || u.Scheme != "https" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("azure.authority_host %q must be an https URL", a.AuthorityHost))
+	}
+	return errs
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func validateProfiles(profiles []Profile) []error {
+	var errs []error
+	names := map[string]bool{}
+	owner := map[string]string{} // subscription -> profile
+
+	for i, p := range profiles {
+		key := fmt.Sprintf("azure.profiles[%d]", i)
+		if p.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name is required", key))
+		} else if names[p.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicated profile name %q", key, p.Name))
+		}
+		names[p.Name] = true
+
+		if p.ClientID == "" {
+			errs = append(errs, fmt.Errorf("%s.client_id is required", key))
+		}
+		if p.TenantID == "" && p.Type != ProfileManagedIdentity {
+			errs = append(errs, fmt.Errorf("%s.tenant_id is required", key))
+		}
+		switch p.Type {
+		case ProfileClientSecret:
+			if p.ClientSecret == "" {
+				errs = append(errs, fmt.Errorf("%s.client_secret is required for type %s", key, p.Type))
+			}
+		case ProfileClientCertificate:
+			if p.CertificatePath == "" {
+				errs = append(errs, fmt.Errorf("%s.certificate_path is required for type %s", key, p.Type))
+			}
+		case ProfileWorkloadIdentity, ProfileManagedIdentity:
+		default:
+			errs = append(errs, fmt.Errorf("%s.type %q must be client_secret, client_certificate, workload_identity or managed_identity", key, p.Type))
+		}
+
+		if len(p.Subscriptions) == 0 {
+			errs = append(errs, fmt.Errorf("%s.subscriptions is empty", key))
+		}
+		for _, sub := range p.Subscriptions {
+			if _, err := uuid.Parse(sub); err != nil {
+				errs = append(errs, fmt.Errorf("%s.subscriptions: %q is not a subscription ID", key, sub))
+				continue
+			}
+			lower := strings.ToLower(sub)
+			if other, ok := owner[lower]; ok {
+				errs = append(errs, fmt.Errorf("%s: subscription %s is already in profile %q", key, sub, other))
+			}
+			owner[lower] = p.Name
+		}
+	}
+	return errs
+}
+
+// sampleData is what the rule templates are tried with when loaded, so a
+// typo fails the startup instead of the requests.
+var sampleData = tmpl.NewData("vm-app-01",
+	"/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-app/providers/Microsoft.Compute/virtualMachines/vm-app-01",
+	"resource", "anonymous", time.Now())
+
+func validateRules(rules []Rule) []error {
+	var errs []error
+	names := map[string]bool{}
+
+	for i, r := range rules {
+		key := fmt.Sprintf("tags.rules[%d]", i)
+		if r.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name is required", key))
+		} else if names[r.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicated rule name %q", key, r.Name))
+		}
+		names[r.Name] = true
+
+		if len(r.Set)+len(r.Default)+len(r.Remove) == 0 {
+			errs = append(errs, fmt.Errorf("%s: needs at least one of set, default or remove", key))
+		}
+		for _, values := range []map[string]string{r.Set, r.Default} {
+			if _, err := tmpl.Render(values, sampleData); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", key, err))
+			}
+		}
+		switch r.Match.Scope {
+		case "", "subscription", "resource_group", "resource":
+		default:
+			errs = append(errs, fmt.Errorf("%s.match.scope %q must be subscription, resource_group or resource", key, r.Match.Scope))
+		}
+		patterns := []string{r.Match.Subscription, r.Match.ResourceGroup, r.Match.Type, r.Match.Name}
+		for _, v := range r.Match.Tags {
+			patterns = append(patterns, v)
+		}
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%s.match: bad pattern %q", key, p))
+			}
+		}
+	}
+	return errs
+}
+
+// LogValue prints the effective config as flat dotted keys, secrets redacted.
+func (c Config) LogValue() slog.Value {
+	bs := c.bindings()
+	attrs := make([]slog.Attr, 0, len(bs)+len(c.Azure.Profiles)+1)
+	for _, b := range bs {
+		attrs = append(attrs, slog.String(b.key, b.display()))
+	}
+	for _, p := range c.Azure.Profiles {
+		attrs = append(attrs, slog.Group("azure.profiles."+p.Name,
+			slog.String("type", p.Type),
+			slog.String("tenant_id", p.TenantID),
+			slog.String("client_id", p.ClientID),
+			slog.String("subscriptions", strings.Join(p.Subscriptions, ",")),
+			slog.String("client_secret", redact(p.ClientSecret)),
+			slog.String("certificate_path", p.CertificatePath),
+			slog.String("certificate_password", redact(p.CertificatePassword)),
+			slog.String("token_file_path", p.TokenFilePath),
+		))
+	}
+	rules := make([]string, len(c.Tags.Rules))
+	for i, r := range c.Tags.Rules {
+		rules[i] = r.Name
+	}
+	attrs = append(attrs, slog.String("tags.rules", strings.Join(rules, ",")))
+	for _, k := range slices.Sorted(maps.Keys(c.Tags.AllowedValues)) {
+		attrs = append(attrs, slog.String("tags.allowed_values."+k, strings.Join(c.Tags.AllowedValues[k], ",")))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+func redact(v string) string {
+	if v == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+func (b binding) display() string {
+	var v string
+	switch p := b.ptr.(type) {
+	case *string:
+		v = *p
+	case *time.Duration:
+		v = p.String()
+	case *bool:
+		v = strconv.FormatBool(*p)
+	case *[]string:
+		v = strings.Join(*p, ",")
+	}
+	if b.secret {
+		return redact(v)
+	}
+	return v
+}
+
+// Usage lists every setting with its env var and flag (for -h).
+func Usage(w io.Writer) {
+	cfg := Default()
+	fmt.Fprintln(w, "Settings (file key / env / flag), precedence: flag > env > file > default")
+	fmt.Fprintln(w, "  config file: -config or CONFIG_FILE (azure.profiles, tags.rules and tags.allowed_values can only be set there)")
+	for _, b := range cfg.bindings() {
+		fmt.Fprintf(w, "  %-28s %-28s -%s\n", b.key, b.env, b.flag)
+	}
+}
@@ -0,0 +1,301 @@
+package config
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envFrom(m map[string]string) func(string) string {
+	return func(k string) string { return m[k] }
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := `
+server:
+  port: "9000"
+  request_timeout: 10s
+log:
+  level: debug
+azure:
+  subscription_id: from-file
+  apply_timeout: 20s
+`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(
+		[]string{"-config", path, "-azure-subscription-id", "from-flag"},
+		envFrom(map[string]string{
+			"AZURE_SUBSCRIPTION_ID": "from-env",
+			"LOG_LEVEL":             "warn",
+			"WEBHOOK_ALLOW_PRIVATE": "true",
+		}),
+	)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if cfg.Server.Port != "9000" {
+		t.Fatalf("expected port from file, got %q", cfg.Server.Port)
+	}
+	if cfg.Log.Level != "warn" {
+		t.Fatalf("expected env to override file, got %q", cfg.Log.Level)
+	}
+	if cfg.Azure.SubscriptionID != "from-flag" {
+		t.Fatalf("expected flag to override env, got %q", cfg.Azure.SubscriptionID)
+	}
+	if cfg.Azure.ApplyTimeout != 20*time.Second || cfg.Server.RequestTimeout != 10*time.Second {
+		t.Fatalf("expected durations from file, got %v / %v", cfg.Azure.ApplyTimeout, cfg.Server.RequestTimeout)
+	}
+	if !cfg.Webhooks.AllowPrivate {
+		t.Fatal("expected a bool from env")
+	}
+	if cfg.Server.IdleTimeout != Default().Server.IdleTimeout {
+		t.Fatalf("expected default idle timeout, got %v", cfg.Server.IdleTimeout)
+	}
+}
+
+func TestLoad_Errors_TableDriven(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"bad port", nil, map[string]string{"PORT": "http"}, "server.port"},
+		{"bad duration", nil, map[string]string{"AZURE_APPLY_TIMEOUT": "soon"}, "AZURE_APPLY_TIMEOUT"},
+		{"zero duration", []string{"-azure-apply-timeout", "0s"}, nil, "azure.apply_timeout must be > 0"},
+		{"bad level", []string{"-log-level", "loud"}, nil, "log.level"},
+		{"bad exporter", nil, map[string]string{"OTEL_TRACES_EXPORTER": "jaeger"}, "tracing.exporter"},
+		{"write below request timeout", []string{"-write-timeout", "30s"}, nil, "write_timeout"},
+		{"secret without tenant", nil, map[string]string{"AZURE_CLIENT_SECRET": "s3cr3t"}, "azure.client_secret"},
+		{"unknown cloud", nil, map[string]string{"AZURE_CLOUD": "AzureGerman"}, "azure.cloud"},
+		{"custom without endpoint", []string{"-azure-cloud", "Custom", "-azure-authority-host", "https://login.example.com/"}, nil, "azure.arm_endpoint is required"},
+		{"custom http off loopback", []string{"-azure-cloud", "Custom", "-azure-arm-endpoint", "http://arm.example.com", "-azure-authority-host", "https://login.example.com/"}, nil, "must be https"},
+		{"custom http authority", []string{"-azure-cloud", "Custom", "-azure-arm-endpoint", "https://arm.example.com", "-azure-authority-host", "http://login.example.com/"}, nil, "azure.authority_host"},
+		{"endpoint without custom", nil, map[string]string{"AZURE_ARM_ENDPOINT": "https://arm.example.com"}, "need azure.cloud Custom"},
+		{"authority of another cloud", nil, map[string]string{"AZURE_AUTHORITY_HOST": "https://login.microsoftonline.us/"}, "need azure.cloud Custom"},
+		{"bad inherit precedence", []string{"-tags-inherit-precedence", "rg"}, nil, "tags.inherit_precedence"},
+		{"bad bool", nil, map[string]string{"WEBHOOK_ALLOW_PRIVATE": "maybe"}, "WEBHOOK_ALLOW_PRIVATE"},
+		{"missing file", []string{"-config", "/nope.yaml"}, nil, "config file"},
+		{"unknown flag", []string{"-nope"}, nil, "nope"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Load(tc.args, envFrom(tc.env))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestLoad_CustomCloud(t *testing.T) {
+	cfg, err := Load(nil, envFrom(map[string]string{
+		"AZURE_CLOUD":          CloudCustom,
+		"AZURE_ARM_ENDPOINT":   "http://localhost:8443",
+		"AZURE_AUTHORITY_HOST": "https://login.example.com/",
+	}))
+	if err != nil {
+		t.Fatalf("expected a local http fake ARM to be valid, got %v", err)
+	}
+	if cfg.Azure.ARMEndpoint != "http://localhost:8443" {
+		t.Fatalf("unexpected endpoint %q", cfg.Azure.ARMEndpoint)
+	}
+}
+
+func TestLoad_WorkloadIdentityAuthority(t *testing.T) {
+	// as set by the AKS workload identity webhook
+	cfg, err := Load(nil, envFrom(map[string]string{"AZURE_AUTHORITY_HOST": "https://login.microsoftonline.com/"}))
+	if err != nil {
+		t.Fatalf("expected the public cloud authority to be accepted, got %v", err)
+	}
+	if cfg.Azure.Cloud != CloudPublic {
+		t.Fatalf("unexpected cloud %q", cfg.Azure.Cloud)
+	}
+}
+
+func TestLoad_UnknownFileKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("server:\n  prot: \"80\"\n"), 0o600)
+
+	if _, err := Load([]string{"-config", path}, envFrom(nil)); err == nil {
+		t.Fatal("expected typo in config file to fail")
+	}
+}
+
+func TestLoad_Profiles(t *testing.T) {
+	write := func(t *testing.T, body string) string {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		os.WriteFile(path, []byte(body), 0o600)
+		return path
+	}
+
+	ok := write(t, `
+azure:
+  profiles:
+    - name: partner
+      type: client_secret
+      tenant_id: 33333333-3333-3333-3333-333333333333
+      client_id: 44444444-4444-4444-4444-444444444444
+      client_secret: s3cr3t
+      subscriptions: [aaaaaaaa-1111-1111-1111-111111111111]
+    - name: gov-mi
+      type: managed_identity
+      client_id: 55555555-5555-5555-5555-555555555555
+      subscriptions: [bbbbbbbb-1111-1111-1111-111111111111]
+`)
+	cfg, err := Load([]string{"-config", ok}, envFrom(nil))
+	if err != nil {
+		t.Fatalf("expected valid profiles, got %v", err)
+	}
+	if len(cfg.Azure.Profiles) != 2 || cfg.Azure.Profiles[1].Type != ProfileManagedIdentity {
+		t.Fatalf("unexpected profiles: %+v", cfg.Azure.Profiles)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"unknown type", `
+azure:
+  profiles:
+    - {name: p, type: password, tenant_id: t, client_id: c, subscriptions: [aaaaaaaa-1111-1111-1111-111111111111]}
+`, "type"},
+		{"secret missing", `
+azure:
+  profiles:
+    - {name: p, type: client_secret, tenant_id: t, client_id: c, subscriptions: [aaaaaaaa-1111-1111-1111-111111111111]}
+`, "client_secret is required"},
+		{"subscription in two profiles", `
+azure:
+  profiles:
+    - {name: a, type: workload_identity, tenant_id: t, client_id: c, subscriptions: [aaaaaaaa-1111-1111-1111-111111111111]}
+    - {name: b, type: workload_identity, tenant_id: t, client_id: c, subscriptions: [AAAAAAAA-1111-1111-1111-111111111111]}
+`, "already in profile"},
+		{"bad template", `
+tags:
+  rules:
+    - {name: r, default: {owner: "{{.RG}}-team", team: "{{.Team}}"}}
+`, `tags.rules[0]: invalid tag template: tag "team": unknown field .Team`},
+		{"duplicated name", `
+azure:
+  profiles:
+    - {name: a, type: workload_identity, tenant_id: t, client_id: c, subscriptions: [aaaaaaaa-1111-1111-1111-111111111111]}
+    - {name: a, type: workload_identity, tenant_id: t, client_id: c, subscriptions: [bbbbbbbb-1111-1111-1111-111111111111]}
+`, "duplicated profile name"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Load([]string{"-config", write(t, tc.body)}, envFrom(nil))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestLoad_Rules(t *testing.T) {
+	write := func(t *testing.T, body string) string {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		os.WriteFile(path, []byte(body), 0o600)
+		return path
+	}
+
+	cfg, err := Load([]string{"-config", write(t, `
+tags:
+  rules:
+    - name: data-confidential
+      match: {resource_group: rg-data-*}
+      set: {dataClassification: confidential}
+    - name: vm-patching
+      match: {type: Microsoft.Compute/virtualMachines}
+      default: {patchGroup: weekly, owner: "{{.RG | trimPrefix \"rg-\"}}-team"}
+      remove: [tmp]
+`)}, envFrom(nil))
+	if err != nil {
+		t.Fatalf("expected valid rules, got %v", err)
+	}
+	if len(cfg.Tags.Rules) != 2 || cfg.Tags.Rules[0].Match.ResourceGroup != "rg-data-*" || cfg.Tags.Rules[1].Default["patchGroup"] != "weekly" {
+		t.Fatalf("unexpected rules: %+v", cfg.Tags.Rules)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"no action", `
+tags:
+  rules:
+    - {name: r, match: {name: vm-*}}
+`, "at least one of set"},
+		{"bad pattern", `
+tags:
+  rules:
+    - {name: r, match: {resource_group: "rg-["}, set: {a: b}}
+`, "bad pattern"},
+		{"unknown scope", `
+tags:
+  rules:
+    - {name: r, match: {scope: tenant}, set: {a: b}}
+`, "match.scope"},
+		{"bad template", `
+tags:
+  rules:
+    - {name: r, default: {owner: "{{.RG}}-team", team: "{{.Team}}"}}
+`, `tags.rules[0]: invalid tag template: tag "team": unknown field .Team`},
+		{"duplicated name", `
+tags:
+  rules:
+    - {name: r, set: {a: b}}
+    - {name: r, set: {a: c}}
+`, "duplicated rule name"},
+		{"bad allowed value", `
+tags:
+  allowed_values: {env: ["[dev"]}
+`, "tags.allowed_values.env: bad pattern"},
+		{"nothing allowed", `
+tags:
+  allowed_values: {env: []}
+`, "no value allowed"},
+		{"duplicated cost dimension", `
+tags:
+  cost_dimensions: [costCenter, CostCenter]
+`, "CostCenter is there twice"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Load([]string{"-config", write(t, tc.body)}, envFrom(nil))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestConfig_LogValue_RedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.Azure.TenantID, cfg.Azure.ClientID, cfg.Azure.ClientSecret = "tenant", "client", "s3cr3t"
+	cfg.Azure.Profiles = []Profile{{Name: "partner", Type: ProfileClientCertificate, CertificatePassword: "s3cr3t"}}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("effective config", slog.Any("config", cfg))
+
+	out := buf.String()
+	if strings.Contains(out, "s3cr3t") {
+		t.Fatalf("secret leaked: %s", out)
+	}
+	if !strings.Contains(out, `"azure.client_id":"client"`) || !strings.Contains(out, `"server.request_timeout":"1m0s"`) {
+		t.Fatalf("expected flat readable keys: %s", out)
+	}
+}
@@ -0,0 +1,53 @@
+// Package events is the in-process bus the store and the handlers publish
+// resource and job events on, for the webhooks and GET /events.
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/google/uuid"
+)
+
+// Bus calls every subscriber, in the publisher's goroutine, for each event.
+// Subscribers must not block: queue the work and return.
+type Bus struct {
+	mu   sync.RWMutex
+	next int
+	subs map[int]func(models.Event)
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]func(models.Event))}
+}
+
+// Subscribe registers fn and returns the function removing it.
+func (b *Bus) Subscribe(fn func(models.Event)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subs[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+// Publish fills the ID and time of e and hands it to the subscribers.
+func (b *Bus) Publish(e models.Event) models.Event {
+	if e.ID == "" {
+		e.ID = uuid.NewString()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, fn := range b.subs {
+		fn(e)
+	}
+	return e
+}
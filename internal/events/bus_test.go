@@ -0,0 +1,24 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+func TestBus_PublishSubscribe(t *testing.T) {
+	b := NewBus()
+	var got []models.Event
+	unsubscribe := b.Subscribe(func(e models.Event) { got = append(got, e) })
+
+	sent := b.Publish(models.Event{Type: models.EventResourceCreated, ResourceID: "r1"})
+	if sent.ID == "" || sent.Time.IsZero() {
+		t.Fatalf("expected id and time to be set, got %+v", sent)
+	}
+	unsubscribe()
+	b.Publish(models.Event{Type: models.EventTagsUpdated})
+
+	if len(got) != 1 || got[0].ID != sent.ID {
+		t.Fatalf("expected only the first event, got %+v", got)
+	}
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+// Log keeps the last events, for GET /events to resume from a Last-Event-ID,
+// and hands the new ones to the streams following it.
+type Log struct {
+	mu      sync.Mutex
+	size    int
+	events  []models.Event // oldest first
+	next    int
+	streams map[int]chan models.Event
+}
+
+// NewLog keeps the last size events. Subscribe its Append to a Bus.
+func NewLog(size int) *Log {
+	return &Log{size: size, streams: make(map[int]chan models.Event)}
+}
+
+// Append adds e to the log and sends it to the streams. A stream whose
+// buffer is full is closed rather than waited for, its client can come back
+// with the last ID it got.
+func (l *Log) Append(e models.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+	if over := len(l.events) - l.size; over > 0 {
+		l.events = slices.Delete(l.events, 0, over)
+	}
+	for id, ch := range l.streams {
+		select {
+		case ch <- e:
+		default:
+			close(ch)
+			delete(l.streams, id)
+		}
+	}
+}
+
+// Follow returns the events logged after lastID and a channel, buffered to
+// buf, of the ones appended afterwards: nothing is missed or sent twice in
+// between. With no lastID the backlog is empty. found is false when lastID is
+// not (or no longer) in the log, the backlog is then the whole log.
+// Call stop once done.
+func (l *Log) Follow(lastID string, buf int) (backlog []models.Event, found bool, next <-chan models.Event, stop func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	found = true
+	if lastID != "" {
+		i := slices.IndexFunc(l.events, func(e models.Event) bool { return e.ID == lastID })
+		found = i >= 0
+		backlog = slices.Clone(l.events[i+1:])
+	}
+
+	id := l.next
+	l.next++
+	ch := make(chan models.Event, buf)
+	l.streams[id] = ch
+	return backlog, found, ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if ch, ok := l.streams[id]; ok {
+			close(ch)
+			delete(l.streams, id)
+		}
+	}
+}
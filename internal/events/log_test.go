@@ -0,0 +1,60 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+func ids(events []models.Event) string {
+	var s string
+	for _, e := range events {
+		s += e.ID
+	}
+	return s
+}
+
+func TestLog_Follow(t *testing.T) {
+	l := NewLog(3)
+	for i := range 5 {
+		l.Append(models.Event{ID: fmt.Sprint(i)})
+	}
+
+	tests := []struct {
+		name    string
+		lastID  string
+		backlog string
+		found   bool
+	}{
+		{"new stream", "", "", true},
+		{"resume", "2", "34", true},
+		{"up to date", "4", "", true},
+		{"dropped from the log", "0", "234", false},
+		{"unknown", "nope", "234", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			backlog, found, _, stop := l.Follow(tc.lastID, 1)
+			defer stop()
+			if ids(backlog) != tc.backlog || found != tc.found {
+				t.Fatalf("expected backlog %q (found %v), got %q (%v)", tc.backlog, tc.found, ids(backlog), found)
+			}
+		})
+	}
+}
+
+func TestLog_SlowStreamIsClosed(t *testing.T) {
+	l := NewLog(10)
+	_, _, next, stop := l.Follow("", 1)
+	defer stop()
+
+	l.Append(models.Event{ID: "a"})
+	l.Append(models.Event{ID: "b"}) // buffer full
+	if e, ok := <-next; !ok || e.ID != "a" {
+		t.Fatalf("expected event a, got %+v", e)
+	}
+	if _, ok := <-next; ok {
+		t.Fatal("expected the stream to be closed")
+	}
+}
@@ -0,0 +1,45 @@
+package fakearm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Credential is an azcore.TokenCredential that never talks to AAD. Set Err
+// to simulate a credential that can't get a token.
+type Credential struct {
+	Err error
+
+	mu     sync.Mutex
+	calls  int
+	scopes []string
+}
+
+func (c *Credential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	c.scopes = opts.Scopes
+	if c.Err != nil {
+		return azcore.AccessToken{}, c.Err
+	}
+	return azcore.AccessToken{Token: "fake-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// Calls returns how many tokens were requested.
+func (c *Credential) Calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+// Scopes returns the scopes of the last token request.
+func (c *Credential) Scopes() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.scopes
+}
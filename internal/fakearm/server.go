@@ -0,0 +1,437 @@
+// Package fakearm is an in-memory Azure Resource Manager for tests. It speaks
+// just enough of the ARM REST API for the real azure.Tagger and the SDK
+// clients: generic resources (GET/PATCH, PATCH as a long-running operation),
+// the Tags-at-scope API, and listing resources and resource groups. Faults
+// (429, 409, 5xx) and slow LROs can be injected.
+//
+//	srv := fakearm.NewServer()
+//	defer srv.Close()
+//	srv.Add("/subscriptions/.../resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1", nil)
+//
+// then point config.Azure at it with Cloud Custom and ARMEndpoint srv.URL,
+// using a Credential instead of a real one.
+package fakearm
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resource is one node of the fake resource graph: a subscription, a
+// resource group or a resource.
+type Resource struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Location string            `json:"location,omitempty"`
+	Tags     map[string]string `json:"tags"`
+}
+
+// Fault makes matching requests fail with Status. 429 and 5xx carry a short
+// retry-after-ms so the SDK retries them without slowing the tests down.
+type Fault struct {
+	Method string // empty matches any method
+	Path   string // case-insensitive substring of the path, empty matches any
+	Status int
+	Times  int // number of requests to fail, 0 = all of them
+}
+
+type operation struct {
+	key       string // resource the operation updates
+	tags      map[string]string
+	remaining int // InProgress polls left
+}
+
+// Server is the fake ARM. The embedded httptest.Server gives URL and Close.
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	resources   map[string]*Resource // lowercased ID -> resource
+	ops         map[string]*operation
+	nextOp      int
+	faults      []*Fault
+	lroPolls    int
+	lroInterval time.Duration
+	requests    []string
+}
+
+const defaultPageSize = 100
+
+// NewServer starts a fake ARM with an empty resource graph. PATCH completes
+// synchronously until SetLRO is called.
+func NewServer() *Server {
+	s := &Server{
+		resources:   make(map[string]*Resource),
+		ops:         make(map[string]*operation),
+		lroInterval: 10 * time.Millisecond,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Add puts a resource in the graph with its subscription and resource group,
+// which are created (untagged) when missing. A subscription or resource group
+// ID adds just that scope.
+func (s *Server) Add(id string, tags map[string]string) *Resource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(id, "/"), "/")
+	if len(parts) >= 2 {
+		s.ensure("/" + strings.Join(parts[:2], "/"))
+	}
+	if len(parts) >= 4 {
+		s.ensure("/" + strings.Join(parts[:4], "/"))
+	}
+	r := s.ensure(id)
+	r.Tags = maps.Clone(tags)
+	if r.Tags == nil {
+		r.Tags = map[string]string{}
+	}
+	return r
+}
+
+func (s *Server) ensure(id string) *Resource {
+	key := strings.ToLower(id)
+	if r, ok := s.resources[key]; ok {
+		return r
+	}
+	parts := strings.Split(strings.Trim(id, "/"), "/")
+	r := &Resource{ID: id, Name: parts[len(parts)-1], Tags: map[string]string{}}
+	switch {
+	case len(parts) == 2:
+		r.Type = "Microsoft.Resources/subscriptions"
+	case len(parts) == 4:
+		r.Type = "Microsoft.Resources/resourceGroups"
+		r.Location = "westeurope"
+	case len(parts) >= 8:
+		r.Type = parts[5] + "/" + parts[6]
+		r.Location = "westeurope"
+	}
+	s.resources[key] = r
+	return r
+}
+
+// Tags returns a copy of the tags of id as ARM currently has them.
+func (s *Server) Tags(id string) (map[string]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.resources[strings.ToLower(id)]
+	if !ok {
+		return nil, false
+	}
+	return maps.Clone(r.Tags), true
+}
+
+// Inject adds a fault. Faults are checked in the order they were added.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// SetLRO makes every resource PATCH answer 202 and stay InProgress for polls
+// polls, each advertising interval as retry-after. polls 0 = synchronous.
+func (s *Server) SetLRO(polls int, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lroPolls, s.lroInterval = polls, interval
+}
+
+// Requests lists the requests served so far as "METHOD /path".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+const tagsSuffix = "/providers/microsoft.resources/tags/default"
+
+var (
+	listResourcesPath = regexp.MustCompile(`(?i)^(/subscriptions/[^/]+(?:/resourcegroups/[^/]+)?)/resources$`)
+	listGroupsPath    = regexp.MustCompile(`(?i)^(/subscriptions/[^/]+)/resourcegroups$`)
+	operationPath     = regexp.MustCompile(`^/operations/([^/]+)$`)
+)
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	lower := strings.ToLower(path)
+	s.requests = append(s.requests, r.Method+" "+path)
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeError(w, http.StatusUnauthorized, "AuthenticationFailed", "missing bearer token")
+		return
+	}
+	if r.URL.Query().Get("api-version") == "" {
+		writeError(w, http.StatusBadRequest, "MissingApiVersionParameter", "the api-version query parameter is required")
+		return
+	}
+	if s.fault(w, r.Method, lower) {
+		return
+	}
+
+	switch {
+	case operationPath.MatchString(path):
+		s.pollOperation(w, r, operationPath.FindStringSubmatch(path)[1])
+	case strings.HasSuffix(lower, tagsSuffix):
+		s.tagsAtScope(w, r, path[:len(path)-len(tagsSuffix)])
+	case r.Method == http.MethodGet && listResourcesPath.MatchString(path):
+		scope := strings.ToLower(listResourcesPath.FindStringSubmatch(path)[1])
+		s.list(w, r, func(res *Resource) bool {
+			return isLeaf(res.ID) && strings.HasPrefix(strings.ToLower(res.ID), scope+"/")
+		})
+	case r.Method == http.MethodGet && listGroupsPath.MatchString(path):
+		sub := strings.ToLower(listGroupsPath.FindStringSubmatch(path)[1])
+		s.list(w, r, func(res *Resource) bool {
+			return res.Type == "Microsoft.Resources/resourceGroups" && strings.HasPrefix(strings.ToLower(res.ID), sub+"/")
+		})
+	default:
+		s.resource(w, r, lower)
+	}
+}
+
+// isLeaf tells a resource from a subscription or resource group:
+// /subscriptions/s/resourceGroups/rg/providers/Namespace/type/name
+func isLeaf(id string) bool {
+	return strings.Count(strings.Trim(id, "/"), "/") >= 7
+}
+
+// fault answers the request with the first matching fault, if any.
+func (s *Server) fault(w http.ResponseWriter, method, path string) bool {
+	for i, f := range s.faults {
+		if (f.Method != "" && !strings.EqualFold(f.Method, method)) ||
+			(f.Path != "" && !strings.Contains(path, strings.ToLower(f.Path))) {
+			continue
+		}
+		if f.Times > 0 {
+			if f.Times--; f.Times == 0 {
+				s.faults = slices.Delete(s.faults, i, i+1)
+			}
+		}
+		if f.Status == http.StatusTooManyRequests || f.Status >= 500 {
+			w.Header().Set("retry-after-ms", "10")
+		}
+		code := map[int]string{
+			http.StatusTooManyRequests: "TooManyRequests",
+			http.StatusConflict:        "Conflict",
+		}[f.Status]
+		if code == "" {
+			code = "InternalServerError"
+		}
+		writeError(w, f.Status, code, "injected fault")
+		return true
+	}
+	return false
+}
+
+func (s *Server) resource(w http.ResponseWriter, r *http.Request, key string) {
+	res, ok := s.resources[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("resource %s not found", r.URL.Path))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, res)
+
+	case http.MethodPatch:
+		var body struct {
+			Tags map[string]*string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+			return
+		}
+		// like ARM, PATCH replaces the whole tags object
+		tags := make(map[string]string, len(body.Tags))
+		for k, v := range body.Tags {
+			if v != nil {
+				tags[k] = *v
+			}
+		}
+
+		if s.lroPolls == 0 {
+			res.Tags = tags
+			writeJSON(w, http.StatusOK, res)
+			return
+		}
+		s.nextOp++
+		id := strconv.Itoa(s.nextOp)
+		s.ops[id] = &operation{key: key, tags: tags, remaining: s.lroPolls}
+		opURL := s.URL + "/operations/" + id + "?api-version=" + r.URL.Query().Get("api-version")
+		w.Header().Set("Azure-AsyncOperation", opURL)
+		w.Header().Set("Location", opURL)
+		w.Header().Set("retry-after-ms", strconv.FormatInt(s.lroInterval.Milliseconds(), 10))
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not supported on resources")
+	}
+}
+
+func (s *Server) pollOperation(w http.ResponseWriter, r *http.Request, id string) {
+	op, ok := s.ops[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "OperationNotFound", "operation "+id+" not found")
+		return
+	}
+	if op.remaining > 0 {
+		op.remaining--
+		w.Header().Set("retry-after-ms", strconv.FormatInt(s.lroInterval.Milliseconds(), 10))
+		writeJSON(w, http.StatusOK, map[string]string{"status": "InProgress"})
+		return
+	}
+	if res, ok := s.resources[op.key]; ok && op.tags != nil {
+		res.Tags = op.tags
+		op.tags = nil
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "Succeeded"})
+}
+
+// tagsAtScope implements Microsoft.Resources/tags/default: GET, PUT (replace),
+// PATCH (Merge, Replace or Delete) and DELETE (remove all tags).
+func (s *Server) tagsAtScope(w http.ResponseWriter, r *http.Request, scope string) {
+	res, ok := s.resources[strings.ToLower(scope)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("scope %s not found", scope))
+		return
+	}
+
+	var body struct {
+		Operation  string `json:"operation"`
+		Properties struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"properties"`
+	}
+	if r.Method == http.MethodPut || r.Method == http.MethodPatch {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		res.Tags = maps.Clone(body.Properties.Tags)
+	case http.MethodPatch:
+		switch body.Operation {
+		case "Merge":
+			maps.Copy(res.Tags, body.Properties.Tags)
+		case "Replace":
+			res.Tags = maps.Clone(body.Properties.Tags)
+		case "Delete":
+			for k, v := range body.Properties.Tags {
+				// with a value only that exact pair goes, like ARM
+				if cur, ok := res.Tags[k]; ok && (v == "" || cur == v) {
+					delete(res.Tags, k)
+				}
+			}
+		default:
+			writeError(w, http.StatusBadRequest, "InvalidTagOperation", fmt.Sprintf("operation %q must be Merge, Replace or Delete", body.Operation))
+			return
+		}
+	case http.MethodDelete:
+		res.Tags = map[string]string{}
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not supported on tags")
+		return
+	}
+	if res.Tags == nil {
+		res.Tags = map[string]string{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":         res.ID + "/providers/Microsoft.Resources/tags/default",
+		"name":       "default",
+		"type":       "Microsoft.Resources/tags",
+		"properties": map[string]any{"tags": res.Tags},
+	})
+}
+
+// list writes the resources matching keep, sorted by ID, one page of $top
+// (default 100) at a time with a nextLink carrying the offset in $skiptoken.
+// $filter supports the tag filter of the real API:
+// "tagName eq 'k'" and "tagName eq 'k' and tagValue eq 'v'".
+func (s *Server) list(w http.ResponseWriter, r *http.Request, keep func(*Resource) bool) {
+	q := r.URL.Query()
+	tagName, tagValue, err := parseTagFilter(q.Get("$filter"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidFilter", err.Error())
+		return
+	}
+
+	var matched []*Resource
+	for _, res := range s.resources {
+		if !keep(res) {
+			continue
+		}
+		if tagName != "" {
+			v, ok := res.Tags[tagName]
+			if !ok || (tagValue != "" && v != tagValue) {
+				continue
+			}
+		}
+		matched = append(matched, res)
+	}
+	slices.SortFunc(matched, func(a, b *Resource) int { return strings.Compare(strings.ToLower(a.ID), strings.ToLower(b.ID)) })
+
+	top := defaultPageSize
+	if v, err := strconv.Atoi(q.Get("$top")); err == nil && v > 0 {
+		top = v
+	}
+	skip, _ := strconv.Atoi(q.Get("$skiptoken"))
+	skip = min(skip, len(matched))
+	end := min(skip+top, len(matched))
+
+	page := map[string]any{"value": matched[skip:end]}
+	if end < len(matched) {
+		next := *r.URL
+		nq := next.Query()
+		nq.Set("$skiptoken", strconv.Itoa(end))
+		next.RawQuery = nq.Encode()
+		page["nextLink"] = s.URL + next.RequestURI()
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+var tagFilter = regexp.MustCompile(`^tagName eq '([^']*)'(?: and tagValue eq '([^']*)')?$`)
+
+func parseTagFilter(filter string) (name, value string, err error) {
+	if filter == "" {
+		return "", "", nil
+	}
+	m := tagFilter.FindStringSubmatch(strings.TrimSpace(filter))
+	if m == nil {
+		return "", "", fmt.Errorf("unsupported $filter %q", filter)
+	}
+	return m[1], m[2], nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]any{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
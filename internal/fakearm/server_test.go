@@ -0,0 +1,158 @@
+package fakearm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+const (
+	sub = "11111111-1111-1111-1111-111111111111"
+	rg  = "/subscriptions/" + sub + "/resourceGroups/rg-app"
+	vm  = rg + "/providers/Microsoft.Compute/virtualMachines/vm-1"
+)
+
+func clientOptions(srv *Server) *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: policy.ClientOptions{
+		Cloud: cloud.Configuration{Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {Endpoint: srv.URL, Audience: srv.URL},
+		}},
+		InsecureAllowCredentialWithHTTP: true,
+	}}
+}
+
+func TestServer_TagsAtScope(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.Add(vm, map[string]string{"env": "dev", "owner": "ana"})
+
+	client, err := armresources.NewTagsClient(sub, &Credential{}, clientOptions(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	_, err = client.UpdateAtScope(ctx, rg, armresources.TagsPatchResource{
+		Operation:  to.Ptr(armresources.TagsPatchOperationMerge),
+		Properties: &armresources.Tags{Tags: map[string]*string{"cost-center": to.Ptr("42")}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("merge on resource group: %v", err)
+	}
+	if tags, _ := srv.Tags(rg); tags["cost-center"] != "42" {
+		t.Fatalf("expected resource group to be tagged, got %v", tags)
+	}
+
+	_, err = client.UpdateAtScope(ctx, vm, armresources.TagsPatchResource{
+		Operation:  to.Ptr(armresources.TagsPatchOperationDelete),
+		Properties: &armresources.Tags{Tags: map[string]*string{"owner": to.Ptr("")}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("delete on resource: %v", err)
+	}
+	got, err := client.GetAtScope(ctx, vm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := got.Properties.Tags; len(tags) != 1 || *tags["env"] != "dev" {
+		t.Fatalf("expected only env left, got %v", tags)
+	}
+}
+
+func TestServer_ListPages(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	for _, name := range []string{"a", "b", "c"} {
+		srv.Add(rg+"/providers/Microsoft.Storage/storageAccounts/"+name, map[string]string{"env": name})
+	}
+	srv.Add("/subscriptions/"+sub+"/resourceGroups/other/providers/Microsoft.Web/sites/d", nil)
+
+	client, err := armresources.NewClient(sub, &Credential{}, clientOptions(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byPage := func(p armresources.ClientListResponse) int { return len(p.Value) }
+	tests := []struct {
+		name string
+		got  int
+		want int
+	}{
+		{"subscription, 2 per page", count(t, client.NewListPager(&armresources.ClientListOptions{Top: to.Ptr[int32](2)}), byPage), 4},
+		{"resource group", count(t, client.NewListByResourceGroupPager("rg-app", nil),
+			func(p armresources.ClientListByResourceGroupResponse) int { return len(p.Value) }), 3},
+		{"tag filter", count(t, client.NewListPager(&armresources.ClientListOptions{Filter: to.Ptr("tagName eq 'env' and tagValue eq 'b'")}), byPage), 1},
+	}
+	for _, tc := range tests {
+		if tc.got != tc.want {
+			t.Fatalf("%s: expected %d resources, got %d", tc.name, tc.want, tc.got)
+		}
+	}
+}
+
+func count[T any](t *testing.T, pager *runtime.Pager[T], size func(T) int) int {
+	t.Helper()
+	n := 0
+	for pager.More() {
+		p, err := pager.NextPage(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += size(p)
+	}
+	return n
+}
+
+func TestServer_Faults(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.Add(vm, nil)
+
+	client, err := armresources.NewClient(sub, &Credential{}, clientOptions(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// retried by the SDK pipeline
+	srv.Inject(Fault{Method: http.MethodGet, Status: http.StatusTooManyRequests, Times: 2})
+	if _, err := client.GetByID(ctx, vm, "2021-04-01", nil); err != nil {
+		t.Fatalf("expected 429s to be retried, got %v", err)
+	}
+
+	// not retried
+	srv.Inject(Fault{Path: "virtualMachines", Status: http.StatusConflict, Times: 1})
+	_, err = client.GetByID(ctx, vm, "2021-04-01", nil)
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusConflict || respErr.ErrorCode != "Conflict" {
+		t.Fatalf("expected a 409 Conflict, got %v", err)
+	}
+
+	srv.Inject(Fault{Status: http.StatusServiceUnavailable})
+	if _, err := client.GetByID(ctx, vm, "2021-04-01", nil); !errors.As(err, &respErr) || respErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 after retries, got %v", err)
+	}
+}
+
+func TestServer_RequiresToken(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + vm + "?api-version=2021-04-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a bearer token, got %d", resp.StatusCode)
+	}
+}
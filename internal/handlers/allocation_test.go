@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestHandlers_ExportCostAllocation(t *testing.T) {
+	cfg := config.Default()
+	cfg.Tags.InheritedKeys = []string{"costCenter"}
+	h := New(store.NewMemoryStore(), cfg)
+	ctx := t.Context()
+	h.store.Create(ctx, "rg-app", rgApp, models.ScopeResourceGroup, map[string]string{"costCenter": "cc-1"})
+	h.store.Create(ctx, "vm-2", rgApp+"/providers/Microsoft.Compute/virtualMachines/vm-2", models.ScopeResource, map[string]string{"Project": "web", "owner": "me"})
+	h.store.Create(ctx, "vm-1", dataVM, models.ScopeResource, map[string]string{"env": "dev"})
+	r := newTestRouter(h)
+
+	rr := do(t, r, "GET", "/v1/export/cost-allocation", nil)
+	if rr.Code != 200 || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("expected a csv, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rows, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil || len(rows) != 4 {
+		t.Fatalf("expected a header and 3 rows, got %q (%v)", rows, err)
+	}
+	// sorted by lower ResourceId: rg-app, vm-2 under it, then rg-data-lake/vm-1
+	status := func(row []string) string { return row[5] + "|" + row[6] + "|" + row[7] + "|" + row[9] + "|" + row[10] }
+	want := []string{"cc-1|||partial|project;owner", "cc-1|web|me|allocated|", "|||unallocated|costCenter;project;owner"}
+	for i, w := range want {
+		if got := status(rows[i+1]); got != w {
+			t.Fatalf("row %d: expected %s, got %s (%q)", i+1, w, got, rows[i+1])
+		}
+	}
+
+	rr = do(t, r, "GET", "/v1/export/cost-allocation?format=parquet&dimensions=owner&selector=owner", nil)
+	f, err := parquet.OpenFile(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if rr.Code != 200 || err != nil || f.NumRows() != 1 {
+		t.Fatalf("expected a parquet file with vm-2, got %d (%v)", rr.Code, err)
+	}
+	if _, ok := f.Schema().Lookup("owner"); !ok {
+		t.Fatal("expected an owner column")
+	}
+
+	for _, q := range []string{"format=xlsx", "dimensions=a,,b", "dimensions=Tags", "selector=!"} {
+		if rr := do(t, r, "GET", "/v1/export/cost-allocation?"+q, nil); rr.Code != 400 {
+			t.Fatalf("%s: expected 400, got %d", q, rr.Code)
+		}
+	}
+}
@@ -3,9 +3,17 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"log/slog"
+	"maps"
 	"net/http"
 	"time"
 
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/azure"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/identity"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tags"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tmpl"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -13,22 +21,34 @@ type applyReq struct {
 	Tags map[string]string `json:"tags"`
 }
 
+type applyResp struct {
+	Message  string            `json:"message"`
+	Resource string            `json:"resource"` // Azure ID
+	Scope    string            `json:"scope"`
+	Tags     map[string]string `json:"tags"`
+}
+
 // ApplyTagsToAzure godoc
 // @Summary      Apply tags to the Azure resource
+// @Description  Replaces the Azure tags with the resource tag sets, overridden by the body tags, plus the ones inherited from the registered resource group (tags.inherited_keys).
+// @Description  Templated values ({{.RG}}, {{.Now | date "2006-01-02"}}...) are rendered first.
 // @Tags         azure
 // @Accept       json
 // @Produce      json
 // @Param        id      path     string   true  "Resource ID"
 // @Param        payload body     applyReq true  "Tags to apply"
-// @Success      200     {object} map[string]any
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      200     {object} applyResp
 // @Failure      400     {object} map[string]string
+// @Failure      403     {object} map[string]string
 // @Failure      404     {object} map[string]string
 // @Failure      500     {object} map[string]string
+// @Failure      503     {object} map[string]string
 // @Router       /resources/{id}/apply-tags [post]
 func (h *Handler) ApplyTagsToAzure(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
-	res, err := h.store.Get(id)
+	res, err := h.store.Get(r.Context(), id)
 	if err != nil {
 		writeErr(w, 404, "not found")
 		return
@@ -43,24 +63,86 @@ func (h *Handler) ApplyTagsToAzure(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, 400, "tags required")
 		return
 	}
+	rendered, err := tmpl.Render(req.Tags, tmpl.NewData(res.Name, res.AzureID, res.Scope, identity.Caller(r), time.Now()))
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+
+	reqLog := logging.FromContext(r.Context()).With(slog.String("resource_id", res.ID))
+	log := reqLog.With(slog.String("azure_id", res.AzureID))
+
+	// reject subscriptions outside the allowlist before any Azure call
+	if _, err := azure.Authorize(h.cfg.Azure.AllowedSubscriptions, res.AzureID); err != nil {
+		log.Warn("apply tags rejected", slog.String("error", err.Error()))
+		writeAzureIDErr(w, err)
+		return
+	}
 
 	tagger, err := h.taggerFactory() //for testing
 	if err != nil {
-		writeErr(w, 400, "azure not configured: set AZURE_SUBSCRIPTION_ID")
+		log.Warn("azure not configured", slog.String("error", err.Error()))
+		writeErr(w, 400, "azure not configured: "+err.Error())
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+	// keep the trace (and other request values) but not the cancellation,
+	// the LRO should not die if the client goes away. Only a shutdown that
+	// outlived the drain period (Interrupt) stops it.
+	ctx := logging.WithContext(context.WithoutCancel(r.Context()), reqLog) // the tagger adds azure_id itself
+
+	// the tag sets, then the body tags, then what the resource group passes down
+	desired := tags.Values(h.effectiveTags(ctx, res, rendered))
+	result := h.apply(ctx, tagger, res, desired)
 
-	if err := tagger.ApplyTags(ctx, res.AzureID, req.Tags); err != nil {
-		writeErr(w, 500, "azure error: "+err.Error())
+	switch result.Status {
+	case models.ApplyInterrupted:
+		log.Warn("apply tags interrupted by shutdown", slog.String("error", result.Error))
+		writeErr(w, 503, "apply interrupted: service is shutting down, retry later")
+		return
+	case models.ApplyFailed:
+		log.Error("apply tags failed", slog.String("error", result.Error))
+		writeErr(w, 500, "azure error: "+result.Error)
 		return
 	}
+	log.Info("tags applied", slog.Int("tag_count", len(desired)))
+
+	writeJSON(w, 200, applyResp{Message: "tags applied", Resource: res.AzureID, Scope: res.Scope, Tags: desired})
+}
+
+// apply pushes desired to Azure within the apply timeout and records the
+// result on res. Used by apply-tags and the re-apply jobs; ctx should not be
+// a request context, only a shutdown past the drain period (Interrupt)
+// cancels it.
+func (h *Handler) apply(ctx context.Context, tagger AzureTagger, res models.Resource, desired map[string]string) models.ApplyResult {
+	h.applies.Add(1)
+	defer h.applies.Done()
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Azure.ApplyTimeout)
+	defer cancel()
+	stop := context.AfterFunc(h.applyCtx, cancel)
+	defer stop()
+
+	h.publish(models.EventAzureApplying, res, map[string]any{"tags": desired})
+	err := tagger.ApplyTags(ctx, res.AzureID, desired)
 
-	writeJSON(w, 200, map[string]any{
-		"message":  "tags applied",
-		"resource": res.AzureID,
-		"tags":     req.Tags,
-	})
+	result := models.ApplyResult{Status: models.ApplySucceeded, Tags: desired, FinishedUnix: time.Now().Unix()}
+	switch {
+	case err != nil && h.applyCtx.Err() != nil:
+		result.Status, result.Error = models.ApplyInterrupted, err.Error()
+	case err != nil:
+		result.Status, result.Error = models.ApplyFailed, err.Error()
+	}
+	// an apply with overrides leaves the stored intent to a later apply
+	synced := maps.Equal(desired, tags.Values(h.effectiveTags(ctx, res, res.Tags)))
+	if rerr := h.store.RecordApply(context.WithoutCancel(ctx), res.ID, result, synced); rerr != nil {
+		logging.FromContext(ctx).Warn("could not record apply result",
+			slog.String("resource_id", res.ID), slog.String("error", rerr.Error()))
+	}
+	if result.Status == models.ApplySucceeded {
+		h.publish(models.EventAzureApplied, res, result)
+	} else {
+		h.publish(models.EventAzureFailed, res, result)
+	}
+	return result
 }
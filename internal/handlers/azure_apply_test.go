@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/azure"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
 	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
-	"github.com/go-chi/chi/v5"
 )
 
 type mockTagger struct {
@@ -17,6 +21,9 @@ type mockTagger struct {
 	resourceID string
 	tags       map[string]string
 	err        error
+	found      []azure.Discovered           // for ListResources
+	removed    map[string]string            // by RemoveTags
+	current    map[string]map[string]string // Azure ID -> tags, for GetTags
 }
 
 func (m *mockTagger) ApplyTags(ctx context.Context, resourceID string, tags map[string]string) error {
@@ -26,28 +33,42 @@ func (m *mockTagger) ApplyTags(ctx context.Context, resourceID string, tags map[
 	return m.err
 }
 
-func newTestRouterWithApply(h *Handler) http.Handler {
-	r := chi.NewRouter()
-	r.Route("/v1", func(r chi.Router) {
-		r.Post("/resources", h.CreateResource)
-		r.Post("/resources/{id}/apply-tags", h.ApplyTagsToAzure)
-	})
-	return r
+func (m *mockTagger) ListResources(ctx context.Context, scopeID string) ([]azure.Discovered, error) {
+	return m.found, m.err
+}
+
+func (m *mockTagger) RemoveTags(ctx context.Context, resourceID string, tags map[string]string) error {
+	m.resourceID = resourceID
+	m.removed = tags
+	return m.err
+}
+
+func (m *mockTagger) GetTags(ctx context.Context, scopeID string) (map[string]string, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	t, ok := m.current[scopeID]
+	if !ok {
+		return nil, &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "ResourceNotFound"}
+	}
+	return t, nil
 }
 
 func TestHandlers_ApplyTagsToAzure_Success(t *testing.T) {
 	st := store.NewMemoryStore()
-	h := New(st)
+	h := New(st, config.Default())
 
 	mt := &mockTagger{}
 	h.taggerFactory = func() (AzureTagger, error) { return mt, nil }
 
-	router := newTestRouterWithApply(h)
+	router := newTestRouter(h)
 
 	// Create a resource first
 	created := st.Create(
+		context.Background(),
 		"vm-1",
 		"/subscriptions/x/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1",
+		models.ScopeResource,
 		map[string]string{},
 	)
 
@@ -77,14 +98,14 @@ func TestHandlers_ApplyTagsToAzure_Success(t *testing.T) {
 
 func TestHandlers_ApplyTagsToAzure_Validation(t *testing.T) {
 	st := store.NewMemoryStore()
-	h := New(st)
+	h := New(st, config.Default())
 
 	mt := &mockTagger{}
 	h.taggerFactory = func() (AzureTagger, error) { return mt, nil }
 
-	router := newTestRouterWithApply(h)
+	router := newTestRouter(h)
 
-	created := st.Create("vm-1", "/subscriptions/x/.../vm-1", map[string]string{})
+	created := st.Create(context.Background(), "vm-1", "/subscriptions/x/.../vm-1", models.ScopeResource, map[string]string{})
 
 	req := httptest.NewRequest(http.MethodPost, "/v1/resources/"+created.ID+"/apply-tags", bytes.NewBufferString(`{"tags":{}}`))
 	req.Header.Set("Content-Type", "application/json")
@@ -98,3 +119,175 @@ func TestHandlers_ApplyTagsToAzure_Validation(t *testing.T) {
 		t.Fatal("did not expect mock tagger to be called on validation failure")
 	}
 }
+
+// blockingTagger waits for the apply context to end, like a slow LRO would.
+type blockingTagger struct {
+	started chan struct{}
+}
+
+func (b *blockingTagger) ApplyTags(ctx context.Context, resourceID string, tags map[string]string) error {
+	close(b.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b *blockingTagger) GetTags(ctx context.Context, scopeID string) (map[string]string, error) {
+	return nil, nil
+}
+
+func (b *blockingTagger) ListResources(ctx context.Context, scopeID string) ([]azure.Discovered, error) {
+	return nil, nil
+}
+
+func (b *blockingTagger) RemoveTags(ctx context.Context, resourceID string, tags map[string]string) error {
+	return nil
+}
+
+func TestHandlers_ApplyTagsToAzure_InterruptedByShutdown(t *testing.T) {
+	st := store.NewMemoryStore()
+	h := New(st, config.Default())
+
+	bt := &blockingTagger{started: make(chan struct{})}
+	h.taggerFactory = func() (AzureTagger, error) { return bt, nil }
+
+	router := newTestRouter(h)
+	created := st.Create(context.Background(), "vm-1", "/subscriptions/x/.../vm-1", models.ScopeResource, map[string]string{})
+
+	rr := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/v1/resources/"+created.ID+"/apply-tags", bytes.NewBufferString(`{"tags":{"env":"prod"}}`))
+		router.ServeHTTP(rr, req)
+		close(done)
+	}()
+
+	<-bt.started
+	if err := h.Interrupt(context.Background()); err != nil {
+		t.Fatalf("expected interrupt to wait for the apply, got %v", err)
+	}
+	<-done
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	got, _ := st.Get(context.Background(), created.ID)
+	if got.LastApply == nil || got.LastApply.Status != models.ApplyInterrupted {
+		t.Fatalf("expected apply to be recorded as interrupted, got %+v", got.LastApply)
+	}
+}
+
+func TestHandlers_ApplyTagsToAzure_SubscriptionNotAllowed(t *testing.T) {
+	st := store.NewMemoryStore()
+	cfg := config.Default()
+	cfg.Azure.AllowedSubscriptions = []string{"22222222-2222-2222-2222-222222222222"}
+	h := New(st, cfg)
+
+	mt := &mockTagger{}
+	h.taggerFactory = func() (AzureTagger, error) { return mt, nil }
+
+	router := newTestRouter(h)
+	created := st.Create(context.Background(), "vm-1",
+		"/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1",
+		models.ScopeResource, map[string]string{})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/resources/"+created.ID+"/apply-tags", bytes.NewBufferString(`{"tags":{"env":"prod"}}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	if mt.called {
+		t.Fatal("did not expect azure to be called for a subscription outside the allowlist")
+	}
+}
+
+func TestHandlers_ApplyTagsToAzure_OverridesKeepNeedsApply(t *testing.T) {
+	st := store.NewMemoryStore()
+	h := New(st, config.Default())
+	mt := &mockTagger{}
+	h.taggerFactory = func() (AzureTagger, error) { return mt, nil }
+	router := newTestRouter(h)
+
+	vm := st.Create(context.Background(), "vm-1", dataVM, models.ScopeResource, map[string]string{"env": "prod"})
+	st.MarkNeedsApply(context.Background(), vm.ID)
+
+	tests := []struct {
+		name       string
+		tags       map[string]string
+		needsApply bool
+	}{
+		{"override", map[string]string{"env": "test"}, true}, // the stored intent isn't in Azure
+		{"stored tags", map[string]string{"env": "prod"}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if rr := do(t, router, http.MethodPost, "/v1/resources/"+vm.ID+"/apply-tags", map[string]any{"tags": tc.tags}); rr.Code != 200 {
+				t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+			}
+			if got, _ := st.Get(context.Background(), vm.ID); got.NeedsApply != tc.needsApply || got.LastApply.Status != models.ApplySucceeded {
+				t.Fatalf("expected needs_apply %v after a successful apply, got %+v", tc.needsApply, got)
+			}
+		})
+	}
+}
+
+func TestHandlers_ApplyTagsToAzure_PushesInheritedTags(t *testing.T) {
+	const rg = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app"
+
+	st := store.NewMemoryStore()
+	cfg := config.Default()
+	cfg.Tags.InheritedKeys = []string{"costCenter", "env"}
+	h := New(st, cfg)
+
+	mt := &mockTagger{}
+	h.taggerFactory = func() (AzureTagger, error) { return mt, nil }
+	router := newTestRouter(h)
+
+	st.Create(context.Background(), "rg-app", rg, models.ScopeResourceGroup, map[string]string{"costCenter": "42", "env": "prod", "owner": "ops"})
+	vm := st.Create(context.Background(), "vm-1", rg+"/providers/Microsoft.Compute/virtualMachines/vm-1", models.ScopeResource, map[string]string{})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/resources/"+vm.ID+"/apply-tags", bytes.NewBufferString(`{"tags":{"app":"web","env":"dev"}}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	want := map[string]string{"app": "web", "env": "dev", "costCenter": "42"}
+	if !maps.Equal(mt.tags, want) {
+		t.Fatalf("expected effective tags %v to be pushed, got %v", want, mt.tags)
+	}
+	got, _ := st.Get(context.Background(), vm.ID)
+	if !maps.Equal(got.LastApply.Tags, want) {
+		t.Fatalf("expected the effective tags to be recorded, got %v", got.LastApply.Tags)
+	}
+}
+
+func TestHandlers_ApplyTagsToAzure_RendersTemplates(t *testing.T) {
+	st := store.NewMemoryStore()
+	h := New(st, config.Default())
+
+	mt := &mockTagger{}
+	h.taggerFactory = func() (AzureTagger, error) { return mt, nil }
+	router := newTestRouter(h)
+
+	vm := st.Create(context.Background(), "vm-1",
+		"/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-shop/providers/Microsoft.Compute/virtualMachines/vm-shop-01",
+		models.ScopeResource, map[string]string{})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/resources/"+vm.ID+"/apply-tags", bytes.NewBufferString(`{"tags":{"app":"{{.Name | trimPrefix \"vm-\"}}"}}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || mt.tags["app"] != "shop-01" {
+		t.Fatalf("expected rendered tag to be pushed, got %d %v", rr.Code, mt.tags)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/resources/"+vm.ID+"/apply-tags", bytes.NewBufferString(`{"tags":{"app":"{{.Nope}}"}}`))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown variable, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/metrics"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/report"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tags"
+)
+
+// driftEntry is a registered resource whose Azure tags differ from its
+// desired ones: what an apply would change there.
+type driftEntry struct {
+	ResourceID string            `json:"resource_id"`
+	Name       string            `json:"name"`
+	AzureID    string            `json:"azure_id"`
+	Set        map[string]string `json:"set,omitempty"`     // missing or different in Azure
+	Removed    []string          `json:"removed,omitempty"` // only in Azure
+	Error      string            `json:"error,omitempty"`   // Azure tags not read
+}
+
+type driftResp struct {
+	Checked int          `json:"checked"`
+	Drifted []driftEntry `json:"drifted"`
+}
+
+// Drift godoc
+// @Summary      Check the registered resources for drift
+// @Description  Reads the Azure tags of the registered resources the selector picks (all by default) and returns the ones that differ from their effective tags, with what an apply would set and remove.
+// @Description  A drifted one gets a drift.detected event when its drift is new or changed since its last one, not on every check.
+// @Description  A resource whose tags couldn't be read (e.g. gone from Azure) is listed with the error.
+// @Tags         azure
+// @Produce      json
+// @Param        selector  query     string  false  "Tag selector, e.g. env=prod,!owner"
+// @Success      200       {object}  driftResp
+// @Failure      400       {object}  map[string]string
+// @Router       /drift [get]
+func (h *Handler) Drift(w http.ResponseWriter, r *http.Request) {
+	sel, err := tags.ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	log := logging.FromContext(r.Context()).With(slog.String("selector", sel.String()))
+	tagger, err := h.taggerFactory()
+	if err != nil {
+		log.Warn("azure not configured", slog.String("error", err.Error()))
+		writeErr(w, 400, "azure not configured: "+err.Error())
+		return
+	}
+
+	var entries []report.Entry
+	for _, res := range h.store.List(r.Context()) {
+		if sel.Matches(res.Tags) {
+			entries = append(entries, report.Entry{Resource: res, Desired: tags.Values(h.effectiveTags(r.Context(), res, res.Tags))})
+		}
+	}
+	readAzureTags(r.Context(), tagger, entries)
+
+	resp := driftResp{Checked: len(entries), Drifted: []driftEntry{}}
+	for _, e := range entries {
+		d := driftEntry{ResourceID: e.Resource.ID, Name: e.Resource.Name, AzureID: e.Resource.AzureID}
+		switch {
+		case e.AzureErr != nil && metrics.ErrorClass(e.AzureErr) == "not_found":
+			d.Error = "not found in Azure"
+		case e.AzureErr != nil:
+			d.Error = e.AzureErr.Error()
+		default:
+			if d.Set, d.Removed = tags.Diff(e.Azure, e.Desired); len(d.Set) == 0 && len(d.Removed) == 0 {
+				h.inSync(e.Resource.ID)
+				continue
+			}
+			h.driftDetected(e.Resource, e.Desired, e.Azure)
+		}
+		resp.Drifted = append(resp.Drifted, d)
+	}
+	slices.SortFunc(resp.Drifted, func(a, b driftEntry) int { return cmp.Compare(strings.ToLower(a.AzureID), strings.ToLower(b.AzureID)) })
+	log.Info("drift checked", slog.Int("checked", resp.Checked), slog.Int("drifted", len(resp.Drifted)))
+	writeJSON(w, 200, resp)
+}
+
+// driftDetected publishes drift.detected for res, unless its last one was
+// for the same desired and actual tags: a drift check run every few minutes
+// mustn't alert every few minutes.
+func (h *Handler) driftDetected(res models.Resource, desired, actual map[string]string) {
+	state, _ := json.Marshal([]map[string]string{desired, actual}) // sorted keys
+	h.driftMu.Lock()
+	known := h.drifted[res.ID] == string(state)
+	h.drifted[res.ID] = string(state)
+	h.driftMu.Unlock()
+	if !known {
+		h.publish(models.EventDriftDetected, res, map[string]any{"desired": desired, "actual": actual})
+	}
+}
+
+// inSync forgets the drift of a resource (back in sync, applied or
+// deleted), the next one is published again.
+func (h *Handler) inSync(id string) {
+	h.driftMu.Lock()
+	defer h.driftMu.Unlock()
+	delete(h.drifted, id)
+}
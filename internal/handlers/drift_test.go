@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+)
+
+func TestHandlers_Drift(t *testing.T) {
+	h := New(store.NewMemoryStore(), config.Default())
+	ctx := context.Background()
+	h.store.Create(ctx, "vm-1", dataVM, models.ScopeResource, map[string]string{"env": "prod", "owner": "me"})
+	h.store.Create(ctx, "rg-app", rgApp, models.ScopeResourceGroup, map[string]string{"env": "dev"})
+	h.store.Create(ctx, "gone", subA+"/resourceGroups/rg-gone", models.ScopeResourceGroup, map[string]string{"env": "dev"})
+
+	mt := &mockTagger{current: map[string]map[string]string{
+		dataVM: {"env": "dev", "temp": "1"}, // env changed, owner missing, temp extra
+		rgApp:  {"env": "dev"},
+	}}
+	h.taggerFactory = func() (AzureTagger, error) { return mt, nil }
+	var drifted []string
+	h.events.Subscribe(func(e models.Event) {
+		if e.Type == models.EventDriftDetected {
+			drifted = append(drifted, e.ResourceID)
+		}
+	})
+	r := newTestRouter(h)
+
+	rr := do(t, r, "GET", "/v1/drift", nil)
+	var resp driftResp
+	if rr.Code != 200 || json.Unmarshal(rr.Body.Bytes(), &resp) != nil {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if resp.Checked != 3 || len(resp.Drifted) != 2 {
+		t.Fatalf("expected vm-1 and gone drifted, got %+v", resp)
+	}
+	vm, gone := resp.Drifted[0], resp.Drifted[1]
+	if gone.Name != "gone" || gone.Error != "not found in Azure" {
+		t.Fatalf("expected gone not found, got %+v", gone)
+	}
+	if vm.Name != "vm-1" || !maps.Equal(vm.Set, map[string]string{"env": "prod", "owner": "me"}) || !slices.Equal(vm.Removed, []string{"temp"}) {
+		t.Fatalf("expected the vm-1 diff, got %+v", vm)
+	}
+	if len(drifted) != 1 || drifted[0] != vm.ResourceID {
+		t.Fatalf("expected one drift.detected event for vm-1, got %v", drifted)
+	}
+
+	rr = do(t, r, "GET", "/v1/drift?selector=env=dev", nil)
+	resp = driftResp{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Checked != 2 || len(resp.Drifted) != 1 {
+		t.Fatalf("expected rg-app and gone checked, got %+v", resp)
+	}
+
+	// the same drift again isn't published again, a changed one is, and so is
+	// one coming back after the resource was in sync
+	azureTags := map[string]map[string]string{
+		"same":      {"env": "dev", "temp": "1"},
+		"changed":   {"env": "dev"},
+		"in sync":   {"env": "prod", "owner": "me"},
+		"came back": {"env": "dev"},
+	}
+	for _, step := range []struct {
+		name   string
+		events int
+	}{{"same", 1}, {"changed", 2}, {"in sync", 2}, {"came back", 3}} {
+		mt.current[dataVM] = azureTags[step.name]
+		do(t, r, "GET", "/v1/drift", nil)
+		if len(drifted) != step.events {
+			t.Fatalf("%s: expected %d drift.detected events in all, got %d", step.name, step.events, len(drifted))
+		}
+	}
+
+	if rr := do(t, r, "GET", "/v1/drift?selector=!", nil); rr.Code != 400 {
+		t.Fatalf("expected 400 on a bad selector, got %d", rr.Code)
+	}
+	h.taggerFactory = func() (AzureTagger, error) { return nil, errors.New("no credentials") }
+	if rr := do(t, r, "GET", "/v1/drift", nil); rr.Code != 400 {
+		t.Fatalf("expected 400 without azure, got %d", rr.Code)
+	}
+}
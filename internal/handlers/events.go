@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tags"
+)
+
+const (
+	streamBuffer    = 256 // events a stream can lag behind before it is closed
+	streamKeepAlive = 15 * time.Second
+)
+
+// StreamEvents godoc
+// @Summary      Stream events
+// @Description  Server-Sent Events of resource changes (resource.created/updated/deleted, tags.updated), Azure applies (azure.applying/applied/failed), drift.detected and job.updated.
+// @Description  The SSE id is the event ID: reconnecting with Last-Event-ID resumes from the last 1000 events. When that ID is gone a "reset" event comes first, reload GET /resources.
+// @Description  selector only lets through the events of resources whose tags match; job events have no resource and always pass, filter them out with types.
+// @Tags         events
+// @Produce      text/event-stream
+// @Param        selector       query   string  false  "Tag selector, e.g. env=prod,costCenter,!owner (values are globs)"
+// @Param        types          query   string  false  "Comma separated event types"
+// @Param        Last-Event-ID  header  string  false  "Resume after this event"
+// @Success      200  {string}  string  "event stream"
+// @Failure      400  {object}  map[string]string
+// @Router       /events [get]
+func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	sel, err := tags.ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	var types []string
+	if t := r.URL.Query().Get("types"); t != "" {
+		types = strings.Split(t, ",")
+		for _, typ := range types {
+			if !slices.Contains(models.EventTypes, typ) {
+				writeErr(w, 400, fmt.Sprintf("unknown event type %q, must be one of %v", typ, models.EventTypes))
+				return
+			}
+		}
+	}
+	keep := func(e models.Event) bool {
+		if len(types) > 0 && !slices.Contains(types, e.Type) {
+			return false
+		}
+		return e.ResourceID == "" || sel.Matches(e.Tags)
+	}
+
+	// the stream outlives the server write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		writeErr(w, 500, err.Error())
+		return
+	}
+
+	backlog, found, next, stop := h.eventLog.Follow(r.Header.Get("Last-Event-ID"), streamBuffer)
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // no proxy buffering
+	w.WriteHeader(200)
+	if !found {
+		fmt.Fprint(w, "event: reset\ndata: {\"reason\":\"Last-Event-ID is not in the event log anymore\"}\n\n")
+	}
+	for _, e := range backlog {
+		if keep(e) {
+			writeEvent(w, e)
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ping := time.NewTicker(streamKeepAlive)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.streams.Done():
+			return // shutting down
+		case e, ok := <-next:
+			if !ok {
+				return // too slow, the client resumes with Last-Event-ID
+			}
+			if !keep(e) {
+				continue
+			}
+			writeEvent(w, e)
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, e models.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+)
+
+type sseEvent struct {
+	id, typ string
+	data    models.Event
+}
+
+// stream opens GET /v1/events and returns the events read from it.
+func stream(t *testing.T, srv *httptest.Server, query, lastID string) <-chan sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/v1/events?"+query, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	out := make(chan sseEvent, 100)
+	go func() {
+		defer resp.Body.Close()
+		defer close(out)
+		var e sseEvent
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			field, value, _ := strings.Cut(sc.Text(), ": ")
+			switch field {
+			case "id":
+				e.id = value
+			case "event":
+				e.typ = value
+			case "data":
+				json.Unmarshal([]byte(value), &e.data)
+			case "":
+				if e.typ != "" {
+					out <- e
+				}
+				e = sseEvent{}
+			}
+		}
+	}()
+	return out
+}
+
+func next(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+		return sseEvent{}
+	}
+}
+
+func TestHandlers_StreamEvents_Selector(t *testing.T) {
+	h := New(store.NewMemoryStore(), config.Default())
+	srv := httptest.NewServer(newTestRouter(h))
+	t.Cleanup(func() {
+		h.CloseStreams()
+		srv.Close()
+	})
+	events := stream(t, srv, "selector="+url.QueryEscape("env=prod")+"&types=resource.created,resource.deleted,tags.updated", "")
+	ctx := context.Background()
+
+	dev := h.store.Create(ctx, "dev", dataVM+"-dev", models.ScopeResource, map[string]string{"env": "dev"})
+	prod := h.store.Create(ctx, "prod", dataVM, models.ScopeResource, map[string]string{"Env": "prod"})
+	h.store.MarkNeedsApply(ctx, prod.ID) // resource.updated, filtered out
+	h.store.MergeTags(ctx, dev.ID, map[string]string{"env": "prod"}, nil, "test")
+	h.store.Delete(ctx, prod.ID)
+
+	want := []struct{ typ, id string }{
+		{models.EventResourceCreated, prod.ID},
+		{models.EventTagsUpdated, dev.ID}, // matches once moved to prod
+		{models.EventResourceDeleted, prod.ID},
+	}
+	for _, w := range want {
+		e := next(t, events)
+		if e.typ != w.typ || e.data.ResourceID != w.id || e.id != e.data.ID {
+			t.Fatalf("expected %s of %s, got %+v", w.typ, w.id, e)
+		}
+	}
+}
+
+func TestHandlers_StreamEvents_Resume(t *testing.T) {
+	h := New(store.NewMemoryStore(), config.Default())
+	router := newTestRouter(h)
+	srv := httptest.NewServer(router)
+	t.Cleanup(func() {
+		h.CloseStreams()
+		srv.Close()
+	})
+	live := stream(t, srv, "", "")
+	ctx := context.Background()
+	for _, name := range []string{"a", "b", "c"} {
+		h.store.Create(ctx, name, dataVM+name, models.ScopeResource, nil)
+	}
+	var got []sseEvent
+	for range 3 {
+		got = append(got, next(t, live))
+	}
+
+	resumed := stream(t, srv, "", got[0].id)
+	for _, w := range got[1:] {
+		if e := next(t, resumed); e.id != w.id {
+			t.Fatalf("expected %s to be replayed, got %+v", w.id, e)
+		}
+	}
+
+	reset := stream(t, srv, "", "gone")
+	if e := next(t, reset); e.typ != "reset" {
+		t.Fatalf("expected a reset event first, got %+v", e)
+	}
+	if e := next(t, reset); e.id != got[0].id {
+		t.Fatalf("expected the whole log replayed, got %+v", e)
+	}
+
+	if rr := do(t, router, http.MethodGet, "/v1/events?selector=%3Denv", nil); rr.Code != 400 {
+		t.Fatalf("expected 400 for a bad selector, got %d", rr.Code)
+	}
+	if rr := do(t, router, http.MethodGet, "/v1/events?types=nope", nil); rr.Code != 400 {
+		t.Fatalf("expected 400 for an unknown type, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/azure"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/metrics"
+)
+
+// StartExpiry removes the expired tags every interval, until Interrupt.
+func (h *Handler) StartExpiry(every time.Duration) {
+	h.applies.Add(1)
+	go func() {
+		defer h.applies.Done()
+		tick := time.NewTicker(every)
+		defer tick.Stop()
+		for {
+			select {
+			case <-h.applyCtx.Done():
+				return
+			case now := <-tick.C:
+				if err := h.ExpireTags(h.applyCtx, now); err != nil {
+					logging.FromContext(h.applyCtx).Warn("tag expiry failed, retrying on the next sweep", slog.String("error", err.Error()))
+				}
+			}
+		}
+	}()
+}
+
+// ExpireTags removes the tags expired at now from Azure (Tags API delete of
+// the exact pairs) then from the store, recording it in the history. A
+// resource Azure fails on keeps its tags for the next sweep; one outside the
+// allowlist or gone from Azure, or every one when Azure isn't configured, only
+// loses them in the store.
+func (h *Handler) ExpireTags(ctx context.Context, now time.Time) error {
+	expired := h.store.Expired(ctx, now.Unix())
+	if len(expired) == 0 {
+		return nil
+	}
+	tagger, taggerErr := h.taggerFactory()
+
+	var errs []error
+	for _, res := range expired {
+		var keys []string
+		pairs := map[string]string{}
+		for k, at := range res.TagExpiry {
+			if at > now.Unix() {
+				continue
+			}
+			keys = append(keys, k)
+			if v, ok := res.Tags[k]; ok {
+				pairs[k] = v
+			}
+		}
+		slices.Sort(keys)
+
+		detail := "removed from azure"
+		if taggerErr != nil {
+			detail = "store only: azure not configured: " + taggerErr.Error()
+		} else if _, err := azure.Authorize(h.cfg.Azure.AllowedSubscriptions, res.AzureID); err != nil {
+			detail = "store only: " + err.Error()
+		} else if len(pairs) > 0 {
+			rctx, cancel := context.WithTimeout(ctx, h.cfg.Azure.ApplyTimeout)
+			err := tagger.RemoveTags(rctx, res.AzureID, pairs)
+			cancel()
+			switch {
+			case err == nil:
+			case metrics.ErrorClass(err) == "not_found":
+				detail = "store only: gone from azure"
+			default:
+				errs = append(errs, fmt.Errorf("%s: %w", res.ID, err))
+				continue
+			}
+		}
+
+		removed, err := h.store.RemoveExpiredTags(ctx, res.ID, keys, now.Unix(), detail)
+		if err != nil {
+			continue // deleted meanwhile
+		}
+		logging.FromContext(ctx).Info("expired tags removed",
+			slog.String("resource_id", res.ID),
+			slog.String("azure_id", res.AzureID),
+			slog.Any("keys", removed),
+			slog.String("detail", detail),
+		)
+	}
+	return errors.Join(errs...)
+}
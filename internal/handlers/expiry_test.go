@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+)
+
+func TestHandlers_ExpireTags(t *testing.T) {
+	const vm = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1"
+
+	tests := []struct {
+		name     string
+		allowed  []string
+		azureErr error
+		noAzure  bool // the tagger factory fails
+		wantErr  bool
+		removed  bool   // from the store
+		pushed   bool   // to Azure
+		detail   string // of the history entry
+	}{
+		{"removed everywhere", nil, nil, false, false, true, true, "removed from azure"},
+		{"azure fails, retried later", nil, errors.New("boom"), false, true, false, true, ""},
+		{"not allowed, store only", []string{"22222222-2222-2222-2222-222222222222"}, nil, false, false, true, false, "store only"},
+		{"azure not configured, store only", nil, nil, true, false, true, false, "store only: azure not configured"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Azure.AllowedSubscriptions = tc.allowed
+			st := store.NewMemoryStore()
+			h := New(st, cfg)
+			mt := &mockTagger{err: tc.azureErr}
+			h.taggerFactory = func() (AzureTagger, error) {
+				if tc.noAzure {
+					return nil, errors.New("no credentials")
+				}
+				return mt, nil
+			}
+			r := newTestRouter(h)
+
+			res := st.Create(t.Context(), "vm-1", vm, models.ScopeResource, map[string]string{"env": "prod"})
+			rr := do(t, r, http.MethodPatch, "/v1/resources/"+res.ID+"/tags", map[string]any{
+				"tags":    map[string]string{"incident": "INC123", "owner": "ops"},
+				"expires": map[string]string{"incident": "1h"},
+			})
+			if rr.Code != 200 {
+				t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+			}
+
+			// nothing expired yet
+			if err := h.ExpireTags(t.Context(), time.Now()); err != nil || mt.removed != nil {
+				t.Fatalf("expected nothing to expire, got %v / %v", err, mt.removed)
+			}
+
+			err := h.ExpireTags(t.Context(), time.Now().Add(2*time.Hour))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if pushed := mt.removed["incident"] == "INC123"; pushed != tc.pushed {
+				t.Fatalf("expected pushed to azure %v, got %v", tc.pushed, mt.removed)
+			}
+			got, _ := st.Get(t.Context(), res.ID)
+			if _, still := got.Tags["incident"]; still == tc.removed {
+				t.Fatalf("expected removed from the store %v, got %v", tc.removed, got.Tags)
+			}
+			if got.Tags["owner"] != "ops" || got.Tags["env"] != "prod" {
+				t.Fatalf("expected the other tags to stay, got %v", got.Tags)
+			}
+
+			history, _ := st.History(t.Context(), res.ID)
+			i := slices.IndexFunc(history, func(e models.HistoryEntry) bool { return e.Action == models.HistoryTagsExpired })
+			if (i >= 0) != tc.removed {
+				t.Fatalf("expected an expiry history entry %v, got %+v", tc.removed, history)
+			}
+			if i >= 0 && (!slices.Equal(history[i].Removed, []string{"incident"}) || !strings.HasPrefix(history[i].Detail, tc.detail)) {
+				t.Fatalf("unexpected history entry %+v", history[i])
+			}
+		})
+	}
+}
+
+func TestHandlers_UpdateResourceTags_Validation(t *testing.T) {
+	st := store.NewMemoryStore()
+	h := New(st, config.Default())
+	r := newTestRouter(h)
+	res := st.Create(t.Context(), "vm-1", "/subscriptions/x/resourceGroups/rg", models.ScopeResourceGroup, nil)
+
+	tests := []struct {
+		name string
+		id   string
+		body any
+		code int
+	}{
+		{"no tags", res.ID, map[string]any{}, 400},
+		{"expiry in the past", res.ID, map[string]any{"tags": map[string]string{"a": "b"}, "expires": map[string]string{"a": "2000-01-01T00:00:00Z"}}, 400},
+		{"expiry of an unknown key", res.ID, map[string]any{"tags": map[string]string{"a": "b"}, "expires": map[string]string{"c": "1h"}}, 400},
+		{"missing resource", "nope", map[string]any{"tags": map[string]string{"a": "b"}}, 404},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if rr := do(t, r, http.MethodPatch, "/v1/resources/"+tc.id+"/tags", tc.body); rr.Code != tc.code {
+				t.Fatalf("expected %d, got %d, body=%s", tc.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandlers_CreateResource_Expiry(t *testing.T) {
+	cfg := config.Default()
+	cfg.Tags.Rules = []config.Rule{{Name: "no-temp", Remove: []string{"temp"}}}
+	st := store.NewMemoryStore()
+	h := New(st, cfg)
+	r := newTestRouter(h)
+	var events []string
+	st.Events().Subscribe(func(e models.Event) { events = append(events, e.Type) })
+
+	rr := do(t, r, http.MethodPost, "/v1/resources", map[string]any{
+		"name": "vm-1", "azureId": dataVM,
+		"tags":    map[string]string{"incident": "INC123", "temp": "x"},
+		"expires": map[string]string{"incident": "1h", "temp": "1h"},
+	})
+	if rr.Code != 201 {
+		t.Fatalf("expected 201, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	res, _ := st.FindByAzureID(t.Context(), dataVM)
+	// temp was removed by the rule, its expiry with it
+	if _, ok := res.TagExpiry["incident"]; !ok || len(res.TagExpiry) != 1 {
+		t.Fatalf("expected only incident to expire, got %v", res.TagExpiry)
+	}
+	if !slices.Equal(events, []string{models.EventResourceCreated}) {
+		t.Fatalf("expected the resource written in one step, got events %v", events)
+	}
+}
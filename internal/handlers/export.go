@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/report"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tags"
+)
+
+const exportFlushEvery = 500 // rows
+
+// ExportResources godoc
+// @Summary      Export resources and their tags
+// @Description  Streams the registered resources, sorted by Azure ID, in the format POST /import reads: CSV (azure_id, name, then a column per tag key) or NDJSON.
+// @Description  tags picks the tag columns (default every key in use; tag keys named azure_id or name only come out in NDJSON). selector picks the resources, see GET /events.
+// @Tags         resources
+// @Produce      text/csv
+// @Produce      application/x-ndjson
+// @Param        format     query     string  false  "csv (default) | ndjson"
+// @Param        tags       query     string  false  "Comma separated tag keys"
+// @Param        selector   query     string  false  "Tag selector, e.g. env=prod,!owner"
+// @Param        delimiter  query     string  false  "CSV delimiter: one character (default ,), tab or semicolon"
+// @Success      200        {string}  string  "CSV or NDJSON rows"
+// @Failure      400        {object}  map[string]string
+// @Router       /export [get]
+func (h *Handler) ExportResources(w http.ResponseWriter, r *http.Request) {
+	format := formatCSV
+	if f := r.URL.Query().Get("format"); f != "" {
+		var err error
+		if format, err = checkFormat(f); err != nil {
+			writeErr(w, 400, err.Error())
+			return
+		}
+	}
+	delim, err := delimiter(r)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	sel, err := tags.ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+
+	var list []models.Resource
+	for _, res := range h.store.List(r.Context()) {
+		if sel.Matches(res.Tags) {
+			list = append(list, res)
+		}
+	}
+	slices.SortFunc(list, func(a, b models.Resource) int {
+		return cmp.Or(cmp.Compare(strings.ToLower(a.AzureID), strings.ToLower(b.AzureID)), cmp.Compare(a.ID, b.ID))
+	})
+	keys := exportKeys(r.URL.Query().Get("tags"), list, format)
+
+	rc := http.NewResponseController(w)
+	if format == formatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="resources.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Comma = delim
+		header := []string{colAzureID, colName}
+		for _, k := range keys {
+			header = append(header, report.CSVCell(k))
+		}
+		cw.Write(header)
+		for i, res := range list {
+			rec := []string{report.CSVCell(res.AzureID), report.CSVCell(res.Name)}
+			for _, k := range keys {
+				v, _ := tags.Get(res.Tags, k)
+				rec = append(rec, report.CSVCell(v))
+			}
+			cw.Write(rec)
+			if i%exportFlushEvery == exportFlushEvery-1 {
+				cw.Flush()
+				rc.Flush()
+			}
+		}
+		cw.Flush()
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="resources.ndjson"`)
+		enc := json.NewEncoder(w)
+		for i, res := range list {
+			row := resourceRow{AzureID: res.AzureID, Name: res.Name, Tags: map[string]string{}}
+			for _, k := range keys {
+				if v, ok := tags.Get(res.Tags, k); ok {
+					row.Tags[k] = v
+				}
+			}
+			enc.Encode(row)
+			if i%exportFlushEvery == exportFlushEvery-1 {
+				rc.Flush()
+			}
+		}
+	}
+	logging.FromContext(r.Context()).Info("resources exported",
+		slog.String("format", format), slog.Int("rows", len(list)), slog.Int("tag_columns", len(keys)))
+}
+
+// exportKeys are the tag keys asked for, or every key used by list, sorted.
+// Keys differing only by case are one column. CSV can't have a tag column
+// named like azure_id or name.
+func exportKeys(asked string, list []models.Resource, format string) []string {
+	var keys []string
+	add := func(k string) {
+		k = strings.TrimSpace(k)
+		if k == "" || slices.ContainsFunc(keys, func(have string) bool { return strings.EqualFold(have, k) }) {
+			return
+		}
+		if format == formatCSV && (strings.EqualFold(k, colAzureID) || strings.EqualFold(k, colName)) {
+			return
+		}
+		keys = append(keys, k)
+	}
+	if asked != "" {
+		for k := range strings.SplitSeq(asked, ",") {
+			add(k)
+		}
+		return keys
+	}
+	for _, res := range list {
+		for k := range res.Tags {
+			add(k)
+		}
+	}
+	slices.SortFunc(keys, func(a, b string) int { return cmp.Compare(strings.ToLower(a), strings.ToLower(b)) })
+	return keys
+}
@@ -1,32 +1,171 @@
 package handlers
 
 import (
+	"cmp"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
 	"net/http"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ThiagoScheffer/azure-tagger-api/internal/azure"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/events"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/identity"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/jobs"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
 	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tags"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tmpl"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/webhooks"
 	"github.com/go-chi/chi/v5"
-	//"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
 )
 
 type Handler struct {
 	store *store.MemoryStore
+	cfg   config.Config
 
 	taggerFactory TaggerFactory //for testing
+
+	// in-flight Azure applies, see Interrupt
+	applies       sync.WaitGroup
+	applyCtx      context.Context
+	cancelApplies context.CancelFunc
+
+	jobs     *jobs.Runner // background re-applies, stopped with the applies
+	events   *events.Bus  // the store's, the handlers publish apply, drift and job events on it too
+	eventLog *events.Log  // for GET /events
+	webhooks *webhooks.Dispatcher
+
+	driftMu sync.Mutex
+	drifted map[string]string // resource ID -> desired and actual tags of its last drift.detected
+
+	// done when the open GET /events streams must end, see CloseStreams
+	streams      context.Context
+	closeStreams context.CancelFunc
 }
 
-func New(st *store.MemoryStore) *Handler {
-	return &Handler{
+// events kept for GET /events resumes
+const eventLogSize = 1000
+
+func New(st *store.MemoryStore, cfg config.Config) *Handler {
+	applyCtx, cancel := context.WithCancel(context.Background())
+	streams, closeStreams := context.WithCancel(context.Background())
+	h := &Handler{
 		store: st,
-		taggerFactory: func() (AzureTagger, error) {
-			apiVersion := os.Getenv("AZURE_RESOURCE_API_VERSION")
-			// example: "2021-04-01" (depends on resource type!)
-			return azure.NewTagger(apiVersion)
-		},
+		cfg:   cfg,
+		// one tagger for the process: it keeps an ARM client per subscription
+		taggerFactory: sync.OnceValues(func() (AzureTagger, error) {
+			return azure.NewTagger(cfg.Azure)
+		}),
+		applyCtx:      applyCtx,
+		cancelApplies: cancel,
+		events:        st.Events(),
+		eventLog:      events.NewLog(eventLogSize),
+		webhooks:      webhooks.NewDispatcher(applyCtx, cfg.Webhooks),
+		streams:       streams,
+		closeStreams:  closeStreams,
+		drifted:       make(map[string]string),
 	}
+	h.jobs = jobs.NewRunner(applyCtx, 2, 100, func(j models.Job) {
+		h.events.Publish(models.Event{Type: models.EventJobUpdated, Data: j})
+	})
+	h.events.Subscribe(h.eventLog.Append)
+	h.events.Subscribe(h.webhooks.Handle)
+	h.events.Subscribe(func(e models.Event) {
+		if e.Type == models.EventResourceDeleted || e.Type == models.EventAzureApplied {
+			h.inSync(e.ResourceID)
+		}
+	})
+	return h
+}
+
+// Routes registers the API endpoints on r, main mounts them under /v1.
+func (h *Handler) Routes(r chi.Router) {
+	r.Post("/resources", h.CreateResource)
+	r.Get("/resources", h.ListResources)
+
+	r.Get("/resources/{id}", h.GetResource)
+	r.Delete("/resources/{id}", h.DeleteResource)
+
+	r.Post("/resources/{id}/apply-tags", h.ApplyTagsToAzure) //endpoint
+	r.Put("/resources/{id}/tag-sets", h.SetResourceTagSets)
+	r.Patch("/resources/{id}/tags", h.UpdateResourceTags)
+	r.Delete("/resources/{id}/tags", h.UnsetResourceTags)
+	r.Get("/resources/{id}/history", h.GetResourceHistory)
+
+	r.Post("/import", h.ImportResources)
+	r.Get("/export", h.ExportResources)
+	r.Get("/export/cost-allocation", h.ExportCostAllocation)
+	r.Get("/reports/compliance", h.ComplianceReport)
+	r.Get("/tags/inventory", h.TagInventory)
+	r.Post("/tags/rename", h.RenameTags)
+
+	r.Post("/tagsets", h.CreateTagSet)
+	r.Get("/tagsets", h.ListTagSets)
+	r.Get("/tagsets/{name}", h.GetTagSet)
+	r.Put("/tagsets/{name}", h.UpdateTagSet)
+	r.Delete("/tagsets/{name}", h.DeleteTagSet)
+
+	r.Get("/rules/preview", h.PreviewRules)
+	r.Post("/discover", h.Discover)
+	r.Get("/drift", h.Drift)
+
+	r.Get("/jobs", h.ListJobs)
+	r.Get("/jobs/{id}", h.GetJob)
+
+	r.Get("/events", h.StreamEvents)
+
+	r.Post("/webhooks", h.CreateWebhook)
+	r.Get("/webhooks", h.ListWebhooks)
+	r.Get("/webhooks/dead-letters", h.ListDeadLetters)
+	r.Post("/webhooks/dead-letters/{id}/redeliver", h.RedeliverDeadLetter)
+	r.Get("/webhooks/{id}", h.GetWebhook)
+	r.Delete("/webhooks/{id}", h.DeleteWebhook)
+}
+
+// publish sends an event about res to the subscribers (event log, webhooks).
+func (h *Handler) publish(typ string, res models.Resource, data any) {
+	h.events.Publish(models.Event{Type: typ, ResourceID: res.ID, AzureID: res.AzureID, Tags: res.Tags, Data: data})
+}
+
+// CloseStreams ends the GET /events streams, which would otherwise keep the
+// server from shutting down. main registers it with srv.RegisterOnShutdown.
+func (h *Handler) CloseStreams() {
+	h.closeStreams()
+}
+
+// Interrupt cancels the Azure applies, background jobs and webhook deliveries
+// still running and waits (until ctx is done) for them to be recorded as
+// interrupted/canceled.
+// main calls it once the HTTP server is drained, or gave up draining.
+func (h *Handler) Interrupt(ctx context.Context) error {
+	h.cancelApplies()
+
+	// the job workers apply too: once they are back, no apply starts anymore
+	if err := h.jobs.Wait(ctx); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		h.applies.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return h.webhooks.Wait(ctx)
 }
 
 func writeJSON(w http.ResponseWriter, code int, v any) {
@@ -39,21 +178,39 @@ func writeErr(w http.ResponseWriter, code int, msg string) {
 	writeJSON(w, code, map[string]string{"error": msg})
 }
 
+// writeAzureIDErr maps the errors of azure.Authorize to a response.
+func writeAzureIDErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, azure.ErrSubscriptionNotAllowed) {
+		writeErr(w, 403, err.Error())
+		return
+	}
+	writeErr(w, 400, err.Error())
+}
+
 type createReq struct {
 	Name    string            `json:"name"`
 	AzureID string            `json:"azureId"`
 	Tags    map[string]string `json:"tags"`
+	TagSets []string          `json:"tagSets"` // merged in order under Tags
+	// Expires gives keys of Tags an expiry: RFC 3339 time or TTL ("72h")
+	Expires map[string]string `json:"expires"`
 }
 
 // CreateResource godoc
 // @Summary      Create a resource
-// @Description  Stores an Azure resource ID + tags
+// @Description  Stores an Azure ID + tags. The ID can be a subscription, a resource group or a resource, the scope kind is detected from it.
+// @Description  Tag values can be templates, e.g. {{.RG}}-team or {{.Now | date "2006-01-02"}}, rendered and stored at creation.
+// @Description  tagSets references existing tag sets, merged in order under the resource tags.
+// @Description  The tagging rules (tags.rules) then run over the tags, see /rules/preview.
+// @Description  expires gives tags an expiry (RFC 3339 time or TTL like 72h), they are removed from the store and from Azure then.
 // @Tags         resources
 // @Accept       json
 // @Produce      json
 // @Param        payload  body      createReq  true  "Resource payload"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
 // @Success      201      {object}  models.Resource
 // @Failure      400      {object}  map[string]string
+// @Failure      403      {object}  map[string]string
 // @Router       /resources [post]
 func (h *Handler) CreateResource(w http.ResponseWriter, r *http.Request) {
 	var req createReq
@@ -65,38 +222,338 @@ func (h *Handler) CreateResource(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, 400, "name and azureId are required")
 		return
 	}
-	if req.Tags == nil {
-		req.Tags = map[string]string{}
+	scope, err := azure.ScopeOf(req.AzureID)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	if len(h.cfg.Azure.AllowedSubscriptions) > 0 {
+		if _, err := azure.Authorize(h.cfg.Azure.AllowedSubscriptions, req.AzureID); err != nil {
+			writeAzureIDErr(w, err)
+			return
+		}
 	}
-	res := h.store.Create(req.Name, req.AzureID, req.Tags)
+	// templated values ({{.RG}}-team...) are stored rendered
+	data := tmpl.NewData(req.Name, req.AzureID, scope, identity.Caller(r), time.Now())
+	rendered, err := tmpl.Render(req.Tags, data)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	final, hits, err := h.withRules(req.AzureID, rendered, data)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	// on the tags the resource ends up with, without the ones a rule removed
+	expires := maps.Clone(req.Expires)
+	maps.DeleteFunc(expires, func(k, _ string) bool {
+		_, asked := rendered[k]
+		_, kept := final[k]
+		return asked && !kept
+	})
+	expiry, err := tags.Expiry(expires, final, data.Now)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	res, err := h.store.CreateWith(r.Context(), store.NewResource{Name: req.Name, AzureID: req.AzureID, Scope: scope, Tags: final, TagSets: req.TagSets, TagExpiry: expiry})
+	if err != nil { // unknown tag set
+		writeErr(w, 400, err.Error())
+		return
+	}
+	logging.FromContext(r.Context()).Info("resource created",
+		slog.String("resource_id", res.ID),
+		slog.String("azure_id", res.AzureID),
+		slog.String("scope", res.Scope),
+		slog.Int("tag_count", len(res.Tags)),
+		slog.Int("rules_fired", len(hits)),
+	)
 	writeJSON(w, 201, res)
 }
 
+// ListResources godoc
+// @Summary      List resources
+// @Description  The registered resources, oldest first. selector filters on their own tags, scope keeps a subscription or resource group and what is under it, q the ones whose name or Azure ID contains it (ignoring case).
+// @Description  limit pages the list: X-Next-Cursor is then set while more remain, pass it back as cursor with the same filters for the next page.
+// @Tags         resources
+// @Produce      json
+// @Param        selector    query     string  false  "Tag selector, e.g. env=prod,!owner"
+// @Param        scope       query     string  false  "Azure ID of a subscription or resource group"
+// @Param        q           query     string  false  "Part of the name or Azure ID"
+// @Param        needsApply  query     bool    false  "Only the ones Azure may be behind on"
+// @Param        limit       query     int     false  "Page size, 1 to 1000 (default all)"
+// @Param        cursor      query     string  false  "X-Next-Cursor of the previous page"
+// @Success      200         {array}   models.Resource
+// @Header       200         {string}  X-Next-Cursor  "Cursor of the next page, when there is one"
+// @Failure      400         {object}  map[string]string
+// @Router       /resources [get]
 func (h *Handler) ListResources(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, 200, h.store.List())
+	q := r.URL.Query()
+	sel, err := tags.ParseSelector(q.Get("selector"))
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	scope := strings.ToLower(strings.TrimSuffix(q.Get("scope"), "/"))
+	text := strings.ToLower(q.Get("q"))
+	needsApply := q.Get("needsApply") == "true"
+	limit := 0 // all
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPage {
+			writeErr(w, 400, fmt.Sprintf("limit must be 1 to %d", maxPage))
+			return
+		}
+		limit = n
+	}
+	var after *pageKey
+	if v := q.Get("cursor"); v != "" {
+		if after, err = parseCursor(v); err != nil {
+			writeErr(w, 400, "invalid cursor")
+			return
+		}
+	}
+
+	out := []models.Resource{}
+	for _, res := range h.store.List(r.Context()) {
+		id := strings.ToLower(res.AzureID)
+		switch {
+		case !sel.Matches(res.Tags),
+			scope != "" && id != scope && !strings.HasPrefix(id, scope+"/"),
+			text != "" && !strings.Contains(strings.ToLower(res.Name), text) && !strings.Contains(id, text),
+			needsApply && !res.NeedsApply,
+			after != nil && after.compare(keyOf(res)) >= 0:
+			continue
+		}
+		out = append(out, res)
+	}
+	slices.SortFunc(out, func(a, b models.Resource) int { return keyOf(a).compare(keyOf(b)) })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+		w.Header().Set("X-Next-Cursor", keyOf(out[limit-1]).cursor())
+	}
+	writeJSON(w, 200, out)
+}
+
+// maxPage is the largest limit of a list.
+const maxPage = 1000
+
+// pageKey is what the resources are listed by. A cursor is the key of the
+// last one of a page, so the next page is what sorts after it even when
+// resources were added or deleted in between.
+type pageKey struct {
+	Created int64  `json:"c"`
+	Name    string `json:"n"`
+	ID      string `json:"i"`
+}
+
+func keyOf(res models.Resource) pageKey {
+	return pageKey{res.CreatedUnix, res.Name, res.ID}
+}
+
+func (k pageKey) compare(o pageKey) int {
+	return cmp.Or(cmp.Compare(k.Created, o.Created), cmp.Compare(k.Name, o.Name), cmp.Compare(k.ID, o.ID))
+}
+
+// cursor is opaque to the clients, they pass it back as it is.
+func (k pageKey) cursor() string {
+	b, _ := json.Marshal(k)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseCursor(s string) (*pageKey, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var k pageKey
+	if err := json.Unmarshal(b, &k); err != nil {
+		return nil, err
+	}
+	if k.ID == "" {
+		return nil, errors.New("cursor without an ID")
+	}
+	return &k, nil
 }
 
+// GetResource godoc
+// @Summary      Get a resource
+// @Description  Returns the resource with its effective tags: its tag sets in order, overridden by its own tags, plus the ones inherited from its resource group (tags.inherited_keys), each with its source.
+// @Tags         resources
+// @Produce      json
+// @Param        id   path      string  true  "Resource ID"
+// @Success      200  {object}  models.ResourceDetail
+// @Failure      404  {object}  map[string]string
+// @Router       /resources/{id} [get]
 func (h *Handler) GetResource(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	res, err := h.store.Get(id)
+	res, err := h.store.Get(r.Context(), id)
+	if err != nil {
+		writeErr(w, 404, "not found")
+		return
+	}
+	writeJSON(w, 200, models.ResourceDetail{
+		Resource:      res,
+		EffectiveTags: h.effectiveTags(r.Context(), res, res.Tags),
+	})
+}
+
+// effectiveTags merges the tag sets of res, explicit (the tags of res, or
+// the ones of an apply) and what res inherits from its resource group, when
+// that one is registered.
+func (h *Handler) effectiveTags(ctx context.Context, res models.Resource, explicit map[string]string) map[string]models.EffectiveTag {
+	sets := make([]models.TagSet, 0, len(res.TagSets))
+	for _, name := range res.TagSets {
+		if ts, err := h.store.GetTagSet(ctx, name); err == nil { // can't be deleted while referenced
+			sets = append(sets, ts)
+		}
+	}
+
+	var parent *models.Resource
+	if rgID, ok := azure.ResourceGroupOf(res.AzureID); ok && len(h.cfg.Tags.InheritedKeys) > 0 {
+		if rg, err := h.store.FindByAzureID(ctx, rgID); err == nil {
+			parent = &rg
+		}
+	}
+	return tags.Effective(sets, explicit, parent, h.cfg.Tags)
+}
+
+type updateTagsReq struct {
+	Tags    map[string]string `json:"tags"`
+	Expires map[string]string `json:"expires"` // RFC 3339 time or TTL ("72h") per key of Tags
+}
+
+// UpdateResourceTags godoc
+// @Summary      Set tags on a resource
+// @Description  Merges the tags into the stored ones (templates rendered) and marks the resource needs_apply. Nothing is pushed to Azure.
+// @Description  expires makes a tag temporary (e.g. incident=INC123 for 72h); a tag set again without it becomes permanent.
+// @Tags         resources
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string         true  "Resource ID"
+// @Param        payload  body      updateTagsReq  true  "Tags to set"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      200      {object}  models.Resource
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Router       /resources/{id}/tags [patch]
+func (h *Handler) UpdateResourceTags(w http.ResponseWriter, r *http.Request) {
+	res, err := h.store.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, 404, "not found")
+		return
+	}
+	var req updateTagsReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, 400, "invalid json")
+		return
+	}
+	if len(req.Tags) == 0 {
+		writeErr(w, 400, "tags required")
+		return
+	}
+	data := tmpl.NewData(res.Name, res.AzureID, res.Scope, identity.Caller(r), time.Now())
+	rendered, err := tmpl.Render(req.Tags, data)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	expiry, err := tags.Expiry(req.Expires, rendered, data.Now)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	res, err = h.store.MergeTags(r.Context(), res.ID, rendered, expiry, identity.Caller(r))
 	if err != nil {
 		writeErr(w, 404, "not found")
 		return
 	}
+	logging.FromContext(r.Context()).Info("resource tags updated",
+		slog.String("resource_id", res.ID),
+		slog.Int("tag_count", len(rendered)),
+		slog.Int("expiring", len(expiry)),
+	)
 	writeJSON(w, 200, res)
 }
 
+// UnsetResourceTags godoc
+// @Summary      Remove tags from a resource
+// @Description  Removes the keys (ignoring case) from the stored tags and marks the resource needs_apply when some were there. Nothing is pushed to Azure. Tags from tag sets or inherited stay, see GET /resources/{id}.
+// @Tags         resources
+// @Produce      json
+// @Param        id    path      string  true  "Resource ID"
+// @Param        keys  query     string  true  "Comma separated keys, e.g. owner,temp"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      200   {object}  models.Resource
+// @Failure      400   {object}  map[string]string
+// @Failure      404   {object}  map[string]string
+// @Router       /resources/{id}/tags [delete]
+func (h *Handler) UnsetResourceTags(w http.ResponseWriter, r *http.Request) {
+	var keys []string
+	for k := range strings.SplitSeq(r.URL.Query().Get("keys"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		writeErr(w, 400, "keys required")
+		return
+	}
+	res, err := h.store.UnsetTags(r.Context(), chi.URLParam(r, "id"), keys, identity.Caller(r))
+	if err != nil {
+		writeErr(w, 404, "not found")
+		return
+	}
+	logging.FromContext(r.Context()).Info("resource tags removed",
+		slog.String("resource_id", res.ID),
+		slog.Int("keys", len(keys)),
+	)
+	writeJSON(w, 200, res)
+}
+
+// GetResourceHistory godoc
+// @Summary      Tag history of a resource
+// @Description  The tag changes of the resource, oldest first: updates, removals, renames and expired tags removed.
+// @Tags         resources
+// @Produce      json
+// @Param        id   path      string  true  "Resource ID"
+// @Success      200  {array}   models.HistoryEntry
+// @Failure      404  {object}  map[string]string
+// @Router       /resources/{id}/history [get]
+func (h *Handler) GetResourceHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := h.store.History(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, 404, "not found")
+		return
+	}
+	writeJSON(w, 200, history)
+}
+
+// DeleteResource godoc
+// @Summary      Delete a resource
+// @Description  Unregisters the resource, its Azure tags stay.
+// @Tags         resources
+// @Param        id  path  string  true  "Resource ID"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      204
+// @Failure      404  {object}  map[string]string
+// @Router       /resources/{id} [delete]
 func (h *Handler) DeleteResource(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	if err := h.store.Delete(id); err != nil {
+	if err := h.store.Delete(r.Context(), id); err != nil {
 		writeErr(w, 404, "not found")
 		return
 	}
+	logging.FromContext(r.Context()).Info("resource deleted", slog.String("resource_id", id))
 	w.WriteHeader(204)
 }
 
 type AzureTagger interface {
 	ApplyTags(ctx context.Context, resourceID string, tags map[string]string) error
+	ListResources(ctx context.Context, scopeID string) ([]azure.Discovered, error)
+	RemoveTags(ctx context.Context, resourceID string, tags map[string]string) error
+	GetTags(ctx context.Context, scopeID string) (map[string]string, error)
 }
 
 type TaggerFactory func() (AzureTagger, error)
@@ -2,30 +2,54 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"maps"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
 	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
 )
 
+// newTestRouter serves the API of h under /v1, as main does.
 func newTestRouter(h *Handler) http.Handler {
 	r := chi.NewRouter()
-	r.Route("/v1", func(r chi.Router) {
-		r.Post("/resources", h.CreateResource)
-		r.Get("/resources", h.ListResources)
-		r.Get("/resources/{id}", h.GetResource)
-		r.Delete("/resources/{id}", h.DeleteResource)
-	})
+	r.Route("/v1", h.Routes)
 	return r
 }
 
+// do sends a request to router. A string body goes as is, anything else as
+// JSON. header is key, value pairs.
+func do(t *testing.T, router http.Handler, method, path string, body any, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var b []byte
+	switch body := body.(type) {
+	case nil:
+	case string:
+		b = []byte(body)
+	default:
+		b, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
 func TestHandlers_Create_List_Get_Delete_HappyPath(t *testing.T) {
 	st := store.NewMemoryStore()
-	h := New(st)
+	h := New(st, config.Default())
 	router := newTestRouter(h)
 
 	// Create
@@ -102,9 +126,98 @@ func TestHandlers_Create_List_Get_Delete_HappyPath(t *testing.T) {
 	}
 }
 
+func TestHandlers_Create_DetectsScope(t *testing.T) {
+	router := newTestRouter(New(store.NewMemoryStore(), config.Default()))
+
+	tests := []struct {
+		azureID string
+		want    string
+	}{
+		{"/subscriptions/11111111-1111-1111-1111-111111111111", "subscription"},
+		{"/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app", "resource_group"},
+		{"/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app/providers/Microsoft.Web/sites/app", "resource"},
+	}
+	for _, tc := range tests {
+		b, _ := json.Marshal(map[string]any{"name": "x", "azureId": tc.azureID})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/resources", bytes.NewReader(b)))
+
+		var got models.Resource
+		json.Unmarshal(rr.Body.Bytes(), &got)
+		if rr.Code != http.StatusCreated || got.Scope != tc.want {
+			t.Fatalf("%s: expected 201 with scope %q, got %d %s", tc.azureID, tc.want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestHandlers_Get_EffectiveTags(t *testing.T) {
+	const rg = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app"
+
+	st := store.NewMemoryStore()
+	cfg := config.Default()
+	cfg.Tags.InheritedKeys = []string{"costCenter"}
+	router := newTestRouter(New(st, cfg))
+
+	st.Create(context.Background(), "rg-app", rg, models.ScopeResourceGroup, map[string]string{"costCenter": "42", "owner": "ops"})
+	vm := st.Create(context.Background(), "vm-1", rg+"/providers/Microsoft.Compute/virtualMachines/vm-1", models.ScopeResource, map[string]string{"app": "web"})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/resources/"+vm.ID, nil))
+
+	var got models.ResourceDetail
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid json response: %v", err)
+	}
+	if len(got.Tags) != 1 {
+		t.Fatalf("expected the explicit tags untouched, got %v", got.Tags)
+	}
+	want := map[string]models.EffectiveTag{
+		"app":        {Value: "web", Source: models.TagExplicit},
+		"costCenter": {Value: "42", Source: models.TagInherited, InheritedFrom: rg},
+	}
+	if !maps.Equal(got.EffectiveTags, want) {
+		t.Fatalf("expected effective tags %v, got %v", want, got.EffectiveTags)
+	}
+}
+
+func TestHandlers_Create_RendersTemplates(t *testing.T) {
+	st := store.NewMemoryStore()
+	router := newTestRouter(New(st, config.Default()))
+
+	body := `{"name":"vm-1","azureId":"/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-shop/providers/Microsoft.Compute/virtualMachines/vm-1",
+		"tags":{"owner":"{{.RG}}-team","createdOn":"{{.Now | date \"2006-01-02\"}}","createdBy":"{{.Caller}}","env":"dev"}}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/resources", bytes.NewBufferString(body))
+	req.Header.Set("X-MS-CLIENT-PRINCIPAL-NAME", "ana@contoso.com")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var created models.Resource
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	want := map[string]string{
+		"owner":     "rg-shop-team",
+		"createdOn": time.Now().UTC().Format("2006-01-02"),
+		"createdBy": "ana@contoso.com",
+		"env":       "dev",
+	}
+	stored, _ := st.Get(context.Background(), created.ID)
+	if !maps.Equal(stored.Tags, want) {
+		t.Fatalf("expected rendered tags %v to be stored, got %v", want, stored.Tags)
+	}
+
+	bad := httptest.NewRequest(http.MethodPost, "/v1/resources", bytes.NewBufferString(`{"name":"vm-1","azureId":"/subscriptions/x/resourceGroups/rg","tags":{"owner":"{{.Team}}"}}`))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, bad)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown variable, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+}
+
 func TestHandlers_Create_TableDriven(t *testing.T) {
 	st := store.NewMemoryStore()
-	h := New(st)
+	h := New(st, config.Default())
 	router := newTestRouter(h)
 
 	tests := []struct {
@@ -132,6 +245,11 @@ func TestHandlers_Create_TableDriven(t *testing.T) {
 			body:       `{"name":"vm-1","azureId":"/subscriptions/x/.../vm-1","tags":{"env":"dev"}}`,
 			wantStatus: http.StatusCreated,
 		},
+		{
+			name:       "not an azure id",
+			body:       `{"name":"vm-1","azureId":"vm-1"}`,
+			wantStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, tc := range tests {
@@ -147,3 +265,104 @@ func TestHandlers_Create_TableDriven(t *testing.T) {
 		})
 	}
 }
+
+func TestHandlers_ListResources_Filters(t *testing.T) {
+	h := New(store.NewMemoryStore(), config.Default())
+	ctx := context.Background()
+	h.store.Create(ctx, "rg-app", rgApp, models.ScopeResourceGroup, map[string]string{"env": "prod"})
+	h.store.Create(ctx, "vm-1", dataVM, models.ScopeResource, map[string]string{"env": "dev"})
+	web := h.store.Create(ctx, "web", rgApp+"/providers/Microsoft.Web/sites/web", models.ScopeResource, map[string]string{"env": "prod", "owner": "me"})
+	h.store.MarkNeedsApply(ctx, web.ID)
+	r := newTestRouter(h)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"rg-app", "vm-1", "web"}},
+		{"selector=env=prod,!owner", []string{"rg-app"}},
+		{"scope=" + strings.ToUpper(rgApp), []string{"rg-app", "web"}},
+		{"q=DATA-LAKE", []string{"vm-1"}},
+		{"q=we&needsApply=true", []string{"web"}},
+		{"scope=" + rgApp + "-2", nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			rr := do(t, r, "GET", "/v1/resources?"+tc.query, nil)
+			var list []models.Resource
+			if rr.Code != 200 || json.Unmarshal(rr.Body.Bytes(), &list) != nil {
+				t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			var names []string
+			for _, res := range list {
+				names = append(names, res.Name)
+			}
+			if !slices.Equal(names, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, names)
+			}
+		})
+	}
+	if rr := do(t, r, "GET", "/v1/resources?selector=!", nil); rr.Code != 400 {
+		t.Fatalf("expected 400 on a bad selector, got %d", rr.Code)
+	}
+}
+
+func TestHandlers_ListResources_Pages(t *testing.T) {
+	h := New(store.NewMemoryStore(), config.Default())
+	ctx := context.Background()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		h.store.Create(ctx, name, rgApp+"/providers/Microsoft.Web/sites/"+name, models.ScopeResource, map[string]string{"env": "prod"})
+	}
+	r := newTestRouter(h)
+
+	var pages [][]string
+	cursor := ""
+	for {
+		rr := do(t, r, "GET", "/v1/resources?selector=env=prod&limit=2&cursor="+cursor, nil)
+		var list []models.Resource
+		if rr.Code != 200 || json.Unmarshal(rr.Body.Bytes(), &list) != nil {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var names []string
+		for _, res := range list {
+			names = append(names, res.Name)
+		}
+		pages = append(pages, names)
+		if cursor = rr.Header().Get("X-Next-Cursor"); cursor == "" {
+			break
+		}
+		if len(pages) == 1 { // deleting a listed one doesn't shift the next pages
+			h.store.Delete(ctx, list[0].ID)
+		}
+	}
+	if want := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}; !slices.EqualFunc(pages, want, slices.Equal) {
+		t.Fatalf("expected %v, got %v", want, pages)
+	}
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=x", "cursor=nope", "cursor=e30"} {
+		if rr := do(t, r, "GET", "/v1/resources?"+query, nil); rr.Code != 400 {
+			t.Fatalf("%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestHandlers_UnsetResourceTags(t *testing.T) {
+	h := New(store.NewMemoryStore(), config.Default())
+	res := h.store.Create(context.Background(), "vm-1", dataVM, models.ScopeResource, map[string]string{"env": "dev", "Owner": "me", "temp": "1"})
+	r := newTestRouter(h)
+
+	rr := do(t, r, "DELETE", "/v1/resources/"+res.ID+"/tags?keys=owner,%20temp", nil)
+	var got models.Resource
+	if rr.Code != 200 || json.Unmarshal(rr.Body.Bytes(), &got) != nil {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !got.NeedsApply || !maps.Equal(got.Tags, map[string]string{"env": "dev"}) {
+		t.Fatalf("expected only env left, got %+v", got)
+	}
+	if rr := do(t, r, "DELETE", "/v1/resources/"+res.ID+"/tags?keys=,", nil); rr.Code != 400 {
+		t.Fatalf("expected 400 without keys, got %d", rr.Code)
+	}
+	if rr := do(t, r, "DELETE", "/v1/resources/nope/tags?keys=env", nil); rr.Code != 404 {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/azure"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/identity"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/report"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tmpl"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	// CSV columns that are not tag keys
+	colAzureID = "azure_id"
+	colName    = "name"
+
+	maxImportBytes = 10 << 20
+)
+
+// resourceRow is a line of an import or an export.
+type resourceRow struct {
+	Line    int               `json:"-"`
+	AzureID string            `json:"azureId"`
+	Name    string            `json:"name,omitempty"`
+	Tags    map[string]string `json:"tags"`
+}
+
+type importError struct {
+	Row     int    `json:"row"` // line in the file
+	AzureID string `json:"azure_id,omitempty"`
+	Error   string `json:"error"`
+}
+
+type importResp struct {
+	Rows    int           `json:"rows"`
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Failed  int           `json:"failed"`
+	Atomic  bool          `json:"atomic"` // nothing was imported when a row failed
+	Errors  []importError `json:"errors"`
+}
+
+// ImportResources godoc
+// @Summary      Import resources and their tags
+// @Description  Upserts one resource per row: Azure IDs not registered yet are created (templates and tagging rules applied like POST /resources), the tags of the others are merged into theirs and they are marked needs_apply. Empty cells are skipped, an import never removes a tag.
+// @Description  CSV: an azure_id column, an optional name column and one column per tag key. NDJSON: one {"azureId", "name", "tags"} object per line. The format comes from format or the Content-Type.
+// @Description  Every row is validated and the failing ones reported with their line. With atomic=true nothing is imported when a row fails (422), otherwise the valid rows are.
+// @Tags         resources
+// @Accept       text/csv
+// @Accept       application/x-ndjson
+// @Produce      json
+// @Param        format     query     string  false  "csv | ndjson"
+// @Param        atomic     query     bool    false  "All rows or none"
+// @Param        delimiter  query     string  false  "CSV delimiter: one character (default ,), tab or semicolon"
+// @Param        payload    body      string  true   "CSV or NDJSON rows"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      200        {object}  importResp
+// @Failure      400        {object}  map[string]string
+// @Failure      413        {object}  map[string]string
+// @Failure      422        {object}  importResp
+// @Router       /import [post]
+func (h *Handler) ImportResources(w http.ResponseWriter, r *http.Request) {
+	format, err := importFormat(r)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	delim, err := delimiter(r)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	atomic := r.URL.Query().Get("atomic") == "true"
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var rows []resourceRow
+	var errs []importError
+	if format == formatCSV {
+		rows, errs, err = readCSV(body, delim)
+	} else {
+		rows, errs, err = readNDJSON(body)
+	}
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeErr(w, 413, fmt.Sprintf("import is limited to %d bytes", tooLarge.Limit))
+		return
+	case err != nil:
+		writeErr(w, 400, err.Error())
+		return
+	}
+	total := len(rows) + len(errs) // malformed rows are already in errs
+
+	items, invalid := h.importItems(r, rows)
+	errs = append(errs, invalid...)
+	slices.SortFunc(errs, func(a, b importError) int { return a.Row - b.Row })
+	resp := importResp{Rows: total, Failed: len(errs), Atomic: atomic, Errors: errs}
+	if resp.Errors == nil {
+		resp.Errors = []importError{}
+	}
+	log := logging.FromContext(r.Context()).With(slog.String("format", format), slog.Bool("atomic", atomic))
+	if atomic && len(errs) > 0 {
+		log.Warn("import rejected", slog.Int("rows", resp.Rows), slog.Int("failed", resp.Failed))
+		writeJSON(w, 422, resp)
+		return
+	}
+
+	for _, res := range h.store.Upsert(r.Context(), items, identity.Caller(r)) {
+		if res.Created {
+			resp.Created++
+		} else {
+			resp.Updated++
+		}
+	}
+	log.Info("resources imported",
+		slog.Int("rows", resp.Rows),
+		slog.Int("created", resp.Created),
+		slog.Int("updated", resp.Updated),
+		slog.Int("failed", resp.Failed),
+	)
+	writeJSON(w, 200, resp)
+}
+
+// importItems validates the rows and turns the valid ones into upserts.
+func (h *Handler) importItems(r *http.Request, rows []resourceRow) ([]store.UpsertItem, []importError) {
+	var items []store.UpsertItem
+	var errs []importError
+	seen := map[string]int{} // lower Azure ID -> line
+	now := time.Now()
+	for _, row := range rows {
+		fail := func(err error) {
+			errs = append(errs, importError{Row: row.Line, AzureID: row.AzureID, Error: err.Error()})
+		}
+		if row.AzureID == "" {
+			fail(errors.New("azure ID required"))
+			continue
+		}
+		scope, err := azure.ScopeOf(row.AzureID)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		if len(h.cfg.Azure.AllowedSubscriptions) > 0 {
+			if _, err := azure.Authorize(h.cfg.Azure.AllowedSubscriptions, row.AzureID); err != nil {
+				fail(err)
+				continue
+			}
+		}
+		if line, ok := seen[strings.ToLower(row.AzureID)]; ok {
+			fail(fmt.Errorf("duplicate of row %d", line))
+			continue
+		}
+		seen[strings.ToLower(row.AzureID)] = row.Line
+
+		if row.Name == "" {
+			row.Name = nameOf(row.AzureID)
+		}
+		data := tmpl.NewData(row.Name, row.AzureID, scope, identity.Caller(r), now)
+		rendered, err := tmpl.Render(row.Tags, data)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		// only used if it isn't registered, cheap enough to always compute
+		newTags, _, err := h.withRules(row.AzureID, rendered, data)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		items = append(items, store.UpsertItem{Name: row.Name, AzureID: row.AzureID, Scope: scope, Tags: rendered, NewTags: newTags})
+	}
+	return items, errs
+}
+
+// importFormat is the format query parameter, or the one of the Content-Type.
+func importFormat(r *http.Request) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return checkFormat(f)
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mt {
+	case "text/csv":
+		return formatCSV, nil
+	case "application/x-ndjson", "application/jsonl", "application/json":
+		return formatNDJSON, nil
+	}
+	return "", fmt.Errorf("unknown format, set format=csv|ndjson or the Content-Type (got %q)", mt)
+}
+
+func checkFormat(f string) (string, error) {
+	if f != formatCSV && f != formatNDJSON {
+		return "", fmt.Errorf("format must be %s or %s", formatCSV, formatNDJSON)
+	}
+	return f, nil
+}
+
+// delimiter is the CSV delimiter query parameter, Excel uses ; in some locales.
+func delimiter(r *http.Request) (rune, error) {
+	d := r.URL.Query().Get("delimiter")
+	switch {
+	case d == "":
+		return ',', nil
+	case d == "tab":
+		return '\t', nil
+	case d == "semicolon": // a raw ; must be sent as %3B
+		return ';', nil
+	case utf8.RuneCountInString(d) == 1 && !strings.ContainsAny(d, "\"\r\n"):
+		return []rune(d)[0], nil
+	}
+	return 0, fmt.Errorf("invalid delimiter %q", d)
+}
+
+// readCSV reads the rows of a CSV with a header. A malformed row is reported
+// and skipped, a bad header or body fails the whole import.
+func readCSV(body io.Reader, delim rune) ([]resourceRow, []importError, error) {
+	br := bufio.NewReader(body)
+	if bom, _ := br.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		br.Discard(3) // Excel's "CSV UTF-8"
+	}
+	cr := csv.NewReader(br)
+	cr.Comma = delim
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading the CSV header: %w", err)
+	}
+	idCol, nameCol := -1, -1
+	for i, col := range header {
+		col = report.CSVValue(strings.TrimSpace(col))
+		header[i] = col
+		switch {
+		case col == "":
+			return nil, nil, fmt.Errorf("column %d has no name", i+1)
+		case slices.IndexFunc(header[:i], func(c string) bool { return strings.EqualFold(c, col) }) >= 0:
+			return nil, nil, fmt.Errorf("column %q is there twice", col)
+		case strings.EqualFold(col, colAzureID):
+			idCol = i
+		case strings.EqualFold(col, colName):
+			nameCol = i
+		}
+	}
+	if idCol < 0 {
+		return nil, nil, fmt.Errorf("missing the %s column", colAzureID)
+	}
+
+	var rows []resourceRow
+	var errs []importError
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			errs = append(errs, importError{Row: perr.StartLine, Error: perr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading the CSV: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+		row := resourceRow{Line: line, AzureID: report.CSVValue(strings.TrimSpace(rec[idCol])), Tags: map[string]string{}}
+		for i, v := range rec {
+			v = report.CSVValue(strings.TrimSpace(v))
+			switch {
+			case i == idCol:
+			case i == nameCol:
+				row.Name = v
+			case v != "":
+				row.Tags[header[i]] = v
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, errs, nil
+}
+
+// readNDJSON reads one JSON row per line, blank lines are skipped.
+func readNDJSON(body io.Reader) ([]resourceRow, []importError, error) {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	var rows []resourceRow
+	var errs []importError
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		row := resourceRow{Line: line}
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			errs = append(errs, importError{Row: line, Error: "invalid json: " + err.Error()})
+			continue
+		}
+		row.Line = line
+		rows = append(rows, row)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, fmt.Errorf("reading the NDJSON: %w", err)
+	}
+	return rows, errs, nil
+}
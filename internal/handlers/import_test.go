@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+)
+
+const (
+	subA  = "/subscriptions/11111111-1111-1111-1111-111111111111"
+	rgApp = subA + "/resourceGroups/rg-app"
+)
+
+// importConfig allows subA only, with a rule on the data resource groups.
+func importConfig() config.Config {
+	cfg := config.Default()
+	cfg.Azure.AllowedSubscriptions = []string{"11111111-1111-1111-1111-111111111111"}
+	cfg.Tags.Rules = []config.Rule{{Name: "data", Match: config.RuleMatch{ResourceGroup: "rg-data-*"}, Default: map[string]string{"dataClassification": "confidential"}}}
+	return cfg
+}
+
+func TestHandlers_Import_TableDriven(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+		code        int
+		want        importResp
+		errRows     []int
+	}{
+		{
+			name: "csv upsert", contentType: "text/csv",
+			body: "\ufeffAzure_ID,name,env,costCenter\n" +
+				dataVM + ",,prod,cc-1\n" +
+				rgApp + ",app,dev,\n",
+			code: 200, want: importResp{Rows: 2, Created: 1, Updated: 1},
+		},
+		{
+			name: "row by row keeps the valid rows", contentType: "text/csv",
+			body: "azure_id,env\n" +
+				rgApp + ",dev\n" +
+				"not-an-id,dev\n" +
+				"/subscriptions/22222222-2222-2222-2222-222222222222,dev\n" +
+				rgApp + ",prod\n" +
+				subA + ",dev,extra\n" +
+				",dev\n",
+			code: 200, want: importResp{Rows: 6, Created: 1, Failed: 5}, errRows: []int{3, 4, 5, 6, 7},
+		},
+		{
+			name: "atomic rejects everything", query: "?atomic=true", contentType: "text/csv",
+			body: "azure_id,env\n" + rgApp + ",dev\nnope,dev\n",
+			code: 422, want: importResp{Rows: 2, Failed: 1, Atomic: true}, errRows: []int{3},
+		},
+		{
+			name: "ndjson", contentType: "application/x-ndjson",
+			body: `{"azureId":"` + dataVM + `","tags":{"env":"prod"}}` + "\n\n" +
+				`{"azureId":"` + rgApp + `","tags":{"team":"{{.RG}}"}}` + "\n" +
+				"{broken\n",
+			code: 200, want: importResp{Rows: 3, Created: 1, Updated: 1, Failed: 1}, errRows: []int{4},
+		},
+		{
+			name: "semicolons", query: "?format=csv&delimiter=semicolon",
+			body: "azure_id;env\n" + rgApp + ";dev\n",
+			code: 200, want: importResp{Rows: 1, Created: 1},
+		},
+		{name: "no azure_id column", contentType: "text/csv", body: "id,env\nx,y\n", code: 400},
+		{name: "duplicate column", contentType: "text/csv", body: "azure_id,env,Env\n", code: 400},
+		{name: "unknown format", contentType: "application/xml", body: "<x/>", code: 400},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := New(store.NewMemoryStore(), importConfig())
+			h.store.Create(context.Background(), "vm-1", dataVM, models.ScopeResource, map[string]string{"env": "dev", "owner": "me"})
+			router := newTestRouter(h)
+			rr := do(t, router, http.MethodPost, "/v1/import"+tc.query, tc.body, "Content-Type", tc.contentType)
+			if rr.Code != tc.code {
+				t.Fatalf("expected %d, got %d, body=%s", tc.code, rr.Code, rr.Body.String())
+			}
+			if tc.code == 400 {
+				return
+			}
+			var got importResp
+			json.Unmarshal(rr.Body.Bytes(), &got)
+			var rows []int
+			for _, e := range got.Errors {
+				rows = append(rows, e.Row)
+			}
+			got.Errors = nil
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %+v, got %+v", tc.want, got)
+			}
+			if !slices.Equal(rows, tc.errRows) {
+				t.Fatalf("expected errors on rows %v, got %s", tc.errRows, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandlers_Import_Tags(t *testing.T) {
+	h := New(store.NewMemoryStore(), importConfig())
+	h.store.Create(context.Background(), "vm-1", dataVM, models.ScopeResource, map[string]string{"env": "dev", "owner": "me"})
+	router := newTestRouter(h)
+	body := "azure_id,env,costCenter\n" +
+		dataVM + ",prod,\n" +
+		rgApp + ",dev,cc-1\n" +
+		"/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-data-lake,prod,\n"
+	if rr := do(t, router, http.MethodPost, "/v1/import", body, "Content-Type", "text/csv"); rr.Code != 200 {
+		t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	ctx := context.Background()
+
+	vm, _ := h.store.FindByAzureID(ctx, dataVM)
+	if vm.Tags["env"] != "prod" || vm.Tags["owner"] != "me" || !vm.NeedsApply || vm.Tags["dataClassification"] != "" {
+		t.Fatalf("expected env merged into the registered vm, no rules, got %+v", vm)
+	}
+	app, _ := h.store.FindByAzureID(ctx, rgApp)
+	if app.Name != "rg-app" || app.Tags["costCenter"] != "cc-1" {
+		t.Fatalf("expected rg-app registered with its tags, got %+v", app)
+	}
+	lake, _ := h.store.FindByAzureID(ctx, subA+"/resourceGroups/rg-data-lake")
+	if lake.Tags["dataClassification"] != "confidential" {
+		t.Fatalf("expected the rules to run on a new resource, got %+v", lake)
+	}
+}
+
+func TestHandlers_Export_RoundTrip(t *testing.T) {
+	h := New(store.NewMemoryStore(), importConfig())
+	h.store.Create(context.Background(), "vm-1", dataVM, models.ScopeResource, map[string]string{"env": "dev", "owner": "me"})
+	router := newTestRouter(h)
+	h.store.Create(context.Background(), "app", rgApp, models.ScopeResourceGroup, map[string]string{"Env": "prod, eu", "team": "a"})
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"every key", "", "azure_id,name,Env,owner,team\n" +
+			subA + "/resourceGroups/rg-app,app,\"prod, eu\",,a\n" +
+			dataVM + ",vm-1,dev,me,\n"},
+		{"picked columns", "?tags=env&delimiter=%3B", "azure_id;name;env\n" +
+			subA + "/resourceGroups/rg-app;app;prod, eu\n" +
+			dataVM + ";vm-1;dev\n"},
+		{"selector", "?selector=owner", "azure_id,name,env,owner\n" + dataVM + ",vm-1,dev,me\n"},
+		{"ndjson", "?format=ndjson&tags=team", `{"azureId":"` + rgApp + `","name":"app","tags":{"team":"a"}}` + "\n" +
+			`{"azureId":"` + dataVM + `","name":"vm-1","tags":{}}` + "\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := do(t, router, http.MethodGet, "/v1/export"+tc.query, nil)
+			if rr.Code != 200 || rr.Body.String() != tc.want {
+				t.Fatalf("expected %d\n%s\ngot %d\n%s", 200, tc.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	// what comes out goes back in
+	csv := do(t, router, http.MethodGet, "/v1/export", nil).Body.String()
+	h2 := New(store.NewMemoryStore(), importConfig())
+	h2.store.Create(context.Background(), "vm-1", dataVM, models.ScopeResource, map[string]string{"env": "dev", "owner": "me"})
+	router2 := newTestRouter(h2)
+	if rr := do(t, router2, http.MethodPost, "/v1/import", csv, "Content-Type", "text/csv"); rr.Code != 200 {
+		t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	app, err := h2.store.FindByAzureID(context.Background(), rgApp)
+	if err != nil || app.Name != "app" || app.Tags["Env"] != "prod, eu" || app.Tags["team"] != "a" {
+		t.Fatalf("expected rg-app back with its tags, got %+v (%v)", app, err)
+	}
+}
+
+func TestHandlers_Export_Formulas(t *testing.T) {
+	h := New(store.NewMemoryStore(), importConfig())
+	h.store.Create(context.Background(), "=vm", dataVM, models.ScopeResource, map[string]string{"owner": "@me", "env": "=1+1", "delta": "-5"})
+	csv := do(t, newTestRouter(h), http.MethodGet, "/v1/export", nil).Body.String()
+	if want := "azure_id,name,delta,env,owner\n" + dataVM + ",'=vm,-5,'=1+1,'@me\n"; csv != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, csv)
+	}
+
+	h2 := New(store.NewMemoryStore(), importConfig())
+	if rr := do(t, newTestRouter(h2), http.MethodPost, "/v1/import", csv, "Content-Type", "text/csv"); rr.Code != 200 {
+		t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	vm, err := h2.store.FindByAzureID(context.Background(), dataVM)
+	if err != nil || vm.Name != "=vm" || vm.Tags["owner"] != "@me" || vm.Tags["env"] != "=1+1" || vm.Tags["delta"] != "-5" {
+		t.Fatalf("expected the values back unquoted, got %+v (%v)", vm, err)
+	}
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/azure"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/identity"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/jobs"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tags"
+)
+
+// JobRenameTags is the kind of the jobs started by POST /tags/rename.
+const JobRenameTags = "rename_tags"
+
+const defaultMaxDistance = 2 // edits between near-duplicate keys or values
+
+// TagInventory godoc
+// @Summary      Tag key and value inventory
+// @Description  Counts every tag key and value of the registered resources (their own tags, not the tag sets) and, with scope, of the resources found in Azure under a subscription or resource group.
+// @Description  Near-duplicates (same ignoring case and separators, an abbreviation like env/environment, or at most distance edits) are suggested a canonical spelling: the most used one. mapping has them all, for POST /tags/rename.
+// @Tags         tags
+// @Produce      json
+// @Param        scope     query     string  false  "Azure ID of a subscription or resource group to count too"
+// @Param        distance  query     int     false  "Max edits between near-duplicates (default 2, 0 for case and abbreviations only)"
+// @Success      200       {object}  models.TagInventory
+// @Failure      400       {object}  map[string]string
+// @Failure      403       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /tags/inventory [get]
+func (h *Handler) TagInventory(w http.ResponseWriter, r *http.Request) {
+	maxDistance := defaultMaxDistance
+	if d := r.URL.Query().Get("distance"); d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 0 || n > 5 {
+			writeErr(w, 400, "distance must be 0 to 5")
+			return
+		}
+		maxDistance = n
+	}
+
+	var registered, inAzure []map[string]string
+	for _, res := range h.store.List(r.Context()) {
+		registered = append(registered, res.Tags)
+	}
+	if scope := r.URL.Query().Get("scope"); scope != "" {
+		found, code, err := h.discovered(r.Context(), scope)
+		if err != nil {
+			writeErr(w, code, err.Error())
+			return
+		}
+		for _, d := range found {
+			inAzure = append(inAzure, d.Tags)
+		}
+	}
+	inv := tags.Inventory(registered, inAzure, maxDistance)
+	logging.FromContext(r.Context()).Info("tag inventory",
+		slog.Int("resources", inv.Resources),
+		slog.Int("azure_resources", inv.AzureResources),
+		slog.Int("keys", len(inv.Keys)),
+		slog.Int("suggestions", len(inv.Suggestions)),
+	)
+	writeJSON(w, 200, inv)
+}
+
+// discovered lists the resources of a subscription or resource group in
+// Azure, or fails with the status to answer.
+func (h *Handler) discovered(ctx context.Context, scope string) ([]azure.Discovered, int, error) {
+	kind, err := azure.ScopeOf(scope)
+	if err != nil {
+		return nil, 400, err
+	}
+	if kind == models.ScopeResource {
+		return nil, 400, errors.New("scope must be a subscription or a resource group")
+	}
+	if _, err := azure.Authorize(h.cfg.Azure.AllowedSubscriptions, scope); err != nil {
+		if errors.Is(err, azure.ErrSubscriptionNotAllowed) {
+			return nil, 403, err
+		}
+		return nil, 400, err
+	}
+	log := logging.FromContext(ctx).With(slog.String("azure_id", scope))
+	tagger, err := h.taggerFactory()
+	if err != nil {
+		log.Warn("azure not configured", slog.String("error", err.Error()))
+		return nil, 400, fmt.Errorf("azure not configured: %w", err)
+	}
+	found, err := tagger.ListResources(ctx, scope)
+	if err != nil {
+		log.Error("listing azure resources failed", slog.String("error", err.Error()))
+		return nil, 500, fmt.Errorf("azure error: %w", err)
+	}
+	return found, 0, nil
+}
+
+type tagDiff struct {
+	ResourceID string            `json:"resource_id"`
+	Name       string            `json:"name"`
+	AzureID    string            `json:"azure_id"`
+	Set        map[string]string `json:"set,omitempty"`     // added or changed
+	Removed    []string          `json:"removed,omitempty"` // keys gone
+	Conflicts  []string          `json:"conflicts,omitempty"`
+}
+
+type renamePreview struct {
+	Matched   int       `json:"matched"` // resources the selector picked
+	Changed   int       `json:"changed"`
+	Conflicts int       `json:"conflicts"` // resources keeping keys whose values differ
+	Diffs     []tagDiff `json:"diffs"`
+}
+
+// RenameTags godoc
+// @Summary      Rename tag keys and remap values in bulk
+// @Description  Queues a job applying the mapping to the tags of the registered resources the selector picks (all by default): keys renamed ({"Env": "env"}, as spelled), then values remapped per key ({"env": {"Prod": "prod"}}).
+// @Description  Keys renamed onto the same one merge when their values agree, otherwise that resource keeps them and fails in the job. Changed resources are marked needs_apply and the change is in their history. Follow it on /jobs/{id}.
+// @Description  dryRun=true only returns what would change. push=true also applies the changed resources to Azure in the job, like a tag set re-apply: the old keys go and the new ones come.
+// @Tags         tags
+// @Accept       json
+// @Produce      json
+// @Param        selector  query     string             false  "Tag selector, e.g. env=prod,!owner"
+// @Param        dryRun    query     bool               false  "Only show the changes"
+// @Param        push      query     bool               false  "Apply the changed resources to Azure"
+// @Param        payload   body      models.TagMapping  true   "Mapping, e.g. the one of GET /tags/inventory"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      200       {object}  renamePreview
+// @Success      202       {object}  models.Job
+// @Failure      400       {object}  map[string]string
+// @Failure      503       {object}  map[string]string
+// @Router       /tags/rename [post]
+func (h *Handler) RenameTags(w http.ResponseWriter, r *http.Request) {
+	var m models.TagMapping
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		writeErr(w, 400, "invalid json")
+		return
+	}
+	if err := checkMapping(m); err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	sel, err := tags.ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	log := logging.FromContext(r.Context()).With(slog.Int("keys", len(m.Keys)), slog.Int("value_keys", len(m.Values)), slog.String("selector", sel.String()))
+
+	if r.URL.Query().Get("dryRun") == "true" {
+		preview := renamePreview{Diffs: []tagDiff{}}
+		for _, res := range h.store.List(r.Context()) {
+			if !sel.Matches(res.Tags) {
+				continue
+			}
+			preview.Matched++
+			out, _, conflicts := tags.Rewrite(m, res.Tags, res.TagExpiry)
+			set, removed := tags.Diff(res.Tags, out)
+			if len(set) == 0 && len(removed) == 0 && len(conflicts) == 0 {
+				continue
+			}
+			if len(set) > 0 || len(removed) > 0 {
+				preview.Changed++
+			}
+			if len(conflicts) > 0 {
+				preview.Conflicts++
+			}
+			preview.Diffs = append(preview.Diffs, tagDiff{ResourceID: res.ID, Name: res.Name, AzureID: res.AzureID, Set: set, Removed: removed, Conflicts: conflicts})
+		}
+		slices.SortFunc(preview.Diffs, func(a, b tagDiff) int { return cmp.Compare(strings.ToLower(a.AzureID), strings.ToLower(b.AzureID)) })
+		log.Info("rename previewed", slog.Int("matched", preview.Matched), slog.Int("changed", preview.Changed))
+		writeJSON(w, 200, preview)
+		return
+	}
+
+	var tagger AzureTagger
+	if r.URL.Query().Get("push") == "true" {
+		if tagger, err = h.taggerFactory(); err != nil {
+			log.Warn("azure not configured", slog.String("error", err.Error()))
+			writeErr(w, 400, "azure not configured: "+err.Error())
+			return
+		}
+	}
+	job, err := h.jobs.Submit(JobRenameTags, h.renameTags(m, sel, tagger, identity.Caller(r)))
+	if err != nil {
+		log.Warn("rename not queued", slog.String("error", err.Error()))
+		writeErr(w, 503, "rename not queued: "+err.Error())
+		return
+	}
+	log.Info("rename queued", slog.String("job_id", job.ID), slog.Bool("push", tagger != nil))
+	writeJSON(w, 202, job)
+}
+
+func checkMapping(m models.TagMapping) error {
+	if len(m.Keys) == 0 && len(m.Values) == 0 {
+		return errors.New("keys or values required")
+	}
+	for old, nk := range m.Keys {
+		if strings.TrimSpace(old) == "" || strings.TrimSpace(nk) == "" {
+			return fmt.Errorf("keys: %q -> %q, keys can't be empty", old, nk)
+		}
+	}
+	for k, values := range m.Values {
+		if strings.TrimSpace(k) == "" || len(values) == 0 {
+			return fmt.Errorf("values: %q needs a key and values to remap", k)
+		}
+	}
+	return nil
+}
+
+// renameTags is the job applying m to the tags of the resources sel picks,
+// one item per resource it changes. With a tagger the changed ones are
+// applied to Azure too.
+func (h *Handler) renameTags(m models.TagMapping, sel tags.Selector, tagger AzureTagger, actor string) jobs.Func {
+	detail := "bulk rename"
+	if len(sel) > 0 {
+		detail += " of " + sel.String()
+	}
+	return func(ctx context.Context, p *jobs.Progress) error {
+		var ids []string
+		for _, res := range h.store.List(ctx) {
+			if !sel.Matches(res.Tags) {
+				continue
+			}
+			if out, _, conflicts := tags.Rewrite(m, res.Tags, res.TagExpiry); len(conflicts) > 0 || !maps.Equal(out, res.Tags) {
+				ids = append(ids, res.ID)
+			}
+		}
+		p.SetTotal(len(ids))
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			p.Step(h.renameOne(ctx, m, sel, tagger, id, actor, detail))
+		}
+		return nil
+	}
+}
+
+func (h *Handler) renameOne(ctx context.Context, m models.TagMapping, sel tags.Selector, tagger AzureTagger, id, actor, detail string) error {
+	var conflicts []string
+	before, after, err := h.store.RewriteTags(ctx, id, func(t map[string]string, e map[string]int64) (map[string]string, map[string]int64) {
+		if !sel.Matches(t) { // changed since it was picked
+			return t, e
+		}
+		out, exp, c := tags.Rewrite(m, t, e)
+		conflicts = c
+		return out, exp
+	}, actor, detail)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("%s: deleted since", id)
+	}
+	if tagger != nil && !maps.Equal(before.Tags, after.Tags) {
+		if err := h.reapplyOne(ctx, tagger, id); err != nil {
+			return err
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%s: values differ, not merged: %s", id, strings.Join(conflicts, "; "))
+	}
+	return nil
+}
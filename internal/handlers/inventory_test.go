@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/azure"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+)
+
+// waitJob polls the job until it finishes.
+func waitJob(t *testing.T, router http.Handler, id string) models.Job {
+	t.Helper()
+	var job models.Job
+	deadline := time.Now().Add(2 * time.Second)
+	for job.FinishedUnix == 0 && time.Now().Before(deadline) {
+		json.Unmarshal(do(t, router, http.MethodGet, "/v1/jobs/"+id, nil).Body.Bytes(), &job)
+		time.Sleep(5 * time.Millisecond)
+	}
+	return job
+}
+
+func TestHandlers_TagInventory(t *testing.T) {
+	st := store.NewMemoryStore()
+	h := New(st, config.Default())
+	mt := &mockTagger{found: []azure.Discovered{{ID: rgApp + "/providers/Microsoft.Web/sites/web-1", Tags: map[string]string{"Environment": "prod"}}}}
+	h.taggerFactory = func() (AzureTagger, error) { return mt, nil }
+	router := newTestRouter(h)
+	st.Create(t.Context(), "vm-1", dataVM, models.ScopeResource, map[string]string{"Env": "Prod"})
+	st.Create(t.Context(), "vm-2", rgApp+"/providers/Microsoft.Compute/virtualMachines/vm-2", models.ScopeResource, map[string]string{"env": "production"})
+
+	rr := do(t, router, http.MethodGet, "/v1/tags/inventory?scope="+rgApp, nil)
+	var inv models.TagInventory
+	if rr.Code != 200 || json.Unmarshal(rr.Body.Bytes(), &inv) != nil {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if inv.Resources != 2 || inv.AzureResources != 1 || len(inv.Keys) != 3 {
+		t.Fatalf("expected 3 keys over 2 + 1 resources, got %+v", inv)
+	}
+	if !maps.Equal(inv.Mapping.Keys, map[string]string{"Env": "env", "Environment": "env"}) || inv.Mapping.Values["env"]["production"] != "prod" {
+		t.Fatalf("expected env and prod canonical, got %+v", inv.Mapping)
+	}
+
+	for _, q := range []string{"distance=9", "scope=nope", "scope=" + dataVM} {
+		if rr := do(t, router, http.MethodGet, "/v1/tags/inventory?"+q, nil); rr.Code != 400 {
+			t.Fatalf("%s: expected 400, got %d", q, rr.Code)
+		}
+	}
+}
+
+func TestHandlers_RenameTags(t *testing.T) {
+	st := store.NewMemoryStore()
+	h := New(st, config.Default())
+	router := newTestRouter(h)
+	renamed := st.Create(t.Context(), "vm-1", dataVM, models.ScopeResource, map[string]string{"Env": "Prod", "owner": "me"})
+	conflict := st.Create(t.Context(), "rg", rgApp, models.ScopeResourceGroup, map[string]string{"env": "dev", "Environment": "prod"})
+	untouched := st.Create(t.Context(), "sub", subA, models.ScopeSubscription, map[string]string{"team": "a"})
+
+	mapping := models.TagMapping{
+		Keys:   map[string]string{"Env": "env", "Environment": "env"},
+		Values: map[string]map[string]string{"env": {"Prod": "prod"}},
+	}
+	rr := do(t, router, http.MethodPost, "/v1/tags/rename", mapping)
+	var job models.Job
+	if rr.Code != http.StatusAccepted || json.Unmarshal(rr.Body.Bytes(), &job) != nil || job.Kind != JobRenameTags {
+		t.Fatalf("expected 202 and a job, got %d: %s", rr.Code, rr.Body.String())
+	}
+	job = waitJob(t, router, job.ID)
+	if job.Status != models.JobFailed || job.Total != 2 || job.Done != 2 || job.Failed != 1 {
+		t.Fatalf("expected 2 resources, the conflicting one failed, got %+v", job)
+	}
+
+	got, _ := st.Get(t.Context(), renamed.ID)
+	if !maps.Equal(got.Tags, map[string]string{"env": "prod", "owner": "me"}) || !got.NeedsApply {
+		t.Fatalf("expected vm-1 renamed and marked, got %+v", got)
+	}
+	if h, _ := st.History(t.Context(), renamed.ID); len(h) != 1 || h[0].Action != models.HistoryTagsRenamed {
+		t.Fatalf("expected the rename in the history, got %+v", h)
+	}
+	if got, _ := st.Get(t.Context(), conflict.ID); !maps.Equal(got.Tags, conflict.Tags) {
+		t.Fatalf("expected the conflicting tags left alone, got %v", got.Tags)
+	}
+	if got, _ := st.Get(t.Context(), untouched.ID); got.NeedsApply {
+		t.Fatal("expected the subscription untouched")
+	}
+
+	for _, body := range []any{models.TagMapping{}, models.TagMapping{Keys: map[string]string{"a": " "}}, "nope"} {
+		if rr := do(t, router, http.MethodPost, "/v1/tags/rename", body); rr.Code != 400 {
+			t.Fatalf("%v: expected 400, got %d", body, rr.Code)
+		}
+	}
+}
+
+func TestHandlers_RenameTags_SelectorDryRunPush(t *testing.T) {
+	st := store.NewMemoryStore()
+	h := New(st, config.Default())
+	mt := &mockTagger{}
+	h.taggerFactory = func() (AzureTagger, error) { return mt, nil }
+	router := newTestRouter(h)
+	prod := st.Create(t.Context(), "vm-1", dataVM, models.ScopeResource, map[string]string{"Environment": "Production", "app": "web"})
+	other := st.Create(t.Context(), "rg", rgApp, models.ScopeResourceGroup, map[string]string{"Environment": "Production", "team": "data"})
+	mapping := models.TagMapping{
+		Keys:   map[string]string{"Environment": "env"},
+		Values: map[string]map[string]string{"env": {"Production": "prod"}},
+	}
+
+	rr := do(t, router, http.MethodPost, "/v1/tags/rename?dryRun=true&selector=app=web", mapping)
+	var preview renamePreview
+	json.Unmarshal(rr.Body.Bytes(), &preview)
+	want := tagDiff{ResourceID: prod.ID, Name: "vm-1", AzureID: dataVM, Set: map[string]string{"env": "prod"}, Removed: []string{"Environment"}}
+	if rr.Code != 200 || preview.Matched != 1 || preview.Changed != 1 || len(preview.Diffs) != 1 || !reflect.DeepEqual(preview.Diffs[0], want) {
+		t.Fatalf("expected the diff of vm-1 only, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got, _ := st.Get(t.Context(), prod.ID); got.Tags["Environment"] != "Production" || got.NeedsApply {
+		t.Fatalf("expected a dry run to change nothing, got %+v", got)
+	}
+
+	rr = do(t, router, http.MethodPost, "/v1/tags/rename?push=true&selector=app=web", mapping)
+	var job models.Job
+	json.Unmarshal(rr.Body.Bytes(), &job)
+	if job = waitJob(t, router, job.ID); job.Status != models.JobSucceeded || job.Done != 1 {
+		t.Fatalf("expected vm-1 renamed and pushed, got %+v", job)
+	}
+	if mt.resourceID != dataVM || !maps.Equal(mt.tags, map[string]string{"env": "prod", "app": "web"}) {
+		t.Fatalf("expected the new tags replacing the old ones in Azure, got %s %v", mt.resourceID, mt.tags)
+	}
+	got, _ := st.Get(t.Context(), prod.ID)
+	if got.NeedsApply || got.LastApply == nil || got.LastApply.Status != models.ApplySucceeded {
+		t.Fatalf("expected the apply recorded, got %+v", got)
+	}
+	if h, _ := st.History(t.Context(), prod.ID); len(h) != 1 || h[0].Detail != "bulk rename of app=web" {
+		t.Fatalf("expected the rename in the history, got %+v", h)
+	}
+	if got, _ := st.Get(t.Context(), other.ID); got.Tags["Environment"] != "Production" {
+		t.Fatalf("expected the rg not picked by the selector, got %v", got.Tags)
+	}
+
+	if rr := do(t, router, http.MethodPost, "/v1/tags/rename?selector=!", mapping); rr.Code != 400 {
+		t.Fatalf("expected 400 on a bad selector, got %d", rr.Code)
+	}
+}
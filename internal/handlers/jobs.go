@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListJobs godoc
+// @Summary      List background jobs
+// @Description  Newest first. Jobs are kept in memory, the oldest finished ones are dropped.
+// @Tags         jobs
+// @Produce      json
+// @Success      200  {array}  models.Job
+// @Router       /jobs [get]
+func (h *Handler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, h.jobs.List())
+}
+
+// GetJob godoc
+// @Summary      Get a background job
+// @Description  Status and progress (done/failed out of total) of a job, e.g. a tag set re-apply.
+// @Tags         jobs
+// @Produce      json
+// @Param        id   path      string  true  "Job ID"
+// @Success      200  {object}  models.Job
+// @Failure      404  {object}  map[string]string
+// @Router       /jobs/{id} [get]
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	j, err := h.jobs.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, 404, "not found")
+		return
+	}
+	writeJSON(w, 200, j)
+}
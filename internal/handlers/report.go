@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/report"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tags"
+)
+
+const reportLiveWorkers = 8 // concurrent Azure reads of a live report
+
+// ComplianceReport godoc
+// @Summary      Tag compliance report
+// @Description  Checks every registered resource against the tag policy (tags.required_keys, tags.allowed_values): coverage overall, per required key, per subscription and per resource group, and the non-compliant resources with why.
+// @Description  The desired tags are checked, with live=true the tags in Azure too (one read per resource): a resource is compliant when both are. One whose Azure tags can't be read counts as unknown, outside the coverage, and is listed in unchecked. selector picks the resources, see GET /events.
+// @Description  format=csv gives one flat table (a section column per kind of row), format=html a printable page.
+// @Tags         reports
+// @Produce      json
+// @Produce      text/csv
+// @Produce      text/html
+// @Param        live       query     bool    false  "Check the Azure tags too"
+// @Param        format     query     string  false  "json (default) | csv | html"
+// @Param        selector   query     string  false  "Tag selector, e.g. env=prod,!owner"
+// @Param        delimiter  query     string  false  "CSV delimiter: one character (default ,), tab or semicolon"
+// @Success      200        {object}  models.ComplianceReport
+// @Failure      400        {object}  map[string]string
+// @Router       /reports/compliance [get]
+func (h *Handler) ComplianceReport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != formatCSV && format != "html" {
+		writeErr(w, 400, "format must be json, csv or html")
+		return
+	}
+	delim, err := delimiter(r)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	sel, err := tags.ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	live := r.URL.Query().Get("live") == "true"
+	log := logging.FromContext(r.Context()).With(slog.Bool("live", live))
+
+	var entries []report.Entry
+	for _, res := range h.store.List(r.Context()) {
+		if sel.Matches(res.Tags) {
+			entries = append(entries, report.Entry{Resource: res, Desired: tags.Values(h.effectiveTags(r.Context(), res, res.Tags))})
+		}
+	}
+	if live {
+		tagger, err := h.taggerFactory()
+		if err != nil {
+			log.Warn("azure not configured", slog.String("error", err.Error()))
+			writeErr(w, 400, "azure not configured: "+err.Error())
+			return
+		}
+		readAzureTags(r.Context(), tagger, entries)
+	}
+	rep := report.Compliance(h.cfg.Tags, entries, live, time.Now())
+	log.Info("compliance report",
+		slog.Int("resources", rep.Total),
+		slog.Int("compliant", rep.Compliant),
+		slog.Int("unknown", rep.Unknown),
+		slog.String("format", format),
+	)
+
+	if format == "" || format == "json" {
+		writeJSON(w, 200, rep)
+		return
+	}
+	var b bytes.Buffer // so an error is still a 500
+	if format == formatCSV {
+		err = report.WriteCSV(&b, rep, delim)
+	} else {
+		err = report.WriteHTML(&b, rep)
+	}
+	if err != nil {
+		log.Error("compliance report not written", slog.String("error", err.Error()))
+		writeErr(w, 500, err.Error())
+		return
+	}
+	if format == formatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="compliance.csv"`)
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.Write(b.Bytes())
+}
+
+// readAzureTags fills in the Azure tags of the entries, a few at a time.
+func readAzureTags(ctx context.Context, tagger AzureTagger, entries []report.Entry) {
+	sem := make(chan struct{}, reportLiveWorkers)
+	var wg sync.WaitGroup
+	for i := range entries {
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			e := &entries[i]
+			e.Azure, e.AzureErr = tagger.GetTags(ctx, e.Resource.AzureID)
+		})
+	}
+	wg.Wait()
+}
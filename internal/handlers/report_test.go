@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+)
+
+func TestHandlers_ComplianceReport(t *testing.T) {
+	cfg := config.Default()
+	cfg.Tags.RequiredKeys = []string{"owner", "env"}
+	cfg.Tags.AllowedValues = map[string][]string{"env": {"prod", "dev"}}
+	h := New(store.NewMemoryStore(), cfg)
+	ctx := context.Background()
+	vm := h.store.Create(ctx, "vm-1", dataVM, models.ScopeResource, map[string]string{"env": "dev"})
+	h.store.CreateTagSet(ctx, "base", "", map[string]string{"owner": "platform"}) // effective tags count
+	h.store.SetResourceTagSets(ctx, vm.ID, []string{"base"})
+	h.store.Create(ctx, "rg-app", rgApp, models.ScopeResourceGroup, map[string]string{"env": "qa"})
+
+	mt := &mockTagger{current: map[string]map[string]string{dataVM: {"env": "dev"}}}
+	h.taggerFactory = func() (AzureTagger, error) { return mt, nil }
+	r := newTestRouter(h)
+
+	rr := do(t, r, "GET", "/v1/reports/compliance", nil)
+	var rep models.ComplianceReport
+	if rr.Code != 200 || json.Unmarshal(rr.Body.Bytes(), &rep) != nil {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rep.Total != 2 || rep.Compliant != 1 || len(rep.NonCompliant) != 1 || rep.NonCompliant[0].AzureID != rgApp {
+		t.Fatalf("expected only rg-app non compliant, got %+v", rep)
+	}
+
+	// Azure misses the owner of vm-1 and doesn't know rg-app
+	rr = do(t, r, "GET", "/v1/reports/compliance?live=true&selector=env=dev", nil)
+	rep = models.ComplianceReport{}
+	json.Unmarshal(rr.Body.Bytes(), &rep)
+	if !rep.Live || rep.Total != 1 || rep.Compliant != 0 || rep.NonCompliant[0].Violations[0].Reason != "missing required tag owner" {
+		t.Fatalf("expected vm-1 non compliant in Azure, got %s", rr.Body.String())
+	}
+
+	rr = do(t, r, "GET", "/v1/reports/compliance?format=csv", nil)
+	if rr.Code != 200 || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/csv") ||
+		!strings.Contains(rr.Body.String(), "non_compliant,,11111111-1111-1111-1111-111111111111,rg-app,"+rgApp) {
+		t.Fatalf("bad csv report %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = do(t, r, "GET", "/v1/reports/compliance?format=html", nil)
+	if rr.Code != 200 || !strings.Contains(rr.Body.String(), "1 of 2 resources compliant") {
+		t.Fatalf("bad html report %d: %s", rr.Code, rr.Body.String())
+	}
+
+	for _, q := range []string{"format=pdf", "selector=!", "delimiter=ab"} {
+		if rr := do(t, r, "GET", "/v1/reports/compliance?"+q, nil); rr.Code != 400 {
+			t.Fatalf("%s: expected 400, got %d", q, rr.Code)
+		}
+	}
+	h.taggerFactory = func() (AzureTagger, error) { return nil, errors.New("no credentials") }
+	if rr := do(t, r, "GET", "/v1/reports/compliance?live=true", nil); rr.Code != 400 {
+		t.Fatalf("expected 400 without azure, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/azure"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/identity"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tags"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tmpl"
+)
+
+type rulePreviewResp struct {
+	AzureID    string            `json:"azure_id"`
+	Scope      string            `json:"scope"`
+	Registered bool              `json:"registered"` // tags are the ones of the registered entry
+	Tags       map[string]string `json:"tags"`
+	Result     map[string]string `json:"result"`
+	Rules      []models.RuleHit  `json:"rules"`
+}
+
+type discoverReq struct {
+	Scope string `json:"scope"` // Azure ID of a subscription or a resource group
+}
+
+type discoveredResource struct {
+	models.Resource
+	Rules []models.RuleHit `json:"rules,omitempty"`
+}
+
+type discoverFailure struct {
+	AzureID string `json:"azure_id"`
+	Error   string `json:"error"`
+}
+
+type discoverResp struct {
+	Found   int                  `json:"found"`
+	Skipped int                  `json:"skipped"` // already registered
+	Drifted int                  `json:"drifted"` // registered, Azure tags differ from the desired ones
+	Created []discoveredResource `json:"created"`
+	Failed  []discoverFailure    `json:"failed"` // not registered, e.g. a rule template failed on it
+}
+
+// nameOf is the entry name given to an Azure ID registered without one: the
+// last segment of the ID.
+func nameOf(azureID string) string {
+	if id, err := arm.ParseResourceID(azureID); err == nil {
+		return id.Name
+	}
+	return azureID
+}
+
+// withRules runs the tagging rules over the tags of a new resource. Rule
+// values can be templates too, rendered with data. Only the values the rules
+// set or defaulted are: the others are rendered already or come from Azure,
+// where {{ is just text.
+func (h *Handler) withRules(azureID string, in map[string]string, data tmpl.Data) (map[string]string, []models.RuleHit, error) {
+	out, hits := tags.ApplyRules(h.cfg.Tags.Rules, azureID, in)
+	if len(hits) == 0 {
+		return out, nil, nil
+	}
+	written := map[string]string{}
+	for _, hit := range hits {
+		for _, keys := range []map[string]string{hit.Set, hit.Defaulted} {
+			for k := range keys {
+				if v, ok := out[k]; ok { // not removed by a later rule
+					written[k] = v
+				}
+			}
+		}
+	}
+	rendered, err := tmpl.Render(written, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tagging rules: %w", err)
+	}
+	maps.Copy(out, rendered)
+	return out, hits, nil
+}
+
+// PreviewRules godoc
+// @Summary      Preview the tagging rules
+// @Description  Shows which rules (tags.rules) would fire for an Azure ID and the tags they would produce. The tags are the ones of the registered entry when there is one.
+// @Tags         rules
+// @Produce      json
+// @Param        azureId  query     string  true  "Azure ID"
+// @Success      200      {object}  rulePreviewResp
+// @Failure      400      {object}  map[string]string
+// @Router       /rules/preview [get]
+func (h *Handler) PreviewRules(w http.ResponseWriter, r *http.Request) {
+	azureID := r.URL.Query().Get("azureId")
+	scope, err := azure.ScopeOf(azureID)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	resp := rulePreviewResp{AzureID: azureID, Scope: scope, Tags: map[string]string{}}
+	name := ""
+	if res, err := h.store.FindByAzureID(r.Context(), azureID); err == nil {
+		resp.Registered, resp.Tags, name = true, res.Tags, res.Name
+	}
+	data := tmpl.NewData(name, azureID, scope, identity.Caller(r), time.Now())
+	if resp.Result, resp.Rules, err = h.withRules(azureID, resp.Tags, data); err != nil {
+		writeErr(w, 400, err.Error()) // like create, the templates are checked when loaded
+		return
+	}
+	if resp.Rules == nil {
+		resp.Rules = []models.RuleHit{}
+	}
+	writeJSON(w, 200, resp)
+}
+
+// Discover godoc
+// @Summary      Discover resources
+// @Description  Lists the resources of a subscription or a resource group in Azure and registers the ones not registered yet, with their Azure tags run through the tagging rules.
+// @Description  The ones a rule changed are marked needs_apply. Registered ones whose Azure tags differ from their desired tags count as drifted (drift.detected event when new or changed).
+// @Description  A resource the rules fail on is listed in failed and not registered, the others still are.
+// @Tags         azure
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      discoverReq  true  "Scope to discover"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      200      {object}  discoverResp
+// @Failure      400      {object}  map[string]string
+// @Failure      403      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /discover [post]
+func (h *Handler) Discover(w http.ResponseWriter, r *http.Request) {
+	var req discoverReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, 400, "invalid json")
+		return
+	}
+	found, code, err := h.discovered(r.Context(), req.Scope)
+	if err != nil {
+		writeErr(w, code, err.Error())
+		return
+	}
+	log := logging.FromContext(r.Context()).With(slog.String("azure_id", req.Scope))
+
+	resp := discoverResp{Found: len(found), Created: []discoveredResource{}, Failed: []discoverFailure{}}
+	for _, d := range found {
+		if res, err := h.store.FindByAzureID(r.Context(), d.ID); err == nil {
+			resp.Skipped++
+			desired := tags.Values(h.effectiveTags(r.Context(), res, res.Tags))
+			if maps.Equal(desired, d.Tags) {
+				h.inSync(res.ID)
+				continue
+			}
+			resp.Drifted++
+			h.driftDetected(res, desired, d.Tags)
+			continue
+		}
+		name := nameOf(d.ID)
+		resScope, err := azure.ScopeOf(d.ID)
+		if err != nil {
+			resp.Skipped++
+			continue
+		}
+		data := tmpl.NewData(name, d.ID, resScope, identity.Caller(r), time.Now())
+		desired, hits, err := h.withRules(d.ID, d.Tags, data)
+		if err != nil {
+			resp.Failed = append(resp.Failed, discoverFailure{AzureID: d.ID, Error: err.Error()})
+			continue
+		}
+
+		res, created := h.store.CreateIfAbsent(r.Context(), store.NewResource{
+			Name: name, AzureID: d.ID, Scope: resScope, Tags: desired,
+			NeedsApply: !maps.Equal(desired, d.Tags),
+		})
+		if !created { // registered by someone else meanwhile
+			resp.Skipped++
+			continue
+		}
+		resp.Created = append(resp.Created, discoveredResource{Resource: res, Rules: hits})
+	}
+	log.Info("resources discovered",
+		slog.Int("found", resp.Found),
+		slog.Int("created", len(resp.Created)),
+		slog.Int("skipped", resp.Skipped),
+		slog.Int("drifted", resp.Drifted),
+		slog.Int("failed", len(resp.Failed)),
+	)
+	writeJSON(w, 200, resp)
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/azure"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+)
+
+const dataVM = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-data-lake/providers/Microsoft.Compute/virtualMachines/vm-1"
+
+// rulesConfig sets the data classification in the data resource groups and
+// defaults a patch group on vms.
+func rulesConfig() config.Config {
+	cfg := config.Default()
+	cfg.Tags.Rules = []config.Rule{
+		{Name: "data", Match: config.RuleMatch{ResourceGroup: "rg-data-*"}, Set: map[string]string{"dataClassification": "confidential"}},
+		{Name: "vm", Match: config.RuleMatch{Type: "Microsoft.Compute/virtualMachines"}, Default: map[string]string{"patchGroup": "{{.RG}}-weekly"}},
+	}
+	return cfg
+}
+
+func TestHandlers_Create_AppliesRules(t *testing.T) {
+	router := newTestRouter(New(store.NewMemoryStore(), rulesConfig()))
+
+	rr := do(t, router, http.MethodPost, "/v1/resources", map[string]any{
+		"name": "vm-1", "azureId": dataVM, "tags": map[string]string{"dataClassification": "public"},
+	})
+	if rr.Code != 201 {
+		t.Fatalf("expected 201, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	var res models.Resource
+	json.Unmarshal(rr.Body.Bytes(), &res)
+	if res.Tags["dataClassification"] != "confidential" || res.Tags["patchGroup"] != "rg-data-lake-weekly" {
+		t.Fatalf("expected the rules to set and default tags, got %v", res.Tags)
+	}
+}
+
+func TestHandlers_PreviewRules(t *testing.T) {
+	router := newTestRouter(New(store.NewMemoryStore(), rulesConfig()))
+
+	tests := []struct {
+		name  string
+		id    string
+		code  int
+		rules []string
+	}{
+		{"vm in a data rg", dataVM, 200, []string{"data", "vm"}},
+		{"storage elsewhere", "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app/providers/Microsoft.Storage/storageAccounts/st", 200, nil},
+		{"invalid id", "nope", 400, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := do(t, router, http.MethodGet, "/v1/rules/preview?azureId="+url.QueryEscape(tc.id), nil)
+			if rr.Code != tc.code {
+				t.Fatalf("expected %d, got %d, body=%s", tc.code, rr.Code, rr.Body.String())
+			}
+			if tc.code != 200 {
+				return
+			}
+			var resp rulePreviewResp
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			var names []string
+			for _, hit := range resp.Rules {
+				names = append(names, hit.Rule)
+			}
+			if !slices.Equal(names, tc.rules) {
+				t.Fatalf("expected rules %v, got %v", tc.rules, names)
+			}
+		})
+	}
+}
+
+func TestHandlers_Discover(t *testing.T) {
+	h := New(store.NewMemoryStore(), rulesConfig())
+	router := newTestRouter(h)
+	const st = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app/providers/Microsoft.Storage/storageAccounts/st"
+	mt := &mockTagger{found: []azure.Discovered{
+		{ID: dataVM, Tags: map[string]string{"env": "prod"}},
+		{ID: st, Tags: map[string]string{"env": "dev"}},
+	}}
+	h.taggerFactory = func() (AzureTagger, error) { return mt, nil }
+
+	body := map[string]string{"scope": "/subscriptions/11111111-1111-1111-1111-111111111111"}
+	rr := do(t, router, http.MethodPost, "/v1/discover", body)
+	if rr.Code != 200 {
+		t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	var resp discoverResp
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Found != 2 || len(resp.Created) != 2 {
+		t.Fatalf("expected 2 resources registered, got %+v", resp)
+	}
+	for _, c := range resp.Created {
+		ruled := c.AzureID == dataVM
+		if c.NeedsApply != ruled || (len(c.Rules) > 0) != ruled {
+			t.Fatalf("expected only the vm to be changed by the rules, got %+v", c)
+		}
+	}
+
+	// a second run registers nothing new, the vm isn't tagged in Azure yet
+	rr = do(t, router, http.MethodPost, "/v1/discover", body)
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Skipped != 2 || len(resp.Created) != 0 || resp.Drifted != 1 {
+		t.Fatalf("expected both resources skipped and the vm drifted, got %+v", resp)
+	}
+
+	if rr := do(t, router, http.MethodPost, "/v1/discover", map[string]string{"scope": dataVM}); rr.Code != 400 {
+		t.Fatalf("expected 400 for a resource scope, got %d", rr.Code)
+	}
+}
+
+func TestHandlers_Rules_BadTemplate(t *testing.T) {
+	h := New(store.NewMemoryStore(), rulesConfig())
+	router := newTestRouter(h)
+	h.cfg.Tags.Rules = append(h.cfg.Tags.Rules,
+		config.Rule{Name: "broken", Match: config.RuleMatch{Name: "vm-*"}, Default: map[string]string{"team": "{{.Team}}"}}, // no .Team
+		config.Rule{Name: "storage", Match: config.RuleMatch{Type: "Microsoft.Storage/*"}, Default: map[string]string{"owner": "{{.RG}}-team"}},
+	)
+	const st = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app/providers/Microsoft.Storage/storageAccounts/st"
+	mt := &mockTagger{found: []azure.Discovered{
+		{ID: dataVM, Tags: map[string]string{"env": "prod"}},
+		{ID: st, Tags: map[string]string{"env": "dev", "note": "{{.Caller}}"}},
+	}}
+	h.taggerFactory = func() (AzureTagger, error) { return mt, nil }
+
+	rr := do(t, router, http.MethodPost, "/v1/discover", map[string]string{"scope": "/subscriptions/11111111-1111-1111-1111-111111111111"})
+	if rr.Code != 200 {
+		t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	var resp discoverResp
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Failed) != 1 || resp.Failed[0].AzureID != dataVM || len(resp.Created) != 1 {
+		t.Fatalf("expected the vm failed and the storage account registered, got %+v", resp)
+	}
+	// only what the rules wrote is a template, not the Azure values
+	if got := resp.Created[0].Tags; got["note"] != "{{.Caller}}" || got["owner"] != "rg-app-team" {
+		t.Fatalf("expected the Azure value kept as is and the rule rendered, got %v", got)
+	}
+
+	rr = do(t, router, http.MethodPost, "/v1/resources", map[string]any{"name": "vm-2", "azureId": dataVM + "2"})
+	if rr.Code != 400 {
+		t.Fatalf("expected 400 for a failing rule template, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := do(t, router, http.MethodGet, "/v1/rules/preview?azureId="+url.QueryEscape(dataVM), nil); rr.Code != 400 {
+		t.Fatalf("expected the preview to fail the same way, got %d", rr.Code)
+	}
+}
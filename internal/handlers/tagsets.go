@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/azure"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/jobs"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tags"
+	"github.com/go-chi/chi/v5"
+)
+
+// JobReapplyTagSet is the kind of the jobs started by PUT /tagsets/{name}?reapply=true.
+const JobReapplyTagSet = "reapply_tag_set"
+
+// tag set names end up in URLs
+var tagSetName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+type tagSetReq struct {
+	Name        string            `json:"name"` // ignored on update, the path has it
+	Description string            `json:"description"`
+	Tags        map[string]string `json:"tags"`
+}
+
+type tagSetUpdateResp struct {
+	TagSet     models.TagSet `json:"tag_set"`
+	Marked     []string      `json:"marked"` // resources now needing an apply
+	Job        *models.Job   `json:"job,omitempty"`
+	QueueError string        `json:"queue_error,omitempty"` // the re-apply wasn't queued, the update is done
+}
+
+type resourceTagSetsReq struct {
+	TagSets []string `json:"tagSets"`
+}
+
+func decodeTagSet(w http.ResponseWriter, r *http.Request) (tagSetReq, bool) {
+	var req tagSetReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, 400, "invalid json")
+		return req, false
+	}
+	if len(req.Tags) == 0 {
+		writeErr(w, 400, "tags required")
+		return req, false
+	}
+	return req, true
+}
+
+// CreateTagSet godoc
+// @Summary      Create a tag set
+// @Description  Stores a named set of tags (e.g. prod-baseline) at version 1. Resources reference it with tagSets.
+// @Tags         tagsets
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      tagSetReq  true  "Tag set"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      201      {object}  models.TagSet
+// @Failure      400      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Router       /tagsets [post]
+func (h *Handler) CreateTagSet(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeTagSet(w, r)
+	if !ok {
+		return
+	}
+	if !tagSetName.MatchString(req.Name) {
+		writeErr(w, 400, "name must be 1-64 letters, digits, '.', '_' or '-'")
+		return
+	}
+	ts, err := h.store.CreateTagSet(r.Context(), req.Name, req.Description, req.Tags)
+	if errors.Is(err, store.ErrAlreadyExists) {
+		writeErr(w, 409, err.Error())
+		return
+	}
+	logging.FromContext(r.Context()).Info("tag set created", slog.String("tag_set", ts.Name))
+	writeJSON(w, 201, ts)
+}
+
+// ListTagSets godoc
+// @Summary      List tag sets
+// @Tags         tagsets
+// @Produce      json
+// @Success      200  {array}  models.TagSet
+// @Router       /tagsets [get]
+func (h *Handler) ListTagSets(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, h.store.ListTagSets(r.Context()))
+}
+
+// GetTagSet godoc
+// @Summary      Get a tag set
+// @Tags         tagsets
+// @Produce      json
+// @Param        name  path      string  true  "Tag set name"
+// @Success      200   {object}  models.TagSet
+// @Failure      404   {object}  map[string]string
+// @Router       /tagsets/{name} [get]
+func (h *Handler) GetTagSet(w http.ResponseWriter, r *http.Request) {
+	ts, err := h.store.GetTagSet(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		writeErr(w, 404, "not found")
+		return
+	}
+	writeJSON(w, 200, ts)
+}
+
+// UpdateTagSet godoc
+// @Summary      Update a tag set
+// @Description  Replaces the tags of the set and bumps its version. Every resource referencing it is marked needs_apply.
+// @Description  With reapply=true a background job applies them to Azure (202, follow it on /jobs/{id}).
+// @Description  When the job can't be queued the update still answers 200, with queue_error set.
+// @Tags         tagsets
+// @Accept       json
+// @Produce      json
+// @Param        name     path      string     true   "Tag set name"
+// @Param        reapply  query     bool       false  "Re-apply the marked resources"
+// @Param        payload  body      tagSetReq  true   "Tag set"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      200      {object}  tagSetUpdateResp
+// @Success      202      {object}  tagSetUpdateResp
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Router       /tagsets/{name} [put]
+func (h *Handler) UpdateTagSet(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	req, ok := decodeTagSet(w, r)
+	if !ok {
+		return
+	}
+	ts, marked, err := h.store.UpdateTagSet(r.Context(), name, req.Description, req.Tags)
+	if err != nil {
+		writeErr(w, 404, "not found")
+		return
+	}
+	log := logging.FromContext(r.Context()).With(slog.String("tag_set", ts.Name), slog.Int("version", ts.Version))
+	log.Info("tag set updated", slog.Int("marked", len(marked)))
+
+	resp := tagSetUpdateResp{TagSet: ts, Marked: marked}
+	if resp.Marked == nil {
+		resp.Marked = []string{}
+	}
+	if r.URL.Query().Get("reapply") != "true" || len(marked) == 0 {
+		writeJSON(w, 200, resp)
+		return
+	}
+
+	job, err := h.jobs.Submit(JobReapplyTagSet, h.reapply(marked))
+	if err != nil {
+		// the update is done and stays, so no 5xx: a retry would bump the
+		// version again. The resources stay marked.
+		log.Warn("re-apply not queued", slog.String("error", err.Error()))
+		resp.QueueError = err.Error()
+		writeJSON(w, 200, resp)
+		return
+	}
+	log.Info("re-apply queued", slog.String("job_id", job.ID))
+	resp.Job = &job
+	writeJSON(w, 202, resp)
+}
+
+// DeleteTagSet godoc
+// @Summary      Delete a tag set
+// @Description  Only a tag set no resource references can be deleted.
+// @Tags         tagsets
+// @Param        name  path  string  true  "Tag set name"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      204
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Router       /tagsets/{name} [delete]
+func (h *Handler) DeleteTagSet(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	err := h.store.DeleteTagSet(r.Context(), name)
+	switch {
+	case errors.Is(err, store.ErrInUse):
+		writeErr(w, 409, err.Error())
+		return
+	case err != nil:
+		writeErr(w, 404, "not found")
+		return
+	}
+	logging.FromContext(r.Context()).Info("tag set deleted", slog.String("tag_set", name))
+	w.WriteHeader(204)
+}
+
+// SetResourceTagSets godoc
+// @Summary      Set the tag sets of a resource
+// @Description  Replaces the tag sets the resource references, merged in that order under its own tags. The resource is marked needs_apply.
+// @Tags         resources
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string              true  "Resource ID"
+// @Param        payload  body      resourceTagSetsReq  true  "Tag set names"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      200      {object}  models.Resource
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Router       /resources/{id}/tag-sets [put]
+func (h *Handler) SetResourceTagSets(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req resourceTagSetsReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, 400, "invalid json")
+		return
+	}
+	res, err := h.store.SetResourceTagSets(r.Context(), id, req.TagSets)
+	switch {
+	case errors.Is(err, store.ErrUnknownTagSet):
+		writeErr(w, 400, err.Error())
+		return
+	case err != nil:
+		writeErr(w, 404, "not found")
+		return
+	}
+	logging.FromContext(r.Context()).Info("resource tag sets set",
+		slog.String("resource_id", res.ID), slog.Any("tag_sets", res.TagSets))
+	writeJSON(w, 200, res)
+}
+
+// reapply is the job applying the effective tags of the given resources to
+// Azure, one item per resource.
+func (h *Handler) reapply(ids []string) jobs.Func {
+	return func(ctx context.Context, p *jobs.Progress) error {
+		tagger, err := h.taggerFactory()
+		if err != nil {
+			return fmt.Errorf("azure not configured: %w", err)
+		}
+		p.SetTotal(len(ids))
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			p.Step(h.reapplyOne(ctx, tagger, id))
+		}
+		return nil
+	}
+}
+
+func (h *Handler) reapplyOne(ctx context.Context, tagger AzureTagger, id string) error {
+	res, err := h.store.Get(ctx, id)
+	if err != nil {
+		return fmt.Errorf("%s: deleted since", id)
+	}
+	if !res.NeedsApply {
+		return nil // another apply got there first
+	}
+	if _, err := azure.Authorize(h.cfg.Azure.AllowedSubscriptions, res.AzureID); err != nil {
+		return fmt.Errorf("%s: %w", id, err)
+	}
+	desired := tags.Values(h.effectiveTags(ctx, res, res.Tags))
+	if result := h.apply(ctx, tagger, res, desired); result.Status != models.ApplySucceeded {
+		return fmt.Errorf("%s: %s", id, result.Error)
+	}
+	return nil
+}
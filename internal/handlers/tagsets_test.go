@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+)
+
+func TestHandlers_TagSets_CRUD(t *testing.T) {
+	h := New(store.NewMemoryStore(), config.Default())
+	router := newTestRouter(h)
+	created := 0
+	h.store.Events().Subscribe(func(e models.Event) {
+		if e.Type == models.EventResourceCreated {
+			created++
+		}
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		code   int
+	}{
+		{"create", http.MethodPost, "/v1/tagsets", map[string]any{"name": "prod-baseline", "tags": map[string]string{"env": "prod"}}, 201},
+		{"create twice", http.MethodPost, "/v1/tagsets", map[string]any{"name": "prod-baseline", "tags": map[string]string{"env": "prod"}}, 409},
+		{"bad name", http.MethodPost, "/v1/tagsets", map[string]any{"name": "a/b", "tags": map[string]string{"env": "prod"}}, 400},
+		{"no tags", http.MethodPost, "/v1/tagsets", map[string]any{"name": "empty"}, 400},
+		{"get", http.MethodGet, "/v1/tagsets/prod-baseline", nil, 200},
+		{"get missing", http.MethodGet, "/v1/tagsets/nope", nil, 404},
+		{"update", http.MethodPut, "/v1/tagsets/prod-baseline", map[string]any{"tags": map[string]string{"env": "prod", "tier": "1"}}, 200},
+		{"update missing", http.MethodPut, "/v1/tagsets/nope", map[string]any{"tags": map[string]string{"env": "prod"}}, 404},
+		{"create resource with unknown set", http.MethodPost, "/v1/resources", map[string]any{"name": "vm", "azureId": "/subscriptions/x/resourceGroups/rg", "tagSets": []string{"nope"}}, 400},
+		{"create resource with set", http.MethodPost, "/v1/resources", map[string]any{"name": "rg", "azureId": "/subscriptions/x/resourceGroups/rg", "tagSets": []string{"prod-baseline"}}, 201},
+		{"delete referenced", http.MethodDelete, "/v1/tagsets/prod-baseline", nil, 409},
+		{"delete missing", http.MethodDelete, "/v1/tagsets/nope", nil, 404},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if rr := do(t, router, tc.method, tc.path, tc.body); rr.Code != tc.code {
+				t.Fatalf("expected %d, got %d, body=%s", tc.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if got := h.store.List(t.Context()); len(got) != 1 || created != 1 {
+		t.Fatalf("expected only the valid resource created, got %+v and %d events", got, created)
+	}
+}
+
+func TestHandlers_Get_EffectiveTags_FromTagSets(t *testing.T) {
+	st := store.NewMemoryStore()
+	h := New(st, config.Default())
+	router := newTestRouter(h)
+
+	st.CreateTagSet(t.Context(), "baseline", "", map[string]string{"env": "prod", "owner": "platform"})
+	res := st.Create(t.Context(), "vm-1", "/subscriptions/x/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1", models.ScopeResource, map[string]string{"owner": "shop"})
+	if rr := do(t, router, http.MethodPut, "/v1/resources/"+res.ID+"/tag-sets", map[string]any{"tagSets": []string{"baseline"}}); rr.Code != 200 {
+		t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr := do(t, router, http.MethodGet, "/v1/resources/"+res.ID, nil)
+	var got models.ResourceDetail
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if tag := got.EffectiveTags["env"]; tag.Source != models.TagFromSet || tag.TagSet != "baseline@1" {
+		t.Fatalf("expected env from baseline@1, got %+v", tag)
+	}
+	if tag := got.EffectiveTags["owner"]; tag.Value != "shop" || tag.Source != models.TagExplicit {
+		t.Fatalf("expected the resource owner to override the set, got %+v", tag)
+	}
+	if !got.NeedsApply {
+		t.Fatal("expected needs_apply after changing the tag sets")
+	}
+}
+
+func TestHandlers_UpdateTagSet_Reapply(t *testing.T) {
+	st := store.NewMemoryStore()
+	h := New(st, config.Default())
+	mt := &mockTagger{}
+	h.taggerFactory = func() (AzureTagger, error) { return mt, nil }
+	router := newTestRouter(h)
+
+	st.CreateTagSet(t.Context(), "baseline", "", map[string]string{"env": "dev"})
+	res := st.Create(t.Context(), "vm-1", "/subscriptions/x/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1", models.ScopeResource, map[string]string{"app": "web"})
+	st.SetResourceTagSets(t.Context(), res.ID, []string{"baseline"})
+
+	rr := do(t, router, http.MethodPut, "/v1/tagsets/baseline?reapply=true", map[string]any{"tags": map[string]string{"env": "prod"}})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	var resp tagSetUpdateResp
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.TagSet.Version != 2 || len(resp.Marked) != 1 || resp.Job == nil {
+		t.Fatalf("expected version 2, one marked resource and a job, got %+v", resp)
+	}
+
+	var job models.Job
+	deadline := time.Now().Add(2 * time.Second)
+	for job.FinishedUnix == 0 && time.Now().Before(deadline) {
+		json.Unmarshal(do(t, router, http.MethodGet, "/v1/jobs/"+resp.Job.ID, nil).Body.Bytes(), &job)
+		time.Sleep(5 * time.Millisecond)
+	}
+	if job.Status != models.JobSucceeded || job.Done != 1 {
+		t.Fatalf("expected the job to re-apply 1 resource, got %+v", job)
+	}
+	if mt.tags["env"] != "prod" || mt.tags["app"] != "web" {
+		t.Fatalf("expected the new set merged with the resource tags, got %v", mt.tags)
+	}
+	if got, _ := st.Get(t.Context(), res.ID); got.NeedsApply {
+		t.Fatal("expected needs_apply cleared by the re-apply")
+	}
+}
+
+func TestHandlers_UpdateTagSet_ReapplyNotQueued(t *testing.T) {
+	st := store.NewMemoryStore()
+	h := New(st, config.Default())
+	router := newTestRouter(h)
+
+	st.CreateTagSet(t.Context(), "baseline", "", map[string]string{"env": "dev"})
+	res := st.Create(t.Context(), "vm-1", "/subscriptions/x/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1", models.ScopeResource, nil)
+	st.SetResourceTagSets(t.Context(), res.ID, []string{"baseline"})
+	if err := h.Interrupt(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the update is done, so it's a 2xx the idempotency layer keeps for retries
+	rr := do(t, router, http.MethodPut, "/v1/tagsets/baseline?reapply=true", map[string]any{"tags": map[string]string{"env": "prod"}})
+	if rr.Code != 200 {
+		t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	var resp tagSetUpdateResp
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.TagSet.Version != 2 || resp.Job != nil || resp.QueueError == "" {
+		t.Fatalf("expected version 2 and the queue error, got %+v", resp)
+	}
+	if got, _ := st.Get(t.Context(), res.ID); !got.NeedsApply {
+		t.Fatal("expected the resource to stay marked")
+	}
+}
+
+func TestHandlers_UpdateTagSet_ReapplyInterrupted(t *testing.T) {
+	st := store.NewMemoryStore()
+	h := New(st, config.Default())
+	bt := &blockingTagger{started: make(chan struct{})}
+	h.taggerFactory = func() (AzureTagger, error) { return bt, nil }
+	router := newTestRouter(h)
+
+	st.CreateTagSet(t.Context(), "baseline", "", map[string]string{"env": "dev"})
+	res := st.Create(t.Context(), "vm-1", "/subscriptions/x/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1", models.ScopeResource, nil)
+	st.SetResourceTagSets(t.Context(), res.ID, []string{"baseline"})
+	if rr := do(t, router, http.MethodPut, "/v1/tagsets/baseline?reapply=true", map[string]any{"tags": map[string]string{"env": "prod"}}); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+
+	// Interrupt returns once the job's apply is recorded
+	<-bt.started
+	if err := h.Interrupt(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.Get(t.Context(), res.ID); got.LastApply == nil || got.LastApply.Status != models.ApplyInterrupted {
+		t.Fatalf("expected the apply recorded as interrupted, got %+v", got.LastApply)
+	}
+}
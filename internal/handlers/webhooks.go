@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/webhooks"
+	"github.com/go-chi/chi/v5"
+)
+
+type webhookReq struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`           // empty means every event
+	Secret string   `json:"secret,omitempty"` // generated when empty
+}
+
+// CreateWebhook godoc
+// @Summary      Register a webhook
+// @Description  Events (resource.created/updated/deleted, tags.updated, azure.applying/applied/failed, drift.detected, job.updated) are POSTed to the URL as JSON, signed with HMAC-SHA256 of "<timestamp>.<body>" in X-Tagger-Signature.
+// @Description  Failed deliveries are retried with backoff, then kept as dead letters. The secret is only returned here.
+// @Description  URLs to loopback, private or link-local addresses are refused unless webhooks.allow_private is set.
+// @Tags         webhooks
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      webhookReq  true  "Webhook"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      201      {object}  models.Webhook
+// @Failure      400      {object}  map[string]string
+// @Router       /webhooks [post]
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, 400, "invalid json")
+		return
+	}
+	hook, err := h.webhooks.Create(req.URL, req.Events, req.Secret)
+	if err != nil {
+		writeErr(w, 400, err.Error())
+		return
+	}
+	logging.FromContext(r.Context()).Info("webhook registered",
+		slog.String("webhook_id", hook.ID), slog.String("url", hook.URL), slog.Any("events", hook.Events))
+	writeJSON(w, 201, hook)
+}
+
+// ListWebhooks godoc
+// @Summary      List webhooks
+// @Tags         webhooks
+// @Produce      json
+// @Success      200  {array}  models.Webhook
+// @Router       /webhooks [get]
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, h.webhooks.List())
+}
+
+// GetWebhook godoc
+// @Summary      Get a webhook
+// @Tags         webhooks
+// @Produce      json
+// @Param        id   path      string  true  "Webhook ID"
+// @Success      200  {object}  models.Webhook
+// @Failure      404  {object}  map[string]string
+// @Router       /webhooks/{id} [get]
+func (h *Handler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, err := h.webhooks.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErr(w, 404, "not found")
+		return
+	}
+	writeJSON(w, 200, hook)
+}
+
+// DeleteWebhook godoc
+// @Summary      Delete a webhook
+// @Description  Deliveries still being retried are dropped.
+// @Tags         webhooks
+// @Param        id   path  string  true  "Webhook ID"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      204
+// @Failure      404  {object}  map[string]string
+// @Router       /webhooks/{id} [delete]
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := h.webhooks.Delete(id); err != nil {
+		writeErr(w, 404, "not found")
+		return
+	}
+	logging.FromContext(r.Context()).Info("webhook deleted", slog.String("webhook_id", id))
+	w.WriteHeader(204)
+}
+
+// ListDeadLetters godoc
+// @Summary      List dead webhook deliveries
+// @Description  Deliveries that were rejected or ran out of retries, newest first.
+// @Tags         webhooks
+// @Produce      json
+// @Success      200  {array}  models.Delivery
+// @Router       /webhooks/dead-letters [get]
+func (h *Handler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, h.webhooks.DeadLetters())
+}
+
+// RedeliverDeadLetter godoc
+// @Summary      Redeliver a dead webhook delivery
+// @Description  Sends the event again, with a fresh set of retries. 503 when the service is stopping or the delivery queue is full.
+// @Tags         webhooks
+// @Produce      json
+// @Param        id   path      string  true  "Delivery ID"
+// @Param        Idempotency-Key  header  string  false  "Makes retries safe, see the README"
+// @Success      202  {object}  models.Delivery
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      503  {object}  map[string]string
+// @Router       /webhooks/dead-letters/{id}/redeliver [post]
+func (h *Handler) RedeliverDeadLetter(w http.ResponseWriter, r *http.Request) {
+	dl, err := h.webhooks.Redeliver(chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, webhooks.ErrNotDead):
+		writeErr(w, 409, err.Error())
+		return
+	case errors.Is(err, webhooks.ErrStopped), errors.Is(err, webhooks.ErrFull):
+		writeErr(w, 503, err.Error())
+		return
+	case err != nil:
+		writeErr(w, 404, err.Error())
+		return
+	}
+	logging.FromContext(r.Context()).Info("webhook delivery requeued",
+		slog.String("delivery_id", dl.ID), slog.String("webhook_id", dl.WebhookID))
+	writeJSON(w, 202, dl)
+}
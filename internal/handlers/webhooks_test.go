@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/webhooks"
+)
+
+type hookCall struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookConfig retries at once and allows the receivers on 127.0.0.1.
+func webhookConfig() config.Config {
+	cfg := config.Default()
+	cfg.Webhooks.RetryBackoff = time.Millisecond
+	cfg.Webhooks.AllowPrivate = true
+	return cfg
+}
+
+// hookReceiver answers with the given status and sends what it got on the channel.
+func hookReceiver(t *testing.T, status int) (*httptest.Server, chan hookCall) {
+	calls := make(chan hookCall, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		calls <- hookCall{r.Header.Clone(), body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, calls
+}
+
+func nextCall(t *testing.T, calls chan hookCall) hookCall {
+	t.Helper()
+	select {
+	case c := <-calls:
+		return c
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook not called")
+		return hookCall{}
+	}
+}
+
+func TestHandlers_Webhook_ResourceCreated(t *testing.T) {
+	h := New(store.NewMemoryStore(), webhookConfig())
+	t.Cleanup(func() { h.Interrupt(context.Background()) })
+	router := newTestRouter(h)
+	srv, calls := hookReceiver(t, 204)
+
+	rr := do(t, router, http.MethodPost, "/v1/webhooks", map[string]any{
+		"url": srv.URL, "events": []string{models.EventResourceCreated}, "secret": "s3cr3t",
+	})
+	if rr.Code != 201 {
+		t.Fatalf("expected 201, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	var hook models.Webhook
+	json.Unmarshal(rr.Body.Bytes(), &hook)
+	if hook.Secret != "s3cr3t" {
+		t.Fatalf("expected the secret back on creation, got %+v", hook)
+	}
+	var got models.Webhook
+	if rr := do(t, router, http.MethodGet, "/v1/webhooks/"+hook.ID, nil); rr.Code != 200 || json.Unmarshal(rr.Body.Bytes(), &got) != nil || got.Secret != "" {
+		t.Fatalf("expected the webhook without its secret, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = do(t, router, http.MethodPost, "/v1/resources", map[string]any{
+		"name": "vm-1", "azureId": dataVM, "tags": map[string]string{"env": "dev"},
+	})
+	if rr.Code != 201 {
+		t.Fatalf("expected 201, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+
+	c := nextCall(t, calls)
+	if want := webhooks.Sign("s3cr3t", c.header.Get(webhooks.HeaderTimestamp), c.body); c.header.Get(webhooks.HeaderSignature) != want {
+		t.Fatalf("expected signature %s, got %s", want, c.header.Get(webhooks.HeaderSignature))
+	}
+	var e models.Event
+	if err := json.Unmarshal(c.body, &e); err != nil || e.Type != models.EventResourceCreated || e.AzureID != dataVM || e.ID == "" {
+		t.Fatalf("unexpected event %s (%v)", c.body, err)
+	}
+
+	if rr := do(t, router, http.MethodPost, "/v1/webhooks", map[string]any{"url": "not a url"}); rr.Code != 400 {
+		t.Fatalf("expected 400 for an invalid url, got %d", rr.Code)
+	}
+	if rr := do(t, router, http.MethodDelete, "/v1/webhooks/"+hook.ID, nil); rr.Code != 204 {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	if rr := do(t, router, http.MethodDelete, "/v1/webhooks/"+hook.ID, nil); rr.Code != 404 {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
+
+func TestHandlers_Webhook_DeadLetters(t *testing.T) {
+	h := New(store.NewMemoryStore(), webhookConfig())
+	t.Cleanup(func() { h.Interrupt(context.Background()) })
+	router := newTestRouter(h)
+	srv, calls := hookReceiver(t, http.StatusBadRequest) // not retried
+	do(t, router, http.MethodPost, "/v1/webhooks", map[string]any{"url": srv.URL})
+
+	h.publish(models.EventDriftDetected, models.Resource{ID: "r1", AzureID: dataVM}, nil)
+	nextCall(t, calls)
+
+	var dead []models.Delivery
+	deadline := time.Now().Add(2 * time.Second)
+	for len(dead) == 0 && time.Now().Before(deadline) {
+		rr := do(t, router, http.MethodGet, "/v1/webhooks/dead-letters", nil)
+		json.Unmarshal(rr.Body.Bytes(), &dead)
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(dead) != 1 || dead[0].LastStatusCode != 400 || dead[0].EventType != models.EventDriftDetected {
+		t.Fatalf("expected one dead delivery, got %+v", dead)
+	}
+
+	if rr := do(t, router, http.MethodPost, "/v1/webhooks/dead-letters/"+dead[0].ID+"/redeliver", nil); rr.Code != 202 {
+		t.Fatalf("expected 202, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	nextCall(t, calls)
+	if rr := do(t, router, http.MethodPost, "/v1/webhooks/dead-letters/nope/redeliver", nil); rr.Code != 404 {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,138 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is one readiness dependency (store, AAD token, subscription...).
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Result is the outcome of one check as shown in the /readyz body.
+type Result struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+	CheckedAt string `json:"checked_at"`
+	Cached    bool   `json:"cached"`
+}
+
+// Report is the /readyz body.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Checker runs the readiness checks. Results are cached for ttl so probes
+// every few seconds (times the number of replicas) don't hammer AAD and ARM.
+type Checker struct {
+	checks  []Check
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+type cached struct {
+	result Result
+	at     time.Time
+}
+
+func NewChecker(ttl, timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{
+		checks:  checks,
+		ttl:     ttl,
+		timeout: timeout,
+		cache:   make(map[string]cached),
+	}
+}
+
+// Run executes every check concurrently (or takes it from the cache).
+func (c *Checker) Run(ctx context.Context) Report {
+	results := make([]Result, len(c.checks))
+
+	var wg sync.WaitGroup
+	for i, chk := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx, chk)
+		}()
+	}
+	wg.Wait()
+
+	rep := Report{Status: StatusOK, Checks: results}
+	for _, r := range results {
+		if r.Status != StatusOK {
+			rep.Status = StatusFail
+		}
+	}
+	return rep
+}
+
+func (c *Checker) run(ctx context.Context, chk Check) Result {
+	c.mu.Lock()
+	if hit, ok := c.cache[chk.Name]; ok && time.Since(hit.at) < c.ttl {
+		c.mu.Unlock()
+		hit.result.Cached = true
+		return hit.result
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := chk.Run(ctx)
+	res := Result{
+		Name:      chk.Name,
+		Status:    StatusOK,
+		LatencyMS: time.Since(start).Milliseconds(),
+		CheckedAt: start.UTC().Format(time.RFC3339),
+	}
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+
+	c.mu.Lock()
+	c.cache[chk.Name] = cached{result: res, at: start}
+	c.mu.Unlock()
+	return res
+}
+
+// Ready serves GET /readyz: 200 when every check passes, 503 otherwise.
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	rep := c.Run(r.Context())
+
+	code := http.StatusOK
+	if rep.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(rep)
+}
+
+// Live serves GET /livez. It only says the process can answer HTTP: a broken
+// dependency must not get the container restarted, only taken out of rotation.
+func Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Report{Status: StatusOK, Checks: []Result{}})
+}
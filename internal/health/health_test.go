@@ -0,0 +1,75 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChecker_Ready_TableDriven(t *testing.T) {
+	ok := Check{Name: "store", Run: func(context.Context) error { return nil }}
+	bad := Check{Name: "azure_token", Run: func(context.Context) error { return errors.New("no credential") }}
+
+	tests := []struct {
+		name       string
+		checks     []Check
+		wantStatus int
+		wantBody   string
+	}{
+		{"all ok", []Check{ok}, http.StatusOK, StatusOK},
+		{"one failing", []Check{ok, bad}, http.StatusServiceUnavailable, StatusFail},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewChecker(time.Minute, time.Second, tc.checks...)
+			rr := httptest.NewRecorder()
+			c.Ready(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("expected %d, got %d", tc.wantStatus, rr.Code)
+			}
+			var rep Report
+			if err := json.Unmarshal(rr.Body.Bytes(), &rep); err != nil {
+				t.Fatalf("invalid json: %v", err)
+			}
+			if rep.Status != tc.wantBody || len(rep.Checks) != len(tc.checks) {
+				t.Fatalf("unexpected report: %+v", rep)
+			}
+		})
+	}
+}
+
+func TestChecker_CachesResults(t *testing.T) {
+	calls := 0
+	c := NewChecker(time.Minute, time.Second, Check{Name: "azure_token", Run: func(context.Context) error {
+		calls++
+		return nil
+	}})
+
+	first := c.Run(context.Background())
+	second := c.Run(context.Background())
+
+	if calls != 1 {
+		t.Fatalf("expected check to run once, ran %d times", calls)
+	}
+	if first.Checks[0].Cached || !second.Checks[0].Cached {
+		t.Fatalf("expected only the second result to be cached: %+v %+v", first, second)
+	}
+}
+
+func TestChecker_TimesOutSlowChecks(t *testing.T) {
+	c := NewChecker(time.Minute, 10*time.Millisecond, Check{Name: "subscription", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+
+	rep := c.Run(context.Background())
+	if rep.Status != StatusFail || rep.Checks[0].Error == "" {
+		t.Fatalf("expected timeout failure, got %+v", rep)
+	}
+}
@@ -0,0 +1,236 @@
+// Package idempotency makes retries safe: the answer to a request carrying an
+// Idempotency-Key header is kept and replayed to the requests repeating that
+// key, so a client that lost an answer can send a POST again without it
+// happening twice.
+//
+// Keys are per caller (identity.Caller). A key sent again with another
+// method, URL or body is a client bug and gets a 422. A retry arriving while
+// the first request still runs waits for its answer. Answers >= 500 are not
+// kept, their retries run again.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/identity"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+)
+
+const (
+	// Header carries the key, any string of up to maxKeyLen chars, e.g. a UUID
+	// the client makes once per call and reuses on its retries.
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set on the answers that are replays.
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxKeyLen  = 255
+	maxBody    = 10 << 20 // the largest body a route takes (imports)
+	maxEntries = 10000
+)
+
+var errMismatch = errors.New("key used for another request")
+
+type entry struct {
+	fingerprint [sha256.Size]byte
+	done        chan struct{} // closed once the answer is kept or dropped
+
+	// the answer, status 0 when it was dropped
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// Cache keeps the answers for ttl, at most maxEntries of them.
+type Cache struct {
+	ttl time.Duration
+	now func() time.Time // for testing
+
+	mu      sync.Mutex
+	entries map[string]*entry // by caller and key
+}
+
+func New(ttl time.Duration) *Cache {
+	return &Cache{ttl: ttl, now: time.Now, entries: map[string]*entry{}}
+}
+
+// Middleware replays the kept answers. GET, HEAD and OPTIONS are safe to
+// retry as they are, they go through untouched.
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLen {
+			writeErr(w, 400, Header+" is too long")
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+		if err != nil {
+			writeErr(w, 400, "reading the body: "+err.Error())
+			return
+		}
+		if len(body) > maxBody {
+			writeErr(w, 413, "body too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		h := sha256.New()
+		io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+		h.Write(body)
+		var fp [sha256.Size]byte
+		h.Sum(fp[:0])
+
+		log := logging.FromContext(r.Context())
+		id := identity.Caller(r) + "\x00" + key
+		e, owner, err := c.begin(r.Context(), id, fp)
+		switch {
+		case errors.Is(err, errMismatch):
+			log.Warn("idempotency key reused", slog.String("idempotency_key", key))
+			writeErr(w, 422, Header+" already used for another request")
+			return
+		case err != nil: // gone while waiting for the first one
+			writeErr(w, 503, err.Error())
+			return
+		case !owner:
+			log.Info("answer replayed", slog.String("idempotency_key", key), slog.Int("status", e.status))
+			maps.Copy(w.Header(), e.header)
+			w.Header().Set(ReplayedHeader, "true")
+			w.WriteHeader(e.status)
+			w.Write(e.body)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		kept := false
+		defer func() {
+			if !kept { // the handler panicked
+				c.finish(id, e, nil)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		kept = true
+		c.finish(id, e, rec)
+	})
+}
+
+// begin returns the entry of id and whether the caller owns it, i.e. must run
+// the request and finish it. Otherwise the entry has the answer to replay.
+func (c *Cache) begin(ctx context.Context, id string, fp [sha256.Size]byte) (*entry, bool, error) {
+	for {
+		c.mu.Lock()
+		e, ok := c.entries[id]
+		if ok && e.status != 0 && c.now().After(e.expires) {
+			delete(c.entries, id)
+			ok = false
+		}
+		if !ok {
+			c.evict()
+			e = &entry{fingerprint: fp, done: make(chan struct{})}
+			c.entries[id] = e
+			c.mu.Unlock()
+			return e, true, nil
+		}
+		c.mu.Unlock()
+
+		if e.fingerprint != fp {
+			return nil, false, errMismatch
+		}
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		if e.status != 0 {
+			return e, false, nil
+		}
+		// dropped, run it again
+	}
+}
+
+// finish keeps the answer rec recorded, or drops it when nil or a >= 500.
+// Caller owns e.
+func (c *Cache) finish(id string, e *entry, rec *recorder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rec == nil || rec.status >= 500 {
+		if c.entries[id] == e {
+			delete(c.entries, id)
+		}
+	} else {
+		if rec.status == 0 { // nothing written
+			rec.status, rec.header = http.StatusOK, rec.ResponseWriter.Header().Clone()
+		}
+		e.status, e.header, e.body = rec.status, rec.header, rec.body.Bytes()
+		e.expires = c.now().Add(c.ttl)
+	}
+	close(e.done)
+}
+
+// evict makes room for an entry: the expired ones go, then the oldest kept
+// ones. Caller holds mu.
+func (c *Cache) evict() {
+	if len(c.entries) < maxEntries {
+		return
+	}
+	now := c.now()
+	for id, e := range c.entries {
+		if e.status != 0 && now.After(e.expires) {
+			delete(c.entries, id)
+		}
+	}
+	for len(c.entries) >= maxEntries {
+		oldest := ""
+		for id, e := range c.entries {
+			if e.status != 0 && (oldest == "" || e.expires.Before(c.entries[oldest].expires)) {
+				oldest = id
+			}
+		}
+		if oldest == "" { // all running
+			return
+		}
+		delete(c.entries, oldest)
+	}
+}
+
+// recorder passes the answer through and keeps a copy.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status, r.header = status, r.ResponseWriter.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func writeErr(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,123 @@
+package idempotency
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// counter answers 201 with how many times it ran, or status when set.
+type counter struct {
+	calls  atomic.Int32
+	status int
+	gate   chan struct{} // when set, waited on before answering
+}
+
+func (c *counter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := c.calls.Add(1)
+	if c.gate != nil {
+		<-c.gate
+	}
+	io.ReadAll(r.Body) // the body is still there
+	w.Header().Set("X-Run", fmt.Sprint(n))
+	w.WriteHeader(max(c.status, 201))
+	fmt.Fprintf(w, "run %d", n)
+}
+
+func send(h http.Handler, method, key, caller, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/v1/resources", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	if caller != "" {
+		req.Header.Set("X-MS-CLIENT-PRINCIPAL-NAME", caller)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMiddleware_Replays(t *testing.T) {
+	next := &counter{}
+	h := New(time.Hour).Middleware(next)
+
+	first := send(h, "POST", "k1", "", `{"a":1}`)
+	again := send(h, "POST", "k1", "", `{"a":1}`)
+	if next.calls.Load() != 1 || again.Code != 201 || again.Body.String() != "run 1" || again.Header().Get("X-Run") != "1" {
+		t.Fatalf("expected the first answer replayed, got %d %q after %q", again.Code, again.Body.String(), first.Body.String())
+	}
+	if first.Header().Get(ReplayedHeader) != "" || again.Header().Get(ReplayedHeader) != "true" {
+		t.Fatalf("expected only the replay marked, got %q %q", first.Header().Get(ReplayedHeader), again.Header().Get(ReplayedHeader))
+	}
+
+	tests := []struct {
+		name                      string
+		method, key, caller, body string
+		wantCode                  int
+		wantCalls                 int32
+	}{
+		{"other body", "POST", "k1", "", `{"a":2}`, 422, 1},
+		{"other method", "PATCH", "k1", "", `{"a":1}`, 422, 1},
+		{"other caller", "POST", "k1", "alice", `{"a":1}`, 201, 2},
+		{"no key", "POST", "", "", `{"a":1}`, 201, 3},
+		{"get", "GET", "k1", "", "", 201, 4},
+		{"key too long", "POST", strings.Repeat("k", 300), "", "", 400, 4},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := send(h, tc.method, tc.key, tc.caller, tc.body)
+			if rr.Code != tc.wantCode || next.calls.Load() != tc.wantCalls {
+				t.Fatalf("expected %d after %d runs, got %d after %d: %s", tc.wantCode, tc.wantCalls, rr.Code, next.calls.Load(), rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestMiddleware_ServerErrorsRunAgain(t *testing.T) {
+	next := &counter{status: 503}
+	h := New(time.Hour).Middleware(next)
+	send(h, "POST", "k1", "", "")
+	next.status = 0
+	if rr := send(h, "POST", "k1", "", ""); rr.Code != 201 || next.calls.Load() != 2 {
+		t.Fatalf("expected the retry to run, got %d after %d runs", rr.Code, next.calls.Load())
+	}
+}
+
+func TestMiddleware_Expires(t *testing.T) {
+	next := &counter{}
+	c := New(time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	h := c.Middleware(next)
+	send(h, "POST", "k1", "", "")
+	now = now.Add(2 * time.Minute)
+	if rr := send(h, "POST", "k1", "", `{"new":true}`); rr.Code != 201 || next.calls.Load() != 2 {
+		t.Fatalf("expected an expired key reusable, got %d after %d runs", rr.Code, next.calls.Load())
+	}
+}
+
+func TestMiddleware_RetryWaitsForTheFirst(t *testing.T) {
+	next := &counter{gate: make(chan struct{})}
+	h := New(time.Hour).Middleware(next)
+
+	var wg sync.WaitGroup
+	answers := make([]string, 3)
+	for i := range answers {
+		wg.Go(func() { answers[i] = send(h, "POST", "k1", "", "x").Body.String() })
+	}
+	for next.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond) // the others are waiting
+	close(next.gate)
+	wg.Wait()
+	if next.calls.Load() != 1 || answers[0] != "run 1" || answers[1] != "run 1" || answers[2] != "run 1" {
+		t.Fatalf("expected one run answered thrice, got %d runs %v", next.calls.Load(), answers)
+	}
+}
@@ -0,0 +1,19 @@
+package identity
+
+import "net/http"
+
+// Anonymous is used when the request carries no caller identity.
+const Anonymous = "anonymous"
+
+// Caller returns who is calling the API. Container Apps authentication (Easy
+// Auth) validates the token in front of us and forwards the principal in these
+// headers, so we only read them, never trust them for authz.
+func Caller(r *http.Request) string {
+	if v := r.Header.Get("X-MS-CLIENT-PRINCIPAL-NAME"); v != "" {
+		return v
+	}
+	if v := r.Header.Get("X-MS-CLIENT-PRINCIPAL-ID"); v != "" {
+		return v
+	}
+	return Anonymous
+}
@@ -0,0 +1,258 @@
+// Package jobs runs background work (re-applies, bulk operations) on a small
+// worker pool and keeps its progress in memory for GET /jobs/{id}.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/metrics"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrQueueFull = errors.New("job queue is full, retry later")
+	ErrStopped   = errors.New("job runner is stopped")
+	ErrNotFound  = errors.New("job not found")
+)
+
+const (
+	maxErrors  = 20   // per job, the first ones are the interesting ones
+	maxHistory = 1000 // finished jobs kept, oldest dropped first
+)
+
+// Func is the work of a job. It reports each item through p and should stop
+// when ctx is done (shutdown).
+type Func func(ctx context.Context, p *Progress) error
+
+// Progress is how a running job reports what it did.
+type Progress struct {
+	r  *Runner
+	id string
+}
+
+// SetTotal sets how many items the job will process.
+func (p *Progress) SetTotal(n int) {
+	p.r.update(p.id, func(j *models.Job) { j.Total = n })
+}
+
+// Step records one processed item, failed when err is not nil.
+func (p *Progress) Step(err error) {
+	p.r.update(p.id, func(j *models.Job) {
+		j.Done++
+		if err != nil {
+			j.Failed++
+			if len(j.Errors) < maxErrors {
+				j.Errors = append(j.Errors, err.Error())
+			}
+		}
+	})
+}
+
+type task struct {
+	id string
+	fn Func
+}
+
+// Runner is the worker pool. Jobs run until ctx (given to NewRunner) is done.
+type Runner struct {
+	ctx    context.Context
+	queue  chan task
+	wg     sync.WaitGroup
+	notify func(models.Job)
+
+	mu    sync.Mutex
+	jobs  map[string]*models.Job
+	order []string // creation order
+}
+
+// NewRunner starts workers goroutines taking jobs from a queue of queueSize.
+// Cancel ctx to stop them, then call Wait. notify (optional) gets a copy of a
+// job each time it changes, it must not block.
+func NewRunner(ctx context.Context, workers, queueSize int, notify func(models.Job)) *Runner {
+	r := &Runner{
+		ctx:    ctx,
+		queue:  make(chan task, queueSize),
+		jobs:   make(map[string]*models.Job),
+		notify: notify,
+	}
+	for range workers {
+		r.wg.Add(1)
+		go r.work()
+	}
+	return r
+}
+
+// Submit queues a job and returns it as queued.
+func (r *Runner) Submit(kind string, fn Func) (models.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// checked under mu, which Wait holds to drain the queue: a job queued
+	// here is either drained by Wait or refused
+	if r.ctx.Err() != nil {
+		return models.Job{}, ErrStopped
+	}
+
+	// registered before it is queued: a worker may pick it up right away,
+	// it only waits for mu to update it
+	j := &models.Job{ID: uuid.NewString(), Kind: kind, Status: models.JobQueued, CreatedUnix: time.Now().Unix()}
+	select {
+	case r.queue <- task{id: j.ID, fn: fn}:
+	default:
+		return models.Job{}, ErrQueueFull
+	}
+	r.jobs[j.ID] = j
+	r.order = append(r.order, j.ID)
+	r.prune()
+	metrics.SetJobQueueDepth(len(r.queue))
+	r.changed(j)
+	return *j, nil
+}
+
+// Get returns a copy of a job.
+func (r *Runner) Get(id string) (models.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return models.Job{}, ErrNotFound
+	}
+	return clone(j), nil
+}
+
+// List returns every known job, newest first.
+func (r *Runner) List() []models.Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]models.Job, 0, len(r.order))
+	for i := len(r.order) - 1; i >= 0; i-- {
+		out = append(out, clone(r.jobs[r.order[i]]))
+	}
+	return out
+}
+
+// Wait waits (until ctx is done) for the workers to return after the runner
+// context was canceled. Jobs still queued are marked canceled.
+func (r *Runner) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		select {
+		case t := <-r.queue:
+			if j, ok := r.jobs[t.id]; ok {
+				r.settle(j, context.Canceled)
+				r.changed(j)
+			}
+		default:
+			metrics.SetJobQueueDepth(0)
+			return nil
+		}
+	}
+}
+
+func (r *Runner) work() {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case t := <-r.queue:
+			metrics.SetJobQueueDepth(len(r.queue))
+			if err := r.ctx.Err(); err != nil {
+				// select picks at random when both are ready
+				r.finish(t.id, err)
+				return
+			}
+			r.run(t)
+		}
+	}
+}
+
+func (r *Runner) run(t task) {
+	r.update(t.id, func(j *models.Job) {
+		j.Status = models.JobRunning
+		j.StartedUnix = time.Now().Unix()
+	})
+
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("job panicked: %v", p)
+			}
+		}()
+		return t.fn(r.ctx, &Progress{r: r, id: t.id})
+	}()
+	r.finish(t.id, err)
+}
+
+func (r *Runner) finish(id string, err error) {
+	r.update(id, func(j *models.Job) { r.settle(j, err) })
+}
+
+// settle sets the final status of j, err is what its Func returned. Caller
+// holds mu.
+func (r *Runner) settle(j *models.Job, err error) {
+	j.FinishedUnix = time.Now().Unix()
+	switch {
+	case err != nil && (errors.Is(err, context.Canceled) || r.ctx.Err() != nil):
+		j.Status, j.Error = models.JobCanceled, err.Error()
+	case err != nil:
+		j.Status, j.Error = models.JobFailed, err.Error()
+	case j.Failed > 0:
+		j.Status = models.JobFailed
+	default:
+		j.Status = models.JobSucceeded
+	}
+}
+
+func (r *Runner) update(id string, fn func(*models.Job)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if j, ok := r.jobs[id]; ok {
+		fn(j)
+		r.changed(j)
+	}
+}
+
+// changed notifies about j. Caller holds mu, so notifications keep the order
+// of the changes.
+func (r *Runner) changed(j *models.Job) {
+	if r.notify != nil {
+		r.notify(clone(j))
+	}
+}
+
+// prune drops the oldest finished jobs past maxHistory. Caller holds mu.
+func (r *Runner) prune() {
+	for i := 0; len(r.order) > maxHistory && i < len(r.order); {
+		j := r.jobs[r.order[i]]
+		if j.FinishedUnix == 0 {
+			i++
+			continue
+		}
+		delete(r.jobs, j.ID)
+		r.order = slices.Delete(r.order, i, i+1)
+	}
+}
+
+func clone(j *models.Job) models.Job {
+	c := *j
+	c.Errors = slices.Clone(j.Errors)
+	return c
+}
@@ -0,0 +1,160 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+// waitFor polls the job until it has finished.
+func waitFor(t *testing.T, r *Runner, id string) models.Job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		j, err := r.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.FinishedUnix != 0 {
+			return j
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return models.Job{}
+}
+
+func TestRunner_Statuses(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := NewRunner(ctx, 2, 10, nil)
+
+	tests := []struct {
+		name   string
+		fn     Func
+		status string
+		done   int
+		failed int
+	}{
+		{"all items ok", func(ctx context.Context, p *Progress) error {
+			p.SetTotal(2)
+			p.Step(nil)
+			p.Step(nil)
+			return nil
+		}, models.JobSucceeded, 2, 0},
+		{"one item failed", func(ctx context.Context, p *Progress) error {
+			p.SetTotal(2)
+			p.Step(nil)
+			p.Step(errors.New("boom"))
+			return nil
+		}, models.JobFailed, 2, 1},
+		{"job failed", func(ctx context.Context, p *Progress) error { return errors.New("no store") }, models.JobFailed, 0, 0},
+		{"panic", func(ctx context.Context, p *Progress) error { panic("oops") }, models.JobFailed, 0, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			queued, err := r.Submit("test", tc.fn)
+			if err != nil || queued.Status != models.JobQueued {
+				t.Fatalf("expected a queued job, got %+v / %v", queued, err)
+			}
+			j := waitFor(t, r, queued.ID)
+			if j.Status != tc.status || j.Done != tc.done || j.Failed != tc.failed {
+				t.Fatalf("expected %s %d/%d, got %+v", tc.status, tc.done, tc.failed, j)
+			}
+		})
+	}
+
+	if got := r.List(); len(got) != len(tests) || got[0].Kind != "test" {
+		t.Fatalf("expected %d jobs newest first, got %+v", len(tests), got)
+	}
+}
+
+func TestRunner_ShutdownCancelsJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	statuses := map[string][]string{}
+	r := NewRunner(ctx, 1, 10, func(j models.Job) {
+		mu.Lock()
+		defer mu.Unlock()
+		statuses[j.ID] = append(statuses[j.ID], j.Status)
+	})
+
+	started := make(chan struct{})
+	running, _ := r.Submit("slow", func(ctx context.Context, p *Progress) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	queued, _ := r.Submit("never runs", func(ctx context.Context, p *Progress) error { return nil })
+	<-started
+
+	cancel()
+	if err := r.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{running.ID, queued.ID} {
+		if j, _ := r.Get(id); j.Status != models.JobCanceled {
+			t.Fatalf("expected job %s to be canceled, got %+v", id, j)
+		}
+	}
+	if _, err := r.Submit("late", func(ctx context.Context, p *Progress) error { return nil }); !errors.Is(err, ErrStopped) {
+		t.Fatalf("expected ErrStopped, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string][]string{
+		running.ID: {models.JobQueued, models.JobRunning, models.JobCanceled},
+		queued.ID:  {models.JobQueued, models.JobCanceled},
+	}
+	for id, w := range want {
+		if !slices.Equal(statuses[id], w) {
+			t.Fatalf("expected notifications %v for job %s, got %v", w, id, statuses[id])
+		}
+	}
+}
+
+func TestRunner_SubmitRacingShutdown(t *testing.T) {
+	for range 50 {
+		ctx, cancel := context.WithCancel(context.Background())
+		r := NewRunner(ctx, 2, 1000, nil)
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Go(func() {
+				for range 20 {
+					r.Submit("late", func(ctx context.Context, p *Progress) error { return nil })
+				}
+			})
+		}
+		cancel()
+		if err := r.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+		for _, j := range r.List() {
+			if j.FinishedUnix == 0 {
+				t.Fatalf("expected every accepted job finished or canceled, got %+v", j)
+			}
+		}
+	}
+}
+
+func TestRunner_QueueFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := NewRunner(ctx, 0, 1, nil) // no worker, nothing leaves the queue
+
+	noop := func(ctx context.Context, p *Progress) error { return nil }
+	if _, err := r.Submit("a", noop); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Submit("b", noop); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+}
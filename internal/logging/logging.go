@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/identity"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const redacted = "[REDACTED]"
+
+// attribute keys containing one of these are never written as-is
+var secretKeys = []string{"secret", "token", "password", "authorization", "credential"}
+
+type ctxKey struct{}
+
+// New builds the JSON logger of the service. level is debug, info, warn or
+// error (anything else means info).
+func New(w io.Writer, level string) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       ParseLevel(level),
+		ReplaceAttr: redact,
+	}))
+}
+
+func ParseLevel(level string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// redact hides secrets by key name, and bearer tokens wherever they show up
+// (error messages from the SDK can echo headers).
+func redact(_ []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	if a.Value.Kind() == slog.KindString {
+		if v := a.Value.String(); strings.Contains(strings.ToLower(v), "bearer ") {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	return a
+}
+
+// WithContext stores a logger in ctx, FromContext gets it back.
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the request logger (with request_id, caller...) or the
+// default logger when ctx doesn't carry one (tests, background work).
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// Middleware replaces chi's middleware.Logger: it builds the request logger
+// (request ID, caller, trace ID) for the handlers and writes one line per
+// request when it completes. Must run after middleware.RequestID and the
+// tracing middleware.
+func Middleware(base *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			l := base.With(
+				slog.String("request_id", middleware.GetReqID(r.Context())),
+				slog.String("caller", identity.Caller(r)),
+			)
+			if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+				l = l.With(slog.String("trace_id", sc.TraceID().String()))
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(WithContext(r.Context(), l)))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			route := ""
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+
+			level := slog.LevelInfo
+			if status >= 500 {
+				level = slog.LevelError
+			}
+			l.LogAttrs(r.Context(), level, "request completed",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("route", route),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote_addr", r.RemoteAddr),
+			)
+		})
+	}
+}
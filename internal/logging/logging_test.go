@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestMiddleware_AddsRequestAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "debug")
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(Middleware(logger))
+	r.Get("/v1/resources/{id}", func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Info("handler line", slog.String("resource_id", chi.URLParam(r, "id")))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/resources/abc", nil)
+	req.Header.Set("X-MS-CLIENT-PRINCIPAL-NAME", "jane@contoso.com")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %d: %s", len(lines), buf.String())
+	}
+
+	for _, line := range lines {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("log line is not json: %v", err)
+		}
+		if m["request_id"] == "" || m["request_id"] == nil {
+			t.Fatalf("expected request_id in %s", line)
+		}
+		if m["caller"] != "jane@contoso.com" {
+			t.Fatalf("expected caller in %s", line)
+		}
+	}
+
+	var done map[string]any
+	json.Unmarshal([]byte(lines[1]), &done)
+	if done["route"] != "/v1/resources/{id}" || done["status"] != float64(204) {
+		t.Fatalf("unexpected completion line: %s", lines[1])
+	}
+}
+
+func TestNew_RedactsSecrets_TableDriven(t *testing.T) {
+	tests := []struct {
+		name string
+		attr slog.Attr
+	}{
+		{"client secret key", slog.String("client_secret", "abc")},
+		{"token key", slog.String("access_token", "eyJ0eXAi")},
+		{"authorization header", slog.String("Authorization", "Basic Zm9v")},
+		{"bearer in value", slog.String("error", "GET failed: Authorization: Bearer eyJ0eXAi")},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			New(&buf, "info").LogAttrs(t.Context(), slog.LevelInfo, "msg", tc.attr)
+
+			if strings.Contains(buf.String(), tc.attr.Value.String()) {
+				t.Fatalf("secret leaked: %s", buf.String())
+			}
+			if !strings.Contains(buf.String(), redacted) {
+				t.Fatalf("expected %s marker: %s", redacted, buf.String())
+			}
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if ParseLevel("debug") != slog.LevelDebug || ParseLevel("WARN") != slog.LevelWarn {
+		t.Fatal("expected known levels to parse")
+	}
+	if ParseLevel("nope") != slog.LevelInfo {
+		t.Fatal("expected unknown level to default to info")
+	}
+}
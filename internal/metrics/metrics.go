@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "azure_tagger"
+
+// Registry holds every metric of the service. We use our own registry (not the
+// prometheus default one) so tests and other packages can't leak metrics into it.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	storeOps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "store_operations_total",
+		Help:      "Store operations by operation name.",
+	}, []string{"op"})
+
+	storeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Store operation latency by operation name.",
+		Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05},
+	}, []string{"op"})
+
+	azureCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "azure_calls_total",
+		Help:      "Azure calls by operation, subscription and error class (none = success).",
+	}, []string{"op", "subscription", "error_class"})
+
+	azureDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "azure_call_duration_seconds",
+		Help:      "Azure call latency by operation and subscription.",
+		// ARM LROs can take a while, so go further than the default buckets
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 15, 30, 60},
+	}, []string{"op", "subscription"})
+
+	resources = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "resources",
+		Help:      "Number of resources registered in the store.",
+	})
+
+	jobQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "job_queue_depth",
+		Help:      "Number of background jobs waiting to run.",
+	})
+
+	webhookAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_attempts_total",
+		Help:      "Webhook delivery attempts by result (delivered, retry, dead).",
+	}, []string{"result"})
+
+	webhookDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_dropped_total",
+		Help:      "Webhook deliveries dropped with the delivery queue full (kept as dead letters).",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration,
+		storeOps, storeDuration,
+		azureCalls, azureDuration,
+		resources, jobQueueDepth,
+		webhookAttempts, webhookDropped,
+	)
+}
+
+// Handler serves the registry in the prometheus text format (GET /metrics).
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Middleware counts and times every request. It must be mounted on the chi
+// router so the route pattern (/v1/resources/{id}) is used as label instead of
+// the raw path, otherwise every resource ID would be a new time series.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK // handler wrote nothing
+		}
+		code := strconv.Itoa(status)
+
+		httpRequests.WithLabelValues(route, r.Method, code).Inc()
+		httpDuration.WithLabelValues(route, r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObserveStore records one store operation. Meant to be deferred:
+//
+//	defer metrics.ObserveStore("get", time.Now())
+func ObserveStore(op string, start time.Time) {
+	storeOps.WithLabelValues(op).Inc()
+	storeDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+// ObserveAzure records one call to Azure (token, ARM request or LRO poll).
+func ObserveAzure(op, subscription string, start time.Time, err error) {
+	azureCalls.WithLabelValues(op, subscription, ErrorClass(err)).Inc()
+	azureDuration.WithLabelValues(op, subscription).Observe(time.Since(start).Seconds())
+}
+
+// SetResourceCount updates the registered resources gauge.
+func SetResourceCount(n int) {
+	resources.Set(float64(n))
+}
+
+// SetJobQueueDepth updates the background job queue gauge.
+func SetJobQueueDepth(n int) {
+	jobQueueDepth.Set(float64(n))
+}
+
+// ObserveWebhookAttempt counts one webhook delivery attempt.
+func ObserveWebhookAttempt(result string) {
+	webhookAttempts.WithLabelValues(result).Inc()
+}
+
+// ObserveWebhookDropped counts one webhook delivery dropped, the queue being
+// full.
+func ObserveWebhookDropped() {
+	webhookDropped.Inc()
+}
+
+// ErrorClass maps an Azure SDK error to a small fixed set of values so it can
+// be used as a label (error messages would explode the cardinality).
+func ErrorClass(err error) string {
+	if err == nil {
+		return "none"
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+
+	var authErr *azidentity.AuthenticationFailedError
+	if errors.As(err, &authErr) {
+		return "auth"
+	}
+
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		switch code := respErr.StatusCode; {
+		case code == http.StatusUnauthorized || code == http.StatusForbidden:
+			return "auth"
+		case code == http.StatusNotFound:
+			return "not_found"
+		case code == http.StatusConflict:
+			return "conflict"
+		case code == http.StatusTooManyRequests:
+			return "throttled"
+		case code >= 500:
+			return "server"
+		default:
+			return "client"
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMiddleware_UsesRoutePattern(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/v1/resources/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.Handle("/metrics", Handler())
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/resources/abc-123", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	ObserveStore("get", time.Now())
+	ObserveAzure("update_by_id", "sub-1", time.Now(), nil)
+	SetResourceCount(3)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		`azure_tagger_http_requests_total{method="GET",route="/v1/resources/{id}",status="404"} 1`,
+		`azure_tagger_store_operations_total{op="get"} 1`,
+		`azure_tagger_azure_calls_total{error_class="none",op="update_by_id",subscription="sub-1"} 1`,
+		`azure_tagger_resources 3`,
+		`azure_tagger_job_queue_depth 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected metrics output to contain %q", want)
+		}
+	}
+	if strings.Contains(body, "abc-123") {
+		t.Fatal("raw path must not be used as a label")
+	}
+}
+
+func TestErrorClass_TableDriven(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, "none"},
+		{"timeout", fmt.Errorf("wrapped: %w", context.DeadlineExceeded), "timeout"},
+		{"canceled", context.Canceled, "canceled"},
+		{"throttled", &azcore.ResponseError{StatusCode: 429}, "throttled"},
+		{"conflict", &azcore.ResponseError{StatusCode: 409}, "conflict"},
+		{"forbidden", &azcore.ResponseError{StatusCode: 403}, "auth"},
+		{"not found", &azcore.ResponseError{StatusCode: 404}, "not_found"},
+		{"server", &azcore.ResponseError{StatusCode: 503}, "server"},
+		{"bad request", &azcore.ResponseError{StatusCode: 400}, "client"},
+		{"other", errors.New("boom"), "other"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ErrorClass(tc.err); got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
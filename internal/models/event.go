@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Event types
+const (
+	EventResourceCreated = "resource.created"
+	EventResourceUpdated = "resource.updated" // anything but its tags, e.g. needs_apply
+	EventResourceDeleted = "resource.deleted"
+	EventTagsUpdated     = "tags.updated"
+	EventAzureApplying   = "azure.applying"
+	EventAzureApplied    = "azure.applied"
+	EventAzureFailed     = "azure.failed"
+	EventDriftDetected   = "drift.detected" // Azure tags differ from the desired ones
+	EventJobUpdated      = "job.updated"    // status or progress, Data is the Job
+)
+
+// EventTypes lists every event type, for filters.
+var EventTypes = []string{
+	EventResourceCreated, EventResourceUpdated, EventResourceDeleted, EventTagsUpdated,
+	EventAzureApplying, EventAzureApplied, EventAzureFailed, EventDriftDetected, EventJobUpdated,
+}
+
+// Event is something that happened to a resource or a job, sent to webhooks
+// and GET /events. Tags are the resource tags after the change, what
+// selectors match.
+type Event struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type"`
+	Time       time.Time         `json:"time"`
+	ResourceID string            `json:"resource_id,omitempty"`
+	AzureID    string            `json:"azure_id,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Data       any               `json:"data,omitempty"`
+}
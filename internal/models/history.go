@@ -0,0 +1,19 @@
+package models
+
+// History actions
+const (
+	HistoryTagsUpdated = "tags_updated" // PATCH /resources/{id}/tags
+	HistoryTagsExpired = "tags_expired" // removed by the expiry sweeper
+	HistoryTagsRenamed = "tags_renamed" // keys renamed or values remapped in bulk
+	HistoryTagsRemoved = "tags_removed" // DELETE /resources/{id}/tags
+)
+
+// HistoryEntry is one change to the tags of a resource.
+type HistoryEntry struct {
+	Unix    int64             `json:"unix"`
+	Action  string            `json:"action"`
+	Actor   string            `json:"actor,omitempty"` // identity.Caller, empty for the service itself
+	Tags    map[string]string `json:"tags,omitempty"`  // set
+	Removed []string          `json:"removed,omitempty"`
+	Detail  string            `json:"detail,omitempty"`
+}
@@ -0,0 +1,49 @@
+package models
+
+// Suggestion kinds
+const (
+	SuggestKey   = "key"
+	SuggestValue = "value"
+)
+
+// TagInventory is every tag key and value in use, with how often.
+type TagInventory struct {
+	Resources      int             `json:"resources"`       // registered resources counted
+	AzureResources int             `json:"azure_resources"` // found in Azure under the scope asked, 0 if none
+	Keys           []KeyUsage      `json:"keys"`
+	Suggestions    []TagSuggestion `json:"suggestions"`
+	// Mapping has every suggestion, ready to be edited and sent to POST
+	// /tags/rename.
+	Mapping TagMapping `json:"mapping"`
+}
+
+// KeyUsage is a tag key as spelled and the resources having it.
+type KeyUsage struct {
+	Key    string       `json:"key"`
+	Count  int          `json:"count"`
+	Azure  int          `json:"azure"`
+	Values []ValueUsage `json:"values"`
+}
+
+// ValueUsage is a value of a key and the resources having it.
+type ValueUsage struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+	Azure int    `json:"azure"`
+}
+
+// TagSuggestion proposes to map near-duplicate keys, or values of a key, to
+// a canonical spelling.
+type TagSuggestion struct {
+	Kind      string   `json:"kind"`          // SuggestKey or SuggestValue
+	Key       string   `json:"key,omitempty"` // canonical key of the values
+	Canonical string   `json:"canonical"`
+	Variants  []string `json:"variants"`
+}
+
+// TagMapping renames tag keys and remaps tag values. Old keys and values are
+// matched as spelled; Values is by key after renaming, ignoring case.
+type TagMapping struct {
+	Keys   map[string]string            `json:"keys,omitempty"`
+	Values map[string]map[string]string `json:"values,omitempty"`
+}
@@ -0,0 +1,26 @@
+package models
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"   // the job or at least one of its items failed
+	JobCanceled  = "canceled" // the service shut down first
+)
+
+// Job is a background task (e.g. re-applying the resources of a tag set).
+// Done counts the items processed so far, Failed the ones that failed.
+type Job struct {
+	ID           string   `json:"id"`
+	Kind         string   `json:"kind"`
+	Status       string   `json:"status"`
+	Total        int      `json:"total"`
+	Done         int      `json:"done"`
+	Failed       int      `json:"failed"`
+	Errors       []string `json:"errors,omitempty"` // first failures only
+	Error        string   `json:"error,omitempty"`
+	CreatedUnix  int64    `json:"created_unix"`
+	StartedUnix  int64    `json:"started_unix,omitempty"`
+	FinishedUnix int64    `json:"finished_unix,omitempty"`
+}
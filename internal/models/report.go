@@ -0,0 +1,75 @@
+package models
+
+// Where a policy violation was found
+const (
+	ViolationStore = "store" // in the desired tags
+	ViolationAzure = "azure" // in the tags Azure has
+)
+
+// ComplianceReport is how the registered resources follow the tag policy
+// (tags.required_keys, tags.allowed_values).
+type ComplianceReport struct {
+	GeneratedUnix int64    `json:"generated_unix"`
+	Live          bool     `json:"live"` // the Azure tags were checked too
+	RequiredKeys  []string `json:"required_keys"`
+	Coverage
+	Keys           []KeyCoverage   `json:"keys"`
+	Subscriptions  []GroupCoverage `json:"subscriptions"`
+	ResourceGroups []GroupCoverage `json:"resource_groups"`
+	NonCompliant   []NonCompliance `json:"non_compliant"`
+	Unchecked      []Unchecked     `json:"unchecked"` // live, the Azure tags couldn't be read
+}
+
+// Coverage counts the compliant resources of a group. A resource whose
+// Azure tags couldn't be read is Unknown and left out of Total, unless its
+// desired tags already break the policy.
+type Coverage struct {
+	Total     int     `json:"total"`
+	Compliant int     `json:"compliant"`
+	Unknown   int     `json:"unknown"`
+	Percent   float64 `json:"percent"` // 0 when Total is 0
+}
+
+// KeyCoverage is how many resources have a required key, with an allowed
+// value.
+type KeyCoverage struct {
+	Key string `json:"key"`
+	Coverage
+}
+
+// GroupCoverage is the coverage of a subscription or of a resource group.
+type GroupCoverage struct {
+	Subscription  string `json:"subscription"`
+	ResourceGroup string `json:"resource_group,omitempty"`
+	Coverage
+}
+
+// NonCompliance is a resource breaking the policy, and why.
+type NonCompliance struct {
+	ResourceID    string      `json:"resource_id"`
+	Name          string      `json:"name"`
+	AzureID       string      `json:"azure_id"`
+	Subscription  string      `json:"subscription"`
+	ResourceGroup string      `json:"resource_group,omitempty"`
+	Violations    []Violation `json:"violations"`
+}
+
+// Unchecked is a resource whose Azure tags couldn't be read on a live
+// report, so its compliance is unknown.
+type Unchecked struct {
+	ResourceID    string `json:"resource_id"`
+	Name          string `json:"name"`
+	AzureID       string `json:"azure_id"`
+	Subscription  string `json:"subscription"`
+	ResourceGroup string `json:"resource_group,omitempty"`
+	Reason        string `json:"reason"`
+}
+
+// Violation is one reason a resource isn't compliant. Key is empty when it
+// is about the whole resource (e.g. gone from Azure), which then misses
+// every required key.
+type Violation struct {
+	Key    string `json:"key,omitempty"`
+	Source string `json:"source"` // ViolationStore or ViolationAzure
+	Reason string `json:"reason"`
+}
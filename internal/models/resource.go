@@ -5,5 +5,69 @@ type Resource struct {
 	Name        string            `json:"name"`
 	Tags        map[string]string `json:"tags"`
 	AzureID     string            `json:"azure_id"`
+	Scope       string            `json:"scope"` // what AzureID points to, see Scope*
 	CreatedUnix int64             `json:"create_unix"`
+	LastApply   *ApplyResult      `json:"last_apply,omitempty"`
+
+	// TagSets are merged in order under Tags, which override them.
+	TagSets []string `json:"tag_sets,omitempty"`
+	// NeedsApply is set when the desired tags changed since the last
+	// successful apply (tag set update, tag update, rules on discovery), so
+	// Azure may be behind.
+	NeedsApply bool `json:"needs_apply"`
+	// TagExpiry is when (unix) a key of Tags expires, removed then from the
+	// store and from Azure.
+	TagExpiry map[string]int64 `json:"tag_expiry,omitempty"`
+}
+
+// Scope kinds, detected from the Azure ID
+const (
+	ScopeSubscription  = "subscription"
+	ScopeResourceGroup = "resource_group"
+	ScopeResource      = "resource"
+)
+
+// Apply outcomes
+const (
+	ApplySucceeded   = "succeeded"
+	ApplyFailed      = "failed"
+	ApplyInterrupted = "interrupted" // the service was shutting down before Azure answered
+)
+
+// ApplyResult is the outcome of the last apply-tags call of a resource.
+type ApplyResult struct {
+	Status       string            `json:"status"`
+	Tags         map[string]string `json:"tags"`
+	Error        string            `json:"error,omitempty"`
+	FinishedUnix int64             `json:"finished_unix"`
+}
+
+// Tag sources
+const (
+	TagExplicit  = "explicit"  // set on the resource itself
+	TagFromSet   = "tag_set"   // from one of its tag sets
+	TagInherited = "inherited" // from its resource group
+)
+
+// EffectiveTag is one desired tag with where it comes from.
+type EffectiveTag struct {
+	Value         string `json:"value"`
+	Source        string `json:"source"`
+	TagSet        string `json:"tag_set,omitempty"`        // name@version
+	InheritedFrom string `json:"inherited_from,omitempty"` // Azure ID of the resource group
+}
+
+// ResourceDetail is a resource with its effective tags: the explicit ones
+// plus the inherited ones. GET /resources/{id} returns it.
+type ResourceDetail struct {
+	Resource
+	EffectiveTags map[string]EffectiveTag `json:"effective_tags"`
+}
+
+// RuleHit is what one tagging rule did to the tags of a resource.
+type RuleHit struct {
+	Rule      string            `json:"rule"`
+	Set       map[string]string `json:"set,omitempty"`
+	Defaulted map[string]string `json:"defaulted,omitempty"` // keys that were missing
+	Removed   []string          `json:"removed,omitempty"`
 }
@@ -0,0 +1,12 @@
+package models
+
+// TagSet is a named, versioned set of tags (e.g. prod-baseline) that many
+// resources can reference instead of repeating the tags.
+type TagSet struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Tags        map[string]string `json:"tags"`
+	Version     int               `json:"version"` // 1 on creation, +1 on every update
+	CreatedUnix int64             `json:"created_unix"`
+	UpdatedUnix int64             `json:"updated_unix"`
+}
@@ -0,0 +1,32 @@
+package models
+
+// Webhook is a subscription to events, delivered as signed JSON to URL.
+type Webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"` // Event* types, empty = all
+	// Secret signs the deliveries (HMAC-SHA256), only returned on creation.
+	Secret      string `json:"secret,omitempty"`
+	CreatedUnix int64  `json:"created_unix"`
+}
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending" // being sent or waiting for a retry
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead" // gave up, see the dead letters
+)
+
+// Delivery is one event sent to one webhook.
+type Delivery struct {
+	ID             string `json:"id"`
+	WebhookID      string `json:"webhook_id"`
+	EventID        string `json:"event_id"`
+	EventType      string `json:"event_type"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	LastStatusCode int    `json:"last_status_code,omitempty"`
+	LastError      string `json:"last_error,omitempty"`
+	CreatedUnix    int64  `json:"created_unix"`
+	FinishedUnix   int64  `json:"finished_unix,omitempty"`
+}
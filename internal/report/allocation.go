@@ -0,0 +1,171 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tags"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Allocation statuses
+const (
+	Allocated   = "allocated"   // every dimension has a value
+	Partial     = "partial"     // some do
+	Unallocated = "unallocated" // none do, the costs can't be allocated
+)
+
+// Columns of the cost-allocation export, named like in the Azure Cost
+// Management exports so the records join on ResourceId. The dimension
+// columns come between the resource ones and these.
+const (
+	colResourceID        = "ResourceId"
+	colSubscriptionID    = "SubscriptionId"
+	colResourceGroup     = "ResourceGroupName"
+	colResourceType      = "ResourceType"
+	colResourceName      = "ResourceName"
+	colTags              = "Tags"
+	colAllocationStatus  = "AllocationStatus"
+	colMissingDimensions = "MissingDimensions"
+)
+
+var allocationColumns = []string{colResourceID, colSubscriptionID, colResourceGroup, colResourceType, colResourceName, colTags, colAllocationStatus, colMissingDimensions}
+
+// AllocationRecord is a resource with its cost-allocation dimensions.
+type AllocationRecord struct {
+	ResourceID     string // lower case, like in the cost data
+	SubscriptionID string
+	ResourceGroup  string
+	ResourceType   string
+	ResourceName   string
+	Dimensions     []string // values in the order of the keys, "" when missing
+	Tags           map[string]string
+	Status         string
+	Missing        []string
+}
+
+// CheckDimensions rejects dimension keys that are empty, there twice, or
+// named like a fixed column.
+func CheckDimensions(keys []string) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("no cost dimension")
+	}
+	for i, k := range keys {
+		eq := func(s string) bool { return strings.EqualFold(s, k) }
+		switch {
+		case strings.TrimSpace(k) == "":
+			return fmt.Errorf("cost dimension %d is empty", i+1)
+		case slices.ContainsFunc(keys[:i], eq):
+			return fmt.Errorf("cost dimension %s is there twice", k)
+		case slices.ContainsFunc(allocationColumns, eq):
+			return fmt.Errorf("cost dimension %s is a column of the export", k)
+		}
+	}
+	return nil
+}
+
+// NewAllocationRecord is the record of an Azure ID with its desired tags,
+// the dimension keys are looked up ignoring case.
+func NewAllocationRecord(azureID string, desired map[string]string, keys []string) AllocationRecord {
+	rec := AllocationRecord{ResourceID: strings.ToLower(azureID), Tags: desired, Dimensions: make([]string, len(keys))}
+	if id, err := arm.ParseResourceID(azureID); err == nil {
+		rec.SubscriptionID = id.SubscriptionID
+		rec.ResourceGroup = id.ResourceGroupName
+		rec.ResourceType = id.ResourceType.String()
+		rec.ResourceName = id.Name
+	}
+	for i, k := range keys {
+		if v, ok := tags.Get(desired, k); ok && strings.TrimSpace(v) != "" {
+			rec.Dimensions[i] = v
+		} else {
+			rec.Missing = append(rec.Missing, k)
+		}
+	}
+	switch len(rec.Missing) {
+	case 0:
+		rec.Status = Allocated
+	case len(keys):
+		rec.Status = Unallocated
+	default:
+		rec.Status = Partial
+	}
+	return rec
+}
+
+// values are the columns of rec in the order of header: resource, dimension
+// then allocation columns.
+func (rec AllocationRecord) values() []string {
+	tagsJSON, _ := json.Marshal(rec.Tags) // keys sorted
+	if rec.Tags == nil {
+		tagsJSON = []byte("{}")
+	}
+	out := []string{rec.ResourceID, rec.SubscriptionID, rec.ResourceGroup, rec.ResourceType, rec.ResourceName}
+	out = append(out, rec.Dimensions...)
+	return append(out, string(tagsJSON), rec.Status, strings.Join(rec.Missing, ";"))
+}
+
+func allocationHeader(keys []string) []string {
+	out := slices.Clone(allocationColumns[:5])
+	out = append(out, keys...)
+	return append(out, allocationColumns[5:]...)
+}
+
+// AllocationWriter writes allocation records as CSV or Parquet.
+type AllocationWriter interface {
+	Write(AllocationRecord) error
+	// Close flushes what is buffered, a Parquet file is only complete then.
+	Close() error
+}
+
+type allocationCSV struct{ cw *csv.Writer }
+
+// NewAllocationCSV writes the header right away.
+func NewAllocationCSV(w io.Writer, keys []string, comma rune) AllocationWriter {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	cw.Write(csvRecord(allocationHeader(keys)))
+	return allocationCSV{cw}
+}
+
+func (a allocationCSV) Write(rec AllocationRecord) error { return a.cw.Write(csvRecord(rec.values())) }
+
+func (a allocationCSV) Close() error {
+	a.cw.Flush()
+	return a.cw.Error()
+}
+
+type allocationParquet struct {
+	w      *parquet.Writer
+	header []string
+}
+
+// NewAllocationParquet writes every column as a UTF-8 string, the dimension
+// ones are null when missing.
+func NewAllocationParquet(w io.Writer, keys []string) AllocationWriter {
+	group := parquet.Group{}
+	for _, col := range allocationColumns {
+		group[col] = parquet.String()
+	}
+	for _, k := range keys {
+		group[k] = parquet.Optional(parquet.String())
+	}
+	schema := parquet.NewSchema("cost_allocation", group)
+	return allocationParquet{w: parquet.NewWriter(w, schema), header: allocationHeader(keys)}
+}
+
+func (a allocationParquet) Write(rec AllocationRecord) error {
+	row := make(map[string]any, len(a.header))
+	for i, v := range rec.values() {
+		if v != "" || !slices.Contains(rec.Missing, a.header[i]) {
+			row[a.header[i]] = v
+		}
+	}
+	return a.w.Write(row)
+}
+
+func (a allocationParquet) Close() error { return a.w.Close() }
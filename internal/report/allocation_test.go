@@ -0,0 +1,109 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestNewAllocationRecord(t *testing.T) {
+	keys := []string{"costCenter", "project", "owner"}
+	tests := []struct {
+		name    string
+		tags    map[string]string
+		status  string
+		missing []string
+	}{
+		{"allocated, keys ignore case", map[string]string{"CostCenter": "cc-1", "project": "p", "owner": "me"}, Allocated, nil},
+		{"partial, empty counts as missing", map[string]string{"costCenter": "cc-1", "owner": " "}, Partial, []string{"project", "owner"}},
+		{"unallocated", map[string]string{"env": "prod"}, Unallocated, keys},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := NewAllocationRecord(appVM, tc.tags, keys)
+			if rec.Status != tc.status || !slices.Equal(rec.Missing, tc.missing) {
+				t.Fatalf("expected %s missing %q, got %s missing %q", tc.status, tc.missing, rec.Status, rec.Missing)
+			}
+		})
+	}
+	rec := NewAllocationRecord(appVM, map[string]string{"costCenter": "cc-1"}, keys)
+	want := AllocationRecord{
+		ResourceID: "/subscriptions/" + sub + "/resourcegroups/rg-app/providers/microsoft.compute/virtualmachines/vm-1", SubscriptionID: sub,
+		ResourceGroup: "rg-app", ResourceType: "Microsoft.Compute/virtualMachines", ResourceName: "vm-1",
+	}
+	if rec.ResourceID != want.ResourceID || rec.SubscriptionID != want.SubscriptionID || rec.ResourceGroup != want.ResourceGroup ||
+		rec.ResourceType != want.ResourceType || rec.ResourceName != want.ResourceName || !slices.Equal(rec.Dimensions, []string{"cc-1", "", ""}) {
+		t.Fatalf("expected %+v, got %+v", want, rec)
+	}
+}
+
+func TestCheckDimensions(t *testing.T) {
+	for _, keys := range [][]string{nil, {"a", " "}, {"a", "A"}, {"resourceid"}} {
+		if CheckDimensions(keys) == nil {
+			t.Errorf("%q: expected an error", keys)
+		}
+	}
+	if err := CheckDimensions([]string{"costCenter", "project"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllocationWriters(t *testing.T) {
+	keys := []string{"costCenter", "owner"}
+	recs := []AllocationRecord{
+		NewAllocationRecord(appVM, map[string]string{"costCenter": "cc-1", "owner": "me"}, keys),
+		NewAllocationRecord(dataRG, map[string]string{"env": "dev"}, keys),
+	}
+
+	var b bytes.Buffer
+	w := NewAllocationCSV(&b, keys, ',')
+	for _, rec := range recs {
+		w.Write(rec)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := []string{"ResourceId", "SubscriptionId", "ResourceGroupName", "ResourceType", "ResourceName", "costCenter", "owner", "Tags", "AllocationStatus", "MissingDimensions"}
+	if !slices.Equal(rows[0], header) {
+		t.Fatalf("expected header %q, got %q", header, rows[0])
+	}
+	if got := rows[2]; got[2] != "rg-data" || got[5] != "" || got[7] != `{"env":"dev"}` || got[8] != Unallocated || got[9] != "costCenter;owner" {
+		t.Fatalf("bad unallocated row %q", got)
+	}
+
+	b.Reset()
+	w = NewAllocationParquet(&b, keys)
+	for _, rec := range recs {
+		w.Write(rec)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := parquet.OpenFile(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("invalid parquet: %v", err)
+	}
+	if f.NumRows() != 2 {
+		t.Fatalf("expected 2 rows, got %d", f.NumRows())
+	}
+	r := parquet.NewReader(f)
+	got := []map[string]any{{}, {}}
+	for _, row := range got {
+		if err := r.Read(&row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got[0]["costCenter"] != "cc-1" || got[0]["AllocationStatus"] != Allocated {
+		t.Fatalf("bad first row %v", got[0])
+	}
+	if got[1]["costCenter"] != nil || got[1]["MissingDimensions"] != "costCenter;owner" {
+		t.Fatalf("expected a null costCenter, got %v", got[1])
+	}
+}
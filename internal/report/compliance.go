@@ -0,0 +1,249 @@
+// Package report builds the reports made from the registered tags: the
+// compliance report (CSV or a printable HTML page, JSON is the models type as
+// is) and the cost-allocation export (CSV or Parquet).
+package report
+
+import (
+	"cmp"
+	"embed"
+	"encoding/csv"
+	"html/template"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/metrics"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/tags"
+)
+
+// Entry is a registered resource to check.
+type Entry struct {
+	Resource models.Resource
+	Desired  map[string]string // its effective tags
+	// Azure are its tags in Azure, only checked on a live report. AzureErr
+	// is why they are unknown.
+	Azure    map[string]string
+	AzureErr error
+}
+
+// Compliance checks the entries against the tag policy of cfg: their desired
+// tags and, when live, their Azure tags too. A resource is compliant when
+// neither breaks the policy; one whose Azure tags couldn't be read is
+// unchecked, counted apart from the others.
+func Compliance(cfg config.Tags, entries []Entry, live bool, now time.Time) models.ComplianceReport {
+	rep := models.ComplianceReport{
+		GeneratedUnix:  now.Unix(),
+		Live:           live,
+		RequiredKeys:   cfg.RequiredKeys,
+		Keys:           make([]models.KeyCoverage, len(cfg.RequiredKeys)),
+		Subscriptions:  []models.GroupCoverage{},
+		ResourceGroups: []models.GroupCoverage{},
+		NonCompliant:   []models.NonCompliance{},
+		Unchecked:      []models.Unchecked{},
+	}
+	if rep.RequiredKeys == nil {
+		rep.RequiredKeys = []string{}
+	}
+	for i, k := range cfg.RequiredKeys {
+		rep.Keys[i].Key = k
+	}
+	subs := map[string]*models.GroupCoverage{} // by lower subscription
+	rgs := map[string]*models.GroupCoverage{}  // by lower subscription/resource group
+
+	for _, e := range entries {
+		violations := tags.Check(cfg, e.Desired, models.ViolationStore)
+		// gone from Azure is an answer, any other error isn't
+		unread := live && e.AzureErr != nil && metrics.ErrorClass(e.AzureErr) != "not_found"
+		if live && !unread {
+			violations = append(violations, checkAzure(cfg, e)...)
+		}
+		ok := len(violations) == 0
+
+		(*coverage)(&rep.Coverage).count(ok, unread)
+		for i := range rep.Keys {
+			(*coverage)(&rep.Keys[i].Coverage).count(!slices.ContainsFunc(violations, func(v models.Violation) bool {
+				return v.Key == "" || strings.EqualFold(v.Key, rep.Keys[i].Key)
+			}), unread)
+		}
+		sub, rg := location(e.Resource.AzureID)
+		group(subs, strings.ToLower(sub), sub, "").count(ok, unread)
+		if rg != "" {
+			group(rgs, strings.ToLower(sub+"/"+rg), sub, rg).count(ok, unread)
+		}
+		if unread {
+			rep.Unchecked = append(rep.Unchecked, models.Unchecked{
+				ResourceID:    e.Resource.ID,
+				Name:          e.Resource.Name,
+				AzureID:       e.Resource.AzureID,
+				Subscription:  sub,
+				ResourceGroup: rg,
+				Reason:        "Azure tags unknown: " + e.AzureErr.Error(),
+			})
+		}
+		if !ok {
+			rep.NonCompliant = append(rep.NonCompliant, models.NonCompliance{
+				ResourceID:    e.Resource.ID,
+				Name:          e.Resource.Name,
+				AzureID:       e.Resource.AzureID,
+				Subscription:  sub,
+				ResourceGroup: rg,
+				Violations:    violations,
+			})
+		}
+	}
+
+	rep.Percent = percent(rep.Coverage)
+	for i := range rep.Keys {
+		rep.Keys[i].Percent = percent(rep.Keys[i].Coverage)
+	}
+	rep.Subscriptions = sorted(subs)
+	rep.ResourceGroups = sorted(rgs)
+	slices.SortFunc(rep.NonCompliant, func(a, b models.NonCompliance) int {
+		return cmp.Compare(strings.ToLower(a.AzureID), strings.ToLower(b.AzureID))
+	})
+	slices.SortFunc(rep.Unchecked, func(a, b models.Unchecked) int {
+		return cmp.Compare(strings.ToLower(a.AzureID), strings.ToLower(b.AzureID))
+	})
+	return rep
+}
+
+// checkAzure checks the Azure tags of e, a resource not found misses every
+// required key.
+func checkAzure(cfg config.Tags, e Entry) []models.Violation {
+	if e.AzureErr != nil {
+		return []models.Violation{{Source: models.ViolationAzure, Reason: "not found in Azure"}}
+	}
+	return tags.Check(cfg, e.Azure, models.ViolationAzure)
+}
+
+// location is the subscription and resource group names of an Azure ID, the
+// resource group is empty for a subscription or a subscription-level resource.
+func location(azureID string) (string, string) {
+	id, err := arm.ParseResourceID(azureID)
+	if err != nil {
+		return "", ""
+	}
+	return id.SubscriptionID, id.ResourceGroupName
+}
+
+// group is the counter of key in m, added on first use.
+func group(m map[string]*models.GroupCoverage, key, sub, rg string) *coverage {
+	g, ok := m[key]
+	if !ok {
+		g = &models.GroupCoverage{Subscription: sub, ResourceGroup: rg}
+		m[key] = g
+	}
+	return (*coverage)(&g.Coverage)
+}
+
+func sorted(m map[string]*models.GroupCoverage) []models.GroupCoverage {
+	out := make([]models.GroupCoverage, 0, len(m))
+	for _, g := range m {
+		g.Percent = percent(g.Coverage)
+		out = append(out, *g)
+	}
+	slices.SortFunc(out, func(a, b models.GroupCoverage) int {
+		return cmp.Or(
+			cmp.Compare(strings.ToLower(a.Subscription), strings.ToLower(b.Subscription)),
+			cmp.Compare(strings.ToLower(a.ResourceGroup), strings.ToLower(b.ResourceGroup)),
+		)
+	})
+	return out
+}
+
+// coverage adds the counting to models.Coverage.
+type coverage models.Coverage
+
+// count adds a resource, unknown when nothing says it isn't compliant but
+// its Azure tags weren't read.
+func (c *coverage) count(ok, unknown bool) {
+	switch {
+	case ok && unknown:
+		c.Unknown++
+	case ok:
+		c.Total++
+		c.Compliant++
+	default:
+		c.Total++
+	}
+}
+
+// percent is rounded to one decimal.
+func percent(c models.Coverage) float64 {
+	if c.Total == 0 {
+		return 0
+	}
+	return math.Round(float64(c.Compliant)*1000/float64(c.Total)) / 10
+}
+
+// reasons flattens the violations of a resource, the Azure ones prefixed.
+func reasons(vs []models.Violation) []string {
+	out := make([]string, len(vs))
+	for i, v := range vs {
+		out[i] = v.Reason
+		if v.Source == models.ViolationAzure {
+			out[i] = "azure: " + v.Reason
+		}
+	}
+	return out
+}
+
+// CSV sections, one row per entry of the report
+const (
+	sectionOverall       = "overall"
+	sectionKey           = "key"
+	sectionSubscription  = "subscription"
+	sectionResourceGroup = "resource_group"
+	sectionNonCompliant  = "non_compliant"
+	sectionUnchecked     = "unchecked"
+)
+
+var csvHeader = []string{"section", "key", "subscription", "resource_group", "azure_id", "total", "compliant", "unknown", "percent", "reasons"}
+
+// WriteCSV writes the report as one flat table, a section column telling
+// the rows apart so a spreadsheet can filter them.
+func WriteCSV(w io.Writer, rep models.ComplianceReport, comma rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	counts := func(c models.Coverage) []string {
+		return []string{strconv.Itoa(c.Total), strconv.Itoa(c.Compliant), strconv.Itoa(c.Unknown), strconv.FormatFloat(c.Percent, 'f', 1, 64)}
+	}
+	cw.Write(csvHeader)
+	cw.Write(append([]string{sectionOverall, "", "", "", ""}, append(counts(rep.Coverage), "")...))
+	for _, k := range rep.Keys {
+		cw.Write(csvRecord(append([]string{sectionKey, k.Key, "", "", ""}, append(counts(k.Coverage), "")...)))
+	}
+	for _, g := range rep.Subscriptions {
+		cw.Write(csvRecord(append([]string{sectionSubscription, "", g.Subscription, "", ""}, append(counts(g.Coverage), "")...)))
+	}
+	for _, g := range rep.ResourceGroups {
+		cw.Write(csvRecord(append([]string{sectionResourceGroup, "", g.Subscription, g.ResourceGroup, ""}, append(counts(g.Coverage), "")...)))
+	}
+	for _, nc := range rep.NonCompliant {
+		cw.Write(csvRecord([]string{sectionNonCompliant, "", nc.Subscription, nc.ResourceGroup, nc.AzureID, "", "", "", "", strings.Join(reasons(nc.Violations), "; ")}))
+	}
+	for _, u := range rep.Unchecked {
+		cw.Write(csvRecord([]string{sectionUnchecked, "", u.Subscription, u.ResourceGroup, u.AzureID, "", "", "", "", u.Reason}))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+//go:embed compliance.html
+var files embed.FS
+
+var page = template.Must(template.New("compliance.html").Funcs(template.FuncMap{
+	"reasons": reasons,
+	"date":    func(unix int64) string { return time.Unix(unix, 0).UTC().Format("2006-01-02 15:04 MST") },
+}).ParseFS(files, "compliance.html"))
+
+// WriteHTML writes the report as a standalone page, meant to be printed.
+func WriteHTML(w io.Writer, rep models.ComplianceReport) error {
+	return page.Execute(w, rep)
+}
@@ -0,0 +1,165 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+const (
+	sub    = "11111111-1111-1111-1111-111111111111"
+	appVM  = "/subscriptions/" + sub + "/resourceGroups/rg-app/providers/Microsoft.Compute/virtualMachines/vm-1"
+	appDB  = "/subscriptions/" + sub + "/resourceGroups/RG-App/providers/Microsoft.Sql/servers/sql-1"
+	dataRG = "/subscriptions/" + sub + "/resourceGroups/rg-data"
+	subID  = "/subscriptions/" + sub
+)
+
+var policy = config.Tags{
+	RequiredKeys:  []string{"owner", "env"},
+	AllowedValues: map[string][]string{"env": {"prod", "dev"}},
+}
+
+func entry(azureID string, desired map[string]string) Entry {
+	return Entry{Resource: models.Resource{ID: azureID, Name: azureID[strings.LastIndex(azureID, "/")+1:], AzureID: azureID}, Desired: desired}
+}
+
+func TestCompliance_Coverage(t *testing.T) {
+	entries := []Entry{
+		entry(appVM, map[string]string{"owner": "me", "env": "prod"}),
+		entry(appDB, map[string]string{"owner": "me", "env": "qa"}),
+		entry(dataRG, map[string]string{"env": "dev"}),
+		entry(subID, map[string]string{"Owner": "it", "ENV": "prod"}),
+	}
+	rep := Compliance(policy, entries, false, time.Unix(100, 0))
+
+	if rep.Total != 4 || rep.Compliant != 2 || rep.Percent != 50 || rep.Live {
+		t.Fatalf("bad overall coverage: %+v", rep.Coverage)
+	}
+	want := []models.KeyCoverage{
+		{Key: "owner", Coverage: models.Coverage{Total: 4, Compliant: 3, Percent: 75}},
+		{Key: "env", Coverage: models.Coverage{Total: 4, Compliant: 3, Percent: 75}},
+	}
+	if !slices.Equal(rep.Keys, want) {
+		t.Fatalf("expected keys %+v, got %+v", want, rep.Keys)
+	}
+	if len(rep.Subscriptions) != 1 || rep.Subscriptions[0].Total != 4 {
+		t.Fatalf("expected one subscription of 4, got %+v", rep.Subscriptions)
+	}
+	// rg-app and RG-App are the same group, a subscription has none
+	groups := []models.GroupCoverage{
+		{Subscription: sub, ResourceGroup: "rg-app", Coverage: models.Coverage{Total: 2, Compliant: 1, Percent: 50}},
+		{Subscription: sub, ResourceGroup: "rg-data", Coverage: models.Coverage{Total: 1, Compliant: 0, Percent: 0}},
+	}
+	if !slices.Equal(rep.ResourceGroups, groups) {
+		t.Fatalf("expected groups %+v, got %+v", groups, rep.ResourceGroups)
+	}
+	if len(rep.NonCompliant) != 2 || rep.NonCompliant[0].AzureID != appDB || rep.NonCompliant[1].AzureID != dataRG {
+		t.Fatalf("expected sql-1 and rg-data non compliant, got %+v", rep.NonCompliant)
+	}
+	if got := rep.NonCompliant[0].Violations; len(got) != 1 || got[0].Key != "env" || got[0].Source != models.ViolationStore {
+		t.Fatalf("expected the env value reported, got %+v", got)
+	}
+}
+
+func TestCompliance_Live(t *testing.T) {
+	good := map[string]string{"owner": "me", "env": "prod"}
+	inSync := entry(appVM, good)
+	inSync.Azure = good
+	behind := entry(appDB, good)
+	behind.Azure = map[string]string{"env": "prod"}
+	gone := entry(dataRG, good)
+	gone.AzureErr = &azcore.ResponseError{StatusCode: http.StatusNotFound}
+	unknown := entry(subID, good)
+	unknown.AzureErr = errors.New("boom")
+	// not read either, but its desired tags already miss owner
+	broken := entry(subID+"/resourceGroups/rg-x", map[string]string{"env": "prod"})
+	broken.AzureErr = errors.New("boom")
+
+	rep := Compliance(policy, []Entry{inSync, behind, gone, unknown, broken}, true, time.Now())
+	if rep.Total != 4 || rep.Compliant != 1 || rep.Unknown != 1 {
+		t.Fatalf("expected vm-1 compliant of 4 and one unknown, got %+v", rep.Coverage)
+	}
+	// unknown only where nothing else failed the key
+	want := []models.KeyCoverage{
+		{Key: "owner", Coverage: models.Coverage{Total: 4, Compliant: 1, Unknown: 1, Percent: 25}},
+		{Key: "env", Coverage: models.Coverage{Total: 3, Compliant: 2, Unknown: 2, Percent: 66.7}},
+	}
+	if !slices.Equal(rep.Keys, want) {
+		t.Fatalf("expected keys %+v, got %+v", want, rep.Keys)
+	}
+	var got []string
+	for _, nc := range rep.NonCompliant {
+		got = append(got, reasons(nc.Violations)...)
+	}
+	wantReasons := []string{"azure: missing required tag owner", "azure: not found in Azure", "missing required tag owner"} // by Azure ID
+	if !slices.Equal(got, wantReasons) {
+		t.Fatalf("expected %q, got %q", wantReasons, got)
+	}
+	if len(rep.Unchecked) != 2 || rep.Unchecked[0].AzureID != subID || rep.Unchecked[0].Reason != "Azure tags unknown: boom" {
+		t.Fatalf("expected the two resources not read unchecked, got %+v", rep.Unchecked)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	rep := Compliance(policy, []Entry{
+		entry(appVM, map[string]string{"owner": "me", "env": "prod"}),
+		entry(appDB, map[string]string{"env": "<qa>"}),
+	}, false, time.Unix(0, 0))
+
+	var b bytes.Buffer
+	if err := WriteCSV(&b, rep, ';'); err != nil {
+		t.Fatal(err)
+	}
+	cr := csv.NewReader(&b)
+	cr.Comma = ';'
+	rows, err := cr.ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	sections := []string{}
+	for _, r := range rows[1:] {
+		sections = append(sections, r[0])
+	}
+	if want := []string{"overall", "key", "key", "subscription", "resource_group", "non_compliant"}; !slices.Equal(sections, want) {
+		t.Fatalf("expected sections %q, got %q", want, sections)
+	}
+	if last := rows[len(rows)-1]; last[4] != appDB || !strings.Contains(last[9], "missing required tag owner; env=<qa>") {
+		t.Fatalf("bad non compliant row %q", last)
+	}
+
+	b.Reset()
+	if err := WriteHTML(&b, rep); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	for _, s := range []string{"1 of 2 resources compliant (50.0%)", "<td>rg-app</td>", "env=&lt;qa&gt;"} {
+		if !strings.Contains(page, s) {
+			t.Fatalf("expected %q in the page:\n%s", s, page)
+		}
+	}
+	// a live one with a resource not read
+	unread := entry(dataRG, map[string]string{"owner": "me", "env": "prod"})
+	unread.AzureErr = errors.New("boom")
+	rep = Compliance(policy, []Entry{unread}, true, time.Unix(0, 0))
+	b.Reset()
+	WriteCSV(&b, rep, ',')
+	if rows, _ := csv.NewReader(&b).ReadAll(); rows[1][7] != "1" || rows[len(rows)-1][0] != "unchecked" || rows[len(rows)-1][9] != "Azure tags unknown: boom" {
+		t.Fatalf("expected the unknown count and an unchecked row, got %q", rows)
+	}
+	b.Reset()
+	WriteHTML(&b, rep)
+	for _, s := range []string{"0 of 0 resources compliant (0.0%)</strong>, 1 more unknown", "Unchecked resources (1)"} {
+		if !strings.Contains(b.String(), s) {
+			t.Fatalf("expected %q in the page:\n%s", s, b.String())
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package report
+
+import (
+	"strconv"
+	"strings"
+)
+
+// formula tells whether a spreadsheet would run v as a formula, leading
+// quotes aside. A plain number like -12.5 is left alone.
+func formula(v string) bool {
+	v = strings.TrimLeft(v, "'")
+	if v == "" || !strings.ContainsRune("=+-@", rune(v[0])) {
+		return false
+	}
+	_, err := strconv.ParseFloat(v, 64)
+	return err != nil
+}
+
+// CSVCell quotes a value a spreadsheet would take for a formula (=, +, - or
+// @ first) with a leading ', so opening an export can't run one.
+func CSVCell(v string) string {
+	if formula(v) {
+		return "'" + v
+	}
+	return v
+}
+
+// CSVValue undoes CSVCell.
+func CSVValue(v string) string {
+	if strings.HasPrefix(v, "'") && formula(v) {
+		return v[1:]
+	}
+	return v
+}
+
+// csvRecord runs CSVCell on every value of rec.
+func csvRecord(rec []string) []string {
+	for i, v := range rec {
+		rec[i] = CSVCell(v)
+	}
+	return rec
+}
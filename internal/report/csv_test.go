@@ -0,0 +1,29 @@
+package report
+
+import "testing"
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"prod", "prod"},
+		{"", ""},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+cmd|' /C calc'!A0", "'+cmd|' /C calc'!A0"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"-12.5", "-12.5"},
+		{"'=1", "''=1"},
+		{"'quoted", "'quoted"},
+		{"a=b", "a=b"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			got := CSVCell(tc.in)
+			if got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+			if back := CSVValue(got); back != tc.in {
+				t.Fatalf("expected %q back, got %q", tc.in, back)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+// SetTagExpiry sets when (unix) keys of a resource expire.
+func (s *MemoryStore) SetTagExpiry(ctx context.Context, id string, expiry map[string]int64) error {
+	defer observe(ctx, "set_tag_expiry")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.resources[id]
+	if !ok {
+		return ErrNotFound
+	}
+	v.TagExpiry = maps.Clone(v.TagExpiry)
+	if v.TagExpiry == nil {
+		v.TagExpiry = map[string]int64{}
+	}
+	maps.Copy(v.TagExpiry, expiry)
+	s.resources[id] = v
+	s.publish(models.EventResourceUpdated, v)
+	return nil
+}
+
+// Expired returns the resources with at least one key expired at now (unix).
+func (s *MemoryStore) Expired(ctx context.Context, now int64) []models.Resource {
+	defer observe(ctx, "expired")()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []models.Resource
+	for _, v := range s.resources {
+		for _, at := range v.TagExpiry {
+			if at <= now {
+				out = append(out, v)
+				break
+			}
+		}
+	}
+	slices.SortFunc(out, func(a, b models.Resource) int { return cmp.Compare(a.CreatedUnix, b.CreatedUnix) })
+	return out
+}
+
+// RemoveExpiredTags removes the keys of a resource still expired at now (a
+// concurrent update may have set them again) and records it in the history
+// with detail. It returns the keys removed.
+func (s *MemoryStore) RemoveExpiredTags(ctx context.Context, id string, keys []string, now int64, detail string) ([]string, error) {
+	defer observe(ctx, "remove_expired_tags")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.resources[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	v.Tags, v.TagExpiry = maps.Clone(v.Tags), maps.Clone(v.TagExpiry)
+
+	var removed []string
+	for _, k := range keys {
+		if at, ok := v.TagExpiry[k]; !ok || at > now {
+			continue
+		}
+		delete(v.TagExpiry, k)
+		if _, ok := v.Tags[k]; ok {
+			delete(v.Tags, k)
+			removed = append(removed, k)
+		}
+	}
+	s.resources[id] = v
+	if len(removed) > 0 {
+		s.addHistory(id, models.HistoryEntry{Unix: now, Action: models.HistoryTagsExpired, Removed: removed, Detail: detail})
+		s.publish(models.EventTagsUpdated, v)
+	}
+	return removed, nil
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+	"slices"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+// maxHistory is how many entries a resource keeps, oldest dropped first.
+const maxHistory = 200
+
+// History returns the tag changes of a resource, oldest first.
+func (s *MemoryStore) History(ctx context.Context, id string) ([]models.HistoryEntry, error) {
+	defer observe(ctx, "history")()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.resources[id]; !ok {
+		return nil, ErrNotFound
+	}
+	return slices.Clone(s.history[id]), nil
+}
+
+// addHistory appends to the history of a resource. Caller holds mu.
+func (s *MemoryStore) addHistory(id string, e models.HistoryEntry) {
+	h := append(s.history[id], e)
+	if len(h) > maxHistory {
+		h = slices.Delete(h, 0, len(h)-maxHistory)
+	}
+	s.history[id] = h
+}
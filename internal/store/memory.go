@@ -1,45 +1,152 @@
 package store
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/events"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/metrics"
 	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
 )
 
-var ErrNotFound = errors.New("not found !")
+var (
+	ErrNotFound      = errors.New("not found !")
+	ErrAlreadyExists = errors.New("already exists")
+	ErrInUse         = errors.New("still referenced by resources")
+	ErrUnknownTagSet = errors.New("unknown tag set")
+)
+
+var tracer = otel.Tracer("github.com/ThiagoScheffer/azure-tagger-api/internal/store")
 
 type MemoryStore struct {
 	mu        sync.RWMutex
 	resources map[string]models.Resource
+	byAzureID map[string][]string      // lower Azure ID -> resource IDs, oldest first
+	tagSets   map[string]models.TagSet // by name
+	history   map[string][]models.HistoryEntry
+	events    *events.Bus
 }
 
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
 		resources: make(map[string]models.Resource), // init the map and return the struct!
+		byAzureID: make(map[string][]string),
+		tagSets:   make(map[string]models.TagSet),
+		history:   make(map[string][]models.HistoryEntry),
+		events:    events.NewBus(),
 	}
 }
 
+// Events is the bus every change to a resource is published on.
+func (s *MemoryStore) Events() *events.Bus {
+	return s.events
+}
+
+// publish sends a change of r. Caller holds mu, so events keep the order of
+// the changes (subscribers don't block).
+func (s *MemoryStore) publish(typ string, r models.Resource) {
+	s.events.Publish(models.Event{Type: typ, ResourceID: r.ID, AzureID: r.AzureID, Tags: maps.Clone(r.Tags), Data: r})
+}
+
+// observe starts the span and the latency timer of a store operation.
+// Usage: defer observe(ctx, "get")()
+func observe(ctx context.Context, op string) func() {
+	_, span := tracer.Start(ctx, "store."+op)
+	start := time.Now()
+	return func() {
+		metrics.ObserveStore(op, start)
+		span.End()
+	}
+}
+
+// Ping is the readiness check of the store. The memory store is always
+// reachable, a database backed one would ping its connection here.
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	defer observe(ctx, "ping")()
+	return ctx.Err()
+}
+
 // Create a new resource in the store and return it !!
-func (s *MemoryStore) Create(name, azureID string, tags map[string]string) models.Resource {
+func (s *MemoryStore) Create(ctx context.Context, name, azureID, scope string, tags map[string]string) models.Resource {
+	defer observe(ctx, "create")()
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.create(NewResource{Name: name, AzureID: azureID, Scope: scope, Tags: tags})
+}
+
+// NewResource is a resource given to CreateWith or CreateIfAbsent.
+type NewResource struct {
+	Name       string
+	AzureID    string
+	Scope      string
+	Tags       map[string]string
+	TagSets    []string         // must be registered
+	TagExpiry  map[string]int64 // see models.Resource
+	NeedsApply bool             // Azure doesn't have Tags yet
+}
+
+// CreateWith is Create with the tag sets and tag expiry of the resource,
+// written in one step: a single resource.created event. With an unknown tag set it returns
+// ErrUnknownTagSet and creates nothing.
+func (s *MemoryStore) CreateWith(ctx context.Context, n NewResource) (models.Resource, error) {
+	defer observe(ctx, "create")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range n.TagSets {
+		if _, ok := s.tagSets[name]; !ok {
+			return models.Resource{}, fmt.Errorf("%w: %q", ErrUnknownTagSet, name)
+		}
+	}
+	return s.create(n), nil
+}
 
+// CreateIfAbsent creates n unless its Azure ID is registered already, then
+// it returns that one and false. The lookup and the create are one step, two
+// calls can't register the same ID.
+func (s *MemoryStore) CreateIfAbsent(ctx context.Context, n NewResource) (models.Resource, bool) {
+	defer observe(ctx, "create")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, err := s.findByAzureID(n.AzureID); err == nil {
+		return v, false
+	}
+	return s.create(n), true
+}
+
+// create is CreateWith without the checks, the caller holds mu.
+func (s *MemoryStore) create(n NewResource) models.Resource {
 	id := uuid.NewString()
 	r := models.Resource{
 		ID:          id,
-		Name:        name,
-		AzureID:     azureID,
-		Tags:        tags,
+		Name:        n.Name,
+		AzureID:     n.AzureID,
+		Scope:       n.Scope,
+		Tags:        n.Tags,
 		CreatedUnix: time.Now().Unix(),
+		TagSets:     slices.Clone(n.TagSets),
+		NeedsApply:  n.NeedsApply || len(n.TagSets) > 0, // Azure has none of the tag set tags yet
+	}
+	if len(n.TagExpiry) > 0 {
+		r.TagExpiry = maps.Clone(n.TagExpiry)
 	}
 	s.resources[id] = r
+	key := strings.ToLower(r.AzureID)
+	s.byAzureID[key] = append(s.byAzureID[key], id)
+	metrics.SetResourceCount(len(s.resources))
+	s.publish(models.EventResourceCreated, r)
 	return r
 }
 
-func (s *MemoryStore) List() []models.Resource {
+func (s *MemoryStore) List(ctx context.Context) []models.Resource {
+	defer observe(ctx, "list")()
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -50,7 +157,8 @@ func (s *MemoryStore) List() []models.Resource {
 	return out
 }
 
-func (s *MemoryStore) Get(id string) (models.Resource, error) {
+func (s *MemoryStore) Get(ctx context.Context, id string) (models.Resource, error) {
+	defer observe(ctx, "get")()
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -61,13 +169,156 @@ func (s *MemoryStore) Get(id string) (models.Resource, error) {
 	return v, nil
 }
 
-func (s *MemoryStore) Delete(id string) error {
+// FindByAzureID returns the entry of an Azure ID (case-insensitive). When the
+// same ID was registered twice the oldest entry wins.
+func (s *MemoryStore) FindByAzureID(ctx context.Context, azureID string) (models.Resource, error) {
+	defer observe(ctx, "find_by_azure_id")()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findByAzureID(azureID)
+}
+
+// findByAzureID is FindByAzureID, the caller holds mu.
+func (s *MemoryStore) findByAzureID(azureID string) (models.Resource, error) {
+	ids := s.byAzureID[strings.ToLower(azureID)]
+	if len(ids) == 0 {
+		return models.Resource{}, ErrNotFound
+	}
+	return s.resources[ids[0]], nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	defer observe(ctx, "delete")()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, ok := s.resources[id]; !ok {
+	r, ok := s.resources[id]
+	if !ok {
 		return ErrNotFound
 	}
 	delete(s.resources, id)
+	key := strings.ToLower(r.AzureID)
+	s.byAzureID[key] = slices.DeleteFunc(s.byAzureID[key], func(v string) bool { return v == id })
+	if len(s.byAzureID[key]) == 0 {
+		delete(s.byAzureID, key)
+	}
+	delete(s.history, id)
+	metrics.SetResourceCount(len(s.resources))
+	s.publish(models.EventResourceDeleted, r)
+	return nil
+}
+
+// RecordApply saves the outcome of the last apply-tags call on the resource.
+// A success clears NeedsApply only when synced, i.e. Azure got the stored
+// effective tags and not overrides of them.
+func (s *MemoryStore) RecordApply(ctx context.Context, id string, result models.ApplyResult, synced bool) error {
+	defer observe(ctx, "record_apply")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.resources[id]
+	if !ok {
+		return ErrNotFound
+	}
+	v.LastApply = &result
+	if result.Status == models.ApplySucceeded && synced {
+		v.NeedsApply = false
+	}
+	s.resources[id] = v
+	s.publish(models.EventResourceUpdated, v)
 	return nil
 }
+
+// MarkNeedsApply flags a resource whose desired tags differ from Azure's.
+func (s *MemoryStore) MarkNeedsApply(ctx context.Context, id string) error {
+	defer observe(ctx, "mark_needs_apply")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.resources[id]
+	if !ok {
+		return ErrNotFound
+	}
+	v.NeedsApply = true
+	s.resources[id] = v
+	s.publish(models.EventResourceUpdated, v)
+	return nil
+}
+
+// MergeTags sets tags on a resource, replacing any other spelling of their
+// keys. Their expiry becomes the one in expiry (unix), none when missing.
+// The change is recorded in the history and the resource needs an apply.
+func (s *MemoryStore) MergeTags(ctx context.Context, id string, tags map[string]string, expiry map[string]int64, actor string) (models.Resource, error) {
+	defer observe(ctx, "merge_tags")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.resources[id]
+	if !ok {
+		return models.Resource{}, ErrNotFound
+	}
+	return s.mergeTags(v, tags, expiry, actor), nil
+}
+
+// mergeTags is MergeTags on v, the caller holds mu.
+func (s *MemoryStore) mergeTags(v models.Resource, tags map[string]string, expiry map[string]int64, actor string) models.Resource {
+	merged := maps.Clone(v.Tags)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	exp := maps.Clone(v.TagExpiry)
+	for k, val := range tags {
+		for old := range merged {
+			if strings.EqualFold(old, k) {
+				delete(merged, old)
+				delete(exp, old)
+			}
+		}
+		merged[k] = val
+		if at, ok := expiry[k]; ok {
+			if exp == nil {
+				exp = map[string]int64{}
+			}
+			exp[k] = at
+		}
+	}
+	v.Tags, v.TagExpiry, v.NeedsApply = merged, exp, true
+	s.resources[v.ID] = v
+	s.addHistory(v.ID, models.HistoryEntry{Unix: time.Now().Unix(), Action: models.HistoryTagsUpdated, Actor: actor, Tags: tags})
+	s.publish(models.EventTagsUpdated, v)
+	return v
+}
+
+// UnsetTags removes keys (ignoring case) from the tags of a resource, with
+// their expiry. When some were there the change is recorded in the history
+// and the resource needs an apply.
+func (s *MemoryStore) UnsetTags(ctx context.Context, id string, keys []string, actor string) (models.Resource, error) {
+	defer observe(ctx, "unset_tags")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.resources[id]
+	if !ok {
+		return models.Resource{}, ErrNotFound
+	}
+	v.Tags, v.TagExpiry = maps.Clone(v.Tags), maps.Clone(v.TagExpiry)
+
+	var removed []string
+	for _, k := range keys {
+		for old := range v.Tags {
+			if strings.EqualFold(old, k) {
+				delete(v.Tags, old)
+				delete(v.TagExpiry, old)
+				removed = append(removed, old)
+			}
+		}
+	}
+	if len(removed) == 0 {
+		return s.resources[id], nil
+	}
+	v.NeedsApply = true
+	s.resources[id] = v
+	s.addHistory(id, models.HistoryEntry{Unix: time.Now().Unix(), Action: models.HistoryTagsRemoved, Actor: actor, Removed: removed})
+	s.publish(models.EventTagsUpdated, v)
+	return v, nil
+}
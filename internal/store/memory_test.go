@@ -1,11 +1,21 @@
 package store
 
-import "testing"
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
 
 func TestMemoryStore_CRUD(t *testing.T) {
 	st := NewMemoryStore()
+	ctx := context.Background()
 
-	created := st.Create("vm-1", "/subscriptions/x/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1", map[string]string{
+	created := st.Create(ctx, "vm-1", "/subscriptions/x/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1", models.ScopeResource, map[string]string{
 		"env": "dev",
 	})
 
@@ -13,7 +23,7 @@ func TestMemoryStore_CRUD(t *testing.T) {
 		t.Fatal("expected created resource to have an ID")
 	}
 
-	got, err := st.Get(created.ID)
+	got, err := st.Get(ctx, created.ID)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -21,16 +31,16 @@ func TestMemoryStore_CRUD(t *testing.T) {
 		t.Fatalf("expected same ID, got %q", got.ID)
 	}
 
-	all := st.List()
+	all := st.List(ctx)
 	if len(all) != 1 {
 		t.Fatalf("expected 1 resource, got %d", len(all))
 	}
 
-	if err := st.Delete(created.ID); err != nil {
+	if err := st.Delete(ctx, created.ID); err != nil {
 		t.Fatalf("expected delete ok, got %v", err)
 	}
 
-	_, err = st.Get(created.ID)
+	_, err = st.Get(ctx, created.ID)
 	if err != ErrNotFound {
 		t.Fatalf("expected ErrNotFound after delete, got %v", err)
 	}
@@ -38,6 +48,7 @@ func TestMemoryStore_CRUD(t *testing.T) {
 
 func TestMemoryStore_Errors_TableDriven(t *testing.T) {
 	st := NewMemoryStore()
+	ctx := context.Background()
 
 	tests := []struct {
 		name string
@@ -47,7 +58,7 @@ func TestMemoryStore_Errors_TableDriven(t *testing.T) {
 		{
 			name: "Get missing -> ErrNotFound",
 			run: func() error {
-				_, err := st.Get("missing")
+				_, err := st.Get(ctx, "missing")
 				return err
 			},
 			want: ErrNotFound,
@@ -55,7 +66,7 @@ func TestMemoryStore_Errors_TableDriven(t *testing.T) {
 		{
 			name: "Delete missing -> ErrNotFound",
 			run: func() error {
-				return st.Delete("missing")
+				return st.Delete(ctx, "missing")
 			},
 			want: ErrNotFound,
 		},
@@ -70,3 +81,158 @@ func TestMemoryStore_Errors_TableDriven(t *testing.T) {
 		})
 	}
 }
+
+func TestMemoryStore_PublishesChanges(t *testing.T) {
+	st := NewMemoryStore()
+	ctx := context.Background()
+	var got []models.Event
+	st.Events().Subscribe(func(e models.Event) { got = append(got, e) })
+
+	r := st.Create(ctx, "vm-1", "/subscriptions/x/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1", models.ScopeResource, map[string]string{"env": "dev"})
+	st.MergeTags(ctx, r.ID, map[string]string{"env": "prod"}, nil, "me")
+	st.MarkNeedsApply(ctx, r.ID)
+	st.Delete(ctx, r.ID)
+	st.MarkNeedsApply(ctx, r.ID) // not found, nothing published
+
+	want := []string{models.EventResourceCreated, models.EventTagsUpdated, models.EventResourceUpdated, models.EventResourceDeleted}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), got)
+	}
+	for i, e := range got {
+		if e.Type != want[i] || e.ResourceID != r.ID {
+			t.Fatalf("expected %s of %s, got %+v", want[i], r.ID, e)
+		}
+	}
+	if got[1].Tags["env"] != "prod" {
+		t.Fatalf("expected the tags after the change, got %v", got[1].Tags)
+	}
+}
+
+func TestMemoryStore_Upsert(t *testing.T) {
+	st := NewMemoryStore()
+	ctx := context.Background()
+	const vm = "/subscriptions/x/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1"
+	existing := st.Create(ctx, "vm-1", vm, models.ScopeResource, map[string]string{"Env": "dev", "owner": "me"})
+
+	got := st.Upsert(ctx, []UpsertItem{
+		{Name: "vm-1", AzureID: strings.ToUpper(vm), Scope: models.ScopeResource, Tags: map[string]string{"env": "prod"}, NewTags: map[string]string{"unused": "x"}},
+		{Name: "rg", AzureID: "/subscriptions/x/resourceGroups/rg", Scope: models.ScopeResourceGroup, NewTags: map[string]string{"costCenter": "cc-1"}},
+	}, "importer")
+
+	if got[0].Created || got[0].Resource.ID != existing.ID || !maps.Equal(got[0].Resource.Tags, map[string]string{"env": "prod", "owner": "me"}) {
+		t.Fatalf("expected the tags merged into the registered vm, got %+v", got[0])
+	}
+	if !got[1].Created || got[1].Resource.Tags["costCenter"] != "cc-1" {
+		t.Fatalf("expected the rg created with its new tags, got %+v", got[1])
+	}
+	if h, _ := st.History(ctx, existing.ID); len(h) != 1 || h[0].Actor != "importer" {
+		t.Fatalf("expected the merge in the history, got %+v", h)
+	}
+}
+
+func TestMemoryStore_CreateIfAbsent(t *testing.T) {
+	st := NewMemoryStore()
+	const id = "/subscriptions/x/resourceGroups/rg"
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for range 10 {
+		wg.Go(func() {
+			if _, ok := st.CreateIfAbsent(t.Context(), NewResource{Name: "rg", AzureID: id, Scope: models.ScopeResourceGroup, NeedsApply: true}); ok {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	if all := st.List(t.Context()); created != 1 || len(all) != 1 || !all[0].NeedsApply {
+		t.Fatalf("expected one resource marked needs_apply, got %d created: %+v", created, all)
+	}
+	if got, ok := st.CreateIfAbsent(t.Context(), NewResource{Name: "other", AzureID: strings.ToUpper(id)}); ok || got.Name != "rg" {
+		t.Fatalf("expected the registered one back, got %+v", got)
+	}
+}
+
+func TestMemoryStore_FindByAzureID(t *testing.T) {
+	st := NewMemoryStore()
+	const id = "/subscriptions/x/resourceGroups/rg"
+
+	first := st.Create(t.Context(), "first", id, models.ScopeResourceGroup, nil)
+	second := st.Create(t.Context(), "second", strings.ToUpper(id), models.ScopeResourceGroup, nil)
+	if got, err := st.FindByAzureID(t.Context(), strings.ToUpper(id)); err != nil || got.ID != first.ID {
+		t.Fatalf("expected the oldest entry, got %+v, %v", got, err)
+	}
+	if err := st.Delete(t.Context(), first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := st.FindByAzureID(t.Context(), id); err != nil || got.ID != second.ID {
+		t.Fatalf("expected the next entry after delete, got %+v, %v", got, err)
+	}
+	if err := st.Delete(t.Context(), second.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.FindByAzureID(t.Context(), id); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemoryStore_RewriteTags(t *testing.T) {
+	st := NewMemoryStore()
+	ctx := context.Background()
+	r := st.Create(ctx, "vm-1", "/subscriptions/x/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1", models.ScopeResource, map[string]string{"Env": "Prod", "owner": "me"})
+	rename := func(tags map[string]string, expiry map[string]int64) (map[string]string, map[string]int64) {
+		out := maps.Clone(tags)
+		if v, ok := out["Env"]; ok {
+			delete(out, "Env")
+			out["env"] = strings.ToLower(v)
+		}
+		return out, expiry
+	}
+
+	before, after, err := st.RewriteTags(ctx, r.ID, rename, "me", "job 1")
+	if err != nil || before.Tags["Env"] != "Prod" || !after.NeedsApply || !maps.Equal(after.Tags, map[string]string{"env": "prod", "owner": "me"}) {
+		t.Fatalf("expected Env renamed, got %+v -> %+v (%v)", before, after, err)
+	}
+	h, _ := st.History(ctx, r.ID)
+	if len(h) != 1 || h[0].Action != models.HistoryTagsRenamed || h[0].Tags["env"] != "prod" || len(h[0].Removed) != 1 || h[0].Detail != "job 1" {
+		t.Fatalf("expected the rename in the history, got %+v", h)
+	}
+	// nothing left to rename, nothing recorded
+	if before, after, _ := st.RewriteTags(ctx, r.ID, rename, "me", "job 2"); !maps.Equal(before.Tags, after.Tags) {
+		t.Fatalf("expected no change, got %v", after.Tags)
+	}
+	if h, _ := st.History(ctx, r.ID); len(h) != 1 {
+		t.Fatalf("expected no new history entry, got %+v", h)
+	}
+	if _, _, err := st.RewriteTags(ctx, "nope", rename, "me", ""); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemoryStore_UnsetTags(t *testing.T) {
+	st := NewMemoryStore()
+	ctx := context.Background()
+	r := st.Create(ctx, "vm-1", "/subscriptions/x/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1", models.ScopeResource, map[string]string{"Env": "prod", "owner": "me"})
+	st.SetTagExpiry(ctx, r.ID, map[string]int64{"Env": 1})
+
+	got, err := st.UnsetTags(ctx, r.ID, []string{"env", "missing"}, "me")
+	if err != nil || !got.NeedsApply || !maps.Equal(got.Tags, map[string]string{"owner": "me"}) || len(got.TagExpiry) != 0 {
+		t.Fatalf("expected Env removed with its expiry, got %+v (%v)", got, err)
+	}
+	h, _ := st.History(ctx, r.ID)
+	if len(h) != 1 || h[0].Action != models.HistoryTagsRemoved || h[0].Actor != "me" || !slices.Equal(h[0].Removed, []string{"Env"}) {
+		t.Fatalf("expected the removal in the history, got %+v", h)
+	}
+	// nothing to remove, nothing recorded
+	if _, err := st.UnsetTags(ctx, r.ID, []string{"env"}, "me"); err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := st.History(ctx, r.ID); len(h) != 1 {
+		t.Fatalf("expected no new history entry, got %+v", h)
+	}
+	if _, err := st.UnsetTags(ctx, "nope", []string{"env"}, "me"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
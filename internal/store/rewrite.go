@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+// RewriteFunc returns the new tags and tag expiry of a resource, it must not
+// modify its arguments.
+type RewriteFunc func(tags map[string]string, expiry map[string]int64) (map[string]string, map[string]int64)
+
+// RewriteTags replaces the tags of a resource with what rewrite makes of
+// them. When they changed, the resource needs an apply and the change is
+// recorded in the history with detail. It returns the resource before and
+// after, equal when nothing changed.
+func (s *MemoryStore) RewriteTags(ctx context.Context, id string, rewrite RewriteFunc, actor, detail string) (models.Resource, models.Resource, error) {
+	defer observe(ctx, "rewrite_tags")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before, ok := s.resources[id]
+	if !ok {
+		return models.Resource{}, models.Resource{}, ErrNotFound
+	}
+	v := before
+	v.Tags, v.TagExpiry = rewrite(before.Tags, before.TagExpiry)
+	if maps.Equal(v.Tags, before.Tags) {
+		return before, before, nil
+	}
+
+	e := models.HistoryEntry{Unix: time.Now().Unix(), Action: models.HistoryTagsRenamed, Actor: actor, Tags: map[string]string{}, Detail: detail}
+	for k, val := range v.Tags {
+		if old, ok := before.Tags[k]; !ok || old != val {
+			e.Tags[k] = val
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(before.Tags)) {
+		if _, ok := v.Tags[k]; !ok {
+			e.Removed = append(e.Removed, k)
+		}
+	}
+	v.NeedsApply = true
+	s.resources[id] = v
+	s.addHistory(id, e)
+	s.publish(models.EventTagsUpdated, v)
+	return before, v, nil
+}
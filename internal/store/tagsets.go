@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+// CreateTagSet stores a new tag set at version 1.
+func (s *MemoryStore) CreateTagSet(ctx context.Context, name, description string, tags map[string]string) (models.TagSet, error) {
+	defer observe(ctx, "create_tag_set")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tagSets[name]; ok {
+		return models.TagSet{}, fmt.Errorf("tag set %q: %w", name, ErrAlreadyExists)
+	}
+	now := time.Now().Unix()
+	ts := models.TagSet{Name: name, Description: description, Tags: tags, Version: 1, CreatedUnix: now, UpdatedUnix: now}
+	s.tagSets[name] = ts
+	return ts, nil
+}
+
+// ListTagSets returns every tag set sorted by name.
+func (s *MemoryStore) ListTagSets(ctx context.Context) []models.TagSet {
+	defer observe(ctx, "list_tag_sets")()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]models.TagSet, 0, len(s.tagSets))
+	for _, ts := range s.tagSets {
+		out = append(out, ts)
+	}
+	slices.SortFunc(out, func(a, b models.TagSet) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+func (s *MemoryStore) GetTagSet(ctx context.Context, name string) (models.TagSet, error) {
+	defer observe(ctx, "get_tag_set")()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ts, ok := s.tagSets[name]
+	if !ok {
+		return models.TagSet{}, ErrNotFound
+	}
+	return ts, nil
+}
+
+// UpdateTagSet replaces the tags of a tag set, bumps its version and marks
+// every resource referencing it as needing an apply. It returns the IDs of
+// those resources.
+func (s *MemoryStore) UpdateTagSet(ctx context.Context, name, description string, tags map[string]string) (models.TagSet, []string, error) {
+	defer observe(ctx, "update_tag_set")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts, ok := s.tagSets[name]
+	if !ok {
+		return models.TagSet{}, nil, ErrNotFound
+	}
+	ts.Description, ts.Tags = description, tags
+	ts.Version++
+	ts.UpdatedUnix = time.Now().Unix()
+	s.tagSets[name] = ts
+
+	var marked []string
+	for id, r := range s.resources {
+		if slices.Contains(r.TagSets, name) {
+			r.NeedsApply = true
+			s.resources[id] = r
+			marked = append(marked, id)
+			s.publish(models.EventResourceUpdated, r)
+		}
+	}
+	slices.Sort(marked)
+	return ts, marked, nil
+}
+
+// DeleteTagSet removes a tag set no resource references anymore.
+func (s *MemoryStore) DeleteTagSet(ctx context.Context, name string) error {
+	defer observe(ctx, "delete_tag_set")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tagSets[name]; !ok {
+		return ErrNotFound
+	}
+	for _, r := range s.resources {
+		if slices.Contains(r.TagSets, name) {
+			return fmt.Errorf("tag set %q: %w (e.g. %s)", name, ErrInUse, r.ID)
+		}
+	}
+	delete(s.tagSets, name)
+	return nil
+}
+
+// SetResourceTagSets replaces the tag sets a resource references (merge
+// order) and marks it as needing an apply. Every tag set must exist.
+func (s *MemoryStore) SetResourceTagSets(ctx context.Context, id string, names []string) (models.Resource, error) {
+	defer observe(ctx, "set_resource_tag_sets")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.resources[id]
+	if !ok {
+		return models.Resource{}, ErrNotFound
+	}
+	for _, name := range names {
+		if _, ok := s.tagSets[name]; !ok {
+			return models.Resource{}, fmt.Errorf("%w: %q", ErrUnknownTagSet, name)
+		}
+	}
+	r.TagSets = slices.Clone(names)
+	r.NeedsApply = true
+	s.resources[id] = r
+	s.publish(models.EventTagsUpdated, r)
+	return r, nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+func TestMemoryStore_TagSets(t *testing.T) {
+	st := NewMemoryStore()
+	ctx := context.Background()
+
+	if _, err := st.CreateTagSet(ctx, "prod-baseline", "", map[string]string{"env": "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.CreateTagSet(ctx, "prod-baseline", "", nil); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("expected ErrAlreadyExists, got %v", err)
+	}
+
+	vm := st.Create(ctx, "vm-1", "/subscriptions/x/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1", models.ScopeResource, nil)
+	other := st.Create(ctx, "vm-2", "/subscriptions/x/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-2", models.ScopeResource, nil)
+	if _, err := st.SetResourceTagSets(ctx, vm.ID, []string{"prod-baseline", "nope"}); !errors.Is(err, ErrUnknownTagSet) {
+		t.Fatalf("expected ErrUnknownTagSet, got %v", err)
+	}
+	if _, err := st.SetResourceTagSets(ctx, vm.ID, []string{"prod-baseline"}); err != nil {
+		t.Fatal(err)
+	}
+	st.RecordApply(ctx, vm.ID, models.ApplyResult{Status: models.ApplySucceeded}, true)
+
+	ts, marked, err := st.UpdateTagSet(ctx, "prod-baseline", "baseline", map[string]string{"env": "prod", "tier": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Version != 2 || len(marked) != 1 || marked[0] != vm.ID {
+		t.Fatalf("expected version 2 marking only vm-1, got v%d %v", ts.Version, marked)
+	}
+	if got, _ := st.Get(ctx, vm.ID); !got.NeedsApply {
+		t.Fatal("expected vm-1 to need an apply")
+	}
+	if got, _ := st.Get(ctx, other.ID); got.NeedsApply {
+		t.Fatal("did not expect vm-2 to need an apply")
+	}
+
+	if err := st.DeleteTagSet(ctx, "prod-baseline"); !errors.Is(err, ErrInUse) {
+		t.Fatalf("expected ErrInUse, got %v", err)
+	}
+	st.SetResourceTagSets(ctx, vm.ID, nil)
+	if err := st.DeleteTagSet(ctx, "prod-baseline"); err != nil {
+		t.Fatalf("expected delete ok, got %v", err)
+	}
+}
+
+func TestMemoryStore_CreateWith(t *testing.T) {
+	st := NewMemoryStore()
+	ctx := context.Background()
+	var got []models.Event
+	st.Events().Subscribe(func(e models.Event) { got = append(got, e) })
+	st.CreateTagSet(ctx, "baseline", "", map[string]string{"env": "prod"})
+
+	id := "/subscriptions/x/resourceGroups/rg"
+	if _, err := st.CreateWith(ctx, NewResource{Name: "rg", AzureID: id, Scope: models.ScopeResourceGroup, TagSets: []string{"baseline", "nope"}}); !errors.Is(err, ErrUnknownTagSet) {
+		t.Fatalf("expected ErrUnknownTagSet, got %v", err)
+	}
+	if len(st.List(ctx)) != 0 || len(got) != 0 {
+		t.Fatalf("expected nothing created nor published, got %+v", got)
+	}
+
+	r, err := st.CreateWith(ctx, NewResource{Name: "rg", AzureID: id, Scope: models.ScopeResourceGroup, TagSets: []string{"baseline"}})
+	if err != nil || !slices.Equal(r.TagSets, []string{"baseline"}) || !r.NeedsApply {
+		t.Fatalf("expected the tag set set and needs_apply, got %+v (%v)", r, err)
+	}
+	if len(got) != 1 || got[0].Type != models.EventResourceCreated {
+		t.Fatalf("expected a single resource.created, got %+v", got)
+	}
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+// UpsertItem is one resource given to Upsert. NewTags are the tags it is
+// created with when its Azure ID is not registered, otherwise Tags are
+// merged into the registered ones.
+type UpsertItem struct {
+	Name    string
+	AzureID string
+	Scope   string
+	Tags    map[string]string
+	NewTags map[string]string
+}
+
+// UpsertResult is what Upsert did with an item.
+type UpsertResult struct {
+	Resource models.Resource
+	Created  bool
+}
+
+// Upsert registers the items whose Azure ID is not registered yet and merges
+// the tags of the others (see MergeTags, recorded in the history as actor).
+// It holds the lock for all of them: no other change gets in between.
+// Results are in the order of items.
+func (s *MemoryStore) Upsert(ctx context.Context, items []UpsertItem, actor string) []UpsertResult {
+	defer observe(ctx, "upsert")()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]UpsertResult, len(items))
+	for i, it := range items {
+		if v, err := s.findByAzureID(it.AzureID); err == nil {
+			if len(it.Tags) > 0 {
+				v = s.mergeTags(v, it.Tags, nil, actor)
+			}
+			out[i] = UpsertResult{Resource: v}
+			continue
+		}
+		out[i] = UpsertResult{Resource: s.create(NewResource{Name: it.Name, AzureID: it.AzureID, Scope: it.Scope, Tags: it.NewTags}), Created: true}
+	}
+	return out
+}
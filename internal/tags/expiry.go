@@ -0,0 +1,34 @@
+package tags
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidExpiry = errors.New("invalid tag expiry")
+
+// Expiry turns the expiry of each key, an RFC 3339 timestamp
+// ("2026-11-01T00:00:00Z") or a TTL from now ("72h"), into unix seconds.
+// Every key must be in tags and expire in the future.
+func Expiry(expires map[string]string, tags map[string]string, now time.Time) (map[string]int64, error) {
+	out := make(map[string]int64, len(expires))
+	for k, v := range expires {
+		if _, ok := tags[k]; !ok {
+			return nil, fmt.Errorf("%w: %q is not one of the tags set", ErrInvalidExpiry, k)
+		}
+		at, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			ttl, derr := time.ParseDuration(v)
+			if derr != nil {
+				return nil, fmt.Errorf("%w: tag %q: %q is neither an RFC 3339 time nor a duration", ErrInvalidExpiry, k, v)
+			}
+			at = now.Add(ttl)
+		}
+		if !at.After(now) {
+			return nil, fmt.Errorf("%w: tag %q: %s is not in the future", ErrInvalidExpiry, k, v)
+		}
+		out[k] = at.Unix()
+	}
+	return out, nil
+}
@@ -0,0 +1,45 @@
+package tags
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExpiry_TableDriven(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	tags := map[string]string{"freezeUntil": "yes", "incident": "INC123"}
+
+	tests := []struct {
+		name    string
+		expires map[string]string
+		want    int64
+		wantErr bool
+	}{
+		{"timestamp", map[string]string{"freezeUntil": "2026-11-01T00:00:00Z"}, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC).Unix(), false},
+		{"ttl", map[string]string{"incident": "72h"}, now.Add(72 * time.Hour).Unix(), false},
+		{"past", map[string]string{"incident": "2026-01-01T00:00:00Z"}, 0, true},
+		{"negative ttl", map[string]string{"incident": "-1h"}, 0, true},
+		{"garbage", map[string]string{"incident": "tomorrow"}, 0, true},
+		{"unknown key", map[string]string{"other": "1h"}, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Expiry(tc.expires, tags, now)
+			if tc.wantErr {
+				if !errors.Is(err, ErrInvalidExpiry) {
+					t.Fatalf("expected ErrInvalidExpiry, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, at := range got {
+				if at != tc.want {
+					t.Fatalf("expected %d, got %d", tc.want, at)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Package tags builds the desired tags of a resource: what apply-tags pushes
+// to Azure and GET /resources/{id} shows.
+package tags
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+// Effective builds the desired tags of a resource, lowest precedence first:
+// its tag sets in order, its explicit tags, then the inherited keys of its
+// resource group (parent, nil when not registered) which only win over the
+// resource's own tags with the parent precedence. Azure tag keys are
+// case-insensitive, so "CostCenter" and "costCenter" are the same tag: the
+// winner keeps its own spelling.
+func Effective(sets []models.TagSet, explicit map[string]string, parent *models.Resource, cfg config.Tags) map[string]models.EffectiveTag {
+	eff := make(map[string]models.EffectiveTag, len(explicit)+len(cfg.InheritedKeys))
+	for _, ts := range sets {
+		for k, v := range ts.Tags {
+			set(eff, k, models.EffectiveTag{Value: v, Source: models.TagFromSet, TagSet: fmt.Sprintf("%s@%d", ts.Name, ts.Version)})
+		}
+	}
+	for k, v := range explicit {
+		set(eff, k, models.EffectiveTag{Value: v, Source: models.TagExplicit})
+	}
+	if parent == nil {
+		return eff
+	}
+
+	for _, key := range cfg.InheritedKeys {
+		pk, pv, ok := lookup(parent.Tags, key)
+		if !ok {
+			continue
+		}
+		if _, ok := lookupEffective(eff, key); ok && cfg.InheritPrecedence != config.PrecedenceParent {
+			continue // the resource overrides its resource group
+		}
+		set(eff, pk, models.EffectiveTag{Value: pv, Source: models.TagInherited, InheritedFrom: parent.AzureID})
+	}
+	return eff
+}
+
+// set puts key in eff, replacing any spelling of it.
+func set(eff map[string]models.EffectiveTag, key string, t models.EffectiveTag) {
+	if old, ok := lookupEffective(eff, key); ok {
+		delete(eff, old)
+	}
+	eff[key] = t
+}
+
+func lookupEffective(eff map[string]models.EffectiveTag, key string) (string, bool) {
+	if _, ok := eff[key]; ok {
+		return key, true
+	}
+	for k := range eff {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// Values drops the provenance, leaving the tags to send to Azure.
+func Values(eff map[string]models.EffectiveTag) map[string]string {
+	out := make(map[string]string, len(eff))
+	for k, t := range eff {
+		out[k] = t.Value
+	}
+	return out
+}
+
+// Get returns the value of key in tags, ignoring case.
+func Get(tags map[string]string, key string) (string, bool) {
+	_, v, ok := lookup(tags, key)
+	return v, ok
+}
+
+// lookup finds key in tags ignoring case and returns it as spelled there.
+func lookup(tags map[string]string, key string) (string, string, bool) {
+	if v, ok := tags[key]; ok {
+		return key, v, true
+	}
+	for k, v := range tags {
+		if strings.EqualFold(k, key) {
+			return k, v, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,75 @@
+package tags
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+func TestEffective_TableDriven(t *testing.T) {
+	rg := &models.Resource{
+		AzureID: "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app",
+		Tags:    map[string]string{"costCenter": "42", "env": "prod", "owner": "platform"},
+	}
+	keys := []string{"costCenter", "env"}
+
+	tests := []struct {
+		name       string
+		explicit   map[string]string
+		parent     *models.Resource
+		precedence string
+		want       map[string]string
+		inherited  []string
+	}{
+		{"no parent", map[string]string{"app": "web"}, nil, config.PrecedenceChild,
+			map[string]string{"app": "web"}, nil},
+		{"inherits only the configured keys", map[string]string{"app": "web"}, rg, config.PrecedenceChild,
+			map[string]string{"app": "web", "costCenter": "42", "env": "prod"}, []string{"costCenter", "env"}},
+		{"child overrides", map[string]string{"ENV": "dev"}, rg, config.PrecedenceChild,
+			map[string]string{"ENV": "dev", "costCenter": "42"}, []string{"costCenter"}},
+		{"parent enforced", map[string]string{"ENV": "dev"}, rg, config.PrecedenceParent,
+			map[string]string{"env": "prod", "costCenter": "42"}, []string{"costCenter", "env"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			eff := Effective(nil, tc.explicit, tc.parent, config.Tags{InheritedKeys: keys, InheritPrecedence: tc.precedence})
+			if got := Values(eff); !maps.Equal(got, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+			for k, tag := range eff {
+				wantInherited := false
+				for _, ik := range tc.inherited {
+					wantInherited = wantInherited || ik == k
+				}
+				if wantInherited != (tag.Source == models.TagInherited) {
+					t.Fatalf("tag %s: unexpected source %q", k, tag.Source)
+				}
+				if wantInherited && tag.InheritedFrom != rg.AzureID {
+					t.Fatalf("tag %s: expected provenance %s, got %q", k, rg.AzureID, tag.InheritedFrom)
+				}
+			}
+		})
+	}
+}
+
+func TestEffective_TagSets(t *testing.T) {
+	sets := []models.TagSet{
+		{Name: "baseline", Version: 3, Tags: map[string]string{"env": "prod", "owner": "platform", "backup": "daily"}},
+		{Name: "shop", Version: 1, Tags: map[string]string{"Owner": "shop-team"}},
+	}
+	rg := &models.Resource{AzureID: "/subscriptions/x/resourceGroups/rg", Tags: map[string]string{"env": "dev"}}
+
+	eff := Effective(sets, map[string]string{"backup": "none"}, rg, config.Tags{InheritedKeys: []string{"env"}, InheritPrecedence: config.PrecedenceChild})
+
+	want := map[string]models.EffectiveTag{
+		"env":    {Value: "prod", Source: models.TagFromSet, TagSet: "baseline@3"},  // a tag set counts as the resource's own
+		"Owner":  {Value: "shop-team", Source: models.TagFromSet, TagSet: "shop@1"}, // later set wins, any spelling
+		"backup": {Value: "none", Source: models.TagExplicit},                       // explicit wins over sets
+	}
+	if !maps.Equal(eff, want) {
+		t.Fatalf("expected %v, got %v", want, eff)
+	}
+}
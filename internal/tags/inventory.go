@@ -0,0 +1,120 @@
+package tags
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+// maxClusterValues is how many values a key can have and still get value
+// suggestions, past that it is free text (owners, ticket IDs...).
+const maxClusterValues = 500
+
+// Inventory counts the keys and values of the registered tags and of the
+// Azure ones (nil when not looked at), and suggests canonical spellings for
+// the keys, and the values of a key, that are Similar.
+func Inventory(registered, inAzure []map[string]string, maxDistance int) models.TagInventory {
+	inv := models.TagInventory{
+		Resources:      len(registered),
+		AzureResources: len(inAzure),
+		Keys:           []models.KeyUsage{},
+		Suggestions:    []models.TagSuggestion{},
+	}
+	usage := map[string]*models.KeyUsage{}
+	values := map[string]map[string]*models.ValueUsage{}
+	count := func(all []map[string]string, azure bool) {
+		for _, tags := range all {
+			for k, v := range tags {
+				ku, ok := usage[k]
+				if !ok {
+					ku = &models.KeyUsage{Key: k}
+					usage[k], values[k] = ku, map[string]*models.ValueUsage{}
+				}
+				vu, ok := values[k][v]
+				if !ok {
+					vu = &models.ValueUsage{Value: v}
+					values[k][v] = vu
+				}
+				if azure {
+					ku.Azure++
+					vu.Azure++
+				} else {
+					ku.Count++
+					vu.Count++
+				}
+			}
+		}
+	}
+	count(registered, false)
+	count(inAzure, true)
+
+	byUse := func(a, b int, x, y string) int {
+		return cmp.Or(-cmp.Compare(a, b), cmp.Compare(strings.ToLower(x), strings.ToLower(y)), cmp.Compare(x, y))
+	}
+	keyCounts := map[string]int{}
+	for k, ku := range usage {
+		for _, vu := range values[k] {
+			ku.Values = append(ku.Values, *vu)
+		}
+		slices.SortFunc(ku.Values, func(a, b models.ValueUsage) int { return byUse(a.Count+a.Azure, b.Count+b.Azure, a.Value, b.Value) })
+		inv.Keys = append(inv.Keys, *ku)
+		keyCounts[k] = ku.Count + ku.Azure
+	}
+	slices.SortFunc(inv.Keys, func(a, b models.KeyUsage) int { return byUse(a.Count+a.Azure, b.Count+b.Azure, a.Key, b.Key) })
+
+	// the values of a key and of its variants are clustered together
+	canonical := map[string]string{}
+	for _, g := range Cluster(keyCounts, maxDistance) {
+		inv.Suggestions = append(inv.Suggestions, models.TagSuggestion{Kind: models.SuggestKey, Canonical: g[0], Variants: g[1:]})
+		for _, k := range g {
+			canonical[k] = g[0]
+		}
+	}
+	valueCounts := map[string]map[string]int{}
+	for k, vs := range values {
+		ck := cmp.Or(canonical[k], k)
+		if valueCounts[ck] == nil {
+			valueCounts[ck] = map[string]int{}
+		}
+		for v, vu := range vs {
+			valueCounts[ck][v] += vu.Count + vu.Azure
+		}
+	}
+	for _, k := range slices.SortedFunc(maps.Keys(valueCounts), func(a, b string) int { return cmp.Compare(strings.ToLower(a), strings.ToLower(b)) }) {
+		if len(valueCounts[k]) > maxClusterValues {
+			continue
+		}
+		for _, g := range Cluster(valueCounts[k], maxDistance) {
+			inv.Suggestions = append(inv.Suggestions, models.TagSuggestion{Kind: models.SuggestValue, Key: k, Canonical: g[0], Variants: g[1:]})
+		}
+	}
+	inv.Mapping = Mapping(inv.Suggestions)
+	return inv
+}
+
+// Mapping turns suggestions into the mapping applying them.
+func Mapping(suggestions []models.TagSuggestion) models.TagMapping {
+	var m models.TagMapping
+	for _, s := range suggestions {
+		for _, v := range s.Variants {
+			if s.Kind == models.SuggestKey {
+				if m.Keys == nil {
+					m.Keys = map[string]string{}
+				}
+				m.Keys[v] = s.Canonical
+				continue
+			}
+			if m.Values == nil {
+				m.Values = map[string]map[string]string{}
+			}
+			if m.Values[s.Key] == nil {
+				m.Values[s.Key] = map[string]string{}
+			}
+			m.Values[s.Key][v] = s.Canonical
+		}
+	}
+	return m
+}
@@ -0,0 +1,106 @@
+package tags
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+func TestSimilar_TableDriven(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"Env", "env", true},
+		{"env", "environment", true},
+		{"Prod", "production", true},
+		{"costCenter", "cost_centre", true},
+		{"prd", "prod", true},
+		{"dev", "qa", false},
+		{"dev", "prod", false},
+		{"ab", "abc", false}, // too short to be an abbreviation
+		{"owner", "team", false},
+	}
+	for _, tc := range tests {
+		if got := Similar(tc.a, tc.b, 2); got != tc.want {
+			t.Errorf("Similar(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestCluster(t *testing.T) {
+	got := Cluster(map[string]int{"Env": 1, "env": 1, "environment": 1, "owner": 3, "Owner": 4, "team": 2}, 2)
+	want := [][]string{{"env", "Env", "environment"}, {"Owner", "owner"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	registered := []map[string]string{
+		{"Env": "Prod", "owner": "me"},
+		{"env": "prod"},
+		{"environment": "production"},
+	}
+	inAzure := []map[string]string{{"env": "prod"}}
+	inv := Inventory(registered, inAzure, 2)
+
+	if inv.Resources != 3 || inv.AzureResources != 1 {
+		t.Fatalf("bad counts: %+v", inv)
+	}
+	if k := inv.Keys[0]; k.Key != "env" || k.Count != 1 || k.Azure != 1 || k.Values[0] != (models.ValueUsage{Value: "prod", Count: 1, Azure: 1}) {
+		t.Fatalf("expected env first, got %+v", k)
+	}
+	want := []models.TagSuggestion{
+		{Kind: models.SuggestKey, Canonical: "env", Variants: []string{"Env", "environment"}},
+		{Kind: models.SuggestValue, Key: "env", Canonical: "prod", Variants: []string{"Prod", "production"}},
+	}
+	if !reflect.DeepEqual(inv.Suggestions, want) {
+		t.Fatalf("expected %+v, got %+v", want, inv.Suggestions)
+	}
+	if !maps.Equal(inv.Mapping.Keys, map[string]string{"Env": "env", "environment": "env"}) ||
+		!maps.Equal(inv.Mapping.Values["env"], map[string]string{"Prod": "prod", "production": "prod"}) {
+		t.Fatalf("bad mapping %+v", inv.Mapping)
+	}
+}
+
+func TestRewrite_TableDriven(t *testing.T) {
+	m := models.TagMapping{
+		Keys:   map[string]string{"Env": "env", "environment": "env"},
+		Values: map[string]map[string]string{"ENV": {"Prod": "prod", "production": "prod"}},
+	}
+	tests := []struct {
+		name       string
+		tags       map[string]string
+		expiry     map[string]int64
+		want       map[string]string
+		wantExpiry map[string]int64
+		conflicts  []string
+	}{
+		{"rename and remap", map[string]string{"Env": "Prod", "owner": "me"}, map[string]int64{"Env": 10},
+			map[string]string{"env": "prod", "owner": "me"}, map[string]int64{"env": 10}, nil},
+		{"merged when equal", map[string]string{"env": "prod", "environment": "production"}, map[string]int64{"env": 10},
+			map[string]string{"env": "prod"}, nil, nil},
+		{"conflict left alone", map[string]string{"Env": "dev", "environment": "production"}, nil,
+			map[string]string{"Env": "dev", "environment": "production"}, nil, []string{"env: Env=dev, environment=production"}},
+		{"nothing to do", map[string]string{"team": "a"}, nil, map[string]string{"team": "a"}, nil, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, expiry, conflicts := Rewrite(m, tc.tags, tc.expiry)
+			if !maps.Equal(got, tc.want) || !maps.Equal(expiry, tc.wantExpiry) || !slices.Equal(conflicts, tc.conflicts) {
+				t.Fatalf("expected %v %v %q, got %v %v %q", tc.want, tc.wantExpiry, tc.conflicts, got, expiry, conflicts)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	set, removed := Diff(map[string]string{"Env": "Prod", "owner": "me", "app": "a"}, map[string]string{"env": "prod", "owner": "me", "app": "b"})
+	if !maps.Equal(set, map[string]string{"env": "prod", "app": "b"}) || !slices.Equal(removed, []string{"Env"}) {
+		t.Fatalf("bad diff %v %v", set, removed)
+	}
+}
@@ -0,0 +1,43 @@
+package tags
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+// Check returns how tags break the tag policy of cfg: required keys missing
+// or empty, values not matching the allowed ones. Keys and globs ignore
+// case. source is set on the violations (models.Violation*).
+func Check(cfg config.Tags, tags map[string]string, source string) []models.Violation {
+	var out []models.Violation
+	missing := map[string]bool{}
+	for _, k := range cfg.RequiredKeys {
+		v, ok := Get(tags, k)
+		switch {
+		case !ok:
+			out = append(out, models.Violation{Key: k, Source: source, Reason: "missing required tag " + k})
+		case strings.TrimSpace(v) == "":
+			out = append(out, models.Violation{Key: k, Source: source, Reason: "required tag " + k + " is empty"})
+		default:
+			continue
+		}
+		missing[strings.ToLower(k)] = true
+	}
+	for _, k := range slices.Sorted(maps.Keys(cfg.AllowedValues)) {
+		v, ok := Get(tags, k)
+		if !ok || missing[strings.ToLower(k)] {
+			continue // not required, or already reported
+		}
+		allowed := cfg.AllowedValues[k]
+		if !slices.ContainsFunc(allowed, func(p string) bool { return p != "" && glob(p, v) }) {
+			out = append(out, models.Violation{Key: k, Source: source,
+				Reason: fmt.Sprintf("%s=%s is not an allowed value (%s)", k, v, strings.Join(allowed, ", "))})
+		}
+	}
+	return out
+}
@@ -0,0 +1,42 @@
+package tags
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+func TestCheck_TableDriven(t *testing.T) {
+	cfg := config.Tags{
+		RequiredKeys:  []string{"owner", "env"},
+		AllowedValues: map[string][]string{"env": {"prod", "dev-*"}, "tier": {"gold"}},
+	}
+	tests := []struct {
+		name string
+		tags map[string]string
+		want []string
+	}{
+		{"compliant, keys and values ignore case", map[string]string{"Owner": "me", "ENV": "Dev-1"}, nil},
+		{"missing and empty", map[string]string{"owner": " "}, []string{"required tag owner is empty", "missing required tag env"}},
+		{"value not allowed", map[string]string{"owner": "me", "env": "qa", "tier": "gold"},
+			[]string{"env=qa is not an allowed value (prod, dev-*)"}},
+		{"optional key checked when set", map[string]string{"owner": "me", "env": "prod", "Tier": "silver"},
+			[]string{"tier=silver is not an allowed value (gold)"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, v := range Check(cfg, tc.tags, models.ViolationStore) {
+				if v.Source != models.ViolationStore {
+					t.Fatalf("wrong source %q", v.Source)
+				}
+				got = append(got, v.Reason)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package tags
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+// Rewrite applies m to tags: keys renamed, then values remapped. Keys ending
+// up the same ignoring case (Env and environment both renamed to env) become
+// one when their values are equal too, otherwise they are left as they were
+// and reported in the conflicts. An expiry follows its key; merged keys only
+// keep one if they all had one (the latest). The inputs are not modified.
+func Rewrite(m models.TagMapping, tags map[string]string, expiry map[string]int64) (map[string]string, map[string]int64, []string) {
+	type entry struct{ old, key, value string }
+	groups := map[string][]entry{} // by lower new key
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		nk, ok := m.Keys[k]
+		if !ok || strings.TrimSpace(nk) == "" {
+			nk = k
+		}
+		groups[strings.ToLower(nk)] = append(groups[strings.ToLower(nk)], entry{k, nk, remap(m, nk, tags[k])})
+	}
+
+	out := make(map[string]string, len(tags))
+	var outExpiry map[string]int64
+	setExpiry := func(k string, at int64) {
+		if outExpiry == nil {
+			outExpiry = map[string]int64{}
+		}
+		outExpiry[k] = at
+	}
+	var conflicts []string
+	for _, lk := range slices.Sorted(maps.Keys(groups)) {
+		g := groups[lk]
+		if slices.ContainsFunc(g, func(e entry) bool { return e.value != g[0].value }) {
+			var vals []string
+			for _, e := range g {
+				out[e.old] = tags[e.old]
+				if at, ok := expiry[e.old]; ok {
+					setExpiry(e.old, at)
+				}
+				vals = append(vals, e.old+"="+tags[e.old])
+			}
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s", g[0].key, strings.Join(vals, ", ")))
+			continue
+		}
+		// the spelling asked for by the mapping wins over the ones kept
+		keep := g[0]
+		if i := slices.IndexFunc(g, func(e entry) bool { return e.key != e.old }); i >= 0 {
+			keep = g[i]
+		}
+		out[keep.key] = keep.value
+		var latest int64
+		for _, e := range g {
+			at, ok := expiry[e.old]
+			if !ok {
+				latest = 0
+				break
+			}
+			latest = max(latest, at)
+		}
+		if latest > 0 {
+			setExpiry(keep.key, latest)
+		}
+	}
+	return out, outExpiry, conflicts
+}
+
+// remap is the value m maps v of key to, v when none.
+func remap(m models.TagMapping, key, v string) string {
+	for k, values := range m.Values {
+		if strings.EqualFold(k, key) {
+			if nv, ok := values[v]; ok {
+				return nv
+			}
+		}
+	}
+	return v
+}
+
+// Diff is what changed from before to after: the keys added or with a new
+// value, and the keys removed (sorted).
+func Diff(before, after map[string]string) (map[string]string, []string) {
+	set := map[string]string{}
+	for k, v := range after {
+		if old, ok := before[k]; !ok || old != v {
+			set[k] = v
+		}
+	}
+	var removed []string
+	for _, k := range slices.Sorted(maps.Keys(before)) {
+		if _, ok := after[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	return set, removed
+}
@@ -0,0 +1,100 @@
+package tags
+
+import (
+	"maps"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/azure"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+// ApplyRules runs the rules in order over the tags of azureID, each one seeing
+// what the previous ones did, and returns the new tags with the rules that
+// matched. tags is not modified. An invalid ID matches no rule.
+func ApplyRules(rules []config.Rule, azureID string, tags map[string]string) (map[string]string, []models.RuleHit) {
+	out := maps.Clone(tags)
+	if out == nil {
+		out = map[string]string{}
+	}
+	id, err := arm.ParseResourceID(azureID)
+	if err != nil {
+		return out, nil
+	}
+	scope, err := azure.ScopeOf(azureID)
+	if err != nil {
+		return out, nil
+	}
+
+	var hits []models.RuleHit
+	for _, r := range rules {
+		if !matches(r.Match, id, scope, out) {
+			continue
+		}
+		hit := models.RuleHit{Rule: r.Name}
+		for k, v := range r.Set {
+			put(out, k, v)
+			if hit.Set == nil {
+				hit.Set = map[string]string{}
+			}
+			hit.Set[k] = v
+		}
+		for k, v := range r.Default {
+			if _, _, ok := lookup(out, k); ok {
+				continue
+			}
+			out[k] = v
+			if hit.Defaulted == nil {
+				hit.Defaulted = map[string]string{}
+			}
+			hit.Defaulted[k] = v
+		}
+		for _, k := range r.Remove {
+			if ek, _, ok := lookup(out, k); ok {
+				delete(out, ek)
+				hit.Removed = append(hit.Removed, ek)
+			}
+		}
+		hits = append(hits, hit)
+	}
+	return out, hits
+}
+
+func matches(m config.RuleMatch, id *arm.ResourceID, scope string, tags map[string]string) bool {
+	if m.Scope != "" && m.Scope != scope {
+		return false
+	}
+	if !glob(m.Subscription, id.SubscriptionID) ||
+		!glob(m.ResourceGroup, id.ResourceGroupName) ||
+		!glob(m.Type, id.ResourceType.String()) ||
+		!glob(m.Name, id.Name) {
+		return false
+	}
+	for k, pattern := range m.Tags {
+		_, v, ok := lookup(tags, k)
+		if !ok || !glob(pattern, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// glob matches ignoring case, an empty pattern matches anything. Patterns are
+// checked by config.Validate, a bad one here just doesn't match.
+func glob(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(s))
+	return ok
+}
+
+// put sets key in tags, replacing any other spelling of it.
+func put(tags map[string]string, key, value string) {
+	if ek, _, ok := lookup(tags, key); ok {
+		delete(tags, ek)
+	}
+	tags[key] = value
+}
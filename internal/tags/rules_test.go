@@ -0,0 +1,62 @@
+package tags
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+func TestApplyRules_TableDriven(t *testing.T) {
+	const (
+		dataVM = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/RG-Data-Lake/providers/Microsoft.Compute/virtualMachines/vm-1"
+		appDB  = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app/providers/Microsoft.Sql/servers/sql-1/databases/db-1"
+		dataRG = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-data-lake"
+	)
+	rules := []config.Rule{
+		{Name: "data", Match: config.RuleMatch{ResourceGroup: "rg-data-*", Scope: models.ScopeResource}, Set: map[string]string{"dataClassification": "confidential"}},
+		{Name: "vm", Match: config.RuleMatch{Type: "microsoft.compute/virtualmachines"}, Default: map[string]string{"patchGroup": "weekly"}},
+		{Name: "temp", Match: config.RuleMatch{Tags: map[string]string{"lifecycle": "tmp-*"}}, Remove: []string{"owner"}},
+		{Name: "sees-previous", Match: config.RuleMatch{Tags: map[string]string{"dataclassification": "*"}}, Set: map[string]string{"reviewed": "no"}},
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		tags map[string]string
+		want map[string]string
+		hits []string
+	}{
+		{"rg glob ignores case, chained rules", dataVM, map[string]string{"DataClassification": "public"},
+			map[string]string{"dataClassification": "confidential", "patchGroup": "weekly", "reviewed": "no"}, []string{"data", "vm", "sees-previous"}},
+		{"default keeps an existing value", dataVM, map[string]string{"PatchGroup": "daily"},
+			map[string]string{"dataClassification": "confidential", "PatchGroup": "daily", "reviewed": "no"}, []string{"data", "vm", "sees-previous"}},
+		{"scope condition", dataRG, nil, map[string]string{}, nil},
+		{"tag condition", appDB, map[string]string{"lifecycle": "tmp-2026", "Owner": "me"},
+			map[string]string{"lifecycle": "tmp-2026"}, []string{"temp"}},
+		{"nothing matches", appDB, map[string]string{"lifecycle": "prod"}, map[string]string{"lifecycle": "prod"}, nil},
+		{"invalid id", "not an id", map[string]string{"a": "b"}, map[string]string{"a": "b"}, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			before := maps.Clone(tc.tags)
+			got, hits := ApplyRules(rules, tc.id, tc.tags)
+			if !maps.Equal(got, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+			var names []string
+			for _, h := range hits {
+				names = append(names, h.Rule)
+			}
+			if !slices.Equal(names, tc.hits) {
+				t.Fatalf("expected rules %v to fire, got %v", tc.hits, names)
+			}
+			if !maps.Equal(tc.tags, before) {
+				t.Fatal("input tags were modified")
+			}
+		})
+	}
+}
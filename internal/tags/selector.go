@@ -0,0 +1,104 @@
+package tags
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+var ErrInvalidSelector = errors.New("invalid selector")
+
+// Selector picks resources by their tags. It is a comma separated list of
+// requirements, all of them must hold:
+//
+//	env=prod      the key has a value matching the glob
+//	env!=prod     the key is missing or its value doesn't match
+//	owner         the key is set
+//	!owner        the key is missing
+//
+// Keys and values are compared ignoring case, like the rules. The empty
+// selector matches everything.
+type Selector []requirement
+
+type requirement struct {
+	key, value string
+	op         string // "=", "!=", "exists", "!exists"
+}
+
+// ParseSelector parses s, see Selector.
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for part := range strings.SplitSeq(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var r requirement
+		switch {
+		case strings.Contains(part, "!="):
+			k, v, _ := strings.Cut(part, "!=")
+			r = requirement{key: strings.TrimSpace(k), value: strings.TrimSpace(v), op: "!="}
+		case strings.Contains(part, "="):
+			k, v, _ := strings.Cut(part, "=")
+			r = requirement{key: strings.TrimSpace(k), value: strings.TrimSpace(v), op: "="}
+		case strings.HasPrefix(part, "!"):
+			r = requirement{key: strings.TrimSpace(part[1:]), op: "!exists"}
+		default:
+			r = requirement{key: part, op: "exists"}
+		}
+		if r.key == "" || strings.ContainsAny(r.key, "=!") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSelector, part)
+		}
+		if _, err := path.Match(r.value, ""); err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidSelector, part, err)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Matches tells whether tags satisfy every requirement.
+func (sel Selector) Matches(tags map[string]string) bool {
+	for _, r := range sel {
+		_, v, ok := lookup(tags, r.key)
+		var hold bool
+		switch r.op {
+		case "=":
+			hold = ok && r.matchValue(v)
+		case "!=":
+			hold = !ok || !r.matchValue(v)
+		case "exists":
+			hold = ok
+		case "!exists":
+			hold = !ok
+		}
+		if !hold {
+			return false
+		}
+	}
+	return true
+}
+
+// matchValue globs v, "env=" only matches an empty value.
+func (r requirement) matchValue(v string) bool {
+	if r.value == "" {
+		return v == ""
+	}
+	return glob(r.value, v)
+}
+
+func (sel Selector) String() string {
+	parts := make([]string, len(sel))
+	for i, r := range sel {
+		switch r.op {
+		case "exists":
+			parts[i] = r.key
+		case "!exists":
+			parts[i] = "!" + r.key
+		default:
+			parts[i] = r.key + r.op + r.value
+		}
+	}
+	return strings.Join(parts, ",")
+}
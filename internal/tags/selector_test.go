@@ -0,0 +1,50 @@
+package tags
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSelector_TableDriven(t *testing.T) {
+	tags := map[string]string{"Env": "prod", "costCenter": "cc-42", "empty": ""}
+
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=PROD", true},
+		{"env=dev", false},
+		{"env!=dev", true},
+		{"env!=prod", false},
+		{"costcenter=cc-*", true},
+		{"owner!=me", true},
+		{"owner", false},
+		{"!owner", true},
+		{"!env", false},
+		{"empty=", true},
+		{"env=", false},
+		{" env = prod , costCenter ", true},
+		{"env=prod,owner", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.selector, func(t *testing.T) {
+			sel, err := ParseSelector(tc.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sel.Matches(tags); got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseSelector_Invalid(t *testing.T) {
+	for _, s := range []string{"=prod", "!", "env=[", "a=b!=c"} {
+		if _, err := ParseSelector(s); !errors.Is(err, ErrInvalidSelector) {
+			t.Fatalf("%q: expected ErrInvalidSelector, got %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package tags
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// minAbbrev is the shortest prefix taken for an abbreviation (env of
+// environment, prod of production).
+const minAbbrev = 3
+
+// separators are ignored by Similar, cost_center is costCenter
+var separators = strings.NewReplacer("_", "", "-", "", ".", "", " ", "")
+
+// Similar tells whether a and b look like spellings of the same thing:
+// equal ignoring case and separators, one an abbreviation of the other, or
+// within maxDistance edits (but not more than a third of the shorter one,
+// "dev" is not "qa").
+func Similar(a, b string, maxDistance int) bool {
+	a, b = separators.Replace(strings.ToLower(a)), separators.Replace(strings.ToLower(b))
+	if a == b {
+		return true
+	}
+	short, long := a, b
+	if utf8.RuneCountInString(short) > utf8.RuneCountInString(long) {
+		short, long = long, short
+	}
+	n := utf8.RuneCountInString(short)
+	if n >= minAbbrev && strings.HasPrefix(long, short) {
+		return true
+	}
+	d := distance(a, b)
+	return d <= maxDistance && d*3 <= n
+}
+
+// distance is the Levenshtein distance of a and b, in runes.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// Cluster groups the spellings of counts (spelling -> uses) that are
+// Similar, transitively. Only groups of two or more are returned, canonical
+// spelling first, then the others by uses. The canonical one is the most used
+// ignoring case, then the most used as spelled, then lower case, then the
+// shortest.
+func Cluster(counts map[string]int, maxDistance int) [][]string {
+	words := make([]string, 0, len(counts))
+	folded := map[string]int{}
+	for w, n := range counts {
+		words = append(words, w)
+		folded[strings.ToLower(w)] += n
+	}
+	slices.Sort(words)
+
+	parent := make([]int, len(words))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range words {
+		for j := i + 1; j < len(words); j++ {
+			if root(i) != root(j) && Similar(words[i], words[j], maxDistance) {
+				parent[root(j)] = root(i)
+			}
+		}
+	}
+
+	groups := map[int][]string{}
+	for i, w := range words {
+		groups[root(i)] = append(groups[root(i)], w)
+	}
+	rank := func(a, b string) int {
+		return cmp.Or(
+			-cmp.Compare(folded[strings.ToLower(a)], folded[strings.ToLower(b)]),
+			-cmp.Compare(counts[a], counts[b]),
+			-cmp.Compare(isLower(a), isLower(b)),
+			cmp.Compare(len(a), len(b)),
+			cmp.Compare(a, b),
+		)
+	}
+	var out [][]string
+	for _, g := range groups {
+		if len(g) > 1 {
+			slices.SortFunc(g, rank)
+			out = append(out, g)
+		}
+	}
+	slices.SortFunc(out, func(a, b []string) int { return cmp.Compare(strings.ToLower(a[0]), strings.ToLower(b[0])) })
+	return out
+}
+
+func isLower(s string) int {
+	if strings.IndexFunc(s, unicode.IsUpper) < 0 {
+		return 1
+	}
+	return 0
+}
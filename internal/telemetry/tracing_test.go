@@ -0,0 +1,57 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMiddleware_StoreSpanIsChildOfRouteSpan(t *testing.T) {
+	if _, err := SetupTracing(context.Background(), "none"); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	st := store.NewMemoryStore()
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/v1/resources/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := st.Get(r.Context(), chi.URLParam(r, "id")); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/resources/missing", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	storeSpan, httpSpan := spans[0], spans[1]
+
+	if httpSpan.Name() != "GET /v1/resources/{id}" {
+		t.Fatalf("expected span named after the route, got %q", httpSpan.Name())
+	}
+	if got := httpSpan.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected incoming trace to be continued, got trace %s", got)
+	}
+	if storeSpan.Name() != "store.get" {
+		t.Fatalf("expected store.get span, got %q", storeSpan.Name())
+	}
+	if storeSpan.Parent().SpanID() != httpSpan.SpanContext().SpanID() {
+		t.Fatal("expected store span to be a child of the request span")
+	}
+}
@@ -0,0 +1,458 @@
+// Package tmpl renders the templated tag values, e.g. owner={{.RG}}-team or
+// createdOn={{.Now | date "2006-01-02"}}.
+//
+// It is not text/template: an action is a pipeline of the fields of Data and
+// the functions below, with string and number literals and parentheses, and
+// nothing else (no variables, if, range, define...). A template is parsed and
+// type checked before it is rendered, so what it can do is only what is
+// listed here.
+package tmpl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+)
+
+var ErrInvalid = errors.New("invalid tag template")
+
+// maxValueLen is the Azure limit for a tag value, in characters.
+const maxValueLen = 256
+
+// Data is what a tag value template can use.
+type Data struct {
+	Subscription string // from the Azure ID
+	RG           string
+	Provider     string // e.g. Microsoft.Compute
+	Type         string // e.g. virtualMachines (servers/databases when nested)
+	Name         string // last segment of the Azure ID
+	Entry        string // name the resource was registered with
+	Scope        string // models.Scope*
+	Caller       string // identity.Caller
+	Now          time.Time
+}
+
+// NewData fills the Azure ID fields from azureID.
+func NewData(entry, azureID, scope, caller string, now time.Time) Data {
+	d := Data{Entry: entry, Scope: scope, Caller: caller, Now: now.UTC()}
+	if id, err := arm.ParseResourceID(azureID); err == nil {
+		d.Subscription, d.RG, d.Name = id.SubscriptionID, id.ResourceGroupName, id.Name
+		d.Provider = id.ResourceType.Namespace
+		d.Type = strings.Join(id.ResourceType.Types, "/")
+	}
+	return d
+}
+
+// kind is the type of a value in a template.
+type kind int
+
+const (
+	kString kind = iota
+	kInt
+	kTime
+	kList
+)
+
+func (k kind) String() string {
+	return [...]string{"string", "number", "time", "list"}[k]
+}
+
+var fields = map[string]struct {
+	kind kind
+	get  func(Data) any
+}{
+	"Subscription": {kString, func(d Data) any { return d.Subscription }},
+	"RG":           {kString, func(d Data) any { return d.RG }},
+	"Provider":     {kString, func(d Data) any { return d.Provider }},
+	"Type":         {kString, func(d Data) any { return d.Type }},
+	"Name":         {kString, func(d Data) any { return d.Name }},
+	"Entry":        {kString, func(d Data) any { return d.Entry }},
+	"Scope":        {kString, func(d Data) any { return d.Scope }},
+	"Caller":       {kString, func(d Data) any { return d.Caller }},
+	"Now":          {kTime, func(d Data) any { return d.Now }},
+}
+
+// function is one of funcs. A piped value is its last argument.
+type function struct {
+	args []kind
+	out  kind
+	call func(args []any) (any, error)
+}
+
+// funcs is all a template can call: pure string and time helpers.
+var funcs = map[string]function{
+	"date": {[]kind{kString, kTime}, kString, func(a []any) (any, error) {
+		return a[1].(time.Time).Format(a[0].(string)), nil
+	}},
+	"lower": {[]kind{kString}, kString, func(a []any) (any, error) { return strings.ToLower(a[0].(string)), nil }},
+	"upper": {[]kind{kString}, kString, func(a []any) (any, error) { return strings.ToUpper(a[0].(string)), nil }},
+	"trim":  {[]kind{kString}, kString, func(a []any) (any, error) { return strings.TrimSpace(a[0].(string)), nil }},
+	"trimPrefix": {[]kind{kString, kString}, kString, func(a []any) (any, error) {
+		return strings.TrimPrefix(a[1].(string), a[0].(string)), nil
+	}},
+	"trimSuffix": {[]kind{kString, kString}, kString, func(a []any) (any, error) {
+		return strings.TrimSuffix(a[1].(string), a[0].(string)), nil
+	}},
+	"replace": {[]kind{kString, kString, kString}, kString, func(a []any) (any, error) {
+		return replace(a[0].(string), a[1].(string), a[2].(string))
+	}},
+	"split": {[]kind{kString, kString}, kList, func(a []any) (any, error) {
+		return strings.Split(a[1].(string), a[0].(string)), nil
+	}},
+	"index": {[]kind{kList, kInt}, kString, func(a []any) (any, error) {
+		list, i := a[0].([]string), a[1].(int)
+		if i < 0 || i >= len(list) {
+			return nil, fmt.Errorf("index %d out of range of %d items", i, len(list))
+		}
+		return list[i], nil
+	}},
+	"truncate": {[]kind{kInt, kString}, kString, func(a []any) (any, error) { return truncate(a[0].(int), a[1].(string)), nil }},
+	"default": {[]kind{kString, kString}, kString, func(a []any) (any, error) {
+		if a[1].(string) == "" {
+			return a[0], nil
+		}
+		return a[1], nil
+	}},
+}
+
+var errTooLong = fmt.Errorf("renders to more than %d characters", maxValueLen)
+
+func limit(s string) (string, error) {
+	if utf8.RuneCountInString(s) > maxValueLen {
+		return "", errTooLong
+	}
+	return s, nil
+}
+
+// replace checks the size of the result before making it, replacing "" in
+// a long value would be quadratic.
+func replace(old, new, s string) (string, error) {
+	n := strings.Count(s, old)
+	if len(s)+n*(len(new)-len(old)) > maxValueLen*utf8.UTFMax {
+		return "", errTooLong
+	}
+	return limit(strings.ReplaceAll(s, old, new))
+}
+
+// truncate keeps the first n characters (runes) of s.
+func truncate(n int, s string) string {
+	if n < 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	i := 0
+	for range n {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return s[:i]
+}
+
+// Render renders the values holding a template ({{ ... }}), the others are
+// returned as they are.
+func Render(tags map[string]string, data Data) (map[string]string, error) {
+	out := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if !strings.Contains(v, "{{") {
+			out[k] = v
+			continue
+		}
+		t, err := Parse(v)
+		if err == nil {
+			v, err = t.Execute(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: tag %q: %v", ErrInvalid, k, err)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// Template is a parsed tag value.
+type Template struct {
+	parts []node // text or actions, in order
+}
+
+// Execute renders t with data.
+func (t *Template) Execute(data Data) (string, error) {
+	var b strings.Builder
+	for _, p := range t.parts {
+		v, err := p.eval(data)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(v.(string))
+	}
+	return limit(b.String())
+}
+
+// node is a literal, a field or a call, typed when parsed.
+type node interface {
+	kind() kind
+	eval(Data) (any, error)
+}
+
+type literal struct {
+	k kind
+	v any
+}
+
+func (n literal) kind() kind             { return n.k }
+func (n literal) eval(Data) (any, error) { return n.v, nil }
+
+type field string
+
+func (n field) kind() kind               { return fields[string(n)].kind }
+func (n field) eval(d Data) (any, error) { return fields[string(n)].get(d), nil }
+
+type call struct {
+	name string
+	args []node
+}
+
+func (n call) kind() kind { return funcs[n.name].out }
+
+func (n call) eval(d Data) (any, error) {
+	args := make([]any, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(d)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := funcs[n.name].call(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+	if s, ok := v.(string); ok {
+		return limit(s)
+	}
+	return v, nil
+}
+
+// Parse parses and type checks a tag value. Anything but the fields and
+// funcs above is an error.
+func Parse(value string) (*Template, error) {
+	var t Template
+	for value != "" {
+		i := strings.Index(value, "{{")
+		if i < 0 {
+			t.parts = append(t.parts, literal{kString, value})
+			break
+		}
+		if i > 0 {
+			t.parts = append(t.parts, literal{kString, value[:i]})
+		}
+		p := &parser{src: value[i+2:]}
+		n, err := p.action()
+		if err != nil {
+			return nil, err
+		}
+		t.parts = append(t.parts, n)
+		value = p.src
+	}
+	return &t, nil
+}
+
+// parser reads one action, src is what follows it once done.
+type parser struct {
+	src  string
+	peek *token
+}
+
+type token struct {
+	typ  byte // one of ". ( ) |", i ident, s string, n number, } end
+	text string
+}
+
+// action is a pipeline rendering a string, up to }}.
+func (p *parser) action() (node, error) {
+	n, err := p.pipeline()
+	if err != nil {
+		return nil, err
+	}
+	if t, err := p.next(); err != nil {
+		return nil, err
+	} else if t.typ != '}' {
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+	if n.kind() != kString {
+		return nil, fmt.Errorf("an action must render a string, not a %s", n.kind())
+	}
+	return n, nil
+}
+
+// pipeline is commands separated by |, each one a call getting the value of
+// the previous one as its last argument.
+func (p *parser) pipeline() (node, error) {
+	n, err := p.command(nil)
+	for err == nil {
+		t, terr := p.lookahead()
+		if terr != nil {
+			return nil, terr
+		}
+		if t.typ != '|' {
+			return n, nil
+		}
+		p.peek = nil
+		n, err = p.command(n)
+	}
+	return nil, err
+}
+
+func (p *parser) command(piped node) (node, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.typ != 'i' {
+		if piped != nil {
+			return nil, fmt.Errorf("%q can't take a piped value, only a function can", t.text)
+		}
+		return p.operand(t)
+	}
+	f, ok := funcs[t.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", t.text)
+	}
+	c := call{name: t.text}
+	for {
+		next, err := p.lookahead()
+		if err != nil {
+			return nil, err
+		}
+		if next.typ == '|' || next.typ == ')' || next.typ == '}' {
+			break
+		}
+		p.peek = nil
+		a, err := p.operand(next)
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, a)
+	}
+	if piped != nil {
+		c.args = append(c.args, piped)
+	}
+	if len(c.args) != len(f.args) {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", t.text, len(f.args), len(c.args))
+	}
+	for i, a := range c.args {
+		if a.kind() != f.args[i] {
+			return nil, fmt.Errorf("%s: argument %d must be a %s, not a %s", t.text, i+1, f.args[i], a.kind())
+		}
+	}
+	return c, nil
+}
+
+// operand is a field, a literal or a parenthesized pipeline.
+func (p *parser) operand(t token) (node, error) {
+	switch t.typ {
+	case '.':
+		name := t.text[1:]
+		if _, ok := fields[name]; !ok {
+			return nil, fmt.Errorf("unknown field %s", t.text)
+		}
+		return field(name), nil
+	case 's':
+		s, err := strconv.Unquote(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("bad string %s", t.text)
+		}
+		return literal{kString, s}, nil
+	case 'n':
+		n, err := strconv.Atoi(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %s", t.text)
+		}
+		return literal{kInt, n}, nil
+	case '(':
+		n, err := p.pipeline()
+		if err != nil {
+			return nil, err
+		}
+		if t, err := p.next(); err != nil {
+			return nil, err
+		} else if t.typ != ')' {
+			return nil, fmt.Errorf("expected ), got %q", t.text)
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+func (p *parser) lookahead() (token, error) {
+	if p.peek == nil {
+		t, err := p.scan()
+		if err != nil {
+			return token{}, err
+		}
+		p.peek = &t
+	}
+	return *p.peek, nil
+}
+
+func (p *parser) next() (token, error) {
+	t, err := p.lookahead()
+	p.peek = nil
+	return t, err
+}
+
+// scan reads the next token of src.
+func (p *parser) scan() (token, error) {
+	p.src = strings.TrimLeftFunc(p.src, unicode.IsSpace)
+	s := p.src
+	if s == "" {
+		return token{}, errors.New("unclosed action")
+	}
+	take := func(typ byte, n int) (token, error) {
+		p.src = s[n:]
+		return token{typ, s[:n]}, nil
+	}
+	switch c := s[0]; {
+	case strings.HasPrefix(s, "}}"):
+		return take('}', 2)
+	case c == '(' || c == ')' || c == '|':
+		return take(c, 1)
+	case c == '.':
+		n := 1 + identLen(s[1:])
+		if n == 1 {
+			return token{}, errors.New("expected a field name after .")
+		}
+		return take('.', n)
+	case c == '"' || c == '`':
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' && c == '"' {
+				i++
+			} else if s[i] == c {
+				return take('s', i+1)
+			}
+		}
+		return token{}, errors.New("unterminated string")
+	case c == '-' || c >= '0' && c <= '9':
+		n := 1
+		for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+			n++
+		}
+		return take('n', n)
+	case identLen(s) > 0:
+		return take('i', identLen(s))
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return token{}, fmt.Errorf("unexpected %q", r)
+}
+
+func identLen(s string) int {
+	n := 0
+	for n < len(s) && (s[n] == '_' || s[n] >= 'a' && s[n] <= 'z' || s[n] >= 'A' && s[n] <= 'Z' || n > 0 && s[n] >= '0' && s[n] <= '9') {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package tmpl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender_TableDriven(t *testing.T) {
+	now := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+	data := NewData("web-vm",
+		"/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-shop/providers/Microsoft.Compute/virtualMachines/vm-shop-01",
+		"resource", "ana@contoso.com", now)
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"plain value untouched", "prod", "prod", false},
+		{"resource group", "{{.RG}}-team", "rg-shop-team", false},
+		{"date of now", `{{.Now | date "2006-01-02"}}`, "2026-03-14", false},
+		{"derived from the name", `{{.Name | trimPrefix "vm-" | upper}}`, "SHOP-01", false},
+		{"split the name", `{{index (split "-" .Name) 1}}`, "shop", false},
+		{"caller and entry", "{{.Caller}}/{{.Entry}}", "ana@contoso.com/web-vm", false},
+		{"provider and type", "{{.Provider}}/{{.Type}}", "Microsoft.Compute/virtualMachines", false},
+		{"default", `{{.Caller | default "nobody"}}`, "ana@contoso.com", false},
+		{"unknown variable", "{{.Team}}", "", true},
+		{"unknown function", `{{env "HOME"}}`, "", true},
+		{"syntax error", "{{.RG", "", true},
+		{"too long", `{{"x" | replace "x" "` + strings.Repeat("y", 257) + `"}}`, "", true},
+		{"long text around an action", strings.Repeat("x", 250) + "{{.RG}}", "", true},
+		{"non-ascii within the limit", strings.Repeat("é", 250) + "{{.RG | truncate 6}}", strings.Repeat("é", 250) + "rg-sho", false},
+		{"replace the empty string", `{{replace "" .Subscription .Subscription}}`, "", true},
+		{"index out of range", `{{index (split "-" .Name) 5}}`, "", true},
+		{"a time is not a value", "{{.Now}}", "", true},
+		{"wrong argument type", "{{upper .Now}}", "", true},
+		{"wrong argument count", `{{trimPrefix .Name}}`, "", true},
+		{"piped into a field", "{{.RG | .Name}}", "", true},
+		{"builtin print", `{{print .RG}}`, "", true},
+		{"builtin printf", `{{printf "%0999999999d" 1}}`, "", true},
+		{"variable", `{{$a := .RG}}{{$a}}`, "", true},
+		{"range", `{{range split "" .Name}}x{{end}}`, "", true},
+		{"define", `{{define "x"}}{{template "x"}}{{end}}{{template "x"}}`, "", true},
+		{"unclosed string", `{{"}}`, "", true},
+		{"braces in a string", `{{"}}" | upper}}`, "}}", false},
+		{"truncate", `{{.Name | truncate 4}}`, "vm-s", false},
+		{"truncate multibyte", `{{"héllo" | truncate 2}}`, "hé", false},
+		{"parentheses", `{{upper (trimSuffix "-01" .Name)}}`, "VM-SHOP", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Render(map[string]string{"k": tc.value}, data)
+			if tc.wantErr {
+				if !errors.Is(err, ErrInvalid) {
+					t.Fatalf("expected ErrInvalid, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if got["k"] != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got["k"])
+			}
+		})
+	}
+}
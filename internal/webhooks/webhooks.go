@@ -0,0 +1,504 @@
+// Package webhooks delivers the resource events to the subscribed URLs as
+// HMAC-signed JSON, retrying with exponential backoff. Deliveries run on a
+// small worker pool; the ones that gave up, or didn't fit in its queue, are
+// kept as dead letters until redelivered.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/logging"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/metrics"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrInvalid  = errors.New("invalid webhook")
+	ErrNotDead  = errors.New("only dead deliveries can be redelivered")
+	ErrStopped  = errors.New("webhooks are stopped")
+	ErrFull     = errors.New("webhook delivery queue is full, retry later")
+
+	errBlocked = errors.New("private, loopback or link-local address (see webhooks.allow_private)")
+)
+
+// Headers of a delivery. The signature is "sha256=" + hex HMAC-SHA256 of
+// "<timestamp>.<body>" with the webhook secret, see Sign.
+const (
+	HeaderSignature = "X-Tagger-Signature"
+	HeaderTimestamp = "X-Tagger-Timestamp"
+	HeaderEvent     = "X-Tagger-Event"
+	HeaderDelivery  = "X-Tagger-Delivery"
+)
+
+const (
+	maxAttempts   = 6
+	maxBackoff    = 5 * time.Minute
+	maxDeliveries = 1000 // finished ones kept, oldest dropped first
+	workers       = 4
+	queueSize     = 1000 // attempts waiting for a worker, more are dropped
+)
+
+// Sign returns the signature header value of a delivery body.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type delivery struct {
+	models.Delivery
+	payload []byte
+}
+
+// Dispatcher keeps the webhooks and their deliveries in memory. Deliveries
+// run until ctx (given to NewDispatcher) is done.
+type Dispatcher struct {
+	ctx          context.Context
+	client       *http.Client
+	backoff      time.Duration
+	maxAttempts  int
+	allowPrivate bool
+	queue        chan string // delivery IDs, one attempt each
+	wg           sync.WaitGroup
+
+	mu         sync.Mutex
+	stopped    bool
+	hooks      map[string]models.Webhook
+	hookOrder  []string
+	deliveries map[string]*delivery
+	order      []string               // deliveries, creation order
+	retries    map[string]*time.Timer // by delivery, waiting for their backoff
+}
+
+func NewDispatcher(ctx context.Context, cfg config.Webhooks) *Dispatcher {
+	return newDispatcher(ctx, cfg, workers, queueSize)
+}
+
+func newDispatcher(ctx context.Context, cfg config.Webhooks, workers, queueSize int) *Dispatcher {
+	d := &Dispatcher{
+		ctx:          ctx,
+		client:       &http.Client{Timeout: cfg.Timeout, Transport: transport(cfg.AllowPrivate)},
+		backoff:      cfg.RetryBackoff,
+		maxAttempts:  maxAttempts,
+		allowPrivate: cfg.AllowPrivate,
+		queue:        make(chan string, queueSize),
+		hooks:        make(map[string]models.Webhook),
+		deliveries:   make(map[string]*delivery),
+		retries:      make(map[string]*time.Timer),
+	}
+	for range workers {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+// transport checks the address each connection is made to, after the DNS
+// lookup and on redirects too: a public name can point anywhere.
+func transport(allowPrivate bool) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if allowPrivate {
+		return t
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, _ := net.SplitHostPort(address)
+			if ip, err := netip.ParseAddr(host); err != nil || blocked(ip) {
+				return fmt.Errorf("%s: %w", host, errBlocked)
+			}
+			return nil
+		},
+	}
+	t.DialContext = dialer.DialContext
+	t.Proxy = nil // the proxy would be the one checked, not the receiver
+	return t
+}
+
+// blocked tells if ip is one a webhook must not reach without
+// webhooks.allow_private: the host itself, the private networks, and the
+// link-local ones (169.254.169.254 is the instance metadata service).
+func blocked(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// Create registers a webhook. Without a secret one is generated; it is only
+// returned here. A URL to a blocked address is refused, one with a name
+// resolving to such an address fails on delivery.
+func (d *Dispatcher) Create(rawURL string, events []string, secret string) (models.Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return models.Webhook{}, fmt.Errorf("%w: url %q must be an absolute http(s) URL", ErrInvalid, rawURL)
+	}
+	if !d.allowPrivate {
+		host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+		ip, err := netip.ParseAddr(host)
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") || err == nil && blocked(ip) {
+			return models.Webhook{}, fmt.Errorf("%w: url %q: %v", ErrInvalid, rawURL, errBlocked)
+		}
+	}
+	for _, e := range events {
+		if !slices.Contains(models.EventTypes, e) {
+			return models.Webhook{}, fmt.Errorf("%w: unknown event %q, must be one of %v", ErrInvalid, e, models.EventTypes)
+		}
+	}
+	if secret == "" {
+		b := make([]byte, 32)
+		crand.Read(b)
+		secret = hex.EncodeToString(b)
+	}
+
+	w := models.Webhook{ID: uuid.NewString(), URL: rawURL, Events: slices.Clone(events), Secret: secret, CreatedUnix: time.Now().Unix()}
+	if w.Events == nil {
+		w.Events = []string{}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks[w.ID] = w
+	d.hookOrder = append(d.hookOrder, w.ID)
+	return w, nil
+}
+
+// List returns the webhooks, oldest first, without their secret.
+func (d *Dispatcher) List() []models.Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]models.Webhook, 0, len(d.hookOrder))
+	for _, id := range d.hookOrder {
+		out = append(out, redacted(d.hooks[id]))
+	}
+	return out
+}
+
+// Get returns a webhook without its secret.
+func (d *Dispatcher) Get(id string) (models.Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.hooks[id]
+	if !ok {
+		return models.Webhook{}, ErrNotFound
+	}
+	return redacted(w), nil
+}
+
+// Delete removes a webhook, its pending retries give up.
+func (d *Dispatcher) Delete(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.hooks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(d.hooks, id)
+	d.hookOrder = slices.DeleteFunc(d.hookOrder, func(h string) bool { return h == id })
+	return nil
+}
+
+// Handle queues a delivery of e to every webhook subscribed to its type.
+// It is the events.Bus subscriber and doesn't block: with the queue full the
+// delivery is a dead letter right away.
+func (d *Dispatcher) Handle(e models.Event) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		logging.FromContext(d.ctx).Error("could not encode event", slog.String("event_type", e.Type), slog.String("error", err.Error()))
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return
+	}
+	for _, id := range d.hookOrder {
+		w := d.hooks[id]
+		if len(w.Events) > 0 && !slices.Contains(w.Events, e.Type) {
+			continue
+		}
+		dl := &delivery{
+			Delivery: models.Delivery{
+				ID: uuid.NewString(), WebhookID: w.ID, EventID: e.ID, EventType: e.Type,
+				Status: models.DeliveryPending, CreatedUnix: time.Now().Unix(),
+			},
+			payload: payload,
+		}
+		d.deliveries[dl.ID] = dl
+		d.order = append(d.order, dl.ID)
+		if !d.enqueue(dl) {
+			dl.Status, dl.FinishedUnix, dl.LastError = models.DeliveryDead, time.Now().Unix(), ErrFull.Error()
+		}
+	}
+	d.prune()
+}
+
+// DeadLetters returns the deliveries that gave up, newest first.
+func (d *Dispatcher) DeadLetters() []models.Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := []models.Delivery{}
+	for i := len(d.order) - 1; i >= 0; i-- {
+		if dl := d.deliveries[d.order[i]]; dl.Status == models.DeliveryDead {
+			out = append(out, dl.Delivery)
+		}
+	}
+	return out
+}
+
+// Redeliver sends a dead delivery again, with a fresh set of attempts.
+func (d *Dispatcher) Redeliver(id string) (models.Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl, ok := d.deliveries[id]
+	if !ok {
+		return models.Delivery{}, ErrNotFound
+	}
+	if dl.Status != models.DeliveryDead {
+		return models.Delivery{}, ErrNotDead
+	}
+	if _, ok := d.hooks[dl.WebhookID]; !ok {
+		return models.Delivery{}, fmt.Errorf("webhook %s: %w", dl.WebhookID, ErrNotFound)
+	}
+	if d.stopped {
+		return models.Delivery{}, ErrStopped
+	}
+	if !d.enqueue(dl) {
+		return models.Delivery{}, ErrFull
+	}
+	dl.Status, dl.Attempts, dl.FinishedUnix = models.DeliveryPending, 0, 0
+	return dl.Delivery, nil
+}
+
+// enqueue queues the next attempt of dl, false (counted as dropped) when the
+// queue is full. Caller holds mu.
+func (d *Dispatcher) enqueue(dl *delivery) bool {
+	select {
+	case d.queue <- dl.ID:
+		return true
+	default:
+		metrics.ObserveWebhookDropped()
+		logging.FromContext(d.ctx).Warn("webhook delivery dropped, queue full",
+			slog.String("webhook_id", dl.WebhookID), slog.String("delivery_id", dl.ID))
+		return false
+	}
+}
+
+// Wait waits (until ctx is done) for the workers to return after the
+// dispatcher context was canceled. No delivery starts afterwards, the ones
+// queued or waiting for a retry are marked dead.
+func (d *Dispatcher) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	d.stopped = true
+	for id, t := range d.retries {
+		t.Stop()
+		d.stop(id, " (service stopped before the retry)")
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for {
+		select {
+		case id := <-d.queue:
+			d.stop(id, " (service stopped before the attempt)")
+		default:
+			return nil
+		}
+	}
+}
+
+// stop marks a delivery that won't be attempted again dead, why appended to
+// its last error. Caller holds mu.
+func (d *Dispatcher) stop(id, why string) {
+	delete(d.retries, id)
+	if dl, ok := d.deliveries[id]; ok {
+		dl.Status, dl.FinishedUnix = models.DeliveryDead, time.Now().Unix()
+		dl.LastError = strings.TrimSpace(dl.LastError + why)
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case id := <-d.queue:
+			if d.ctx.Err() != nil {
+				// select picks at random when both are ready, Wait drains it
+				d.mu.Lock()
+				d.stop(id, " (service stopped before the attempt)")
+				d.mu.Unlock()
+				return
+			}
+			d.attempt(id)
+		}
+	}
+}
+
+// attempt sends a delivery once, then finishes it or schedules its retry.
+func (d *Dispatcher) attempt(id string) {
+	d.mu.Lock()
+	dl := d.deliveries[id]
+	w, ok := d.hooks[dl.WebhookID]
+	payload := dl.payload
+	d.mu.Unlock()
+	if !ok {
+		d.finish(id, models.DeliveryDead, "webhook deleted")
+		return
+	}
+
+	code, err := d.send(w, id, dl.EventType, payload)
+	attempts := d.record(id, code, err)
+	switch {
+	case err == nil:
+		metrics.ObserveWebhookAttempt("delivered")
+		d.finish(id, models.DeliveryDelivered, "")
+		return
+	case !retryable(code) || errors.Is(err, errBlocked) || attempts >= d.maxAttempts || d.ctx.Err() != nil:
+		metrics.ObserveWebhookAttempt("dead")
+		logging.FromContext(d.ctx).Warn("webhook delivery failed",
+			slog.String("webhook_id", w.ID), slog.String("delivery_id", id), slog.Int("attempts", attempts), slog.String("error", err.Error()))
+		d.finish(id, models.DeliveryDead, "")
+		return
+	}
+	metrics.ObserveWebhookAttempt("retry")
+
+	// a timer rather than a sleeping worker, the others keep going meanwhile
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		d.stop(id, " (service stopped before the retry)")
+		return
+	}
+	d.retries[id] = time.AfterFunc(d.wait(attempts), func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if _, ok := d.retries[id]; !ok {
+			return // stopped by Wait
+		}
+		delete(d.retries, id)
+		if !d.enqueue(dl) {
+			d.stop(id, " ("+ErrFull.Error()+")")
+		}
+	})
+}
+
+// send POSTs the event once. A non-2xx status is an error.
+func (d *Dispatcher) send(w models.Webhook, deliveryID, eventType string, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderSignature, Sign(w.Secret, ts, payload))
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderDelivery, deliveryID)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable: network errors (code 0), timeouts, throttling and 5xx. Any
+// other 4xx means the receiver rejects the event, retrying won't help.
+func retryable(code int) bool {
+	return code == 0 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// wait is the backoff before the retry following attempt: doubled each
+// time, capped, plus up to 10% jitter so receivers coming back up aren't hit
+// by every retry at once.
+func (d *Dispatcher) wait(attempt int) time.Duration {
+	b := min(d.backoff<<(attempt-1), maxBackoff)
+	if b <= 0 {
+		return 0
+	}
+	return b + rand.N(b/10+1)
+}
+
+// record saves the outcome of one attempt and returns the attempts so far.
+func (d *Dispatcher) record(id string, code int, err error) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl := d.deliveries[id]
+	dl.Attempts++
+	dl.LastStatusCode = code
+	dl.LastError = ""
+	if err != nil {
+		dl.LastError = err.Error()
+	}
+	return dl.Attempts
+}
+
+// finish sets the final status, reason (when not empty) replacing the last error.
+func (d *Dispatcher) finish(id, status, reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl := d.deliveries[id]
+	dl.Status, dl.FinishedUnix = status, time.Now().Unix()
+	if reason != "" {
+		dl.LastError = reason
+	}
+}
+
+// prune drops the oldest finished deliveries past maxDeliveries. Caller holds mu.
+func (d *Dispatcher) prune() {
+	for i := 0; len(d.order) > maxDeliveries && i < len(d.order); {
+		dl := d.deliveries[d.order[i]]
+		if dl.Status == models.DeliveryPending {
+			i++
+			continue
+		}
+		delete(d.deliveries, dl.ID)
+		d.order = slices.Delete(d.order, i, i+1)
+	}
+}
+
+func redacted(w models.Webhook) models.Webhook {
+	w.Secret = ""
+	return w
+}
@@ -0,0 +1,244 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/models"
+)
+
+// receiver answers the scripted status codes in order, then 200, and keeps
+// what it got.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	got      []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	rc := &receiver{statuses: statuses}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		rc.got = append(rc.got, r)
+		rc.bodies = append(rc.bodies, body)
+		code := http.StatusOK
+		if len(rc.statuses) > 0 {
+			code, rc.statuses = rc.statuses[0], rc.statuses[1:]
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.got)
+}
+
+func newTestDispatcher(t *testing.T) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	// the receivers are on 127.0.0.1
+	d := NewDispatcher(ctx, config.Webhooks{Timeout: time.Second, RetryBackoff: time.Millisecond, AllowPrivate: true})
+	t.Cleanup(func() {
+		cancel()
+		d.Wait(context.Background())
+	})
+	return d
+}
+
+// settle waits for every delivery to be finished and returns them.
+func settle(t *testing.T, d *Dispatcher) []models.Delivery {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		d.mu.Lock()
+		var out []models.Delivery
+		pending := false
+		for _, id := range d.order {
+			out = append(out, d.deliveries[id].Delivery)
+			pending = pending || d.deliveries[id].Status == models.DeliveryPending
+		}
+		d.mu.Unlock()
+		if !pending {
+			return out
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("deliveries still pending")
+	return nil
+}
+
+func TestDispatcher_Deliveries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		status   string
+		attempts int
+	}{
+		{"delivered", nil, models.DeliveryDelivered, 1},
+		{"retried then delivered", []int{500, 429, 503}, models.DeliveryDelivered, 4},
+		{"rejected, no retry", []int{400}, models.DeliveryDead, 1},
+		{"gives up after max attempts", []int{500, 500, 500, 500, 500, 500, 500}, models.DeliveryDead, maxAttempts},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestDispatcher(t)
+			rc := newReceiver(t, tc.statuses...)
+			w, err := d.Create(rc.URL, []string{models.EventTagsUpdated}, "s3cr3t")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d.Handle(models.Event{ID: "e1", Type: models.EventTagsUpdated, ResourceID: "r1"})
+			d.Handle(models.Event{ID: "e2", Type: models.EventAzureApplied}) // filtered out
+
+			got := settle(t, d)
+			if len(got) != 1 || got[0].Status != tc.status || got[0].Attempts != tc.attempts || got[0].WebhookID != w.ID {
+				t.Fatalf("expected one %s delivery after %d attempts, got %+v", tc.status, tc.attempts, got)
+			}
+			if dead := d.DeadLetters(); (len(dead) == 1) != (tc.status == models.DeliveryDead) {
+				t.Fatalf("unexpected dead letters %+v", dead)
+			}
+
+			rc.mu.Lock()
+			defer rc.mu.Unlock()
+			r, body := rc.got[0], rc.bodies[0]
+			if want := Sign("s3cr3t", r.Header.Get(HeaderTimestamp), body); r.Header.Get(HeaderSignature) != want {
+				t.Fatalf("expected signature %s, got %s", want, r.Header.Get(HeaderSignature))
+			}
+			var e models.Event
+			if err := json.Unmarshal(body, &e); err != nil || e.ID != "e1" || r.Header.Get(HeaderEvent) != models.EventTagsUpdated {
+				t.Fatalf("unexpected payload %s (%v)", body, err)
+			}
+		})
+	}
+}
+
+func TestDispatcher_Redeliver(t *testing.T) {
+	d := newTestDispatcher(t)
+	rc := newReceiver(t, http.StatusGone)
+	d.Create(rc.URL, nil, "")
+
+	d.Handle(models.Event{ID: "e1", Type: models.EventDriftDetected})
+	dead := settle(t, d)
+	if dead[0].Status != models.DeliveryDead {
+		t.Fatalf("expected a dead delivery, got %+v", dead)
+	}
+	if _, err := d.Redeliver("nope"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := d.Redeliver(dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	got := settle(t, d)
+	if got[0].Status != models.DeliveryDelivered || rc.count() != 2 || len(d.DeadLetters()) != 0 {
+		t.Fatalf("expected the redelivery to succeed, got %+v", got)
+	}
+	if _, err := d.Redeliver(dead[0].ID); !errors.Is(err, ErrNotDead) {
+		t.Fatalf("expected ErrNotDead, got %v", err)
+	}
+}
+
+func TestDispatcher_Create(t *testing.T) {
+	d := NewDispatcher(t.Context(), config.Webhooks{Timeout: time.Second})
+	private := NewDispatcher(t.Context(), config.Webhooks{Timeout: time.Second, AllowPrivate: true})
+
+	tests := []struct {
+		name         string
+		url          string
+		events       []string
+		allowPrivate bool
+		wantErr      bool
+	}{
+		{"all events", "https://cmdb.example.com/hook", nil, false, false},
+		{"filtered", "http://cmdb.example.com:9000/x", []string{models.EventAzureFailed, models.EventDriftDetected}, false, false},
+		{"relative url", "/hook", nil, false, true},
+		{"ftp", "ftp://example.com", nil, false, true},
+		{"unknown event", "https://example.com", []string{"resource.exploded"}, false, true},
+		{"localhost", "http://localhost:9000/x", nil, false, true},
+		{"loopback", "http://127.0.0.1/x", nil, false, true},
+		{"instance metadata", "http://169.254.169.254/metadata/instance", nil, false, true},
+		{"private network", "http://10.1.2.3/x", nil, false, true},
+		{"ipv6 loopback", "http://[::1]/x", nil, false, true},
+		{"mapped ipv4", "http://[::ffff:192.168.0.1]/x", nil, false, true},
+		{"private allowed", "http://localhost:9000/x", nil, true, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := d
+			if tc.allowPrivate {
+				d = private
+			}
+			w, err := d.Create(tc.url, tc.events, "")
+			if tc.wantErr {
+				if !errors.Is(err, ErrInvalid) {
+					t.Fatalf("expected ErrInvalid, got %v", err)
+				}
+				return
+			}
+			if err != nil || len(w.Secret) != 64 {
+				t.Fatalf("expected a generated secret, got %+v / %v", w, err)
+			}
+			if got, _ := d.Get(w.ID); got.Secret != "" {
+				t.Fatal("expected the secret to be returned only on creation")
+			}
+		})
+	}
+}
+
+func TestDispatcher_BlockedOnDelivery(t *testing.T) {
+	d := NewDispatcher(t.Context(), config.Webhooks{Timeout: time.Second, RetryBackoff: time.Millisecond})
+	rc := newReceiver(t)
+	// as if a public name resolved to 127.0.0.1: Create can't see it, the
+	// connection is refused
+	d.hooks["h1"] = models.Webhook{ID: "h1", URL: rc.URL, Events: []string{}}
+	d.hookOrder = []string{"h1"}
+
+	d.Handle(models.Event{ID: "e1", Type: models.EventDriftDetected})
+	got := settle(t, d)
+	if got[0].Status != models.DeliveryDead || got[0].Attempts != 1 || rc.count() != 0 {
+		t.Fatalf("expected a dead delivery without retries nor call, got %+v (%d calls)", got, rc.count())
+	}
+}
+
+func TestDispatcher_QueueFull(t *testing.T) {
+	// no worker: the first delivery stays queued, the second doesn't fit
+	ctx, cancel := context.WithCancel(t.Context())
+	d := newDispatcher(ctx, config.Webhooks{Timeout: time.Second}, 0, 1)
+	d.Create("https://cmdb.example.com/hook", nil, "")
+
+	d.Handle(models.Event{ID: "e1", Type: models.EventDriftDetected})
+	d.Handle(models.Event{ID: "e2", Type: models.EventDriftDetected})
+	dead := d.DeadLetters()
+	if len(dead) != 1 || dead[0].EventID != "e2" || dead[0].LastError != ErrFull.Error() {
+		t.Fatalf("expected the second delivery dropped as a dead letter, got %+v", dead)
+	}
+	if _, err := d.Redeliver(dead[0].ID); !errors.Is(err, ErrFull) {
+		t.Fatalf("expected ErrFull, got %v", err)
+	}
+
+	// the queued one is marked dead on shutdown
+	cancel()
+	if err := d.Wait(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if dead := d.DeadLetters(); len(dead) != 2 {
+		t.Fatalf("expected both deliveries dead after the shutdown, got %+v", dead)
+	}
+}
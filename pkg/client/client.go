@@ -0,0 +1,281 @@
+// Package client is a Go client of the tagger API (the /v1 routes), the one
+// taggerctl uses. Its types mirror the API payloads, internal/models can't be
+// imported from outside the module; contract_test.go checks them against the
+// swagger spec.
+//
+// Every call takes a context, answers other than 2xx come back as an *Error
+// matching the Err* sentinels with errors.Is. Calls that change something
+// carry an Idempotency-Key, so the ones failing on the network or with a
+// 408, 429, 502, 503 or 504 are retried without running twice, see WithRetry.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Client calls one tagger API server. It is safe for concurrent use.
+type Client struct {
+	base  *url.URL // with /v1
+	token string
+	http  *http.Client
+
+	retries int
+	backoff time.Duration // before the first retry, doubled on each one
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken sends the token as a bearer token, for a server behind Container
+// Apps authentication (Easy Auth).
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient replaces the default HTTP client (30s timeout).
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithRetry sets how many times a failed call is retried (3 by default, 0
+// for none) and the wait before the first retry (250ms), doubled on each one
+// up to 5s. A Retry-After of the server replaces it, within the same cap.
+func WithRetry(retries int, backoff time.Duration) Option {
+	return func(c *Client) { c.retries, c.backoff = max(retries, 0), backoff }
+}
+
+const maxBackoff = 5 * time.Second
+
+// New is a client of the server at baseURL, e.g. http://localhost:8080.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("server url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("server url %q: want http(s)://host[:port]", baseURL)
+	}
+	u.Path += "/v1"
+	c := &Client{base: u, http: &http.Client{Timeout: 30 * time.Second}, retries: 3, backoff: 250 * time.Millisecond}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+type keyCtx struct{}
+
+// WithIdempotencyKey makes the call made with ctx send key as its
+// Idempotency-Key instead of a new one per call, to retry a call across
+// restarts of the caller. The server keeps the answers for a day by default.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyCtx{}, key)
+}
+
+// The kinds of *Error, to match with errors.Is.
+var (
+	ErrBadRequest    = errors.New("bad request")   // 400
+	ErrUnauthorized  = errors.New("unauthorized")  // 401, 403
+	ErrNotFound      = errors.New("not found")     // 404
+	ErrConflict      = errors.New("conflict")      // 409
+	ErrTooLarge      = errors.New("too large")     // 413
+	ErrUnprocessable = errors.New("unprocessable") // 422, e.g. a failed atomic import or an Idempotency-Key reused
+	ErrUnavailable   = errors.New("unavailable")   // 408, 429, 502, 503, 504, see Temporary
+	ErrServer        = errors.New("server error")  // the other 5xx
+)
+
+// Error is an answer of the API other than 2xx.
+type Error struct {
+	Method     string
+	Path       string // under /v1
+	StatusCode int
+	Message    string // the error of the body, or the status text
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// Is matches the Err* sentinel of the status.
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case 400:
+		return target == ErrBadRequest
+	case 401, 403:
+		return target == ErrUnauthorized
+	case 404:
+		return target == ErrNotFound
+	case 409:
+		return target == ErrConflict
+	case 413:
+		return target == ErrTooLarge
+	case 422:
+		return target == ErrUnprocessable
+	}
+	if retryable(e.StatusCode) {
+		return target == ErrUnavailable
+	}
+	return e.StatusCode >= 500 && target == ErrServer
+}
+
+// Temporary tells if the call may succeed later, it was retried already.
+func (e *Error) Temporary() bool {
+	return retryable(e.StatusCode)
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do sends in as JSON (when not nil) and decodes the answer into out (when
+// not nil).
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	_, err := c.doHeader(ctx, method, path, query, in, out)
+	return err
+}
+
+// doHeader is do returning the headers of the answer.
+func (c *Client) doHeader(ctx context.Context, method, path string, query url.Values, in, out any) (http.Header, error) {
+	var body []byte
+	contentType := ""
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return nil, err
+		}
+		contentType = "application/json"
+	}
+	resp, err := c.send(ctx, method, path, query, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return resp.Header, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return nil, fmt.Errorf("%s %s: decoding the answer: %w", method, path, err)
+	}
+	return resp.Header, nil
+}
+
+// send sends a request and returns the answer when it is a 2xx, an *Error
+// otherwise. The caller closes the body.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, contentType string, body []byte) (*http.Response, error) {
+	resp, err := c.raw(ctx, method, path, query, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	return nil, readError(method, path, resp)
+}
+
+// raw sends a request, whatever the answer, retrying it on the network
+// errors and the retryable statuses. Only the last answer is returned.
+func (c *Client) raw(ctx context.Context, method, path string, query url.Values, contentType string, body []byte) (*http.Response, error) {
+	u := *c.base
+	u.Path += path
+	u.RawQuery = query.Encode()
+	key := ""
+	if method != http.MethodGet && method != http.MethodHead {
+		key, _ = ctx.Value(keyCtx{}).(string)
+		if key == "" {
+			key = uuid.NewString() // the same for the retries
+		}
+	}
+
+	wait := c.backoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		resp, err := c.http.Do(req)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return nil, ctx.Err()
+		case attempt == c.retries,
+			err == nil && !retryable(resp.StatusCode):
+			return resp, err
+		}
+
+		next := min(wait, maxBackoff)
+		if resp != nil {
+			if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+				next = min(time.Duration(s)*time.Second, maxBackoff)
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // reuses the connection
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(next):
+		}
+		wait *= 2
+	}
+}
+
+// readError is the *Error of an answer, its body is {"error": "..."}.
+func readError(method, path string, resp *http.Response) error {
+	e := &Error{Method: method, Path: path, StatusCode: resp.StatusCode}
+	var body struct {
+		Error string `json:"error"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(b, &body) == nil && body.Error != "" {
+		e.Message = body.Error
+	} else if s := strings.TrimSpace(string(b)); s != "" && len(s) < 200 {
+		e.Message = s
+	} else {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+// query drops the empty values of kv (key, value pairs).
+func query(kv ...string) url.Values {
+	q := url.Values{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			q.Set(kv[i], kv[i+1])
+		}
+	}
+	return q
+}
+
+func boolParam(b bool) string {
+	if b {
+		return "true"
+	}
+	return ""
+}
@@ -0,0 +1,258 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/config"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/handlers"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/idempotency"
+	"github.com/ThiagoScheffer/azure-tagger-api/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	rgApp = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-app"
+	webID = rgApp + "/providers/Microsoft.Web/sites/web"
+)
+
+// newServer serves the routes the client calls, on a fresh store, and
+// returns a client of it made with opts. Azure is not configured.
+func newServer(t *testing.T, opts ...Option) *Client {
+	t.Helper()
+	h := handlers.New(store.NewMemoryStore(), config.Default())
+	r := chi.NewRouter()
+	r.Route("/v1", func(r chi.Router) {
+		r.Use(idempotency.New(time.Hour).Middleware)
+		r.Post("/resources", h.CreateResource)
+		r.Get("/resources", h.ListResources)
+		r.Get("/resources/{id}", h.GetResource)
+		r.Delete("/resources/{id}", h.DeleteResource)
+		r.Patch("/resources/{id}/tags", h.UpdateResourceTags)
+		r.Delete("/resources/{id}/tags", h.UnsetResourceTags)
+		r.Post("/resources/{id}/apply-tags", h.ApplyTagsToAzure)
+		r.Get("/resources/{id}/history", h.GetResourceHistory)
+		r.Get("/drift", h.Drift)
+		r.Post("/import", h.ImportResources)
+		r.Get("/export", h.ExportResources)
+		r.Post("/tags/rename", h.RenameTags)
+		r.Get("/jobs", h.ListJobs)
+		r.Get("/jobs/{id}", h.GetJob)
+	})
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL+"/", append([]Option{WithToken("secret")}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient_Resources(t *testing.T) {
+	c := newServer(t)
+	ctx := context.Background()
+
+	rg, err := c.CreateResource(ctx, CreateResource{Name: "rg-app", AzureID: rgApp, Tags: map[string]string{"env": "prod"}})
+	if err != nil || rg.ID == "" || rg.Scope != ScopeResourceGroup {
+		t.Fatalf("expected rg-app created, got %+v (%v)", rg, err)
+	}
+	web, err := c.CreateResource(ctx, CreateResource{Name: "web", AzureID: webID, Tags: map[string]string{"env": "dev", "owner": "me"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := c.ListResources(ctx, ListOptions{Selector: "env=dev", Query: "WEB"})
+	if err != nil || len(list) != 1 || list[0].ID != web.ID {
+		t.Fatalf("expected web, got %+v (%v)", list, err)
+	}
+	var names []string
+	for res, err := range c.Resources(ctx, ListOptions{PageSize: 1}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, res.Name)
+	}
+	if !slices.Equal(names, []string{"rg-app", "web"}) {
+		t.Fatalf("expected both across pages, got %v", names)
+	}
+	for _, err := range c.Resources(ctx, ListOptions{PageSize: 5000}) {
+		if !errors.Is(err, ErrBadRequest) {
+			t.Fatalf("expected a bad request, got %v", err)
+		}
+	}
+	if _, err := c.SetTags(ctx, web.ID, map[string]string{"temp": "1"}, map[string]string{"temp": "72h"}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.UnsetTags(ctx, web.ID, "owner")
+	if err != nil || !res.NeedsApply || !maps.Equal(res.Tags, map[string]string{"env": "dev", "temp": "1"}) || res.TagExpiry["temp"] == 0 {
+		t.Fatalf("expected owner removed and temp expiring, got %+v (%v)", res, err)
+	}
+	detail, err := c.GetResource(ctx, web.ID)
+	if err != nil || detail.EffectiveTags["env"].Value != "dev" {
+		t.Fatalf("expected the effective tags, got %+v (%v)", detail, err)
+	}
+	history, err := c.History(ctx, web.ID)
+	if err != nil || len(history) != 2 || history[1].Action != "tags_removed" {
+		t.Fatalf("expected the set and the removal, got %+v (%v)", history, err)
+	}
+
+	// Azure isn't configured: the error of the API comes back
+	_, err = c.ApplyTags(ctx, web.ID, res.Tags)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || !errors.Is(err, ErrBadRequest) || !strings.HasPrefix(apiErr.Message, "azure not configured") ||
+		err.Error() != "POST /resources/"+web.ID+"/apply-tags: 400 "+apiErr.Message {
+		t.Fatalf("expected a 400 azure not configured, got %v", err)
+	}
+
+	if err := c.DeleteResource(ctx, web.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetResource(ctx, web.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestClient_ImportExportRename(t *testing.T) {
+	c := newServer(t)
+	ctx := context.Background()
+
+	in := "azure_id,name,Env\n" + rgApp + ",rg-app,Prod\n" + webID + ",web,Dev\nnot-an-id,x,Dev\n"
+	got, err := c.Import(ctx, strings.NewReader(in), ImportOptions{})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || got.Created != 2 || got.Failed != 1 || got.Errors[0].Row != 4 {
+		t.Fatalf("expected 2 rows created and line 4 failed, got %+v (%v)", got, err)
+	}
+	if got, err := c.Import(ctx, strings.NewReader(in), ImportOptions{Atomic: true}); !errors.Is(err, ErrUnprocessable) || got.Failed != 1 {
+		t.Fatalf("expected a 422, got %+v (%v)", got, err)
+	}
+
+	m := TagMapping{Keys: map[string]string{"Env": "env"}, Values: map[string]map[string]string{"env": {"Prod": "prod", "Dev": "dev"}}}
+	preview, err := c.PreviewRename(ctx, m, "")
+	if err != nil || preview.Matched != 2 || preview.Changed != 2 {
+		t.Fatalf("expected 2 resources to change, got %+v (%v)", preview, err)
+	}
+	job, err := c.RenameTags(ctx, m, RenameOptions{Selector: "Env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []string
+	job, err = c.WaitJob(ctx, job.ID, 10*time.Millisecond, func(j Job) { seen = append(seen, j.Status) })
+	if err != nil || job.Status != JobSucceeded || job.Done != 2 || len(seen) == 0 || seen[len(seen)-1] != JobSucceeded {
+		t.Fatalf("expected the job to succeed, got %+v %v (%v)", job, seen, err)
+	}
+	if jobs, err := c.ListJobs(ctx); err != nil || len(jobs) != 1 {
+		t.Fatalf("expected 1 job, got %+v (%v)", jobs, err)
+	}
+
+	rc, err := c.Export(ctx, ExportOptions{Format: "ndjson", Selector: "env=prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, _ := io.ReadAll(rc)
+	if out := string(b); !strings.Contains(out, `"env":"prod"`) || strings.Contains(out, "web") {
+		t.Fatalf("expected rg-app renamed only, got %s", out)
+	}
+	if _, err := c.Export(ctx, ExportOptions{Format: "xml"}); !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("expected a 400, got %v", err)
+	}
+}
+
+func TestClient_Token(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		http.Error(w, "gateway down", 502)
+	}))
+	defer srv.Close()
+	c, _ := New(srv.URL, WithToken("secret"), WithRetry(0, 0))
+	_, err := c.ListJobs(context.Background())
+	var apiErr *Error
+	if auth != "Bearer secret" || !errors.As(err, &apiErr) || !apiErr.Temporary() || !errors.Is(err, ErrUnavailable) || apiErr.Message != "gateway down" {
+		t.Fatalf("expected the token sent and the 502 text, got %q %v", auth, err)
+	}
+	for _, u := range []string{"", "localhost:8080", "ftp://host"} {
+		if _, err := New(u); err == nil {
+			t.Fatalf("expected %q rejected", u)
+		}
+	}
+}
+
+func TestClient_Retries(t *testing.T) {
+	keys, statuses := make(chan string, 10), make(chan int, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys <- r.Header.Get("Idempotency-Key")
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(<-statuses)
+		io.WriteString(w, `{"id":"r1"}`)
+	}))
+	defer srv.Close()
+	c, _ := New(srv.URL, WithRetry(2, time.Millisecond))
+	// answer queues the statuses of the next call, sent returns its keys
+	answer := func(s ...int) {
+		for _, status := range s {
+			statuses <- status
+		}
+	}
+	sent := func() []string {
+		var out []string
+		for len(keys) > 0 {
+			out = append(out, <-keys)
+		}
+		return out
+	}
+
+	answer(503, 429, 201)
+	res, err := c.CreateResource(context.Background(), CreateResource{Name: "web", AzureID: webID})
+	if got := sent(); err != nil || res.ID != "r1" || len(got) != 3 || got[0] == "" || got[1] != got[0] || got[2] != got[0] {
+		t.Fatalf("expected 3 tries with one key, got %+v %v (%v)", res, got, err)
+	}
+
+	answer(503, 503, 503)
+	_, err = c.CreateResource(WithIdempotencyKey(context.Background(), "mine"), CreateResource{})
+	if got := sent(); !errors.Is(err, ErrUnavailable) || !slices.Equal(got, []string{"mine", "mine", "mine"}) {
+		t.Fatalf("expected to give up after 3 tries, got %v %v", got, err)
+	}
+
+	answer(400)
+	if _, err := c.ListJobs(context.Background()); !errors.Is(err, ErrBadRequest) || !slices.Equal(sent(), []string{""}) {
+		t.Fatalf("expected a GET without key nor retry, got %v", err)
+	}
+}
+
+// lossy forwards the requests but loses the first answer, as a broken
+// connection would.
+type lossy struct {
+	lost bool
+}
+
+func (l *lossy) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil && !l.lost {
+		l.lost = true
+		resp.Body.Close()
+		return nil, errors.New("connection reset")
+	}
+	return resp, err
+}
+
+func TestClient_RetryDoesNotRunTwice(t *testing.T) {
+	c := newServer(t, WithHTTPClient(&http.Client{Transport: &lossy{}}), WithRetry(1, time.Millisecond))
+	ctx := context.Background()
+	res, err := c.CreateResource(ctx, CreateResource{Name: "web", AzureID: webID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := c.ListResources(ctx, ListOptions{})
+	if err != nil || len(list) != 1 || list[0].ID != res.ID {
+		t.Fatalf("expected the resource created once, got %+v (%v)", list, err)
+	}
+}
@@ -0,0 +1,298 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ThiagoScheffer/azure-tagger-api/docs"
+)
+
+// The contract tests check the client against the swagger spec swag
+// generates from the handlers (docs/docs.go): the payload types have the
+// fields of their definitions, and every request the client makes and every
+// answer it gets are declared. Regenerate the spec when a handler changes,
+// then fix the client.
+
+// payloads are the client types of the spec definitions.
+var payloads = map[string]any{
+	"models.Resource":        Resource{},
+	"models.ResourceDetail":  ResourceDetail{},
+	"models.ApplyResult":     ApplyResult{},
+	"models.EffectiveTag":    EffectiveTag{},
+	"models.HistoryEntry":    HistoryEntry{},
+	"models.Job":             Job{},
+	"models.TagMapping":      TagMapping{},
+	"handlers.createReq":     CreateResource{},
+	"handlers.updateTagsReq": updateTags{},
+	"handlers.applyReq":      applyTags{},
+	"handlers.applyResp":     Applied{},
+	"handlers.driftResp":     Drift{},
+	"handlers.driftEntry":    DriftEntry{},
+	"handlers.importResp":    ImportResult{},
+	"handlers.importError":   ImportError{},
+	"handlers.renamePreview": RenamePreview{},
+	"handlers.tagDiff":       TagDiff{},
+}
+
+// operations are the ones the client calls, as METHOD path in the spec.
+var operations = []string{
+	"DELETE /resources/{id}",
+	"DELETE /resources/{id}/tags",
+	"GET /drift",
+	"GET /export",
+	"GET /jobs",
+	"GET /jobs/{id}",
+	"GET /resources",
+	"GET /resources/{id}",
+	"GET /resources/{id}/history",
+	"PATCH /resources/{id}/tags",
+	"POST /import",
+	"POST /resources",
+	"POST /resources/{id}/apply-tags",
+	"POST /tags/rename",
+}
+
+type spec struct {
+	BasePath    string                                 `json:"basePath"`
+	Paths       map[string]map[string]operation        `json:"paths"`
+	Definitions map[string]struct{ Properties fields } `json:"definitions"`
+}
+
+type operation struct {
+	Parameters []struct {
+		Name   string  `json:"name"`
+		In     string  `json:"in"`
+		Schema *schema `json:"schema"`
+	} `json:"parameters"`
+	Responses map[string]struct {
+		Schema *schema `json:"schema"`
+	} `json:"responses"`
+}
+
+type schema struct {
+	Ref   string  `json:"$ref"`
+	Items *schema `json:"items"`
+}
+
+// definition is the name of the definition s or its items refer to.
+func (s *schema) definition() string {
+	if s == nil {
+		return ""
+	}
+	if s.Items != nil {
+		return s.Items.definition()
+	}
+	return strings.TrimPrefix(s.Ref, "#/definitions/")
+}
+
+type fields map[string]json.RawMessage
+
+func loadSpec(t *testing.T) *spec {
+	t.Helper()
+	var s spec
+	if err := json.Unmarshal([]byte(docs.SwaggerInfo.ReadDoc()), &s); err != nil {
+		t.Fatalf("reading the spec (generated without --td \"[[,]]\"?): %v", err)
+	}
+	return &s
+}
+
+// jsonFields are the JSON keys of a struct type, with the ones of its
+// embedded structs.
+func jsonFields(t reflect.Type) []string {
+	var out []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch {
+		case name == "-":
+		case f.Anonymous && name == "":
+			out = append(out, jsonFields(f.Type)...)
+		case name != "":
+			out = append(out, name)
+		case f.IsExported():
+			out = append(out, f.Name)
+		}
+	}
+	return out
+}
+
+func TestContract_Payloads(t *testing.T) {
+	s := loadSpec(t)
+	for def, v := range payloads {
+		t.Run(def, func(t *testing.T) {
+			d, ok := s.Definitions[def]
+			if !ok {
+				t.Fatalf("no definition %s in the spec", def)
+			}
+			got := slices.Sorted(slices.Values(jsonFields(reflect.TypeOf(v))))
+			want := slices.Sorted(maps.Keys(d.Properties))
+			if !slices.Equal(got, want) {
+				t.Fatalf("%T has %v, the spec %v", v, got, want)
+			}
+		})
+	}
+}
+
+// checker is a transport checking the requests and answers against the
+// spec.
+type checker struct {
+	t    *testing.T
+	spec *spec
+
+	mu   sync.Mutex
+	seen map[string]bool // operations
+}
+
+func (c *checker) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	path, op, ok := c.operation(req)
+	if !ok {
+		c.t.Errorf("%s %s: not in the spec", req.Method, req.URL.Path)
+		return resp, nil
+	}
+	name := req.Method + " " + path
+	c.mu.Lock()
+	c.seen[name] = true
+	c.mu.Unlock()
+
+	params := map[string]bool{}
+	for _, p := range op.Parameters {
+		params[p.In+" "+p.Name] = true
+		if def := p.Schema.definition(); def != "" && payloads[def] == nil {
+			c.t.Errorf("%s: body %s has no client type", name, def)
+		}
+	}
+	for q := range req.URL.Query() {
+		if !params["query "+q] {
+			c.t.Errorf("%s: query parameter %s not in the spec", name, q)
+		}
+	}
+	if req.Header.Get("Idempotency-Key") != "" && !params["header Idempotency-Key"] {
+		c.t.Errorf("%s: Idempotency-Key header not in the spec", name)
+	}
+
+	answer, ok := op.Responses[strconv.Itoa(resp.StatusCode)]
+	if !ok {
+		c.t.Errorf("%s: status %d not in the spec", name, resp.StatusCode)
+		return resp, nil
+	}
+	if def := answer.Schema.definition(); def != "" && resp.StatusCode < 300 {
+		if payloads[def] == nil {
+			c.t.Errorf("%s: answer %s has no client type", name, def)
+		}
+		c.checkBody(name, def, body)
+	}
+	return resp, nil
+}
+
+// operation finds the path template and operation of req, the one with
+// the most literal segments when several match.
+func (c *checker) operation(req *http.Request) (string, operation, bool) {
+	got := strings.Split(strings.TrimPrefix(req.URL.Path, c.spec.BasePath), "/")
+	best, literals := "", -1
+	for path := range c.spec.Paths {
+		want := strings.Split(path, "/")
+		if len(want) != len(got) {
+			continue
+		}
+		n := 0
+		for i := range want {
+			if strings.HasPrefix(want[i], "{") {
+				continue
+			}
+			if want[i] != got[i] {
+				n = -1
+				break
+			}
+			n++
+		}
+		if n > literals {
+			best, literals = path, n
+		}
+	}
+	op, ok := c.spec.Paths[best][strings.ToLower(req.Method)]
+	return best, op, ok
+}
+
+// checkBody checks the keys of an answer (an object or an array of them)
+// are properties of the definition: the server answers what it declares.
+func (c *checker) checkBody(name, def string, body []byte) {
+	var objects []map[string]any
+	if json.Unmarshal(body, &objects) != nil {
+		var one map[string]any
+		if err := json.Unmarshal(body, &one); err != nil {
+			c.t.Errorf("%s: answer not a %s: %v", name, def, err)
+			return
+		}
+		objects = append(objects, one)
+	}
+	props := c.spec.Definitions[def].Properties
+	for _, o := range objects {
+		for k := range o {
+			if _, ok := props[k]; !ok {
+				c.t.Errorf("%s: answer has %s, not a property of %s", name, k, def)
+			}
+		}
+	}
+}
+
+func TestContract_Calls(t *testing.T) {
+	chk := &checker{t: t, spec: loadSpec(t), seen: map[string]bool{}}
+	c := newServer(t, WithHTTPClient(&http.Client{Transport: chk}))
+	ctx := context.Background()
+
+	// every call of the client, on the paths the scenario takes
+	rg, err := c.CreateResource(ctx, CreateResource{Name: "rg-app", AzureID: rgApp, Tags: map[string]string{"Env": "Prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListResources(ctx, ListOptions{Selector: "Env", Scope: rgApp, Query: "rg", PageSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	c.GetResource(ctx, rg.ID)
+	c.SetTags(ctx, rg.ID, map[string]string{"temp": "1"}, map[string]string{"temp": "1h"})
+	c.UnsetTags(ctx, rg.ID, "temp")
+	c.ApplyTags(ctx, rg.ID, nil)
+	c.History(ctx, rg.ID)
+	c.Drift(ctx, "Env")
+	c.Import(ctx, strings.NewReader("azure_id,name,Env\n"+webID+",web,Dev\nbad,x,y\n"), ImportOptions{Format: "csv", Atomic: true, Delimiter: ","})
+	c.Import(ctx, strings.NewReader(`{"azure_id":"`+webID+`","name":"web","tags":{"Env":"Dev"}}`+"\n"), ImportOptions{Format: "ndjson"})
+	if rc, err := c.Export(ctx, ExportOptions{Format: "csv", Tags: []string{"Env"}, Selector: "Env", Delimiter: ";"}); err == nil {
+		rc.Close()
+	}
+	m := TagMapping{Keys: map[string]string{"Env": "env"}}
+	c.PreviewRename(ctx, m, "Env")
+	job, err := c.RenameTags(ctx, m, RenameOptions{Selector: "Env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.WaitJob(ctx, job.ID, 10*time.Millisecond, nil)
+	c.ListJobs(ctx)
+	c.DeleteResource(ctx, rg.ID)
+	c.GetResource(ctx, rg.ID)
+
+	if got := slices.Sorted(maps.Keys(chk.seen)); !slices.Equal(got, operations) {
+		t.Fatalf("expected the scenario to call %v, got %v", operations, got)
+	}
+}
@@ -0,0 +1,196 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CreateResource registers an Azure ID with its tags.
+func (c *Client) CreateResource(ctx context.Context, req CreateResource) (Resource, error) {
+	var res Resource
+	err := c.do(ctx, http.MethodPost, "/resources", nil, req, &res)
+	return res, err
+}
+
+// ListResources returns the registered resources opts picks, oldest first.
+func (c *Client) ListResources(ctx context.Context, opts ListOptions) ([]Resource, error) {
+	list := []Resource{}
+	for res, err := range c.Resources(ctx, opts) {
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, res)
+	}
+	return list, nil
+}
+
+// defaultPageSize is the page size of Resources when opts has none.
+const defaultPageSize = 100
+
+// Resources iterates over the registered resources opts picks, oldest first,
+// fetching them a page at a time. An error ends the iteration.
+func (c *Client) Resources(ctx context.Context, opts ListOptions) iter.Seq2[Resource, error] {
+	return func(yield func(Resource, error) bool) {
+		q := query("selector", opts.Selector, "scope", opts.Scope, "q", opts.Query, "needsApply", boolParam(opts.NeedsApply),
+			"limit", strconv.Itoa(cmp.Or(opts.PageSize, defaultPageSize)))
+		for {
+			var page []Resource
+			header, err := c.doHeader(ctx, http.MethodGet, "/resources", q, nil, &page)
+			if err != nil {
+				yield(Resource{}, err)
+				return
+			}
+			for _, res := range page {
+				if !yield(res, nil) {
+					return
+				}
+			}
+			next := header.Get("X-Next-Cursor")
+			if next == "" {
+				return
+			}
+			q.Set("cursor", next)
+		}
+	}
+}
+
+// GetResource returns a resource with its effective tags.
+func (c *Client) GetResource(ctx context.Context, id string) (ResourceDetail, error) {
+	var res ResourceDetail
+	err := c.do(ctx, http.MethodGet, "/resources/"+url.PathEscape(id), nil, nil, &res)
+	return res, err
+}
+
+// DeleteResource unregisters a resource, its Azure tags stay.
+func (c *Client) DeleteResource(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/resources/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// SetTags merges tags into the ones of a resource. expires gives some of them
+// an expiry (RFC 3339 time or TTL like "72h"), it can be nil.
+func (c *Client) SetTags(ctx context.Context, id string, tags, expires map[string]string) (Resource, error) {
+	var res Resource
+	err := c.do(ctx, http.MethodPatch, "/resources/"+url.PathEscape(id)+"/tags", nil, updateTags{Tags: tags, Expires: expires}, &res)
+	return res, err
+}
+
+// UnsetTags removes keys (ignoring case) from the tags of a resource.
+func (c *Client) UnsetTags(ctx context.Context, id string, keys ...string) (Resource, error) {
+	var res Resource
+	err := c.do(ctx, http.MethodDelete, "/resources/"+url.PathEscape(id)+"/tags", query("keys", strings.Join(keys, ",")), nil, &res)
+	return res, err
+}
+
+// ApplyTags replaces the Azure tags of a resource with its tag sets,
+// overridden by tags, plus what it inherits.
+func (c *Client) ApplyTags(ctx context.Context, id string, tags map[string]string) (Applied, error) {
+	var out Applied
+	err := c.do(ctx, http.MethodPost, "/resources/"+url.PathEscape(id)+"/apply-tags", nil, applyTags{Tags: tags}, &out)
+	return out, err
+}
+
+// History returns the tag changes of a resource, oldest first.
+func (c *Client) History(ctx context.Context, id string) ([]HistoryEntry, error) {
+	var out []HistoryEntry
+	err := c.do(ctx, http.MethodGet, "/resources/"+url.PathEscape(id)+"/history", nil, nil, &out)
+	return out, err
+}
+
+// Drift compares the Azure tags of the resources selector picks (all when
+// empty) with their desired tags.
+func (c *Client) Drift(ctx context.Context, selector string) (Drift, error) {
+	var out Drift
+	err := c.do(ctx, http.MethodGet, "/drift", query("selector", selector), nil, &out)
+	return out, err
+}
+
+// Import upserts the resources of a CSV or NDJSON file. When rows failed the
+// result comes with an *Error, nothing was imported if opts.Atomic.
+func (c *Client) Import(ctx context.Context, r io.Reader, opts ImportOptions) (ImportResult, error) {
+	var out ImportResult
+	body, err := io.ReadAll(r) // kept for the retries
+	if err != nil {
+		return out, err
+	}
+	contentType := "text/csv"
+	if opts.Format == "ndjson" {
+		contentType = "application/x-ndjson"
+	}
+	q := query("format", opts.Format, "atomic", boolParam(opts.Atomic), "delimiter", opts.Delimiter)
+	resp, err := c.raw(ctx, http.MethodPost, "/import", q, contentType, body)
+	if err != nil {
+		return out, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnprocessableEntity {
+		return out, readError(http.MethodPost, "/import", resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return out, fmt.Errorf("POST /import: decoding the answer: %w", err)
+	}
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return out, &Error{Method: http.MethodPost, Path: "/import", StatusCode: resp.StatusCode, Message: fmt.Sprintf("%d of %d rows failed, nothing imported", out.Failed, out.Rows)}
+	}
+	if out.Failed > 0 {
+		return out, &Error{Method: http.MethodPost, Path: "/import", StatusCode: resp.StatusCode, Message: fmt.Sprintf("%d of %d rows failed", out.Failed, out.Rows)}
+	}
+	return out, nil
+}
+
+// Export streams the registered resources as CSV or NDJSON, the caller
+// closes it.
+func (c *Client) Export(ctx context.Context, opts ExportOptions) (io.ReadCloser, error) {
+	q := query("format", opts.Format, "tags", strings.Join(opts.Tags, ","), "selector", opts.Selector, "delimiter", opts.Delimiter)
+	resp, err := c.send(ctx, http.MethodGet, "/export", q, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// ListJobs returns the background jobs, newest first.
+func (c *Client) ListJobs(ctx context.Context) ([]Job, error) {
+	var out []Job
+	err := c.do(ctx, http.MethodGet, "/jobs", nil, nil, &out)
+	return out, err
+}
+
+// GetJob returns the status and progress of a job.
+func (c *Client) GetJob(ctx context.Context, id string) (Job, error) {
+	var out Job
+	err := c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, nil, &out)
+	return out, err
+}
+
+// WaitJob polls a job every interval until it is finished, calling progress
+// (when not nil) each time it changed.
+func (c *Client) WaitJob(ctx context.Context, id string, interval time.Duration, progress func(Job)) (Job, error) {
+	var last Job
+	for {
+		j, err := c.GetJob(ctx, id)
+		if err != nil {
+			return last, err
+		}
+		if progress != nil && (j.Status != last.Status || j.Done != last.Done || j.Failed != last.Failed || j.Total != last.Total) {
+			progress(j)
+		}
+		if j.Finished() {
+			return j, nil
+		}
+		last = j
+		select {
+		case <-ctx.Done():
+			return last, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
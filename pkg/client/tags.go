@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// TagMapping renames tag keys (old -> new, as spelled) then remaps values
+// per key (key -> old value -> new one).
+type TagMapping struct {
+	Keys   map[string]string            `json:"keys,omitempty"`
+	Values map[string]map[string]string `json:"values,omitempty"`
+}
+
+// RenameOptions are the parameters of POST /tags/rename.
+type RenameOptions struct {
+	Selector string // resources to rename, all by default
+	Push     bool   // apply the changed resources to Azure too
+}
+
+// RenamePreview is what a rename would change.
+type RenamePreview struct {
+	Matched   int       `json:"matched"`
+	Changed   int       `json:"changed"`
+	Conflicts int       `json:"conflicts"`
+	Diffs     []TagDiff `json:"diffs"`
+}
+
+// TagDiff is the change a rename makes to the tags of a resource.
+type TagDiff struct {
+	ResourceID string            `json:"resource_id"`
+	Name       string            `json:"name"`
+	AzureID    string            `json:"azure_id"`
+	Set        map[string]string `json:"set,omitempty"`
+	Removed    []string          `json:"removed,omitempty"`
+	Conflicts  []string          `json:"conflicts,omitempty"`
+}
+
+// RenameTags queues a job applying m to the tags of the registered
+// resources, follow it with WaitJob.
+func (c *Client) RenameTags(ctx context.Context, m TagMapping, opts RenameOptions) (Job, error) {
+	var out Job
+	err := c.do(ctx, http.MethodPost, "/tags/rename", query("selector", opts.Selector, "push", boolParam(opts.Push)), m, &out)
+	return out, err
+}
+
+// PreviewRename returns what RenameTags would change, without changing it.
+func (c *Client) PreviewRename(ctx context.Context, m TagMapping, selector string) (RenamePreview, error) {
+	var out RenamePreview
+	err := c.do(ctx, http.MethodPost, "/tags/rename", query("selector", selector, "dryRun", "true"), m, &out)
+	return out, err
+}
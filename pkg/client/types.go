@@ -0,0 +1,169 @@
+package client
+
+// Scope kinds, detected by the server from the Azure ID
+const (
+	ScopeSubscription  = "subscription"
+	ScopeResourceGroup = "resource_group"
+	ScopeResource      = "resource"
+)
+
+// Resource is a registered Azure ID with its desired tags.
+type Resource struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Tags        map[string]string `json:"tags"`
+	AzureID     string            `json:"azure_id"`
+	Scope       string            `json:"scope"`
+	CreatedUnix int64             `json:"create_unix"`
+	LastApply   *ApplyResult      `json:"last_apply,omitempty"`
+	TagSets     []string          `json:"tag_sets,omitempty"`
+	NeedsApply  bool              `json:"needs_apply"`
+	TagExpiry   map[string]int64  `json:"tag_expiry,omitempty"` // unix
+}
+
+// ApplyResult is the outcome of the last apply of a resource.
+type ApplyResult struct {
+	Status       string            `json:"status"` // succeeded, failed or interrupted
+	Tags         map[string]string `json:"tags"`
+	Error        string            `json:"error,omitempty"`
+	FinishedUnix int64             `json:"finished_unix"`
+}
+
+// EffectiveTag is a desired tag with where it comes from: explicit, tag_set
+// or inherited.
+type EffectiveTag struct {
+	Value         string `json:"value"`
+	Source        string `json:"source"`
+	TagSet        string `json:"tag_set,omitempty"`        // name@version
+	InheritedFrom string `json:"inherited_from,omitempty"` // Azure ID of the resource group
+}
+
+// ResourceDetail is a resource with its effective tags.
+type ResourceDetail struct {
+	Resource
+	EffectiveTags map[string]EffectiveTag `json:"effective_tags"`
+}
+
+// CreateResource is the payload of POST /resources.
+type CreateResource struct {
+	Name    string            `json:"name"`
+	AzureID string            `json:"azureId"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	TagSets []string          `json:"tagSets,omitempty"`
+	Expires map[string]string `json:"expires,omitempty"` // RFC 3339 time or TTL ("72h") per key of Tags
+}
+
+// ListOptions filter GET /resources, every one is optional.
+type ListOptions struct {
+	Selector   string // tag selector, e.g. env=prod,!owner
+	Scope      string // Azure ID of a subscription or resource group
+	Query      string // part of the name or Azure ID
+	NeedsApply bool
+	PageSize   int // resources per request, 1 to 1000 (default 100)
+}
+
+// updateTags is the payload of PATCH /resources/{id}/tags.
+type updateTags struct {
+	Tags    map[string]string `json:"tags"`
+	Expires map[string]string `json:"expires,omitempty"`
+}
+
+// applyTags is the payload of POST /resources/{id}/apply-tags.
+type applyTags struct {
+	Tags map[string]string `json:"tags"`
+}
+
+// Applied is the answer of an apply to Azure.
+type Applied struct {
+	Message  string            `json:"message"`
+	Resource string            `json:"resource"` // Azure ID
+	Scope    string            `json:"scope"`
+	Tags     map[string]string `json:"tags"` // what Azure has now
+}
+
+// HistoryEntry is one change to the tags of a resource.
+type HistoryEntry struct {
+	Unix    int64             `json:"unix"`
+	Action  string            `json:"action"`
+	Actor   string            `json:"actor,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Removed []string          `json:"removed,omitempty"`
+	Detail  string            `json:"detail,omitempty"`
+}
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// Job is a background task of the server, e.g. a bulk rename.
+type Job struct {
+	ID           string   `json:"id"`
+	Kind         string   `json:"kind"`
+	Status       string   `json:"status"`
+	Total        int      `json:"total"`
+	Done         int      `json:"done"`
+	Failed       int      `json:"failed"`
+	Errors       []string `json:"errors,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	CreatedUnix  int64    `json:"created_unix"`
+	StartedUnix  int64    `json:"started_unix,omitempty"`
+	FinishedUnix int64    `json:"finished_unix,omitempty"`
+}
+
+// Finished tells if the job is over, whatever the outcome.
+func (j Job) Finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCanceled
+}
+
+// Drift is the answer of GET /drift.
+type Drift struct {
+	Checked int          `json:"checked"`
+	Drifted []DriftEntry `json:"drifted"`
+}
+
+// DriftEntry is a resource whose Azure tags differ from its desired ones.
+type DriftEntry struct {
+	ResourceID string            `json:"resource_id"`
+	Name       string            `json:"name"`
+	AzureID    string            `json:"azure_id"`
+	Set        map[string]string `json:"set,omitempty"`     // an apply would set
+	Removed    []string          `json:"removed,omitempty"` // an apply would remove
+	Error      string            `json:"error,omitempty"`   // Azure tags not read
+}
+
+// ImportOptions are the parameters of POST /import.
+type ImportOptions struct {
+	Format    string // csv or ndjson
+	Atomic    bool   // all rows or none
+	Delimiter string // CSV: one character, tab or semicolon
+}
+
+// ImportResult is the answer of an import.
+type ImportResult struct {
+	Rows    int           `json:"rows"`
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Failed  int           `json:"failed"`
+	Atomic  bool          `json:"atomic"`
+	Errors  []ImportError `json:"errors"`
+}
+
+// ImportError is a row that failed.
+type ImportError struct {
+	Row     int    `json:"row"` // line in the file
+	AzureID string `json:"azure_id,omitempty"`
+	Error   string `json:"error"`
+}
+
+// ExportOptions are the parameters of GET /export.
+type ExportOptions struct {
+	Format    string   // csv (default) or ndjson
+	Tags      []string // tag columns, default every key in use
+	Selector  string
+	Delimiter string
+}